@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -10,6 +11,8 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/tjarjoura/cc/pkg/diag"
 )
 
 func dumpFiles(t *testing.T, files ...string) {
@@ -23,6 +26,42 @@ func dumpFiles(t *testing.T, files ...string) {
 	}
 }
 
+// Compile a program, link it and check that it exits with 0
+func compileAndRun(t *testing.T, fullPath string) {
+	var asmFiles, objFiles []string
+	outFile := strings.ReplaceAll(fullPath, ".c", "")
+	defer func() {
+		allFiles := append(asmFiles, outFile)
+		allFiles = append(allFiles, objFiles...)
+		for _, f := range allFiles {
+			os.Remove(f)
+		}
+	}()
+
+	asmFiles, err := compile("", fullPath)
+	if err != nil {
+		t.Fatalf("error compiling %s: %s", path.Base(fullPath), err)
+	}
+
+	objFiles, err = assemble("", asmFiles...)
+	if err != nil {
+		t.Errorf("error assembling %s: %s", path.Base(fullPath), err)
+		dumpFiles(t, asmFiles...)
+		t.FailNow()
+	}
+
+	if err := link(outFile, objFiles...); err != nil {
+		t.Fatalf("error linking %s: %s", outFile, err)
+	}
+
+	cmd := exec.Command(outFile)
+	if err := cmd.Run(); err != nil {
+		t.Errorf("error running %s: %s", outFile, err)
+		dumpFiles(t, asmFiles...)
+		t.FailNow()
+	}
+}
+
 // Compile and check the status codes of all test programs
 func TestCC(t *testing.T) {
 	log.SetFlags(0)
@@ -38,42 +77,84 @@ func TestCC(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// every program is also compiled at each level of optimization
 	for _, f := range sourceFiles {
-		t.Run(f.Name(), func(t *testing.T) {
-			fullPath := path.Join(testPrograms, f.Name())
-			var asmFiles, objFiles []string
-			outFile := strings.ReplaceAll(fullPath, ".c", "")
-			defer func() {
-				allFiles := append(asmFiles, outFile)
-				allFiles = append(allFiles, objFiles...)
-				for _, f := range allFiles {
-					os.Remove(f)
-				}
-			}()
-
-			asmFiles, err := compile("",
-				path.Join(testPrograms, f.Name()))
-			if err != nil {
-				t.Fatalf("error compiling %s: %s", f.Name(), err)
-			}
+		for _, level := range []int{0, 1, 2} {
+			t.Run(fmt.Sprintf("%s/-O%d", f.Name(), level), func(t *testing.T) {
+				optLevel = level
+				defer func() { optLevel = 0 }()
+				compileAndRun(t, path.Join(testPrograms, f.Name()))
+			})
+		}
+	}
+}
 
-			objFiles, err = assemble("", asmFiles...)
-			if err != nil {
-				t.Errorf("error assembling %s: %s", f.Name(), err)
-				dumpFiles(t, asmFiles...)
-				t.FailNow()
-			}
+// The recursion in testdata/deep_recursion.c overflows the stack unless the
+// calls in tail position become jumps, which is the default at -O2
+func TestSiblingCalls(t *testing.T) {
+	log.SetFlags(0)
 
-			if err := link(outFile, objFiles...); err != nil {
-				t.Fatalf("error linking %s: %s", outFile, err)
-			}
+	_, thisFile, _, _ := runtime.Caller(0)
+	program := path.Join(path.Dir(thisFile), "testdata", "deep_recursion.c")
 
-			cmd := exec.Command(outFile)
-			if err := cmd.Run(); err != nil {
-				t.Errorf("error running %s: %s", outFile, err)
-				dumpFiles(t, asmFiles...)
-				t.FailNow()
+	for _, args := range [][]string{{"-O2"}, {"-O1", "-foptimize-sibling-calls"}} {
+		t.Run(strings.Join(args, " "), func(t *testing.T) {
+			defer func() { optLevel, siblingCalls = 0, nil }()
+			if _, err := parseOptions(args); err != nil {
+				t.Fatal(err)
 			}
+			compileAndRun(t, program)
 		})
 	}
 }
+
+// A warning turned into an error fails the build before anything is
+// assembled
+func TestWerror(t *testing.T) {
+	source := path.Join(t.TempDir(), "unused.c")
+	if err := os.WriteFile(source, []byte("int main() { int a; return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	diagnostics = diag.NewPrinter(&out)
+	defer func() {
+		diagnostics = diag.NewPrinter(os.Stderr)
+		warnings = diag.NewWarningOptions()
+	}()
+
+	if _, err := parseOptions([]string{"-Werror=unused-variable"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := build(source); err == nil {
+		t.Fatalf("expected the build to fail")
+	}
+	if !strings.Contains(out.String(), "error: unused variable 'a'") {
+		t.Errorf("expected an error for the unused variable, got\n%s", out.String())
+	}
+	if _, err := os.Stat(strings.ReplaceAll(source, ".c", ".asm")); err == nil {
+		t.Errorf("expected no assembly to be left behind")
+	}
+}
+
+// Formatting a file in place keeps its comments
+func TestFormatWrite(t *testing.T) {
+	source := path.Join(t.TempDir(), "comments.c")
+	input := "// add them\nint add(int a,int b){\n\treturn a+b; /* no overflow */\n}\n"
+	if err := os.WriteFile(source, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := formatFiles([]string{"-w", source}); status != 0 {
+		t.Fatalf("expected status 0, got %d", status)
+	}
+
+	formatted, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "// add them\nint add(int a, int b) {\n\treturn a + b; /* no overflow */\n}\n"
+	if string(formatted) != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, formatted)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/compiler"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/parser"
+	"github.com/tjarjoura/cc/pkg/sema"
+)
+
+// The value of -dump-ast, which is "tree" when it is given without a format
+type dumpFormat string
+
+func (d *dumpFormat) String() string   { return string(*d) }
+func (d *dumpFormat) IsBoolFlag() bool { return true }
+
+func (d *dumpFormat) Set(s string) error {
+	switch s {
+	case "true", "tree":
+		*d = "tree"
+	case "json":
+		*d = "json"
+	case "false":
+		*d = ""
+	default:
+		return fmt.Errorf("unrecognized AST dump format '%s'", s)
+	}
+	return nil
+}
+
+/* Print the tokens of each source file, one per line with the type, the
+ * literal and where it starts */
+func dumpTokens(sourceFiles ...string) error {
+	for _, inputFile := range sourceFiles {
+		inp, err := os.ReadFile(inputFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", inputFile, err)
+		}
+
+		for _, tok := range lexer.NewFile(inputFile, string(inp)).Tokens() {
+			fmt.Printf("%s '%s' Loc=<%s>\n", tok.Type, tok.Literal, tok.Pos())
+		}
+	}
+
+	return nil
+}
+
+/* Print the AST of each source file instead of compiling it. The tree is
+ * dumped even if there are errors, with the types filled in if it parsed. */
+func dumpAST(format dumpFormat, sourceFiles ...string) error {
+	failed := []string{}
+	for _, inputFile := range sourceFiles {
+		inp, err := os.ReadFile(inputFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", inputFile, err)
+		}
+
+		diagnostics.AddSource(inputFile, string(inp))
+		p := parser.New(lexer.NewFile(inputFile, string(inp)))
+		tUnit := p.Parse()
+
+		var typeOf ast.TypeOf
+		if checkParserErrors(p) {
+			checker := sema.New(tUnit)
+			checker.Warnings = warnings
+			info := checker.Check()
+			if !checkSemaErrors(checker) {
+				failed = append(failed, inputFile)
+			}
+
+			typeOf = func(e ast.Expression) (ast.Declaration, string) {
+				tv, ok := info.Types[e]
+				if !ok {
+					return nil, ""
+				}
+				return tv.Type, tv.Category.String()
+			}
+		} else {
+			failed = append(failed, inputFile)
+		}
+
+		if format == "json" {
+			err = ast.DumpJSON(os.Stdout, tUnit, typeOf)
+		} else {
+			err = ast.DumpTree(os.Stdout, tUnit, typeOf)
+		}
+		if err != nil {
+			return fmt.Errorf("error dumping the AST of %s: %s", inputFile, err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("got errors for %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+/* Print the IR that each source file is lowered to instead of generating
+ * assembly, after the optimization passes */
+func dumpIR(sourceFiles ...string) error {
+	for _, inputFile := range sourceFiles {
+		inp, err := os.ReadFile(inputFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %s", inputFile, err)
+		}
+
+		diagnostics.AddSource(inputFile, string(inp))
+		p := parser.New(lexer.NewFile(inputFile, string(inp)))
+		tUnit := p.Parse()
+		if !checkParserErrors(p) {
+			return fmt.Errorf("got parser errors for %s", inputFile)
+		}
+
+		checker := sema.New(tUnit)
+		checker.Warnings = warnings
+		info := checker.Check()
+		if !checkSemaErrors(checker) {
+			return fmt.Errorf("got semantic errors for %s", inputFile)
+		}
+
+		c := compiler.New(tUnit, info)
+		c.Passes = passes()
+		c.Compile()
+		if !checkCompilerErrors(inputFile, c) {
+			return fmt.Errorf("got compiler errors for %s", inputFile)
+		}
+
+		fmt.Print(c.IR())
+	}
+
+	return nil
+}
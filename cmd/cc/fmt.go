@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/parser"
+)
+
+/* cc fmt [-w] [-l] files...: print the source files in a consistent format */
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false,
+		"If set, will write the result back to the source file instead of stdout")
+	list := flags.Bool("l", false,
+		"If set, will only list the files whose formatting differs")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Println("No input files given.")
+		return 1
+	}
+	defer diagnostics.Flush()
+
+	status := 0
+	for _, inputFile := range flags.Args() {
+		inp, err := os.ReadFile(inputFile)
+		if err != nil {
+			log.Printf("error reading %s: %s", inputFile, err)
+			status = 1
+			continue
+		}
+
+		diagnostics.AddSource(inputFile, string(inp))
+		p := parser.New(lexer.NewFile(inputFile, string(inp)))
+		tUnit := p.Parse()
+		if !checkParserErrors(p) {
+			status = 1
+			continue
+		}
+
+		formatted := ast.Format(tUnit)
+		if *list {
+			if formatted != string(inp) {
+				fmt.Println(inputFile)
+			}
+		} else if *write {
+			if formatted == string(inp) {
+				continue
+			}
+			if err := os.WriteFile(inputFile, []byte(formatted), 0644); err != nil {
+				log.Printf("error writing %s: %s", inputFile, err)
+				status = 1
+			}
+		} else {
+			fmt.Print(formatted)
+		}
+	}
+
+	return status
+}
@@ -6,51 +6,201 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
 
 	"github.com/tjarjoura/cc/pkg/compiler"
+	"github.com/tjarjoura/cc/pkg/diag"
 	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/opt"
 	"github.com/tjarjoura/cc/pkg/parser"
+	"github.com/tjarjoura/cc/pkg/sema"
 )
 
 var (
 	assembleOnly = flag.Bool("asm", false,
 		"If set, will only generate assembly code from each source file")
+	diagnosticsFormat = flag.String("fdiagnostics-format", "text",
+		"How to print diagnostics: text, or json or sarif on stdout")
+	diagnosticsColor = flag.String("fdiagnostics-color", "auto",
+		"Whether to color diagnostics: auto, always or never")
+
+	dumpTokensFlag = flag.Bool("dump-tokens", false,
+		"If set, will print the tokens of each source file instead of compiling")
+	dumpIRFlag = flag.Bool("dump-ir", false,
+		"If set, will print the intermediate representation of each source file instead of compiling")
+	printAfterAll = flag.Bool("print-after-all", false,
+		"If set, will print the IR of each function to stderr after every optimization pass")
+	inlineLimit = flag.Int("finline-limit", opt.DefaultInlineLimit,
+		"The cost up to which functions declared inline are inlined at -O1 and above")
+	inlineReport = flag.Bool("fopt-info-inline", false,
+		"If set, will print to stderr which calls were inlined and why the others weren't")
+
+	// -dump-ast or -dump-ast=json
+	dumpFormatFlag dumpFormat
+
+	diagnostics = diag.NewPrinter(os.Stderr)
+
+	// -Wall, -Wno-shadow, -Werror=sign-compare, -w and so on
+	warnings = diag.NewWarningOptions()
+
+	// -O0, -O1 or -O2, -O is the same as -O1
+	optLevel = 0
+
+	// -foptimize-sibling-calls or -fno-optimize-sibling-calls, which are
+	// optimized at -O2 by default. Nothing is in tail position at -O0.
+	siblingCalls *bool
 )
 
+func init() {
+	flag.Var(&dumpFormatFlag, "dump-ast",
+		"If set, will print the AST of each source file instead of compiling, as a tree or as json")
+}
+
+// Take the warning and optimization options out of the arguments, the flag
+// package can't handle options like -Wno-unused-variable or -O2
+func parseOptions(args []string) ([]string, error) {
+	rest := []string{}
+	for i, arg := range args {
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		} else if arg == "-foptimize-sibling-calls" || arg == "-fno-optimize-sibling-calls" {
+			enabled := arg == "-foptimize-sibling-calls"
+			siblingCalls = &enabled
+		} else if strings.HasPrefix(arg, "-O") {
+			switch arg {
+			case "-O", "-O1":
+				optLevel = 1
+			case "-O0":
+				optLevel = 0
+			case "-O2":
+				optLevel = 2
+			default:
+				return nil, fmt.Errorf("unrecognized optimization level '%s'", arg)
+			}
+		} else if !diag.IsWarningOption(arg) {
+			rest = append(rest, arg)
+		} else if err := warnings.Set(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	return rest, nil
+}
+
+// Set up the diagnostics printer from the flags, text is colored when it
+// goes to a terminal
+func setupDiagnostics() error {
+	format := diag.Format(*diagnosticsFormat)
+	switch format {
+	case diag.Text:
+	case diag.JSON, diag.SARIF:
+		diagnostics = diag.NewPrinter(os.Stdout)
+		diagnostics.Format = format
+		return nil
+	default:
+		return fmt.Errorf("unrecognized diagnostics format '%s'", format)
+	}
+
+	switch *diagnosticsColor {
+	case "auto":
+		diagnostics.Color = diag.IsTerminal(os.Stderr)
+	case "always":
+		diagnostics.Color = true
+	case "never":
+		diagnostics.Color = false
+	default:
+		return fmt.Errorf("unrecognized diagnostics color '%s'", *diagnosticsColor)
+	}
+
+	return nil
+}
+
 func checkParserErrors(p *parser.Parser) bool {
 	ret := true
-	for _, err := range p.Errors() {
-		log.Printf("parser error: %s\n", err.String())
+	errors := p.Errors()
+	for i := range errors {
+		diagnostics.Print(&errors[i].Diagnostic)
 		ret = false
 	}
 
 	return ret
 }
 
-func checkCompilerErrors(c *compiler.Compiler) bool {
+// Print the diagnostic unless it is a warning that is turned off, returns
+// false if it is an error
+func report(d *diag.Diagnostic) bool {
+	if !warnings.Apply(d) {
+		return true
+	}
+
+	diagnostics.Print(d)
+	return d.Severity != diag.Error
+}
+
+func checkSemaErrors(c *sema.Checker) bool {
+	ret := true
+	errors := c.Errors()
+	for i := range errors {
+		ret = report(&errors[i].Diagnostic) && ret
+	}
+
+	return ret
+}
+
+func checkCompilerErrors(inputFile string, c *compiler.Compiler) bool {
 	ret := true
 	errorMap := c.Errors()
-	for _, err := range errorMap["global"] {
-		log.Printf("compiler error: %s\n", err.String())
-		ret = false
+	global := errorMap["global"]
+	for i := range global {
+		ret = report(&global[i].Diagnostic) && ret
 	}
 
 	delete(errorMap, "global")
-	for name, errors := range errorMap {
-		if len(errors) > 0 {
-			log.Printf("in function %s:", name)
+	names := []string{}
+	for name := range errorMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		errors := []*diag.Diagnostic{}
+		for i := range errorMap[name] {
+			if d := &errorMap[name][i].Diagnostic; warnings.Apply(d) {
+				errors = append(errors, d)
+			}
 		}
 
-		for _, err := range errors {
-			log.Printf("compiler error: %s", err.String())
-			ret = false
+		if len(errors) > 0 && diagnostics.Format == diag.Text {
+			log.Printf("%s: In function '%s':", inputFile, name)
+		}
+
+		for _, d := range errors {
+			diagnostics.Print(d)
+			ret = ret && d.Severity != diag.Error
 		}
 	}
 
 	return ret
 }
 
+/* The optimization passes for -O, which print what they did to stderr with
+ * -print-after-all */
+func passes() *opt.Manager {
+	m := opt.NewPipeline(optLevel)
+	if *printAfterAll {
+		m.PrintAfterAll = os.Stderr
+	}
+	if m.Inliner != nil {
+		m.Inliner.Limit = *inlineLimit
+		if *inlineReport {
+			m.Inliner.Report = os.Stderr
+		}
+	}
+	return m
+}
+
 /* Take *.c source files and produce *.asm files */
 func compile(outputFile string, sourceFiles ...string) ([]string, error) {
 	if len(sourceFiles) > 1 && outputFile != "" {
@@ -66,7 +216,8 @@ func compile(outputFile string, sourceFiles ...string) ([]string, error) {
 				fmt.Errorf("error reading %s: %s", inputFile, err)
 		}
 
-		p := parser.New(lexer.New(string(inp)))
+		diagnostics.AddSource(inputFile, string(inp))
+		p := parser.New(lexer.NewFile(inputFile, string(inp)))
 		tUnit := p.Parse()
 
 		if !checkParserErrors(p) {
@@ -74,10 +225,24 @@ func compile(outputFile string, sourceFiles ...string) ([]string, error) {
 				fmt.Errorf("got parser errors for %s", inputFile)
 		}
 
-		c := compiler.New(tUnit)
+		checker := sema.New(tUnit)
+		checker.Warnings = warnings
+		info := checker.Check()
+		if !checkSemaErrors(checker) {
+			return asmFiles,
+				fmt.Errorf("got semantic errors for %s", inputFile)
+		}
+
+		c := compiler.New(tUnit, info)
+		c.Passes = passes()
+		c.Peephole = optLevel > 0
+		c.SiblingCalls = optLevel > 1
+		if siblingCalls != nil {
+			c.SiblingCalls = *siblingCalls
+		}
 		c.Compile()
 
-		if !checkCompilerErrors(c) {
+		if !checkCompilerErrors(inputFile, c) {
 			return asmFiles,
 				fmt.Errorf("got compiler errors for %s", inputFile)
 		}
@@ -155,12 +320,60 @@ func link(outputFile string, objFiles ...string) error {
 
 func main() {
 	log.SetFlags(0)
-	flag.Parse()
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		os.Exit(formatFiles(os.Args[2:]))
+	}
+
+	args, err := parseOptions(os.Args[1:])
+	if err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	flag.CommandLine.Parse(args)
+
 	if flag.NArg() == 0 {
 		fmt.Println("No input files given.")
 		os.Exit(1)
 	}
 
+	if err := setupDiagnostics(); err != nil {
+		log.Print(err)
+		os.Exit(1)
+	}
+	defer diagnostics.Flush()
+
+	if *dumpTokensFlag {
+		if err := dumpTokens(flag.Args()...); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+
+	if dumpFormatFlag != "" {
+		if err := dumpAST(dumpFormatFlag, flag.Args()...); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+
+	if *dumpIRFlag {
+		if err := dumpIR(flag.Args()...); err != nil {
+			log.Print(err)
+		}
+		return
+	}
+
+	if err := build(flag.Args()...); err != nil {
+		log.Print(err)
+		// exiting skips the deferred calls
+		diagnostics.Flush()
+		os.Exit(1)
+	}
+}
+
+/* Compile, assemble and link the source files into a.out, removing the
+ * intermediate files afterwards */
+func build(sourceFiles ...string) error {
 	var asmFiles, objFiles []string
 	defer func() {
 		for _, f := range append(asmFiles, objFiles...) {
@@ -171,21 +384,15 @@ func main() {
 		}
 	}()
 
-	asmFiles, err := compile("", flag.Args()...)
+	asmFiles, err := compile("", sourceFiles...)
 	if err != nil {
-		log.Print(err)
-		return
+		return err
 	}
 
 	objFiles, err = assemble("", asmFiles...)
 	if err != nil {
-		log.Print(err)
-		return
+		return err
 	}
 
-	err = link("", objFiles...)
-	if err != nil {
-		log.Print(err)
-		return
-	}
+	return link("", objFiles...)
 }
@@ -0,0 +1,48 @@
+int main() {
+	int x = 1;
+	int result = 0;
+	long n = 4;
+
+	{
+		int x = 2;
+		long y = x * 10;
+		{
+			char x = 3;
+			result = result + x;
+		}
+		result = result + x + y;
+	}
+
+	{
+		int z = 5;
+		int vla[n];
+		vla[3] = z;
+		result = result + vla[3];
+		{
+			int n = 100;
+			result = result + n;
+		}
+	}
+
+	{
+		int w;
+		w = x + n;
+		result = result + w;
+	}
+
+	/* blocks next to each other can share their stack slots */
+	{
+		long a[100];
+		a[0] = 7;
+		a[99] = a[0] + 1;
+		result = result + a[99];
+	}
+	{
+		long b[100];
+		b[0] = 9;
+		b[99] = b[0];
+		result = result + b[99] + x;
+	}
+
+	return result != 3 + 2 + 20 + 5 + 100 + 5 + 8 + 10;
+}
@@ -0,0 +1,38 @@
+long sum8(long a, long b, long c, long d, long e, long f, long g, long h) {
+	return a + 2 * b + 3 * c + 4 * d + 5 * e + 6 * f + 7 * g + 8 * h;
+}
+
+int id(int x) { return x; }
+
+int pressure(int a, int b, int c) {
+	int v1 = a + 1;
+	int v2 = b + 2;
+	int v3 = c + 3;
+	int v4 = a * b;
+	int v5 = b * c;
+	int v6 = c * a;
+	int v7 = v1 + v2;
+	int v8 = v3 + v4;
+	int v9 = v5 + v6;
+	int v10 = v7 * 2;
+	int v11 = v8 * 3;
+	int v12 = v9 * 4;
+	int v13 = id(v1) + id(v2);
+	int v14 = v10 / v3 + v11 % v2;
+	int v15 = v12 << v1;
+	int v16 = v12 >> v2;
+
+	return v1 + v2 + v3 + v4 + v5 + v6 + v7 + v8 + v9 + v10 + v11 + v12
+		+ v13 + v14 + v15 + v16;
+}
+
+int main() {
+	int x = 7;
+	long big = sum8(1, 2, 3, 4, 5, 6, 7, 8);
+	int deep = ((x + 1) * (x + 2) - (x + 3) * (x + 4)) / ((x - 5) * (x - 6))
+		+ ((x << 2) + (x >> 1)) % (x - 4) + id(id(x) + id(id(x) * 2));
+
+	return (big != 204) + (deep != -19 + 1 + 21)
+		+ (pressure(1, 2, 3) != 2 + 4 + 6 + 2 + 6 + 3 + 6 + 8 + 9 + 12 + 24 + 36
+			+ 6 + 2 + 144 + 2);
+}
@@ -0,0 +1,16 @@
+int main() {
+	int a[(1 << 4) - 6 * 2];
+	char b[sizeof(long) % 5 + (3 > 2) + !0];
+	long l = 4294967296 >> 4;
+	int m = -7 / 2 + -7 % 2;
+	int t = 0 ? 99 : 7;
+	int bits = (12 & 10) + (12 ^ 10) + ~5 + (12 | 3);
+	unsigned int u = sizeof(int) - 5;
+	int shifted = -16 >> 2;
+	int logic = (1 && 0) + (0 || 2);
+	int n = 5;
+	int x = n * (2 + 3) - (n << 1);
+
+	return sizeof a + sizeof b + (l == 268435456) + m + t + bits + (u > 100) +
+		(u == 4294967295) + shifted + logic + x != 16 + 5 + 1 - 4 + 7 + 23 + 1 + 1 - 4 + 1 + 15;
+}
@@ -0,0 +1,13 @@
+int twice(int n) {
+	return n * 2;
+	n = n + 1;
+	return n;
+}
+
+int main() {
+	int unused[4];
+	int x = 3;
+	unused[1] = x;
+	return twice(x) != 6;
+	x = 5;
+}
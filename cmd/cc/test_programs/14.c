@@ -0,0 +1,21 @@
+
+long scale(long i, long j) { return i * 3 + j * 8 - 5; }
+
+int pick(int a, int b) { return a > b ? a - b : b << 2; }
+
+int zero(int *p) {
+	p[0] = 0;
+	p[1] = 100000;
+	return p[0] + p[1];
+}
+
+unsigned mix(unsigned a, unsigned b) { return (a / b) + (a % b) * 9 + (a >> 3); }
+
+int main() {
+	int xs[2];
+	long s = scale(4, 5);
+	int n = zero(xs);
+	int r = pick(9, 3) + pick(1, 2) + pick(7, 0) + pick(3, 0);
+	unsigned m = mix(1000, 7);
+	return (s != 47) + (n != 100000) + (r != 6 + 8 + 7 + 3) + (m != 142 + 54 + 125);
+}
@@ -0,0 +1,31 @@
+struct point {
+	int x;
+	int y;
+};
+
+static inline int get_x(struct point *p) { return p->x; }
+static inline int get_y(struct point *p) { return p->y; }
+
+static inline void set(struct point *p, int x, int y) {
+	p->x = x;
+	p->y = y;
+}
+
+static int dot(struct point *a, struct point *b) {
+	return get_x(a) * get_x(b) + get_y(a) * get_y(b);
+}
+
+static int sign(int n) { return n < 0 ? -1 : n > 0; }
+
+static int fact(int n) { return n <= 1 ? 1 : n * fact(n - 1); }
+
+int (*pointer(void))(int) { return sign; }
+
+int main() {
+	struct point a, b;
+	set(&a, 3, 4);
+	set(&b, -2, 5);
+	int d = dot(&a, &b);
+	int s = sign(d) + sign(-d) + sign(0) + pointer()(-7);
+	return (d != 14) + (s != -1) + (fact(5) != 120);
+}
@@ -0,0 +1,117 @@
+
+// the multiplication by k doesn't change in the loop
+long sum_scaled(long *xs, long n, long k) {
+	long sum = 0;
+	for (long i = 0; i < n; i = i + 1) {
+		sum = sum + xs[i] * (k * 3);
+	}
+	return sum;
+}
+
+// a small constant trip count
+int unrolled() {
+	int a[4];
+	for (int i = 0; i < 4; i = i + 1)
+		a[i] = i * 5;
+	return a[0] + a[1] + a[2] + a[3];
+}
+
+int collatz(int n) {
+	int steps = 0;
+	while (n != 1) {
+		if (n % 2 == 0)
+			n = n / 2;
+		else
+			n = 3 * n + 1;
+		steps = steps + 1;
+	}
+	return steps;
+}
+
+// the first multiple of m that is at least n, but not more than limit
+int first_multiple(int n, int m, int limit) {
+	int i = n;
+	for (;;) {
+		if (i > limit)
+			return -1;
+		if (i % m == 0)
+			break;
+		i = i + 1;
+	}
+	return i;
+}
+
+int sum_odd(int n) {
+	int sum = 0;
+	int i = 0;
+	do {
+		i = i + 1;
+		if (i % 2 == 0)
+			continue;
+		sum = sum + i;
+	} while (i < n);
+	return sum;
+}
+
+int grid(int rows, int cols) {
+	int total = 0;
+	for (int r = 0; r < rows; r = r + 1) {
+		for (int c = 0; c < cols; c = c + 1) {
+			if (c > r)
+				break;
+			total = total + r * 10 + c;
+		}
+	}
+	return total;
+}
+
+// the arrays are released every iteration, and when the loop is left early
+int vla_loop(int n) {
+	int sum = 0;
+	for (int i = 1; i < 1000; i = i + 1) {
+		int buf[i];
+		buf[i - 1] = i;
+		if (i == n)
+			break;
+		sum = sum + buf[i - 1];
+	}
+	return sum;
+}
+
+int classify(int x) {
+	if (x < 0)
+		return 1;
+	else if (x == 0)
+		return 2;
+	else if (x < 10) {
+		return 3;
+	}
+	return 4;
+}
+
+int main() {
+	long xs[5];
+	for (int i = 0; i < 5; i = i + 1)
+		xs[i] = i + 1;
+
+	int fails = 0;
+	if (sum_scaled(xs, 5, 2) != 90)
+		fails = fails + 1;
+	if (unrolled() != 30)
+		fails = fails + 1;
+	if (collatz(27) != 111)
+		fails = fails + 1;
+	if (first_multiple(10, 7, 100) != 14)
+		fails = fails + 1;
+	if (first_multiple(10, 70, 50) != -1)
+		fails = fails + 1;
+	if (sum_odd(10) != 25)
+		fails = fails + 1;
+	if (grid(4, 3) != 0 + 10 + 11 + 20 + 21 + 22 + 30 + 31 + 32)
+		fails = fails + 1;
+	if (vla_loop(100) != 4950)
+		fails = fails + 1;
+	if (classify(-5) + classify(0) + classify(7) + classify(12) != 10)
+		fails = fails + 1;
+	return fails;
+}
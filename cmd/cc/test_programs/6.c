@@ -0,0 +1,41 @@
+struct point {
+	int x;
+	int y;
+};
+
+struct mixed {
+	char c;
+	long l;
+	short s;
+};
+
+union both {
+	int i;
+	char c[5];
+};
+
+struct aligned {
+	char c;
+	_Alignas(16) int i;
+};
+
+int main() {
+	int n = 3;
+	int m = 4;
+	int vla[n][m];
+	m = 5; // the size of vla was fixed when it was declared
+	_Alignas(16) char buf[3];
+	struct point p;
+	long total = sizeof(int) - 4 + sizeof(char) - 1 + sizeof(long) - 8
+		+ sizeof(struct point) - 8 + sizeof p - 8
+		+ sizeof(struct mixed) - 24 + _Alignof(struct mixed) - 8
+		+ sizeof(union both) - 8 + _Alignof(union both) - 4
+		+ sizeof(struct aligned) - 32 + _Alignof(struct aligned) - 16
+		+ sizeof(int[3][4]) - 48 + sizeof(int (*)[3]) - 8
+		+ sizeof(char *[3]) - 24 + sizeof buf - 3
+		+ sizeof vla - 48 + sizeof(int[n]) - 12
+		+ sizeof vla[0] - 16 + sizeof *vla - 16 + sizeof(int[m]) - 20
+		+ sizeof(struct point *) - 8;
+
+	return total + sizeof total - 8;
+}
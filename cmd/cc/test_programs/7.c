@@ -0,0 +1,14 @@
+int main() {
+	int x = 3;
+	int y = 10;
+	int evaluated = 0;
+	int z = x > 2 ? y - 1 : (evaluated = 1);
+	long big = x < 2 ? 1 : 0;
+	int nested = x == 3 ? y != 10 ? 100 : 7 : 200;
+	int a = 0, b = (a = 4, a * 2);
+	int c = (x = 5, y = x + 1, y);
+
+	return (evaluated != 0) + (z != 9) + (nested != 7) + (a != 4)
+		+ (b != 8) + (c != 6) + (x != 5) + (1 ? 0 : 1)
+		+ (x <= 5 ? 0 : 1) + (x >= 6 ? 1 : 0);
+}
@@ -0,0 +1,44 @@
+int snprintf(char *str, unsigned long size, const char *format, ...);
+int vsnprintf(char *str, unsigned long size, const char *format, va_list ap);
+
+int sum(int count, ...) {
+	va_list ap;
+	va_list copy;
+	int total;
+
+	va_start(ap, count);
+	va_copy(copy, ap);
+	total = va_arg(ap, int) + va_arg(ap, int) + va_arg(ap, int)
+		+ va_arg(ap, int) + va_arg(ap, int) + va_arg(ap, int)
+		+ va_arg(ap, int) + va_arg(ap, int);
+	total = total * 10 + va_arg(copy, int);
+	va_end(copy);
+	va_end(ap);
+
+	return count == 8 ? total : -1;
+}
+
+static int format(char *buf, unsigned long size, const char *fmt, ...) {
+	va_list ap;
+	int n;
+
+	va_start(ap, fmt);
+	n = vsnprintf(buf, size, fmt, ap);
+	va_end(ap);
+
+	return n;
+}
+
+static long second(long a, long b, long c, long d, long e, long f, long g, long h) {
+	return h - g;
+}
+
+int main() {
+	char buf[32];
+	long seven = 7;
+
+	return (sum(8, 1, 2, 3, 4, 5, 6, 7, 8) != 361)
+		+ (format(buf, sizeof buf, "%d-%s-%ld", 42, "x", seven) != 6)
+		+ (snprintf(buf, 32, "%c%c", 111, 107) != 2)
+		+ (second(1, 2, 3, 4, 5, 6, 7, 9) != 2);
+}
@@ -0,0 +1,42 @@
+int abs(int x);
+
+struct plugin {
+	int id;
+	int (*run)(int, int);
+};
+
+static int add(int a, int b) { return a + b; }
+static int sub(int a, int b) { return a - b; }
+static int mul(int a, int b) { return a * b; }
+
+int apply(int (*op)(int, int), int a, int b) {
+	return op(a, b);
+}
+
+static int (*pick(int which))(int, int) {
+	return which ? sub : add;
+}
+
+int main() {
+	int (*table[3])(int, int);
+	int (*fp)(int, int) = add;
+	int (*neg)(int) = abs;
+	struct plugin plugins[2];
+	struct plugin *p = &plugins[1];
+	int i = 2;
+
+	table[0] = add;
+	table[1] = &sub;
+	table[2] = mul;
+
+	plugins[0].id = 1;
+	plugins[0].run = table[2];
+	p->id = 2;
+	p->run = pick(1);
+
+	return (fp(2, 3) != 5) + ((*fp)(4, 4) != 8) + (table[i](3, 4) != 12)
+		+ (table[1](10, 3) != 7) + (apply(mul, 6, 7) != 42)
+		+ (plugins[0].run(plugins[0].id, 5) != 5) + (p->run(9, p->id) != 7)
+		+ (pick(0)(1, 1) != 2) + (neg(-5) != 5) + (fp == add ? 0 : 1)
+		+ (apply(fp, 1, 2) != 3) + (fp != table[1] ? 0 : 1);
+}
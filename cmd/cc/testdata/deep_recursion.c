@@ -0,0 +1,32 @@
+/* Recursion millions of calls deep, which only fits on the stack if the
+   calls in tail position reuse the stack frame of their caller */
+
+static long count(long n, long acc) { return n == 0 ? acc : count(n - 1, acc + 1); }
+
+int even(long n);
+int odd(long n) { return n == 0 ? 0 : even(n - 1); }
+int even(long n) { return n == 0 ? 1 : odd(n - 1); }
+
+/* the arguments after the sixth are passed on the stack */
+long rotate(long n, long a, long b, long c, long d, long e, long f, long g) {
+	return n == 0 ? a * 1000000 + b * 100000 + c * 10000 + d * 1000 + e * 100 + f * 10 + g
+		: rotate(n - 1, b, c, d, e, f, g, a);
+}
+
+long back(long n, long a, long b, long c, long d, long e, long f, long g);
+long forth(long n, long a, long b, long c, long d, long e, long f, long g) {
+	return n == 0 ? a + g : back(n - 1, a + 1, b, c, d, e, f, g + 2);
+}
+long back(long n, long a, long b, long c, long d, long e, long f, long g) {
+	return n == 0 ? a + g : forth(n - 1, a, b, c, d, e, f, g - 1);
+}
+
+/* more arguments on the stack than it was given, so this is a call */
+long wider(long n) { return rotate(n, 1, 2, 3, 4, 5, 6, 7); }
+
+int main() {
+	long n = 3000000;
+	return (count(n, 0) != n) + (even(n) != 1) + (odd(n) != 0) +
+		(rotate(n + 3, 1, 2, 3, 4, 5, 6, 7) != 7123456) + (forth(n, 0, 0, 0, 0, 0, 0, 0) != n) +
+		(wider(8) != 2345671);
+}
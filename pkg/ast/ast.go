@@ -1,12 +1,40 @@
 package ast
 
+import "github.com/tjarjoura/cc/pkg/token"
+
 type Node interface {
 	String() string
+	Pos() token.Pos // the first character of the node
+	End() token.Pos // just past the last character of the node
+	SetSpan(start token.Pos, stop token.Pos)
+}
+
+// The source range of a node. Nodes that the compiler makes up, like
+// implicit conversions and the builtin types, have an invalid span.
+type Span struct {
+	Start token.Pos
+	Stop  token.Pos
+}
+
+func (s *Span) Pos() token.Pos { return s.Start }
+func (s *Span) End() token.Pos { return s.Stop }
+
+func (s *Span) SetSpan(start token.Pos, stop token.Pos) {
+	s.Start, s.Stop = start, stop
 }
 
 type TranslationUnit struct {
-	Node
+	Span
 	DeclarationStatements []*DeclarationStatement
+	Comments              []*Comment // in the order they appear in the source
+}
+
+func (t *TranslationUnit) String() string { return Format(t) }
+
+// A "// line" or "/* block */" comment, Text includes the delimiters
+type Comment struct {
+	Span
+	Text string
 }
 
-func (t *TranslationUnit) String() string { return "" } //TODO
+func (c *Comment) String() string { return c.Text }
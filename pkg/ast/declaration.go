@@ -3,8 +3,9 @@ package ast
 import (
 	"bytes"
 	"fmt"
-	"reflect"
 	"strings"
+
+	"github.com/tjarjoura/cc/pkg/token"
 )
 
 type Declaration interface {
@@ -15,6 +16,7 @@ type Declaration interface {
 }
 
 type Pointer struct {
+	Span
 	PointsTo Declaration
 	Const    bool
 	Volatile bool
@@ -54,6 +56,7 @@ func (p *Pointer) SetType(decl Declaration) {
 }
 
 type Array struct {
+	Span
 	ArrayOf   Declaration
 	ArraySize Expression
 }
@@ -82,10 +85,12 @@ func (a *Array) SetType(decl Declaration) {
 }
 
 type BaseType struct {
+	Span
 	Name     string // "int" or "long long int" or custom typedef identifier "uint8_t" etc..
 	Const    bool
 	Volatile bool
 	Signed   bool
+	Unsigned bool
 }
 
 func (t *BaseType) declarationNode() {}
@@ -112,13 +117,75 @@ func (t *BaseType) Type() Declaration {
 
 func (t *BaseType) SetType(d Declaration) {} // no op
 
-type StructOrUnionSpecification struct{} //TODO
+type StructOrUnionSpecification struct {
+	Span
+	Kind     string                 // "struct" or "union"
+	Tag      string                 // empty for anonymous structs and unions
+	Members  []*VariableDeclaration // nil unless this specifier defines the members
+	Const    bool
+	Volatile bool
+
+	// Every specifier with the same tag in the same scope points to the
+	// same Definition, which holds the members once they are known
+	Definition *StructOrUnionSpecification
+}
+
+func (s *StructOrUnionSpecification) declarationNode() {}
+
+func (s *StructOrUnionSpecification) String() string {
+	var out bytes.Buffer
+
+	if s.Const {
+		out.WriteString("const ")
+	}
+
+	if s.Volatile {
+		out.WriteString("volatile ")
+	}
+
+	out.WriteString(s.Kind)
+	if s.Tag != "" {
+		out.WriteString(" ")
+		out.WriteString(s.Tag)
+	}
+
+	if s.Members != nil {
+		out.WriteString(" {")
+		for _, member := range s.Members {
+			out.WriteString(" ")
+			out.WriteString(member.String())
+			out.WriteString(";")
+		}
+		out.WriteString(" }")
+	}
+
+	return out.String()
+}
+
+func (s *StructOrUnionSpecification) Type() Declaration {
+	return nil
+}
+
+func (s *StructOrUnionSpecification) SetType(d Declaration) {} // no op
+
+// Fields returns the members of the struct or union, or nil if it is incomplete
+func (s *StructOrUnionSpecification) Fields() []*VariableDeclaration {
+	if s.Definition != nil {
+		return s.Definition.Members
+	}
+
+	return s.Members
+}
 
 type FunctionDeclaration struct {
+	Span
 	Name         string
+	NamePos      token.Pos // where the name is declared, invalid for types
 	StorageClass string
+	Inline       bool // declared with the inline function specifier
 	ReturnType   Declaration
 	Parameters   []Declaration
+	Variadic     bool // the parameter list ends with "..."
 	Body         *BlockStatement
 }
 
@@ -129,6 +196,10 @@ func (f *FunctionDeclaration) String() string {
 		paramTypes = append(paramTypes, param.String())
 	}
 
+	if f.Variadic {
+		paramTypes = append(paramTypes, "...")
+	}
+
 	return fmt.Sprintf("%v %s(%s)", f.ReturnType.String(), f.Name,
 		strings.Join(paramTypes, ", "))
 }
@@ -136,10 +207,13 @@ func (f *FunctionDeclaration) Type() Declaration     { return f.ReturnType }
 func (f *FunctionDeclaration) SetType(d Declaration) { f.ReturnType = d }
 
 type VariableDeclaration struct {
+	Span
 	Name         string
+	NamePos      token.Pos
 	StorageClass string
 	VarType      Declaration
 	Definition   Expression
+	AlignAs      Expression // from _Alignas, nil if not given
 }
 
 func (v *VariableDeclaration) declarationNode() {}
@@ -155,31 +229,13 @@ func (v *VariableDeclaration) String() string {
 func (v *VariableDeclaration) Type() Declaration     { return v.VarType }
 func (v *VariableDeclaration) SetType(d Declaration) { v.VarType = d }
 
-func ConvertError(to Declaration, from Declaration) bool {
-	validCombinations := [][]string{
-		[]string{"Array", "Pointer"},
-		[]string{"Array", "BaseType"},
-		[]string{"BaseType", "Pointer"},
-	}
-
-	toType, fromType := reflect.TypeOf(to).Name(), reflect.TypeOf(from).Name()
-
-	if toType == fromType {
-		// TODO handle struct, union, and typedef types
-		return false
-	}
-
-	for _, combo := range validCombinations {
-		if (toType == combo[0] && fromType == combo[1]) ||
-			(toType == combo[1] && fromType == combo[0]) {
-			return true
-		}
-	}
-
-	return false
+// A declaration that could not be parsed, covering the tokens that were
+// skipped
+type BadDeclaration struct {
+	Span
 }
 
-func ConvertWarn(to Declaration, from Declaration) bool {
-	// TODO implement
-	return false
-}
+func (b *BadDeclaration) declarationNode()      {}
+func (b *BadDeclaration) String() string        { return "<bad declaration>" }
+func (b *BadDeclaration) Type() Declaration     { return nil }
+func (b *BadDeclaration) SetType(d Declaration) {} // no op
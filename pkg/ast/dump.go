@@ -0,0 +1,264 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+// TypeOf gives the type and value category of an expression as the semantic
+// checker worked them out, or a nil type if it does not know
+type TypeOf func(e Expression) (Declaration, string)
+
+// DumpTree writes the node and everything below it as an indented tree, one
+// node per line with its kind, source range and details like the name,
+// type and operator. If typeOf is not nil the expressions show their types.
+func DumpTree(w io.Writer, node Node, typeOf TypeOf) error {
+	d := &dumper{typeOf: typeOf, seen: map[*StructOrUnionSpecification]bool{}}
+	var out strings.Builder
+	writeTree(&out, d.node(node), "", "")
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// DumpJSON writes the same tree as DumpTree as a JSON object, with the
+// children of each node in "inner"
+func DumpJSON(w io.Writer, node Node, typeOf TypeOf) error {
+	d := &dumper{typeOf: typeOf, seen: map[*StructOrUnionSpecification]bool{}}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d.node(node))
+}
+
+type dumpPos struct {
+	File   string `json:"file,omitempty"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type dumpNode struct {
+	Kind         string      `json:"kind"`
+	Begin        *dumpPos    `json:"begin,omitempty"`
+	End          *dumpPos    `json:"end,omitempty"`
+	Name         string      `json:"name,omitempty"`
+	Type         string      `json:"type,omitempty"`
+	Category     string      `json:"valueCategory,omitempty"`
+	StorageClass string      `json:"storageClass,omitempty"`
+	Inline       bool        `json:"inline,omitempty"`
+	Operator     string      `json:"operator,omitempty"`
+	Value        string      `json:"value,omitempty"`
+	Conversion   string      `json:"conversion,omitempty"`
+	Inner        []*dumpNode `json:"inner,omitempty"`
+}
+
+type dumper struct {
+	typeOf TypeOf
+
+	// struct and union definitions that were dumped already, so that the
+	// members of "struct s { ... } a, b;" are only shown once
+	seen map[*StructOrUnionSpecification]bool
+}
+
+func position(pos token.Pos) *dumpPos {
+	if !pos.IsValid() {
+		return nil
+	}
+	return &dumpPos{File: pos.File, Line: pos.Line, Column: pos.Column}
+}
+
+func (d *dumper) node(node Node) *dumpNode {
+	n := &dumpNode{
+		Kind:  strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast."),
+		Begin: position(node.Pos()),
+		End:   position(node.End()),
+	}
+
+	switch x := node.(type) {
+	case *TranslationUnit:
+		for _, declStmt := range x.DeclarationStatements {
+			d.add(n, declStmt)
+		}
+	case *DeclarationStatement:
+		for _, decl := range x.Declarations {
+			d.add(n, decl)
+		}
+	case *BlockStatement:
+		for _, stmt := range x.Statements {
+			d.add(n, stmt)
+		}
+	case *ExpressionStatement:
+		d.add(n, x.Expression)
+	case *ReturnStatement:
+		if x.ReturnValue != nil {
+			d.add(n, x.ReturnValue)
+		}
+	case *IfStatement:
+		d.add(n, x.Condition, x.Consequence)
+		if x.Alternative != nil {
+			d.add(n, x.Alternative)
+		}
+	case *WhileStatement:
+		d.add(n, x.Condition, x.Body)
+	case *DoWhileStatement:
+		d.add(n, x.Body, x.Condition)
+	case *ForStatement:
+		// the clauses that are left out are not shown, like in "for (;;)"
+		if x.Init != nil {
+			d.add(n, x.Init)
+		}
+		if x.Condition != nil {
+			d.add(n, x.Condition)
+		}
+		if x.Post != nil {
+			d.add(n, x.Post)
+		}
+		d.add(n, x.Body)
+	case *BreakStatement, *ContinueStatement, *BadStatement, *BadDeclaration:
+	case *VariableDeclaration:
+		n.Name, n.Type, n.StorageClass = x.Name, TypeString(x), x.StorageClass
+		d.definition(n, x)
+		if x.AlignAs != nil {
+			d.add(n, x.AlignAs)
+		}
+		if x.Definition != nil {
+			d.add(n, x.Definition)
+		}
+	case *FunctionDeclaration:
+		n.Name, n.Type, n.StorageClass = x.Name, TypeString(x), x.StorageClass
+		n.Inline = x.Inline
+		d.definition(n, x.ReturnType)
+		for _, param := range x.Parameters {
+			d.add(n, param)
+		}
+		if x.Body != nil {
+			d.add(n, x.Body)
+		}
+	case *StructOrUnionSpecification:
+		n.Name, n.Type = x.Tag, TypeString(x)
+		if x.Members != nil && !d.seen[x] {
+			d.seen[x] = true
+			for _, member := range x.Members {
+				d.add(n, member)
+			}
+		}
+	case Declaration:
+		// a type name, like in sizeof(int *) or an unnamed parameter
+		n.Type = TypeString(x)
+		d.definition(n, x)
+	case Expression:
+		d.expression(n, x)
+	}
+
+	return n
+}
+
+func (d *dumper) add(n *dumpNode, children ...Node) {
+	for _, child := range children {
+		n.Inner = append(n.Inner, d.node(child))
+	}
+}
+
+// A struct or union that is defined in the type of a declaration is shown
+// as its first child
+func (d *dumper) definition(n *dumpNode, decl Declaration) {
+	s, ok := baseType(decl).(*StructOrUnionSpecification)
+	if ok && s.Members != nil && !d.seen[s] {
+		n.Inner = append(n.Inner, d.node(s))
+	}
+}
+
+func (d *dumper) expression(n *dumpNode, e Expression) {
+	if d.typeOf != nil {
+		if t, category := d.typeOf(e); t != nil {
+			n.Type, n.Category = TypeString(t), category
+		}
+	}
+
+	switch x := e.(type) {
+	case *PrefixExpression:
+		n.Operator = x.Operator
+		d.add(n, x.Right)
+	case *InfixExpression:
+		n.Operator = x.Operator
+		d.add(n, x.Left, x.Right)
+	case *ConditionalExpression:
+		d.add(n, x.Condition, x.Consequence, x.Alternative)
+	case *Identifier:
+		n.Name = x.Value
+	case *IntegerLiteral:
+		n.Value = x.Token.Literal
+	case *FloatLiteral:
+		n.Value = x.Token.Literal
+	case *StringLiteral:
+		n.Value = quote(x.Value)
+	case *SizeofExpression:
+		if x.TypeName != nil {
+			d.add(n, x.TypeName)
+		} else {
+			d.add(n, x.Right)
+		}
+	case *AlignofExpression:
+		d.add(n, x.TypeName)
+	case *CallExpression:
+		d.add(n, x.Function)
+		for _, arg := range x.Arguments {
+			d.add(n, arg)
+		}
+	case *VaArgExpression:
+		d.add(n, x.VaList, x.TypeName)
+	case *IndexExpression:
+		d.add(n, x.Left, x.Index)
+	case *MemberExpression:
+		n.Name, n.Operator = x.Member, "."
+		if x.Arrow {
+			n.Operator = "->"
+		}
+		d.add(n, x.Left)
+	case *ImplicitConversion:
+		n.Conversion = string(x.Kind)
+		d.add(n, x.Expression)
+	}
+}
+
+// Like clang's -ast-dump:
+//
+//	TranslationUnit <1:1, 3:2>
+//	`-DeclarationStatement <1:1, 3:2>
+//	  `-FunctionDeclaration <1:1, 3:2> main 'int (void)'
+func writeTree(out *strings.Builder, n *dumpNode, prefix string, childPrefix string) {
+	out.WriteString(prefix + n.Kind)
+	if n.Begin != nil && n.End != nil {
+		fmt.Fprintf(out, " <%d:%d, %d:%d>", n.Begin.Line, n.Begin.Column,
+			n.End.Line, n.End.Column)
+	}
+
+	var inline string
+	if n.Inline {
+		inline = "inline"
+	}
+	for _, detail := range []string{n.StorageClass, inline, n.Operator, n.Name} {
+		if detail != "" {
+			out.WriteString(" " + detail)
+		}
+	}
+	if n.Type != "" {
+		out.WriteString(" '" + n.Type + "'")
+	}
+	for _, detail := range []string{n.Category, n.Conversion, n.Value} {
+		if detail != "" {
+			out.WriteString(" " + detail)
+		}
+	}
+	out.WriteString("\n")
+
+	for i, child := range n.Inner {
+		if i == len(n.Inner)-1 {
+			writeTree(out, child, childPrefix+"`-", childPrefix+"  ")
+		} else {
+			writeTree(out, child, childPrefix+"|-", childPrefix+"| ")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package ast_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/sema"
+)
+
+const dumpInput = `struct s { int a; } v;
+int f(int n) {
+	return v.a + n;
+}
+`
+
+func TestDumpTree(t *testing.T) {
+	tUnit := parse(t, dumpInput)
+	info := sema.New(tUnit).Check()
+	typeOf := func(e ast.Expression) (ast.Declaration, string) {
+		tv := info.Types[e]
+		return tv.Type, tv.Category.String()
+	}
+
+	expected := "TranslationUnit <1:1, 5:1>\n" +
+		"|-DeclarationStatement <1:1, 1:23>\n" +
+		"| `-VariableDeclaration <1:1, 1:22> v 'struct s'\n" +
+		"|   `-StructOrUnionSpecification <1:1, 1:20> s 'struct s'\n" +
+		"|     `-VariableDeclaration <1:12, 1:17> a 'int'\n" +
+		"`-DeclarationStatement <2:1, 4:2>\n" +
+		"  `-FunctionDeclaration <2:1, 4:2> f 'int(int)'\n" +
+		"    |-VariableDeclaration <2:7, 2:12> n 'int'\n" +
+		"    `-BlockStatement <2:14, 4:2>\n" +
+		"      `-ReturnStatement <3:2, 3:17>\n" +
+		"        `-InfixExpression <3:9, 3:16> + 'int' rvalue\n" +
+		"          |-MemberExpression <3:9, 3:12> . a 'int' lvalue\n" +
+		"          | `-Identifier <3:9, 3:10> v 'struct s' lvalue\n" +
+		"          `-Identifier <3:15, 3:16> n 'int' lvalue\n"
+
+	var out strings.Builder
+	if err := ast.DumpTree(&out, tUnit, typeOf); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+// The clauses of a for statement that are left out aren't shown
+func TestDumpStatements(t *testing.T) {
+	tUnit := parse(t, "int f(int n) {\n\tfor (int i = 0; ; i = i + 1)\n\t\tif (i == n)\n\t\t\tbreak;\n}\n")
+
+	expected := "TranslationUnit <1:1, 6:1>\n" +
+		"`-DeclarationStatement <1:1, 5:2>\n" +
+		"  `-FunctionDeclaration <1:1, 5:2> f 'int(int)'\n" +
+		"    |-VariableDeclaration <1:7, 1:12> n 'int'\n" +
+		"    `-BlockStatement <1:14, 5:2>\n" +
+		"      `-ForStatement <2:2, 4:10>\n" +
+		"        |-DeclarationStatement <2:7, 2:17>\n" +
+		"        | `-VariableDeclaration <2:7, 2:16> i 'int'\n" +
+		"        |   `-IntegerLiteral <2:15, 2:16> 0\n" +
+		"        |-InfixExpression <2:20, 2:29> =\n" +
+		"        | |-Identifier <2:20, 2:21> i\n" +
+		"        | `-InfixExpression <2:24, 2:29> +\n" +
+		"        |   |-Identifier <2:24, 2:25> i\n" +
+		"        |   `-IntegerLiteral <2:28, 2:29> 1\n" +
+		"        `-IfStatement <3:3, 4:10>\n" +
+		"          |-InfixExpression <3:7, 3:13> ==\n" +
+		"          | |-Identifier <3:7, 3:8> i\n" +
+		"          | `-Identifier <3:12, 3:13> n\n" +
+		"          `-BreakStatement <4:4, 4:10>\n"
+
+	var out strings.Builder
+	if err := ast.DumpTree(&out, tUnit, nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestDumpJSON(t *testing.T) {
+	tUnit := parse(t, dumpInput)
+
+	var out strings.Builder
+	if err := ast.DumpJSON(&out, tUnit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	type node struct {
+		Kind  string
+		Name  string
+		Type  string
+		Begin struct{ Line, Column int }
+		Inner []node
+	}
+	var root node
+	if err := json.Unmarshal([]byte(out.String()), &root); err != nil {
+		t.Fatalf("dump is not valid JSON: %s\n%s", err, out.String())
+	}
+
+	f := root.Inner[1].Inner[0]
+	if f.Kind != "FunctionDeclaration" || f.Name != "f" || f.Type != "int(int)" ||
+		f.Begin.Line != 2 || f.Begin.Column != 1 {
+		t.Errorf("wrong function node %+v", f)
+	}
+
+	ret := f.Inner[1].Inner[0].Inner[0]
+	if ret.Kind != "InfixExpression" || ret.Type != "" {
+		t.Errorf("expected an untyped InfixExpression, got %+v", ret)
+	}
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/tjarjoura/cc/pkg/token"
 )
@@ -12,6 +13,7 @@ type Expression interface {
 }
 
 type PrefixExpression struct {
+	Span
 	Token    token.Token
 	Operator string
 	Right    Expression
@@ -23,6 +25,7 @@ func (p *PrefixExpression) String() string {
 }
 
 type InfixExpression struct {
+	Span
 	Token    token.Token
 	Left     Expression
 	Operator string
@@ -34,7 +37,22 @@ func (i *InfixExpression) String() string {
 	return fmt.Sprintf("(%s %s %s)", i.Left.String(), i.Operator, i.Right.String())
 }
 
+type ConditionalExpression struct {
+	Span
+	Token       token.Token
+	Condition   Expression
+	Consequence Expression
+	Alternative Expression
+}
+
+func (c *ConditionalExpression) expressionNode() {}
+func (c *ConditionalExpression) String() string {
+	return fmt.Sprintf("(%s ? %s : %s)", c.Condition.String(),
+		c.Consequence.String(), c.Alternative.String())
+}
+
 type Identifier struct {
+	Span
 	Token token.Token
 	Value string
 }
@@ -43,6 +61,7 @@ func (i *Identifier) expressionNode() {}
 func (i *Identifier) String() string  { return i.Value }
 
 type IntegerLiteral struct {
+	Span
 	Token token.Token
 	Value int64
 }
@@ -51,9 +70,133 @@ func (i *IntegerLiteral) expressionNode() {}
 func (i *IntegerLiteral) String() string  { return i.Token.Literal }
 
 type FloatLiteral struct {
+	Span
 	Token token.Token
 	Value float64
 }
 
 func (fp *FloatLiteral) expressionNode() {}
 func (fp *FloatLiteral) String() string  { return fp.Token.Literal }
+
+type SizeofExpression struct {
+	Span
+	Token    token.Token
+	Right    Expression  // set for sizeof expr
+	TypeName Declaration // set for sizeof(type-name)
+}
+
+func (s *SizeofExpression) expressionNode() {}
+func (s *SizeofExpression) String() string {
+	if s.TypeName != nil {
+		return fmt.Sprintf("sizeof(%s)", s.TypeName.String())
+	}
+
+	return fmt.Sprintf("(sizeof %s)", s.Right.String())
+}
+
+type AlignofExpression struct {
+	Span
+	Token    token.Token
+	TypeName Declaration
+}
+
+func (a *AlignofExpression) expressionNode() {}
+func (a *AlignofExpression) String() string {
+	return fmt.Sprintf("_Alignof(%s)", a.TypeName.String())
+}
+
+type CallExpression struct {
+	Span
+	Token     token.Token
+	Function  Expression
+	Arguments []Expression
+}
+
+func (c *CallExpression) expressionNode() {}
+func (c *CallExpression) String() string {
+	args := []string{}
+	for _, arg := range c.Arguments {
+		args = append(args, arg.String())
+	}
+
+	return fmt.Sprintf("%s(%s)", c.Function.String(), strings.Join(args, ", "))
+}
+
+type StringLiteral struct {
+	Span
+	Token token.Token
+	Value string // with escape sequences already interpreted
+}
+
+func (s *StringLiteral) expressionNode() {}
+func (s *StringLiteral) String() string  { return s.Token.Literal }
+
+// va_arg(ap, type) from <stdarg.h>, which takes a type name so it is not a
+// regular function call
+type VaArgExpression struct {
+	Span
+	Token    token.Token
+	VaList   Expression
+	TypeName Declaration
+}
+
+func (v *VaArgExpression) expressionNode() {}
+func (v *VaArgExpression) String() string {
+	return fmt.Sprintf("va_arg(%s, %s)", v.VaList.String(), v.TypeName.String())
+}
+
+// arr[idx], which is the same as *(arr + idx)
+type IndexExpression struct {
+	Span
+	Token token.Token
+	Left  Expression
+	Index Expression
+}
+
+func (i *IndexExpression) expressionNode() {}
+func (i *IndexExpression) String() string {
+	return fmt.Sprintf("%s[%s]", i.Left.String(), i.Index.String())
+}
+
+// s.m, or p->m if Arrow is set
+type MemberExpression struct {
+	Span
+	Token  token.Token
+	Left   Expression
+	Member string
+	Arrow  bool
+}
+
+func (m *MemberExpression) expressionNode() {}
+func (m *MemberExpression) String() string {
+	if m.Arrow {
+		return fmt.Sprintf("%s->%s", m.Left.String(), m.Member)
+	}
+	return fmt.Sprintf("%s.%s", m.Left.String(), m.Member)
+}
+
+type ConversionKind string
+
+const (
+	ArrayToPointer     ConversionKind = "array to pointer"
+	FunctionToPointer  ConversionKind = "function to pointer"
+	IntegerConversion  ConversionKind = "integer conversion"
+	FloatingConversion ConversionKind = "floating conversion"
+	NullToPointer      ConversionKind = "null to pointer"
+	IntegerToPointer   ConversionKind = "integer to pointer"
+	PointerToInteger   ConversionKind = "pointer to integer"
+	PointerConversion  ConversionKind = "pointer conversion"
+	ToVoid             ConversionKind = "to void"
+)
+
+// A conversion that the semantic checker made explicit. It does not appear in
+// the source, so it prints as the expression being converted.
+type ImplicitConversion struct {
+	Span
+	Kind       ConversionKind
+	Expression Expression
+	To         Declaration
+}
+
+func (i *ImplicitConversion) expressionNode() {}
+func (i *ImplicitConversion) String() string  { return i.Expression.String() }
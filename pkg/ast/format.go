@@ -0,0 +1,95 @@
+package ast
+
+import "strings"
+
+// TypeString formats a type the way it is written in C, like "int (*)[3]" or
+// "char *const *". Diagnostics should use it rather than String, which shows
+// the structure of the declaration.
+func TypeString(d Declaration) string {
+	return typeString(d, "")
+}
+
+// Build the abstract declarator from the outside in. A pointer goes on the
+// left of what we have so far, arrays and functions go on the right, which
+// needs parentheses if there is a pointer there.
+func typeString(d Declaration, declarator string) string {
+	switch t := d.(type) {
+	case *VariableDeclaration:
+		return typeString(t.Type(), declarator)
+	case *Pointer:
+		quals := qualifiers(t.Const, t.Volatile)
+		if quals != "" && declarator != "" {
+			quals += " "
+		}
+		return typeString(t.PointsTo, "*"+quals+declarator)
+	case *Array:
+		size := ""
+		if t.ArraySize != nil {
+			size = t.ArraySize.String()
+		}
+		return typeString(t.ArrayOf, group(declarator)+"["+size+"]")
+	case *FunctionDeclaration:
+		params := []string{}
+		for _, param := range t.Parameters {
+			params = append(params, TypeString(ParameterType(param)))
+		}
+		if t.Variadic {
+			params = append(params, "...")
+		} else if len(params) == 0 {
+			params = append(params, "void")
+		}
+		return typeString(t.ReturnType,
+			group(declarator)+"("+strings.Join(params, ", ")+")")
+	}
+
+	specifier := specifierString(d)
+	if strings.HasPrefix(declarator, "*") || strings.HasPrefix(declarator, "(*") {
+		return specifier + " " + declarator
+	}
+	return specifier + declarator
+}
+
+func group(declarator string) string {
+	if strings.HasPrefix(declarator, "*") {
+		return "(" + declarator + ")"
+	}
+	return declarator
+}
+
+func qualifiers(isConst bool, isVolatile bool) string {
+	quals := []string{}
+	if isConst {
+		quals = append(quals, "const")
+	}
+	if isVolatile {
+		quals = append(quals, "volatile")
+	}
+	return strings.Join(quals, " ")
+}
+
+// The type specifier, with the qualifiers that apply to it
+func specifierString(d Declaration) string {
+	var name string
+	var isConst, isVolatile bool
+	switch t := d.(type) {
+	case *BaseType:
+		name, isConst, isVolatile = t.Name, t.Const, t.Volatile
+		if t.Unsigned {
+			name = "unsigned " + name
+		}
+	case *StructOrUnionSpecification:
+		name, isConst, isVolatile = t.Kind+" "+t.Tag, t.Const, t.Volatile
+		if t.Tag == "" {
+			name = t.Kind + " <anonymous>"
+		}
+	case nil:
+		return "<nil>"
+	default:
+		return d.String()
+	}
+
+	if quals := qualifiers(isConst, isVolatile); quals != "" {
+		return quals + " " + name
+	}
+	return name
+}
@@ -0,0 +1,634 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+// Format prints a node as C source that parses back into the same tree. The
+// output is consistently formatted: declarations and statements go on their
+// own lines indented with tabs, and expressions only get the parentheses
+// they need. The comments of a translation unit go on their own lines before
+// the statement or member that follows them, or at the end of the line they
+// were on.
+func Format(node Node) string {
+	p := &printer{}
+	p.node(node)
+	return p.out.String()
+}
+
+// Fprint writes the formatted node to w
+func Fprint(w io.Writer, node Node) error {
+	_, err := io.WriteString(w, Format(node))
+	return err
+}
+
+type printer struct {
+	out    strings.Builder
+	indent int
+
+	comments []*Comment // the ones that weren't printed yet
+}
+
+func (p *printer) node(node Node) {
+	switch n := node.(type) {
+	case *TranslationUnit:
+		p.translationUnit(n)
+	case Statement:
+		p.statement(n)
+	case Declaration:
+		p.out.WriteString(p.declaration([]Declaration{n}))
+	case Expression:
+		p.out.WriteString(p.expression(n, lowestPrecedence, lowestPrecedence))
+	}
+}
+
+// Function definitions are set apart from the declarations around them by a
+// blank line
+func (p *printer) translationUnit(t *TranslationUnit) {
+	p.comments = t.Comments
+	for i, declStmt := range t.DeclarationStatements {
+		if i > 0 && (isDefinition(declStmt) || isDefinition(t.DeclarationStatements[i-1])) {
+			p.out.WriteString("\n")
+		}
+		p.statement(declStmt)
+	}
+
+	// the ones after the last declaration, or where nothing could be put
+	// next to them
+	for _, comment := range p.comments {
+		p.out.WriteString(comment.Text + "\n")
+	}
+	p.comments = nil
+}
+
+// The comments before pos, each on a line of its own. A blank line that set
+// a comment apart from what follows it is kept.
+func (p *printer) leadingComments(pos token.Pos) string {
+	var out strings.Builder
+	for p.commentsBefore(pos) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		next := pos
+		if p.commentsBefore(pos) {
+			next = p.comments[0].Pos()
+		}
+		out.WriteString(strings.Repeat("\t", p.indent) + comment.Text + "\n")
+		if next.Line > comment.End().Line+1 {
+			out.WriteString("\n")
+		}
+	}
+	return out.String()
+}
+
+// The comments that start on the line that end is on, to go at the end of the
+// line printed for it
+func (p *printer) trailingComments(end token.Pos) string {
+	trailing := ""
+	for len(p.comments) > 0 && end.IsValid() && p.comments[0].Pos().Line <= end.Line {
+		trailing += " " + p.comments[0].Text
+		p.comments = p.comments[1:]
+	}
+	return trailing
+}
+
+func before(a token.Pos, b token.Pos) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+func isDefinition(declStmt *DeclarationStatement) bool {
+	if len(declStmt.Declarations) != 1 {
+		return false
+	}
+
+	fn, ok := declStmt.Declarations[0].(*FunctionDeclaration)
+	return ok && fn.Body != nil
+}
+
+// A line with the node that ends at end, and the comments after it
+func (p *printer) line(s string, end token.Pos) {
+	p.out.WriteString(strings.Repeat("\t", p.indent))
+	p.out.WriteString(s)
+	p.out.WriteString(p.trailingComments(end) + "\n")
+}
+
+// The statement starts on a new line, after the comments before it
+func (p *printer) statement(stmt Statement) {
+	p.out.WriteString(p.leadingComments(stmt.Pos()))
+	switch s := stmt.(type) {
+	case *DeclarationStatement:
+		if len(s.Declarations) == 1 {
+			if fn, ok := s.Declarations[0].(*FunctionDeclaration); ok && fn.Body != nil {
+				p.out.WriteString(strings.Repeat("\t", p.indent))
+				p.out.WriteString(p.declaration(s.Declarations) + " ")
+				p.block(fn.Body)
+				return
+			}
+		}
+		p.line(p.declaration(s.Declarations)+";", s.End())
+	case *BlockStatement:
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.block(s)
+	case *ExpressionStatement:
+		p.line(p.expression(s.Expression, lowestPrecedence, lowestPrecedence)+";", s.End())
+	case *ReturnStatement:
+		if s.ReturnValue == nil {
+			p.line("return;", s.End())
+			return
+		}
+		p.line("return "+p.expression(s.ReturnValue, lowestPrecedence,
+			lowestPrecedence)+";", s.End())
+	case *IfStatement:
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.ifStatement(s)
+	case *WhileStatement:
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.out.WriteString("while (" + p.expression(s.Condition, lowestPrecedence,
+			lowestPrecedence) + ")")
+		p.body(s.Body, false)
+	case *DoWhileStatement:
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.out.WriteString("do")
+		if p.body(s.Body, true); !isBlock(s.Body) {
+			p.out.WriteString(strings.Repeat("\t", p.indent))
+		}
+		p.out.WriteString("while (" + p.expression(s.Condition, lowestPrecedence,
+			lowestPrecedence) + ");" + p.trailingComments(s.End()) + "\n")
+	case *ForStatement:
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+		p.forStatement(s)
+	case *BreakStatement:
+		p.line("break;", s.End())
+	case *ContinueStatement:
+		p.line("continue;", s.End())
+	default:
+		p.line(stmt.String(), stmt.End())
+	}
+}
+
+// The block starts where the output is and ends with a newline
+func (p *printer) block(b *BlockStatement) {
+	p.braces(b)
+	p.out.WriteString(p.trailingComments(b.End()) + "\n")
+}
+
+// Like block, without the newline at the end
+func (p *printer) braces(b *BlockStatement) {
+	if len(b.Statements) == 0 && !p.commentsBefore(b.End()) {
+		p.out.WriteString("{}")
+		return
+	}
+
+	p.out.WriteString("{\n")
+	p.indent++
+	for _, stmt := range b.Statements {
+		p.statement(stmt)
+	}
+	p.out.WriteString(p.leadingComments(b.End()))
+	p.indent--
+	p.out.WriteString(strings.Repeat("\t", p.indent) + "}")
+}
+
+// Whether there are comments left to print before pos
+func (p *printer) commentsBefore(pos token.Pos) bool {
+	return len(p.comments) > 0 && pos.IsValid() && before(p.comments[0].Pos(), pos)
+}
+
+func isBlock(stmt Statement) bool {
+	_, ok := stmt.(*BlockStatement)
+	return ok
+}
+
+// The body of an if, a loop or an else, after what comes before it on the
+// line. A block stays on that line and anything else goes on the next one,
+// indented. If more follows the body, like an else, it goes after the '}' of
+// a block or on a line of its own.
+func (p *printer) body(stmt Statement, more bool) {
+	// an if without an else would take the else that follows as its own
+	if more && danglingIf(stmt) {
+		stmt = &BlockStatement{Statements: []Statement{stmt}}
+	}
+
+	if b, ok := stmt.(*BlockStatement); ok {
+		p.out.WriteString(" ")
+		p.braces(b)
+		if more {
+			p.out.WriteString(" ")
+		} else {
+			p.out.WriteString(p.trailingComments(b.End()) + "\n")
+		}
+		return
+	}
+
+	p.out.WriteString("\n")
+	p.indent++
+	p.statement(stmt)
+	p.indent--
+}
+
+// Whether the statement ends in an if without an else
+func danglingIf(stmt Statement) bool {
+	switch s := stmt.(type) {
+	case *IfStatement:
+		return s.Alternative == nil || danglingIf(s.Alternative)
+	case *WhileStatement:
+		return danglingIf(s.Body)
+	case *ForStatement:
+		return danglingIf(s.Body)
+	}
+	return false
+}
+
+// The statement starts where the output is. An if in the else goes on the
+// same line, like "else if (...)".
+func (p *printer) ifStatement(s *IfStatement) {
+	p.out.WriteString("if (" + p.expression(s.Condition, lowestPrecedence,
+		lowestPrecedence) + ")")
+	p.body(s.Consequence, s.Alternative != nil)
+	if s.Alternative == nil {
+		return
+	}
+
+	if !isBlock(s.Consequence) {
+		p.out.WriteString(strings.Repeat("\t", p.indent))
+	}
+	p.out.WriteString("else")
+	if elseIf, ok := s.Alternative.(*IfStatement); ok {
+		p.out.WriteString(" ")
+		p.ifStatement(elseIf)
+		return
+	}
+	p.body(s.Alternative, false)
+}
+
+// The statement starts where the output is. The clauses that are left out
+// leave their ';' behind, like in "for (;;)".
+func (p *printer) forStatement(s *ForStatement) {
+	head := "for ("
+	switch init := s.Init.(type) {
+	case *DeclarationStatement:
+		head += p.declaration(init.Declarations) + ";"
+	case *ExpressionStatement:
+		head += p.expression(init.Expression, lowestPrecedence, lowestPrecedence) + ";"
+	default:
+		head += ";"
+	}
+	if s.Condition != nil {
+		head += " " + p.expression(s.Condition, lowestPrecedence, lowestPrecedence)
+	}
+	head += ";"
+	if s.Post != nil {
+		head += " " + p.expression(s.Post, lowestPrecedence, lowestPrecedence)
+	}
+
+	p.out.WriteString(head + ")")
+	p.body(s.Body, false)
+}
+
+// Declarations that share their specifiers, like "int x, *p", without the
+// ';'
+func (p *printer) declaration(decls []Declaration) string {
+	var storageClass string
+	var alignAs Expression
+	var inline bool
+	switch d := decls[0].(type) {
+	case *VariableDeclaration:
+		storageClass, alignAs = d.StorageClass, d.AlignAs
+	case *FunctionDeclaration:
+		storageClass, inline = d.StorageClass, d.Inline
+	}
+
+	specifiers := []string{}
+	if storageClass != "" {
+		specifiers = append(specifiers, storageClass)
+	}
+	if inline {
+		specifiers = append(specifiers, "inline")
+	}
+
+	if alignof, ok := alignAs.(*AlignofExpression); ok {
+		specifiers = append(specifiers, "_Alignas("+p.typeName(alignof.TypeName)+")")
+	} else if alignAs != nil {
+		specifiers = append(specifiers, "_Alignas("+p.expression(alignAs,
+			commaPrecedence, lowestPrecedence)+")")
+	}
+
+	declarators := []string{}
+	var base Declaration
+	for _, decl := range decls {
+		var declarator string
+		base, declarator = p.declarator(decl, name(decl))
+		if v, ok := decl.(*VariableDeclaration); ok && v.Definition != nil {
+			declarator += " = " + p.expression(v.Definition, commaPrecedence,
+				lowestPrecedence)
+		}
+		declarators = append(declarators, declarator)
+	}
+
+	specifiers = append(specifiers, p.specifier(base))
+	return join(strings.Join(specifiers, " "), strings.Join(declarators, ", "))
+}
+
+func name(d Declaration) string {
+	switch decl := d.(type) {
+	case *VariableDeclaration:
+		return decl.Name
+	case *FunctionDeclaration:
+		return decl.Name
+	}
+	return ""
+}
+
+// Put the specifiers in front of the declarator. Abstract declarators of
+// arrays and functions go right after them, like "int[3]".
+func join(specifier string, declarator string) string {
+	if declarator == "" || strings.HasPrefix(declarator, "[") ||
+		(strings.HasPrefix(declarator, "(") && !strings.HasPrefix(declarator, "(*")) {
+		return specifier + declarator
+	}
+	return specifier + " " + declarator
+}
+
+// A type name like "int (*)[3]", as used in sizeof
+func (p *printer) typeName(t Declaration) string {
+	base, declarator := p.declarator(t, "")
+	return join(p.specifier(base), declarator)
+}
+
+// Build the declarator around inner, from the outside in like typeString but
+// with parameter names and the expressions in array sizes. Returns the type
+// at the bottom, which goes in the specifiers.
+func (p *printer) declarator(d Declaration, inner string) (Declaration, string) {
+	switch t := d.(type) {
+	case *VariableDeclaration:
+		return p.declarator(t.VarType, inner)
+	case *Pointer:
+		quals := qualifiers(t.Const, t.Volatile)
+		if quals != "" && inner != "" {
+			quals += " "
+		}
+		return p.declarator(t.PointsTo, "*"+quals+inner)
+	case *Array:
+		size := ""
+		if t.ArraySize != nil {
+			size = p.expression(t.ArraySize, commaPrecedence, lowestPrecedence)
+		}
+		return p.declarator(t.ArrayOf, group(inner)+"["+size+"]")
+	case *FunctionDeclaration:
+		params := []string{}
+		for _, param := range t.Parameters {
+			params = append(params, p.declaration([]Declaration{param}))
+		}
+		if t.Variadic {
+			params = append(params, "...")
+		} else if len(params) == 0 {
+			params = append(params, "void")
+		}
+		return p.declarator(t.ReturnType,
+			group(inner)+"("+strings.Join(params, ", ")+")")
+	}
+
+	return d, inner
+}
+
+// The type specifier with its qualifiers, struct and union definitions
+// include their members
+func (p *printer) specifier(d Declaration) string {
+	var name string
+	var isConst, isVolatile bool
+	switch t := d.(type) {
+	case *BaseType:
+		name, isConst, isVolatile = t.Name, t.Const, t.Volatile
+		if t.Unsigned {
+			name = "unsigned " + name
+		} else if t.Signed {
+			name = "signed " + name
+		}
+	case *StructOrUnionSpecification:
+		name, isConst, isVolatile = t.Kind, t.Const, t.Volatile
+		if t.Tag != "" {
+			name += " " + t.Tag
+		}
+		if t.Members != nil {
+			name += " " + p.members(t)
+		}
+	case nil:
+		return ""
+	default:
+		return d.String()
+	}
+
+	if quals := qualifiers(isConst, isVolatile); quals != "" {
+		return quals + " " + name
+	}
+	return name
+}
+
+// The members of a struct or union between braces, the ones that were
+// declared together are printed together
+func (p *printer) members(s *StructOrUnionSpecification) string {
+	members := s.Members
+	if len(members) == 0 && !p.commentsBefore(s.End()) {
+		return "{}"
+	}
+
+	var out strings.Builder
+	out.WriteString("{\n")
+	p.indent++
+	for i := 0; i < len(members); {
+		base := baseType(members[i])
+		shared := []Declaration{members[i]}
+		for i++; i < len(members) && baseType(members[i]) == base &&
+			members[i].AlignAs == members[i-1].AlignAs; i++ {
+			shared = append(shared, members[i])
+		}
+
+		out.WriteString(p.leadingComments(shared[0].Pos()))
+		out.WriteString(strings.Repeat("\t", p.indent))
+		out.WriteString(p.declaration(shared) + ";")
+		out.WriteString(p.trailingComments(shared[len(shared)-1].End()) + "\n")
+	}
+	out.WriteString(p.leadingComments(s.End()))
+	p.indent--
+	out.WriteString(strings.Repeat("\t", p.indent) + "}")
+	return out.String()
+}
+
+// The type specifier at the bottom of a declaration
+func baseType(d Declaration) Declaration {
+	for {
+		switch t := d.(type) {
+		case *VariableDeclaration, *Pointer, *Array, *FunctionDeclaration:
+			d = t.Type()
+		default:
+			return d
+		}
+	}
+}
+
+// Operator precedence, the same levels the parser uses. Expressions are
+// parenthesized when the parser would otherwise group them differently.
+const (
+	lowestPrecedence = iota
+	commaPrecedence
+	assignmentPrecedence
+	conditionalPrecedence
+)
+
+var binaryPrecedence = map[string]int{
+	",": commaPrecedence,
+
+	"=": assignmentPrecedence, "+=": assignmentPrecedence, "-=": assignmentPrecedence,
+	"*=": assignmentPrecedence, "/=": assignmentPrecedence, "%=": assignmentPrecedence,
+	"<<=": assignmentPrecedence, ">>=": assignmentPrecedence, "&=": assignmentPrecedence,
+	"|=": assignmentPrecedence, "^=": assignmentPrecedence,
+
+	"||": 4,
+	"&&": 5,
+	"|":  6,
+	"^":  7,
+	"&":  8,
+	"==": 9, "!=": 9,
+	">": 10, ">=": 10,
+	"<": 11, "<=": 11,
+	"<<": 12, ">>": 12,
+	"+": 13, "-": 13,
+	"*": 14, "/": 14, "%": 14,
+}
+
+var unaryPrecedence = map[string]int{
+	"sizeof": 15,
+	"&":      16,
+	"*":      17,
+	"!":      18, "~": 18,
+	"+": 19, "-": 19,
+	"++": 20, "--": 20,
+}
+
+// Postfix operators apply to everything on their left that binds tighter
+const (
+	arrowPrecedence = 21 + iota
+	dotPrecedence
+	subscriptPrecedence
+	callPrecedence
+)
+
+// Print an expression that is parsed with at least min precedence, that is
+// its operators must bind tighter than min, and which is followed by an
+// operator with the follow precedence. A prefix operator takes everything
+// that binds tighter than it as its operand, so it has to be parenthesized
+// when what follows binds tighter.
+func (p *printer) expression(e Expression, min int, follow int) string {
+	paren := func(s string) string { return "(" + s + ")" }
+
+	switch x := e.(type) {
+	case *ImplicitConversion:
+		return p.expression(x.Expression, min, follow)
+	case *InfixExpression:
+		prec := binaryPrecedence[x.Operator]
+		if prec <= min {
+			return paren(p.expression(e, lowestPrecedence, lowestPrecedence))
+		}
+
+		// assignments are right associative, the rest are left associative
+		leftMin, rightMin := prec-1, prec
+		if prec == assignmentPrecedence {
+			leftMin, rightMin = prec, prec-1
+		}
+
+		left := p.expression(x.Left, leftMin, prec)
+		right := p.expression(x.Right, rightMin, follow)
+		if x.Operator == "," {
+			return left + ", " + right
+		}
+		return left + " " + x.Operator + " " + right
+	case *ConditionalExpression:
+		if conditionalPrecedence <= min {
+			return paren(p.expression(e, lowestPrecedence, lowestPrecedence))
+		}
+
+		return p.expression(x.Condition, conditionalPrecedence, conditionalPrecedence) +
+			" ? " + p.expression(x.Consequence, lowestPrecedence, lowestPrecedence) +
+			" : " + p.expression(x.Alternative, conditionalPrecedence-1, follow)
+	case *PrefixExpression:
+		prec := unaryPrecedence[x.Operator]
+		if follow > prec {
+			return paren(p.expression(e, lowestPrecedence, lowestPrecedence))
+		}
+
+		// "- -x" must not turn into "--x"
+		operand := p.expression(x.Right, prec, follow)
+		if strings.HasPrefix(operand, x.Operator[len(x.Operator)-1:]) &&
+			strings.ContainsAny(x.Operator, "+-&") {
+			return x.Operator + " " + operand
+		}
+		return x.Operator + operand
+	case *SizeofExpression:
+		if x.TypeName != nil {
+			return "sizeof(" + p.typeName(x.TypeName) + ")"
+		}
+
+		prec := unaryPrecedence["sizeof"]
+		if follow > prec {
+			return paren(p.expression(e, lowestPrecedence, lowestPrecedence))
+		}
+
+		operand := p.expression(x.Right, prec, follow)
+		if strings.HasPrefix(operand, "(") {
+			return "sizeof" + operand
+		}
+		return "sizeof " + operand
+	case *AlignofExpression:
+		return "_Alignof(" + p.typeName(x.TypeName) + ")"
+	case *VaArgExpression:
+		return fmt.Sprintf("%s(%s, %s)", x.Token.Literal,
+			p.expression(x.VaList, commaPrecedence, lowestPrecedence),
+			p.typeName(x.TypeName))
+	case *CallExpression:
+		args := []string{}
+		for _, arg := range x.Arguments {
+			args = append(args, p.expression(arg, commaPrecedence, lowestPrecedence))
+		}
+		return p.expression(x.Function, callPrecedence-1, callPrecedence) +
+			"(" + strings.Join(args, ", ") + ")"
+	case *IndexExpression:
+		return p.expression(x.Left, subscriptPrecedence-1, subscriptPrecedence) +
+			"[" + p.expression(x.Index, lowestPrecedence, lowestPrecedence) + "]"
+	case *MemberExpression:
+		if x.Arrow {
+			return p.expression(x.Left, arrowPrecedence-1, arrowPrecedence) + "->" + x.Member
+		}
+		return p.expression(x.Left, dotPrecedence-1, dotPrecedence) + "." + x.Member
+	case *StringLiteral:
+		return quote(x.Value)
+	}
+
+	return e.String()
+}
+
+// A string literal for the value, characters that can't be written as they
+// are use escape sequences
+func quote(s string) string {
+	var out strings.Builder
+	out.WriteString(`"`)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			out.WriteString(`\` + string(c))
+		case c == '\n':
+			out.WriteString(`\n`)
+		case c == '\t':
+			out.WriteString(`\t`)
+		case c < ' ' || c > '~':
+			out.WriteString(fmt.Sprintf(`\%03o`, c))
+		default:
+			out.WriteByte(c)
+		}
+	}
+	out.WriteString(`"`)
+	return out.String()
+}
@@ -0,0 +1,213 @@
+package ast_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/parser"
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+func parse(t *testing.T, input string) *ast.TranslationUnit {
+	p := parser.New(lexer.New(input))
+	tUnit := p.Parse()
+	for _, err := range p.Errors() {
+		t.Fatalf("parser error in %q: %s", input, err.String())
+	}
+	return tUnit
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"int x=1,*p,a[3+1];", "int x = 1, *p, a[3 + 1];\n"},
+		{"static const unsigned long y;", "static const unsigned long int y;\n"},
+		{"int (*fp)(int a,char*);", "int (*fp)(int a, char *);\n"},
+		{"int (*f(int))[3];", "int (*f(int))[3];\n"},
+		{"int f();", "int f(void);\n"},
+		{"int printf(const char *fmt, ...);", "int printf(const char *fmt, ...);\n"},
+		{"char *const *volatile p;", "char *const *volatile p;\n"},
+		{"_Alignas(16) int a; _Alignas(long) char b;", "_Alignas(16) int a;\n_Alignas(long int) char b;\n"},
+		{"struct s { int a, *b; char c[2]; } x;", "struct s {\n\tint a, *b;\n\tchar c[2];\n} x;\n"},
+		{"union u { int a; };", "union u {\n\tint a;\n};\n"},
+		{"int x = a + b * c;", "int x = a + b * c;\n"},
+		{"int x = (a + b) * c;", "int x = (a + b) * c;\n"},
+		{"int x = a - (b - c);", "int x = a - (b - c);\n"},
+		{"int x = (a - b) - c;", "int x = a - b - c;\n"},
+		{"int x = (a = b) = c;", "int x = (a = b) = c;\n"},
+		{"int x = a = b = c;", "int x = a = b = c;\n"},
+		{"int x = (a, b);", "int x = (a, b);\n"},
+		{"int x = f((a, b), c);", "int x = f((a, b), c);\n"},
+		{"int x = (a ? b : c) ? d : e ? f : g;", "int x = (a ? b : c) ? d : e ? f : g;\n"},
+		{"int x = -(-a);", "int x = - -a;\n"},
+		{"int x = &*p;", "int x = &*p;\n"},
+		{"int x = -(a + b);", "int x = -(a + b);\n"},
+		{"int x = (*p)[1] + *q[1];", "int x = (*p)[1] + *q[1];\n"},
+		{"int x = (sizeof a) + sizeof(int) + sizeof (a + 1);", "int x = sizeof a + sizeof(int) + sizeof(a + 1);\n"},
+		{"int x = (a > b) < c;", "int x = (a > b) < c;\n"},
+		{"int x = p->a.b[2](3);", "int x = p->a.b[2](3);\n"},
+		{"int x = (a + b).c;", "int x = (a + b).c;\n"},
+		{`char *s = "a\tb" "\"c\"\n\001";`, `char *s = "a\tb\"c\"\n\001";` + "\n"},
+		{"int f(int n){int x;{}return n;}", "int f(int n) {\n\tint x;\n\t{}\n\treturn n;\n}\n"},
+		{"int a; int f(void) { return 0; } int b; int c;",
+			"int a;\n\nint f(void) {\n\treturn 0;\n}\n\nint b;\nint c;\n"},
+		{"int f(int n, ...) { va_list ap; va_start(ap, n); return va_arg(ap, int); }",
+			"int f(int n, ...) {\n\tva_list ap;\n\tva_start(ap, n);\n\treturn va_arg(ap, int);\n}\n"},
+		{"static inline int get(int *p) { return *p; }",
+			"static inline int get(int *p) {\n\treturn *p;\n}\n"},
+		{"int f(int a) { if (a) return 1; else if (a < 0) { return 2; } else return 3; }",
+			"int f(int a) {\n\tif (a)\n\t\treturn 1;\n\telse if (a < 0) {\n\t\treturn 2;\n\t} else\n\t\treturn 3;\n}\n"},
+		{"int f(int a) { while (a) { a = a - 1; } do a = a + 1; while (a < 3); do {} while (a); return a; }",
+			"int f(int a) {\n\twhile (a) {\n\t\ta = a - 1;\n\t}\n\tdo\n\t\ta = a + 1;\n\twhile (a < 3);\n\tdo {} while (a);\n\treturn a;\n}\n"},
+		{"int f(int n) { for (int i = 0; i < n; i = i + 1) { if (i) continue; break; } for (;;) return n; }",
+			"int f(int n) {\n\tfor (int i = 0; i < n; i = i + 1) {\n\t\tif (i)\n\t\t\tcontinue;\n\t\tbreak;\n\t}\n\tfor (;;)\n\t\treturn n;\n}\n"},
+		// the else stays with the outer if
+		{"int f(int a, int b) { if (a) { if (b) return 1; } else return 2; }",
+			"int f(int a, int b) {\n\tif (a) {\n\t\tif (b)\n\t\t\treturn 1;\n\t} else\n\t\treturn 2;\n}\n"},
+		// comments go before what follows them, or at the end of their line
+		{"/* header */\n\n// x\nint x; // the x\nint y;", "/* header */\n\n// x\nint x; // the x\nint y;\n"},
+		{"int f(int a) {\n// a\nreturn a; /* b */ }", "int f(int a) {\n\t// a\n\treturn a; /* b */\n}\n"},
+		{"int f() { /* nothing */ } // f", "int f(void) {\n\t/* nothing */\n} // f\n"},
+		{"struct s {\nint a; // a\n/* b */ int b;\n// end\n};", "struct s {\n\tint a; // a\n\t/* b */\n\tint b;\n\t// end\n};\n"},
+		{"int f(int a) { return a +\n// b\n1; }", "int f(int a) {\n\treturn a + 1; // b\n}\n"},
+		{"int x; // last\n/* after\n   all */", "int x; // last\n/* after\n   all */\n"},
+	}
+
+	for _, tt := range tests {
+		actual := parse(t, tt.input).String()
+		if actual != tt.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+// Printing a tree and parsing it again has to give back the same tree, and
+// printing that has to give the same output
+func TestFormatRoundTrip(t *testing.T) {
+	inputs := []string{
+		"int x = 1, *p = &x, **pp = &p;",
+		"struct node { int value; struct node *next, *prev; } *head;",
+		"struct node; struct node *n; struct node { int v; };",
+		"const union { int a; char b[4]; } u;",
+		"int (*signal(int sig, void (*handler)(int)))(int);",
+		"char s[] = \"hello\\\\world\";",
+		"int f(int a[], int n) { return a[n - 1] * -n + ~a[0] % 2 >> 1 & 3 ^ 4 | 5; }",
+		"int g(int a, int b) { a += b -= 2; a <<= 1; return a && b || !a ? a == b : a != b; }",
+		"int h(int *p) { return sizeof *p + sizeof(int *[3]) + _Alignof(struct { int a; }); }",
+		"int k(int a, int b) { return a <= b, a >= b, a < b, a > b; }",
+		"int l(int a) { if (a) if (a < 1) a = 1; else a = 2; else while (a) do a = a - 1; while (a > 5); }",
+		"int m(int a) { for (a = 0; ; ) { if (a) break; else continue; } for (int i; i; ) if (i) return i; }",
+		"// f\nint f(int a /* a */) { if (a) { /* a */ return 1; } // b\n else return 2; /* c */ }\n/* end */",
+		"struct s { /* empty */ }; int g(void) { do {} /* d */ while (0); { // e\n } }",
+	}
+
+	programs, _ := filepath.Glob("../../cmd/cc/test_programs/*.c")
+	for _, program := range programs {
+		src, err := os.ReadFile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, string(src))
+	}
+
+	for _, input := range inputs {
+		tUnit := parse(t, input)
+		formatted := tUnit.String()
+		again := parse(t, formatted)
+
+		if !equal(reflect.ValueOf(tUnit), reflect.ValueOf(again), map[[2]uintptr]bool{}) {
+			t.Errorf("%q: formatted as\n%s\nwhich parses differently", input, formatted)
+		}
+
+		if again.String() != formatted {
+			t.Errorf("%q: formatting is not stable, got\n%s\nthen\n%s", input,
+				formatted, again.String())
+		}
+	}
+}
+
+// A tree can have an if without an else in the consequence of one with an
+// else, like after the inner else was deleted. Printed as it is, the else
+// would go with the inner if when it is parsed again.
+func TestFormatDanglingElse(t *testing.T) {
+	inner := &ast.IfStatement{
+		Condition:   &ast.Identifier{Value: "b"},
+		Consequence: &ast.ReturnStatement{ReturnValue: &ast.Identifier{Value: "a"}},
+	}
+	outer := &ast.IfStatement{
+		Condition:   &ast.Identifier{Value: "a"},
+		Consequence: inner,
+		Alternative: &ast.ReturnStatement{ReturnValue: &ast.Identifier{Value: "b"}},
+	}
+
+	expected := "if (a) {\n\tif (b)\n\t\treturn a;\n} else\n\treturn b;\n"
+	if actual := ast.Format(outer); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+var (
+	spanType  = reflect.TypeOf(ast.Span{})
+	posType   = reflect.TypeOf(token.Pos{})
+	tokenType = reflect.TypeOf(token.Token{})
+)
+
+// Compare two trees, ignoring where the nodes are in the source. Pointers
+// that were compared already are skipped since struct types can refer to
+// themselves.
+func equal(a reflect.Value, b reflect.Value, seen map[[2]uintptr]bool) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equal(a.Elem(), b.Elem(), seen)
+	case reflect.Pointer:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+
+		key := [2]uintptr{a.Pointer(), b.Pointer()}
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+		return equal(a.Elem(), b.Elem(), seen)
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i), seen) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if a.Type() != b.Type() {
+			return false
+		}
+		for i := 0; i < a.NumField(); i++ {
+			switch a.Field(i).Type() {
+			case spanType, posType, tokenType:
+				continue
+			}
+			if !equal(a.Field(i), b.Field(i), seen) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return a.Interface() == b.Interface()
+}
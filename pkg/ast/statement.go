@@ -11,6 +11,7 @@ type Statement interface {
 }
 
 type BlockStatement struct {
+	Span
 	Statements []Statement
 }
 
@@ -26,6 +27,7 @@ func (b *BlockStatement) String() string {
 }
 
 type DeclarationStatement struct {
+	Span
 	Declarations []Declaration
 }
 
@@ -40,6 +42,7 @@ func (d *DeclarationStatement) String() string {
 }
 
 type ExpressionStatement struct {
+	Span
 	Expression Expression
 }
 
@@ -49,16 +52,102 @@ func (e *ExpressionStatement) String() string {
 }
 
 type ReturnStatement struct {
-	ReturnValue Expression
+	Span
+	ReturnValue Expression // nil for "return;"
 }
 
 func (r *ReturnStatement) statementNode() {}
 func (r *ReturnStatement) String() string {
+	if r.ReturnValue == nil {
+		return "return;"
+	}
+
 	return fmt.Sprintf("return %s;", r.ReturnValue.String())
 }
 
-type IfStatement struct{}
-type WhileStatement struct{}
-type DoWhileStatement struct{}
-type ForStatement struct{}
+// A statement that could not be parsed, covering the tokens that were skipped
+type BadStatement struct {
+	Span
+}
+
+func (b *BadStatement) statementNode() {}
+func (b *BadStatement) String() string { return "<bad statement>" }
+
+type IfStatement struct {
+	Span
+	Condition   Expression
+	Consequence Statement
+	Alternative Statement // nil without an else
+}
+
+func (i *IfStatement) statementNode() {}
+func (i *IfStatement) String() string {
+	if i.Alternative == nil {
+		return fmt.Sprintf("if (%s) %s", i.Condition.String(), i.Consequence.String())
+	}
+
+	return fmt.Sprintf("if (%s) %s else %s", i.Condition.String(),
+		i.Consequence.String(), i.Alternative.String())
+}
+
+type WhileStatement struct {
+	Span
+	Condition Expression
+	Body      Statement
+}
+
+func (w *WhileStatement) statementNode() {}
+func (w *WhileStatement) String() string {
+	return fmt.Sprintf("while (%s) %s", w.Condition.String(), w.Body.String())
+}
+
+type DoWhileStatement struct {
+	Span
+	Body      Statement
+	Condition Expression
+}
+
+func (d *DoWhileStatement) statementNode() {}
+func (d *DoWhileStatement) String() string {
+	return fmt.Sprintf("do %s while (%s);", d.Body.String(), d.Condition.String())
+}
+
+type ForStatement struct {
+	Span
+	Init      Statement  // a DeclarationStatement or an ExpressionStatement, or nil
+	Condition Expression // nil if the loop only ends with a break
+	Post      Expression // nil if there is nothing to do after each iteration
+	Body      Statement
+}
+
+func (f *ForStatement) statementNode() {}
+func (f *ForStatement) String() string {
+	init, cond, post := ";", "", ""
+	if f.Init != nil {
+		init = f.Init.String()
+	}
+	if f.Condition != nil {
+		cond = " " + f.Condition.String()
+	}
+	if f.Post != nil {
+		post = " " + f.Post.String()
+	}
+
+	return fmt.Sprintf("for (%s%s;%s) %s", init, cond, post, f.Body.String())
+}
+
+type BreakStatement struct {
+	Span
+}
+
+func (b *BreakStatement) statementNode() {}
+func (b *BreakStatement) String() string { return "break;" }
+
+type ContinueStatement struct {
+	Span
+}
+
+func (c *ContinueStatement) statementNode() {}
+func (c *ContinueStatement) String() string { return "continue;" }
+
 type SwitchStatement struct{}
@@ -0,0 +1,540 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target independent rules about C types, shared by the semantic checker and
+// the compiler
+
+var (
+	CharType   = &BaseType{Name: "char"}
+	IntType    = &BaseType{Name: "int"}
+	LongType   = &BaseType{Name: "long int"}
+	DoubleType = &BaseType{Name: "double"}
+	VoidType   = &BaseType{Name: "void"}
+
+	// Type of the result of sizeof and _Alignof
+	SizeType = &BaseType{Name: "long int", Unsigned: true}
+)
+
+// Integer conversion ranks along with the width in bits of each integer type
+// in the LP64 data model, which is the only one we target
+var integerRanks = map[string]int{
+	"char":          1,
+	"short int":     2,
+	"int":           3,
+	"long int":      4,
+	"long long int": 5,
+}
+
+var integerWidths = map[string]int{
+	"char":          8,
+	"short int":     16,
+	"int":           32,
+	"long int":      64,
+	"long long int": 64,
+}
+
+func IsVoid(d Declaration) bool {
+	b, ok := d.(*BaseType)
+	return ok && b.Name == "void"
+}
+
+func IsVaList(d Declaration) bool {
+	b, ok := d.(*BaseType)
+	return ok && b.Name == "va_list"
+}
+
+func IsFloat(d Declaration) bool {
+	b, ok := d.(*BaseType)
+	if !ok {
+		return false
+	}
+
+	return strings.Contains(b.Name, "double") ||
+		strings.Contains(b.Name, "float")
+}
+
+func IsInteger(d Declaration) bool {
+	b, ok := d.(*BaseType)
+	return ok && !IsFloat(b) && b.Name != "void" && !IsVaList(b)
+}
+
+func IsUnsigned(d Declaration) bool {
+	b, ok := d.(*BaseType)
+	return ok && b.Unsigned
+}
+
+// The width in bits of an integer type, 0 for other types
+func IntegerWidth(d Declaration) int {
+	if !IsInteger(d) {
+		return 0
+	}
+	return integerWidths[d.(*BaseType).Name]
+}
+
+func IsPointer(d Declaration) bool {
+	_, ok := d.(*Pointer)
+	return ok
+}
+
+func IsArithmetic(d Declaration) bool {
+	return IsInteger(d) || IsFloat(d)
+}
+
+func IsScalar(d Declaration) bool {
+	return IsArithmetic(d) || IsPointer(d)
+}
+
+func IsStruct(d Declaration) bool {
+	_, ok := d.(*StructOrUnionSpecification)
+	return ok
+}
+
+func IsComplete(d Declaration) bool {
+	switch t := d.(type) {
+	case *BaseType:
+		return t.Name != "void"
+	case *StructOrUnionSpecification:
+		return t.Fields() != nil
+	case *Array:
+		return t.ArraySize != nil && IsComplete(t.ArrayOf)
+	}
+
+	return true
+}
+
+func IsConst(d Declaration) bool {
+	switch t := d.(type) {
+	case *BaseType:
+		return t.Const
+	case *Pointer:
+		return t.Const
+	case *StructOrUnionSpecification:
+		return t.Const
+	}
+
+	return false
+}
+
+func IsVolatile(d Declaration) bool {
+	switch t := d.(type) {
+	case *BaseType:
+		return t.Volatile
+	case *Pointer:
+		return t.Volatile
+	case *StructOrUnionSpecification:
+		return t.Volatile
+	}
+
+	return false
+}
+
+// Return a copy of the type with the given qualifiers added
+func Qualify(d Declaration, isConst bool, isVolatile bool) Declaration {
+	switch t := d.(type) {
+	case *BaseType:
+		c := *t
+		c.Const, c.Volatile = c.Const || isConst, c.Volatile || isVolatile
+		return &c
+	case *Pointer:
+		c := *t
+		c.Const, c.Volatile = c.Const || isConst, c.Volatile || isVolatile
+		return &c
+	case *StructOrUnionSpecification:
+		c := *t
+		c.Const, c.Volatile = c.Const || isConst, c.Volatile || isVolatile
+		return &c
+	}
+
+	return d
+}
+
+// Return a copy of the type without top level qualifiers
+func Unqualified(d Declaration) Declaration {
+	switch t := d.(type) {
+	case *BaseType:
+		c := *t
+		c.Const, c.Volatile = false, false
+		return &c
+	case *Pointer:
+		c := *t
+		c.Const, c.Volatile = false, false
+		return &c
+	case *StructOrUnionSpecification:
+		c := *t
+		c.Const, c.Volatile = false, false
+		return &c
+	}
+
+	return d
+}
+
+// Whether two types are the same, ignoring top level qualifiers
+func SameType(a Declaration, b Declaration) bool {
+	switch x := a.(type) {
+	case *BaseType:
+		y, ok := b.(*BaseType)
+		return ok && x.Name == y.Name && x.Unsigned == y.Unsigned
+	case *Pointer:
+		y, ok := b.(*Pointer)
+		return ok && SameType(x.PointsTo, y.PointsTo) &&
+			IsConst(x.PointsTo) == IsConst(y.PointsTo)
+	case *Array:
+		y, ok := b.(*Array)
+		return ok && SameType(x.ArrayOf, y.ArrayOf)
+	case *StructOrUnionSpecification:
+		y, ok := b.(*StructOrUnionSpecification)
+		return ok && x.Definition == y.Definition
+	case *FunctionDeclaration:
+		y, ok := b.(*FunctionDeclaration)
+		if !ok || !SameType(x.ReturnType, y.ReturnType) ||
+			len(x.Parameters) != len(y.Parameters) || x.Variadic != y.Variadic {
+			return false
+		}
+
+		for i := range x.Parameters {
+			if !SameType(ParameterType(x.Parameters[i]), ParameterType(y.Parameters[i])) {
+				return false
+			}
+		}
+		return true
+	case *VariableDeclaration:
+		return SameType(x.Type(), b)
+	}
+
+	return false
+}
+
+// Whether two types are compatible, which is what C requires of the types in
+// two declarations of the same object or function. Unlike SameType, the
+// qualifiers have to match as well.
+func Compatible(a Declaration, b Declaration) bool {
+	if v, ok := a.(*VariableDeclaration); ok {
+		a = v.Type()
+	}
+	if v, ok := b.(*VariableDeclaration); ok {
+		b = v.Type()
+	}
+
+	if IsConst(a) != IsConst(b) || IsVolatile(a) != IsVolatile(b) {
+		return false
+	}
+
+	switch x := a.(type) {
+	case *BaseType:
+		y, ok := b.(*BaseType)
+		return ok && x.Name == y.Name && x.Unsigned == y.Unsigned
+	case *Pointer:
+		y, ok := b.(*Pointer)
+		return ok && Compatible(x.PointsTo, y.PointsTo)
+	case *Array:
+		y, ok := b.(*Array)
+		if !ok || !Compatible(x.ArrayOf, y.ArrayOf) {
+			return false
+		}
+
+		// arrays of different constant sizes are the only ones that conflict
+		n, okX := x.ArraySize.(*IntegerLiteral)
+		m, okY := y.ArraySize.(*IntegerLiteral)
+		return !okX || !okY || n.Value == m.Value
+	case *StructOrUnionSpecification:
+		y, ok := b.(*StructOrUnionSpecification)
+		return ok && x.Definition == y.Definition
+	case *FunctionDeclaration:
+		y, ok := b.(*FunctionDeclaration)
+		if !ok || !Compatible(x.ReturnType, y.ReturnType) ||
+			len(x.Parameters) != len(y.Parameters) || x.Variadic != y.Variadic {
+			return false
+		}
+
+		// qualifiers on parameters don't affect the type of the function
+		for i := range x.Parameters {
+			if !Compatible(Unqualified(ParameterType(x.Parameters[i])),
+				Unqualified(ParameterType(y.Parameters[i]))) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
+
+// The composite of two compatible types, which combines what each of them
+// says about the type, like the size of an array
+func Composite(a Declaration, b Declaration) Declaration {
+	switch x := a.(type) {
+	case *Pointer:
+		if y, ok := b.(*Pointer); ok {
+			c := *x
+			c.PointsTo = Composite(x.PointsTo, y.PointsTo)
+			return &c
+		}
+	case *Array:
+		if y, ok := b.(*Array); ok {
+			c := *x
+			c.ArrayOf = Composite(x.ArrayOf, y.ArrayOf)
+			if c.ArraySize == nil {
+				c.ArraySize = y.ArraySize
+			}
+			return &c
+		}
+	case *FunctionDeclaration:
+		y, ok := b.(*FunctionDeclaration)
+		if !ok || len(x.Parameters) != len(y.Parameters) {
+			return a
+		}
+
+		c := *x
+		c.ReturnType = Composite(x.ReturnType, y.ReturnType)
+		c.Parameters = make([]Declaration, len(x.Parameters))
+		for i, param := range x.Parameters {
+			other := y.Parameters[i]
+			if v, ok := other.(*VariableDeclaration); ok {
+				other = v.Type()
+			}
+
+			if v, ok := param.(*VariableDeclaration); ok {
+				p := *v
+				p.VarType = Composite(v.Type(), other)
+				c.Parameters[i] = &p
+			} else {
+				c.Parameters[i] = Composite(param, other)
+			}
+		}
+		return &c
+	}
+
+	return a
+}
+
+// The conversion done when a value of type from is assigned to an object of
+// type to, which also happens in initialization, argument passing and return.
+// The value has already been decayed, and nullPointer says whether it is a
+// null pointer constant. The kind is empty if nothing needs to be done. If
+// there is something wrong with the conversion, problem describes it, and ok
+// says whether it is only worth a warning.
+func AssignmentConversion(to Declaration, from Declaration,
+	nullPointer bool) (kind ConversionKind, problem string, ok bool) {
+	switch {
+	case IsArithmetic(to) && IsArithmetic(from):
+		if SameType(to, from) {
+			return "", "", true
+		} else if IsFloat(to) || IsFloat(from) {
+			return FloatingConversion, "", true
+		}
+		return IntegerConversion, "", true
+	case IsPointer(to) && IsInteger(from):
+		if nullPointer {
+			return NullToPointer, "", true
+		}
+		return IntegerToPointer, "makes pointer from integer without a cast", true
+	case IsInteger(to) && IsPointer(from):
+		return PointerToInteger, "makes integer from pointer without a cast", true
+	case IsPointer(to) && IsPointer(from):
+		kind = PointerConversion
+		if SameType(to, from) {
+			kind = ""
+		}
+
+		// void * converts to and from any other pointer type
+		pt, pf := to.(*Pointer).PointsTo, from.(*Pointer).PointsTo
+		if !IsVoid(pt) && !IsVoid(pf) && !Compatible(Unqualified(pt), Unqualified(pf)) {
+			return kind, "uses incompatible pointer types", true
+		}
+
+		// the pointer can only gain qualifiers
+		var lost []string
+		if IsConst(pf) && !IsConst(pt) {
+			lost = append(lost, "const")
+		}
+		if IsVolatile(pf) && !IsVolatile(pt) {
+			lost = append(lost, "volatile")
+		}
+
+		switch len(lost) {
+		case 1:
+			problem = fmt.Sprintf("discards '%s' qualifier from pointer target type",
+				lost[0])
+		case 2:
+			problem = "discards 'const volatile' qualifiers from pointer target type"
+		}
+		return kind, problem, true
+	case IsStruct(to) && Compatible(Unqualified(to), Unqualified(from)):
+		return "", "", true
+	}
+
+	return "", "incompatible types", false
+}
+
+// Whether a value of type from can not be converted to type to at all
+func ConvertError(to Declaration, from Declaration) bool {
+	_, _, ok := AssignmentConversion(to, Decay(from), false)
+	return !ok
+}
+
+// Whether converting a value of type from to type to without a cast deserves
+// a warning
+func ConvertWarn(to Declaration, from Declaration) bool {
+	_, problem, ok := AssignmentConversion(to, Decay(from), false)
+	return ok && problem != ""
+}
+
+// Arrays are converted to a pointer to their first element and functions to a
+// pointer to the function in most expressions
+func Decay(d Declaration) Declaration {
+	switch t := d.(type) {
+	case *Array:
+		return &Pointer{PointsTo: t.ArrayOf}
+	case *FunctionDeclaration:
+		return &Pointer{PointsTo: t}
+	}
+
+	return d
+}
+
+// The type of the function called through an expression of type t, or nil if
+// t is neither a function nor a pointer to one
+func FunctionType(t Declaration) *FunctionDeclaration {
+	if p, ok := Decay(t).(*Pointer); ok {
+		fn, _ := p.PointsTo.(*FunctionDeclaration)
+		return fn
+	}
+
+	return nil
+}
+
+func ParameterName(param Declaration) string {
+	switch p := param.(type) {
+	case *VariableDeclaration:
+		return p.Name
+	case *FunctionDeclaration:
+		return p.Name
+	}
+
+	return ""
+}
+
+// Parameters of array, function and va_list type are adjusted to pointers
+func ParameterType(param Declaration) Declaration {
+	t := param
+	if v, ok := param.(*VariableDeclaration); ok {
+		t = v.Type()
+	}
+
+	switch p := t.(type) {
+	case *Array:
+		return &Pointer{PointsTo: p.ArrayOf}
+	case *FunctionDeclaration:
+		return &Pointer{PointsTo: p}
+	}
+
+	if IsVaList(t) {
+		return &Pointer{PointsTo: t}
+	}
+
+	return t
+}
+
+// Integer promotions, everything with a lower rank than int becomes an int
+func Promote(d Declaration) Declaration {
+	if b, ok := d.(*BaseType); ok && IsInteger(b) &&
+		integerRanks[b.Name] < integerRanks["int"] {
+		return IntType
+	}
+
+	return d
+}
+
+// The usual arithmetic conversions, the common type of a binary operation
+func ArithmeticType(a Declaration, b Declaration) Declaration {
+	a, b = Promote(a), Promote(b)
+	if IsFloat(a) || IsFloat(b) {
+		if !IsFloat(b) || (IsFloat(a) && floatRank(a) >= floatRank(b)) {
+			return Unqualified(a)
+		}
+		return Unqualified(b)
+	}
+
+	x, y := a.(*BaseType), b.(*BaseType)
+	if x.Unsigned == y.Unsigned {
+		if integerRanks[y.Name] > integerRanks[x.Name] {
+			return Unqualified(y)
+		}
+		return Unqualified(x)
+	}
+
+	// make x the signed one
+	if x.Unsigned {
+		x, y = y, x
+	}
+
+	if integerRanks[y.Name] >= integerRanks[x.Name] {
+		return Unqualified(y)
+	} else if integerWidths[x.Name] > integerWidths[y.Name] {
+		return Unqualified(x)
+	}
+
+	return &BaseType{Name: x.Name, Unsigned: true}
+}
+
+func floatRank(d Declaration) int {
+	switch d.(*BaseType).Name {
+	case "float":
+		return 1
+	case "double":
+		return 2
+	}
+
+	return 3
+}
+
+// The result type of cond ? a : b. Returns a message if the operands don't
+// go together, which is an error if the returned type is nil and a warning
+// otherwise
+func ConditionalType(a Declaration, b Declaration,
+	aIsNull bool, bIsNull bool) (Declaration, string) {
+	a, b = Decay(a), Decay(b)
+
+	switch {
+	case IsArithmetic(a) && IsArithmetic(b):
+		return ArithmeticType(a, b), ""
+	case IsVoid(a) && IsVoid(b):
+		return VoidType, ""
+	case IsPointer(a) && bIsNull:
+		return a, ""
+	case IsPointer(b) && aIsNull:
+		return b, ""
+	case IsPointer(a) && IsPointer(b):
+		pa, pb := a.(*Pointer).PointsTo, b.(*Pointer).PointsTo
+		c := IsConst(pa) || IsConst(pb)
+		v := IsVolatile(pa) || IsVolatile(pb)
+
+		if IsVoid(pa) || IsVoid(pb) {
+			return &Pointer{PointsTo: Qualify(VoidType, c, v)}, ""
+		} else if Compatible(Unqualified(pa), Unqualified(pb)) {
+			return &Pointer{PointsTo: Qualify(Composite(pa, pb), c, v)}, ""
+		}
+
+		return &Pointer{PointsTo: Qualify(VoidType, c, v)},
+			"pointer type mismatch in conditional expression"
+	case IsStruct(a) && Compatible(Unqualified(a), Unqualified(b)):
+		return Unqualified(a), ""
+	}
+
+	return nil, "type mismatch in conditional expression"
+}
+
+// Find a member of a struct or union by name, along with its index
+func (s *StructOrUnionSpecification) Member(name string) (*VariableDeclaration, int) {
+	for i, field := range s.Fields() {
+		if field.Name == name {
+			return field, i
+		}
+	}
+
+	return nil, -1
+}
@@ -0,0 +1,311 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is called for every node that Walk reaches. If it
+// returns a visitor w, the children of the node are walked with w, followed
+// by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree in depth-first order, starting with node. It goes
+// through the types of declarations, like the size of an array, but not the
+// types that the semantic checker attaches to implicit conversions.
+func Walk(v Visitor, node Node) {
+	visitors := []Visitor{v}
+	pre := func(c *Cursor) bool {
+		w := visitors[len(visitors)-1].Visit(c.Node())
+		if w == nil {
+			return false
+		}
+		visitors = append(visitors, w)
+		return true
+	}
+	post := func(c *Cursor) bool {
+		visitors[len(visitors)-1].Visit(nil)
+		visitors = visitors[:len(visitors)-1]
+		return true
+	}
+
+	Apply(node, pre, post)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect calls f for every node in the tree in depth-first order, and then
+// f(nil) after the children of a node. The children are skipped if f returns
+// false.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// ApplyFunc is called by Apply for each node, with a cursor that can change
+// the tree in place
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses the tree like Walk, calling pre before and post after the
+// children of each node, either of which may be nil. If pre returns false
+// the children and post are skipped. If post returns false the traversal
+// stops. Apply returns the root, which may have been replaced.
+//
+// Nodes that are replaced or inserted are not traversed, except that the
+// children of a node replaced in pre are. Nodes have to be replaced with
+// ones of the right kind for their place, like an Expression for the Left
+// of an InfixExpression, otherwise Apply panics.
+func Apply(root Node, pre ApplyFunc, post ApplyFunc) (result Node) {
+	parent := &rootNode{Node: root}
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+		result = parent.Node
+	}()
+
+	a := &applier{pre: pre, post: post}
+	applyField(a, Node(parent), "Node", &parent.Node)
+	return parent.Node
+}
+
+var abort = new(int)
+
+// holds the root so that it can be replaced
+type rootNode struct {
+	Node
+}
+
+// A Cursor describes the node that Apply is at and where it is in the tree
+type Cursor struct {
+	parent Node
+	name   string
+	iter   *iterator // nil unless the node is in a list
+	node   Node
+
+	replace      func(Node)
+	insertBefore func(Node)
+	insertAfter  func(Node)
+	delete       func()
+}
+
+type iterator struct {
+	index int
+	step  int
+}
+
+// Node returns the current node
+func (c *Cursor) Node() Node { return c.node }
+
+// Parent returns the node that holds the current node, or nil for the root
+func (c *Cursor) Parent() Node {
+	if _, ok := c.parent.(*rootNode); ok {
+		return nil
+	}
+	return c.parent
+}
+
+// Name returns the field of the parent that holds the current node, like
+// "Left" or "Statements"
+func (c *Cursor) Name() string { return c.name }
+
+// Index returns the position of the current node in a list like the
+// statements of a block, or -1 if it is not in one
+func (c *Cursor) Index() int {
+	if c.iter == nil {
+		return -1
+	}
+	return c.iter.index
+}
+
+// Replace puts n in the place of the current node. A node that is not in a
+// list can be replaced with nil if it is optional, like the Definition of a
+// VariableDeclaration.
+func (c *Cursor) Replace(n Node) {
+	c.replace(n)
+	c.node = n
+}
+
+// Delete removes the current node from the list that it is in
+func (c *Cursor) Delete() {
+	if c.iter == nil {
+		panic(fmt.Sprintf("Delete of %s node that is not in a list", c.name))
+	}
+	c.delete()
+	c.iter.step--
+}
+
+// InsertBefore inserts n before the current node in its list
+func (c *Cursor) InsertBefore(n Node) {
+	if c.iter == nil {
+		panic(fmt.Sprintf("InsertBefore of %s node that is not in a list", c.name))
+	}
+	c.insertBefore(n)
+	c.iter.index++
+}
+
+// InsertAfter inserts n after the current node in its list
+func (c *Cursor) InsertAfter(n Node) {
+	if c.iter == nil {
+		panic(fmt.Sprintf("InsertAfter of %s node that is not in a list", c.name))
+	}
+	c.insertAfter(n)
+	c.iter.step++
+}
+
+type applier struct {
+	pre  ApplyFunc
+	post ApplyFunc
+}
+
+func (a *applier) apply(c *Cursor) {
+	if a.pre != nil && !a.pre(c) || c.node == nil {
+		return
+	}
+
+	a.children(c.node)
+
+	if a.post != nil && !a.post(c) {
+		panic(abort)
+	}
+}
+
+// A single child, which is skipped if it is nil
+func applyField[T Node](a *applier, parent Node, name string, field *T) {
+	if Node(*field) == nil {
+		return
+	}
+
+	a.apply(&Cursor{
+		parent: parent,
+		name:   name,
+		node:   *field,
+		replace: func(n Node) {
+			if n == nil {
+				var zero T
+				*field = zero
+			} else {
+				*field = n.(T)
+			}
+		},
+	})
+}
+
+// Every child in a list, which can grow and shrink as it is traversed
+func applyList[T Node](a *applier, parent Node, name string, list *[]T) {
+	iter := &iterator{}
+	for iter.index = 0; iter.index < len(*list); iter.index += iter.step {
+		iter.step = 1
+		a.apply(&Cursor{
+			parent: parent,
+			name:   name,
+			iter:   iter,
+			node:   (*list)[iter.index],
+			replace: func(n Node) {
+				(*list)[iter.index] = n.(T)
+			},
+			insertBefore: func(n Node) {
+				*list = append((*list)[:iter.index],
+					append([]T{n.(T)}, (*list)[iter.index:]...)...)
+			},
+			insertAfter: func(n Node) {
+				*list = append((*list)[:iter.index+1],
+					append([]T{n.(T)}, (*list)[iter.index+1:]...)...)
+			},
+			delete: func() {
+				*list = append((*list)[:iter.index], (*list)[iter.index+1:]...)
+			},
+		})
+	}
+}
+
+func (a *applier) children(node Node) {
+	switch x := node.(type) {
+	case *TranslationUnit:
+		applyList(a, x, "DeclarationStatements", &x.DeclarationStatements)
+
+	// statements
+	case *DeclarationStatement:
+		applyList(a, x, "Declarations", &x.Declarations)
+	case *BlockStatement:
+		applyList(a, x, "Statements", &x.Statements)
+	case *ExpressionStatement:
+		applyField(a, x, "Expression", &x.Expression)
+	case *ReturnStatement:
+		applyField(a, x, "ReturnValue", &x.ReturnValue)
+	case *IfStatement:
+		applyField(a, x, "Condition", &x.Condition)
+		applyField(a, x, "Consequence", &x.Consequence)
+		applyField(a, x, "Alternative", &x.Alternative)
+	case *WhileStatement:
+		applyField(a, x, "Condition", &x.Condition)
+		applyField(a, x, "Body", &x.Body)
+	case *DoWhileStatement:
+		applyField(a, x, "Body", &x.Body)
+		applyField(a, x, "Condition", &x.Condition)
+	case *ForStatement:
+		applyField(a, x, "Init", &x.Init)
+		applyField(a, x, "Condition", &x.Condition)
+		applyField(a, x, "Post", &x.Post)
+		applyField(a, x, "Body", &x.Body)
+
+	// declarations
+	case *VariableDeclaration:
+		applyField(a, x, "AlignAs", &x.AlignAs)
+		applyField(a, x, "VarType", &x.VarType)
+		applyField(a, x, "Definition", &x.Definition)
+	case *FunctionDeclaration:
+		applyField(a, x, "ReturnType", &x.ReturnType)
+		applyList(a, x, "Parameters", &x.Parameters)
+		if x.Body != nil {
+			applyField(a, x, "Body", &x.Body)
+		}
+	case *Pointer:
+		applyField(a, x, "PointsTo", &x.PointsTo)
+	case *Array:
+		applyField(a, x, "ArrayOf", &x.ArrayOf)
+		applyField(a, x, "ArraySize", &x.ArraySize)
+	case *StructOrUnionSpecification:
+		applyList(a, x, "Members", &x.Members)
+
+	// expressions
+	case *PrefixExpression:
+		applyField(a, x, "Right", &x.Right)
+	case *InfixExpression:
+		applyField(a, x, "Left", &x.Left)
+		applyField(a, x, "Right", &x.Right)
+	case *ConditionalExpression:
+		applyField(a, x, "Condition", &x.Condition)
+		applyField(a, x, "Consequence", &x.Consequence)
+		applyField(a, x, "Alternative", &x.Alternative)
+	case *SizeofExpression:
+		applyField(a, x, "Right", &x.Right)
+		applyField(a, x, "TypeName", &x.TypeName)
+	case *AlignofExpression:
+		applyField(a, x, "TypeName", &x.TypeName)
+	case *CallExpression:
+		applyField(a, x, "Function", &x.Function)
+		applyList(a, x, "Arguments", &x.Arguments)
+	case *VaArgExpression:
+		applyField(a, x, "VaList", &x.VaList)
+		applyField(a, x, "TypeName", &x.TypeName)
+	case *IndexExpression:
+		applyField(a, x, "Left", &x.Left)
+		applyField(a, x, "Index", &x.Index)
+	case *MemberExpression:
+		applyField(a, x, "Left", &x.Left)
+	case *ImplicitConversion:
+		applyField(a, x, "Expression", &x.Expression)
+
+	case *Identifier, *IntegerLiteral, *FloatLiteral, *StringLiteral,
+		*BaseType, *BreakStatement, *ContinueStatement, *BadStatement, *BadDeclaration:
+		// no children
+	default:
+		panic(fmt.Sprintf("ast.Apply: unexpected node %T", node))
+	}
+}
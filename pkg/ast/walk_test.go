@@ -0,0 +1,208 @@
+package ast_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+const walkInput = `int a[2 + 1];
+int f(int n) {
+	int x = n * 2;
+	f(x);
+	return x + n;
+}
+`
+
+func TestInspect(t *testing.T) {
+	tUnit := parse(t, walkInput)
+
+	kinds := []string{}
+	depth, maxDepth := 0, 0
+	ast.Inspect(tUnit, func(n ast.Node) bool {
+		if n == nil {
+			depth--
+			return true
+		}
+
+		depth++
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+		switch x := n.(type) {
+		case *ast.Identifier:
+			kinds = append(kinds, x.Value)
+		case *ast.IntegerLiteral:
+			kinds = append(kinds, x.Token.Literal)
+		case *ast.ReturnStatement:
+			kinds = append(kinds, "return")
+		case *ast.BlockStatement:
+			kinds = append(kinds, "{")
+		}
+		return true
+	})
+
+	expected := "2 1 { n 2 f x return x n"
+	if actual := strings.Join(kinds, " "); actual != expected {
+		t.Errorf("expected to visit %q, got %q", expected, actual)
+	}
+
+	if depth != 0 || maxDepth != 8 {
+		t.Errorf("expected to end at depth 0 after reaching 8, got %d and %d",
+			depth, maxDepth)
+	}
+
+	// the children of a node are skipped when false is returned
+	kinds = kinds[:0]
+	ast.Inspect(tUnit, func(n ast.Node) bool {
+		if x, ok := n.(*ast.Identifier); ok {
+			kinds = append(kinds, x.Value)
+		}
+		_, isReturn := n.(*ast.ReturnStatement)
+		return !isReturn
+	})
+	if actual := strings.Join(kinds, " "); actual != "n f x" {
+		t.Errorf("expected to visit %q, got %q", "n f x", actual)
+	}
+}
+
+type counter map[string]int
+
+func (c counter) Visit(n ast.Node) ast.Visitor {
+	if n != nil {
+		c[fmt.Sprintf("%T", n)]++
+	}
+	return c
+}
+
+func TestWalk(t *testing.T) {
+	c := counter{}
+	ast.Walk(c, parse(t, walkInput))
+
+	expected := map[string]int{
+		"*ast.TranslationUnit":      1,
+		"*ast.DeclarationStatement": 3,
+		"*ast.VariableDeclaration":  3,
+		"*ast.FunctionDeclaration":  1,
+		"*ast.Array":                1,
+		"*ast.BaseType":             4,
+		"*ast.BlockStatement":       1,
+		"*ast.ExpressionStatement":  1,
+		"*ast.ReturnStatement":      1,
+		"*ast.InfixExpression":      3,
+		"*ast.CallExpression":       1,
+		"*ast.Identifier":           5,
+		"*ast.IntegerLiteral":       3,
+	}
+	for kind, n := range expected {
+		if c[kind] != n {
+			t.Errorf("expected %d %s nodes, got %d", n, kind, c[kind])
+		}
+	}
+	if len(c) != len(expected) {
+		t.Errorf("expected %d kinds of nodes, got %v", len(expected), c)
+	}
+}
+
+// Fold additions and multiplications of integer literals
+func fold(c *ast.Cursor) bool {
+	infix, ok := c.Node().(*ast.InfixExpression)
+	if !ok {
+		return true
+	}
+	left, ok1 := infix.Left.(*ast.IntegerLiteral)
+	right, ok2 := infix.Right.(*ast.IntegerLiteral)
+	if !ok1 || !ok2 {
+		return true
+	}
+
+	var value int64
+	switch infix.Operator {
+	case "+":
+		value = left.Value + right.Value
+	case "*":
+		value = left.Value * right.Value
+	default:
+		return true
+	}
+
+	literal := fmt.Sprint(value)
+	c.Replace(&ast.IntegerLiteral{Token: token.Token{Type: token.INTL, Literal: literal},
+		Value: value})
+	return true
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		input    string
+		pre      ast.ApplyFunc
+		post     ast.ApplyFunc
+		expected string
+	}{
+		{
+			"int x = 1 + 2 * 3 + y;",
+			nil, fold,
+			"int x = 7 + y;\n",
+		},
+		{
+			// statements that are deleted or inserted are not visited
+			"int f(void) { g(); h(); g(); return 0; }",
+			func(c *ast.Cursor) bool {
+				stmt, ok := c.Node().(*ast.ExpressionStatement)
+				if !ok {
+					return true
+				}
+				if stmt.String() == "g();" {
+					c.Delete()
+				} else {
+					c.InsertBefore(&ast.ExpressionStatement{Expression: &ast.Identifier{Value: "before"}})
+					c.InsertAfter(&ast.ExpressionStatement{Expression: &ast.Identifier{Value: "after"}})
+				}
+				return false
+			}, nil,
+			"int f(void) {\n\tbefore;\n\th();\n\tafter;\n\treturn 0;\n}\n",
+		},
+		{
+			"int x = 1, y = 2;",
+			func(c *ast.Cursor) bool {
+				if c.Name() == "Definition" && c.Index() == -1 {
+					if _, ok := c.Parent().(*ast.VariableDeclaration); ok {
+						c.Replace(nil)
+					}
+				}
+				return true
+			}, nil,
+			"int x, y;\n",
+		},
+		{
+			// the traversal stops when post returns false
+			"int x = 1 + 1; int y = 2 + 2;",
+			nil, func(c *ast.Cursor) bool {
+				fold(c)
+				_, ok := c.Node().(*ast.IntegerLiteral)
+				return !ok || c.Node().String() != "2"
+			},
+			"int x = 2;\nint y = 2 + 2;\n",
+		},
+	}
+
+	for _, tt := range tests {
+		root := ast.Apply(parse(t, tt.input), tt.pre, tt.post)
+		if actual := ast.Format(root); actual != tt.expected {
+			t.Errorf("%q: expected\n%s\ngot\n%s", tt.input, tt.expected, actual)
+		}
+	}
+
+	root := ast.Apply(parse(t, "int x;"), func(c *ast.Cursor) bool {
+		if c.Parent() == nil {
+			c.Replace(&ast.TranslationUnit{})
+		}
+		return true
+	}, nil)
+	if tUnit, ok := root.(*ast.TranslationUnit); !ok || len(tUnit.DeclarationStatements) != 0 {
+		t.Errorf("expected the root to be replaced, got %v", root)
+	}
+}
@@ -0,0 +1,86 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
+)
+
+// Offsets of the fields of a va_list
+const (
+	VA_GP_OFFSET     = 0
+	VA_FP_OFFSET     = 4
+	VA_OVERFLOW_AREA = 8
+	VA_REG_SAVE_AREA = 16
+)
+
+// The address of a va_list. A va_list parameter has already been adjusted
+// to a pointer.
+func (f *Function) vaListPointer(expr ast.Expression) ir.Value {
+	v := f.compileExpression(expr)
+	if v == nil {
+		return nil
+	}
+
+	if p, ok := v.Type().(*ast.Pointer); ok && ast.IsVaList(p.PointsTo) {
+		return f.rvalue(v).val
+	}
+	return f.decay(v).val
+}
+
+// va_start(ap, last) makes ap point at the first unnamed argument
+func (f *Function) compileVaStart(call *ast.CallExpression) *value {
+	p := f.vaListPointer(call.Arguments[0])
+	if p == nil {
+		return nil
+	}
+	f.b.VaStart(p)
+
+	return f.constant(0, voidType)
+}
+
+// Nothing needs to be cleaned up
+func (f *Function) compileVaEnd(call *ast.CallExpression) *value {
+	if p := f.vaListPointer(call.Arguments[0]); p == nil {
+		return nil
+	}
+
+	return f.constant(0, voidType)
+}
+
+// va_copy(dest, src)
+func (f *Function) compileVaCopy(call *ast.CallExpression) *value {
+	dest := f.vaListPointer(call.Arguments[0])
+	if dest == nil {
+		return nil
+	}
+	src := f.vaListPointer(call.Arguments[1])
+	if src == nil {
+		return nil
+	}
+	f.b.VaCopy(dest, src)
+
+	return f.constant(0, voidType)
+}
+
+// Fetch the next unnamed argument
+func (f *Function) compileVaArg(v *ast.VaArgExpression) *value {
+	t := v.TypeName
+	if ast.IsFloat(t) || !ast.IsScalar(t) {
+		f.err(fmt.Sprintf("'va_arg' of type '%s' is not supported yet",
+			ast.TypeString(t)))
+		return nil
+	} else if ast.IsInteger(t) && layout.SizeOf(ast.Promote(t)) != layout.SizeOf(t) {
+		f.warn(fmt.Sprintf(
+			"'%s' is promoted to 'int' when passed through '...'", ast.TypeString(t)))
+	}
+
+	p := f.vaListPointer(v.VaList)
+	if p == nil {
+		return nil
+	}
+
+	return &value{val: f.b.VaArg(irType(t), p), dataType: t}
+}
@@ -0,0 +1,140 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
+)
+
+// Give every named parameter a stack slot and store the incoming value there
+func (f *Function) compileParameters() {
+	for i, param := range f.Declaration.Parameters {
+		t := ast.ParameterType(param)
+		if !ast.IsScalar(t) {
+			f.err(fmt.Sprintf("parameters of type '%s' are not supported yet",
+				ast.TypeString(t)))
+			continue
+		}
+
+		address := f.alloca(t, layout.AlignOf(t))
+		f.b.Store(f.IR.Params[i], address)
+		f.declareVariable(param, &value{val: address, dataType: t, lvalue: true})
+	}
+}
+
+// Calls to a function by name go straight to it, anything else is evaluated
+// to a function pointer and called indirectly
+func (f *Function) compileCall(call *ast.CallExpression) *value {
+	// the checker doesn't resolve the names of the builtins
+	if ident, ok := call.Function.(*ast.Identifier); ok && f.compiler.info.Uses[ident] == nil {
+		switch ident.Value {
+		case "va_start", "__builtin_va_start":
+			return f.compileVaStart(call)
+		case "va_end", "__builtin_va_end":
+			return f.compileVaEnd(call)
+		case "va_copy", "__builtin_va_copy":
+			return f.compileVaCopy(call)
+		}
+	}
+
+	var direct ir.Value
+	if ident, ok := calledName(call.Function); ok {
+		if fn, ok := f.compiler.info.Uses[ident].(*ast.FunctionDeclaration); ok {
+			direct = &ir.Global{Name: fn.Name}
+		}
+	}
+
+	fnDecl := ast.FunctionType(f.typeOf(call.Function))
+	nParams := len(fnDecl.Parameters)
+
+	// arguments are evaluated from right to left
+	args := make([]ir.Value, len(call.Arguments))
+	for i := len(call.Arguments) - 1; i >= 0; i-- {
+		var paramType ast.Declaration
+		if i < nParams {
+			paramType = ast.ParameterType(fnDecl.Parameters[i])
+		}
+
+		arg := f.compileArgument(call.Arguments[i], paramType)
+		if arg == nil {
+			return nil
+		}
+		args[i] = arg.val
+	}
+
+	callee := direct
+	if callee == nil {
+		v := f.compileExpression(call.Function)
+		if v == nil {
+			return nil
+		}
+		callee = f.rvalue(v).val
+	}
+
+	result := f.b.Call(irType(fnDecl.Type()), callee, args, fnDecl.Variadic)
+	if result == nil {
+		return f.constant(0, voidType)
+	}
+	return &value{val: result, dataType: fnDecl.Type()}
+}
+
+// The function named by the callee of a direct call. The checker has wrapped it
+// in a conversion to a function pointer.
+func calledName(callee ast.Expression) (*ast.Identifier, bool) {
+	if conv, ok := callee.(*ast.ImplicitConversion); ok &&
+		conv.Kind == ast.FunctionToPointer {
+		callee = conv.Expression
+	}
+
+	ident, ok := callee.(*ast.Identifier)
+	return ident, ok
+}
+
+// Evaluate an argument to a 64 bit value. Arguments without a parameter to
+// convert to get the default argument promotions.
+func (f *Function) compileArgument(expr ast.Expression,
+	paramType ast.Declaration) *value {
+	arg := f.compileExpression(expr)
+	if arg == nil {
+		return nil
+	}
+
+	arg = f.decay(arg)
+	if !ast.IsScalar(arg.Type()) {
+		f.err(fmt.Sprintf("arguments of type '%s' are not supported yet",
+			ast.TypeString(arg.Type())))
+		return nil
+	} else if ast.IsFloat(arg.Type()) {
+		f.err("floating point arguments are not supported yet")
+		return nil
+	}
+
+	if paramType == nil {
+		paramType = ast.Promote(arg.Type())
+	}
+
+	arg = f.compileTypeConversion(paramType, arg.Type(), arg)
+	if arg == nil {
+		return nil
+	}
+
+	if ast.IsInteger(arg.Type()) {
+		return f.loadLong(arg)
+	}
+
+	return arg
+}
+
+// A function designator is an lvalue whose address is the function itself.
+// A function that isn't defined in this file may be in another object.
+func (f *Function) compileFunctionDesignator(fnDecl *ast.FunctionDeclaration) *value {
+	_, defined := f.compiler.symbolMap[fnDecl.Name]
+	return &value{
+		val: &ir.Global{Name: fnDecl.Name,
+			Extern: !defined && fnDecl.StorageClass != "static"},
+		dataType: fnDecl,
+		lvalue:   true,
+	}
+}
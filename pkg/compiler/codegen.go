@@ -0,0 +1,654 @@
+package compiler
+
+import (
+	"fmt"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
+)
+
+// Size of the area where variadic functions store the argument registers,
+// 6 general purpose registers followed by 8 vector registers
+const REG_SAVE_AREA_SIZE = 6*8 + 8*16
+
+// Only used to give the 16 byte vector registers a size
+var vectorType = &ast.BaseType{Name: "long double"}
+
+var shortType = &ast.BaseType{Name: "short int"}
+
+// A C type with the size of an IR type, for sizing x86 operands
+func operandType(t ir.Type) ast.Declaration {
+	switch t {
+	case ir.I8:
+		return charType
+	case ir.I16:
+		return shortType
+	case ir.I32:
+		return intType
+	case ir.I64:
+		return longType
+	case ir.Ptr:
+		return &ast.Pointer{PointsTo: voidType}
+	}
+	return nil
+}
+
+func reg(r *Register, t ir.Type) *RegisterOperand {
+	return &RegisterOperand{Register: r, DataType: operandType(t)}
+}
+
+func imm(n int64, t ir.Type) *ImmediateInt {
+	return &ImmediateInt{Value: n, DataType: operandType(t)}
+}
+
+// Most instructions only take immediates that fit in 32 bits
+func fitsInt32(n int64) bool { return n >= -1<<31 && n < 1<<31 }
+
+// Generates x86-64 instructions from the IR of a function. Virtual
+// registers are kept in the machine registers the allocator gave them, or in
+// a slot in the stack frame if they were spilled. The operands of an
+// instruction are moved into scratch registers as it needs them, see
+// clobbers for which.
+type generator struct {
+	f           *Function
+	registers   map[*ir.Register]*Register
+	slots       map[*ir.Register]*Address // where spilled registers are kept
+	allocas     map[*ir.Register]*Address // the stack slots created by alloca
+	saved       map[*Register]*Address    // where callee saved registers are kept
+	frameSize   int64
+	regSaveArea int64 // where variadic functions store the argument registers
+	labels      int
+	next        *ir.Block // the block that is laid out after the current one
+}
+
+func (f *Function) generate() {
+	allocation := allocateRegisters(f.IR)
+	g := &generator{
+		f:         f,
+		registers: allocation.registers,
+		slots:     map[*ir.Register]*Address{},
+		allocas:   map[*ir.Register]*Address{},
+		saved:     map[*Register]*Address{},
+	}
+
+	for _, b := range f.IR.Blocks {
+		if b.ID > g.labels {
+			g.labels = b.ID
+		}
+	}
+
+	g.layoutFrame(allocation)
+	g.prologue()
+	for i, b := range f.IR.Blocks {
+		g.next = nil
+		if i+1 < len(f.IR.Blocks) {
+			g.next = f.IR.Blocks[i+1]
+		}
+
+		if i > 0 {
+			g.emit(Label(blockLabel(b)))
+		}
+		for _, instr := range b.Instructions {
+			// the callee returns for us
+			if instr.Tail && g.siblingCall(instr) {
+				break
+			}
+			g.instruction(instr)
+		}
+	}
+
+	if f.compiler.Peephole {
+		f.Instructions = peephole(f.Instructions)
+	}
+}
+
+func (g *generator) emit(instrs ...*Instruction) {
+	g.f.Instructions = append(g.f.Instructions, instrs...)
+}
+
+func blockLabel(b *ir.Block) *LabelOperand {
+	return &LabelOperand{Name: fmt.Sprintf(".L%d", b.ID)}
+}
+
+// Create a label that doesn't clash with the labels of blocks
+func (g *generator) newLabel() *LabelOperand {
+	g.labels++
+	return &LabelOperand{Name: fmt.Sprintf(".L%d", g.labels)}
+}
+
+// Reserve size bytes below the frame pointer and return their offset
+func (g *generator) grow(size, align int64) int64 {
+	g.frameSize = int64(layout.AlignUp(uint64(g.frameSize+size), uint64(align)))
+	return -g.frameSize
+}
+
+// Give every alloca, every spilled register and every callee saved register
+// that is used its place in the stack frame
+func (g *generator) layoutFrame(allocation *allocation) {
+	if g.f.IR.Variadic {
+		g.regSaveArea = g.grow(REG_SAVE_AREA_SIZE, 16)
+	}
+
+	for _, r := range CALLEE_SAVED {
+		if allocation.used[r] {
+			g.saved[r] = &Address{Base: REG_RBP, Displacement: g.grow(8, 8),
+				DataType: longType}
+		}
+	}
+	for _, r := range allocation.spilled {
+		g.slots[r] = &Address{Base: REG_RBP, Displacement: g.grow(8, 8),
+			DataType: operandType(r.T)}
+	}
+
+	// a stack slot goes below the ones that can be alive at the same time,
+	// so the slots of blocks next to each other overlap
+	type slot struct {
+		scope *ir.Scope
+		end   int64 // how far below the frame pointer it starts
+	}
+	base, placed := g.frameSize, []slot{}
+	for _, instr := range g.f.IR.Entry().Instructions {
+		if instr.Op != ir.Alloca {
+			continue
+		}
+
+		start := base
+		for _, other := range placed {
+			if other.end > start && !other.scope.Disjoint(instr.Scope) {
+				start = other.end
+			}
+		}
+		end := int64(layout.AlignUp(uint64(start+instr.Size), uint64(instr.Align)))
+		if end > g.frameSize {
+			g.frameSize = end
+		}
+
+		g.allocas[instr.Dest] = &Address{Base: REG_RBP, Displacement: -end}
+		placed = append(placed, slot{instr.Scope, end})
+	}
+}
+
+// Where the value of a virtual register is kept
+func (g *generator) location(r *ir.Register) Operand {
+	if machine, ok := g.registers[r]; ok {
+		return reg(machine, r.T)
+	}
+	return g.slots[r]
+}
+
+// Set up the stack frame and store the parameters in their slots. The
+// first 6 arrive in registers, the rest were pushed by the caller.
+func (g *generator) prologue() {
+	vp := operandType(ir.Ptr)
+	rbp := &RegisterOperand{REG_RBP, vp}
+	rsp := &RegisterOperand{REG_RSP, vp}
+	g.emit(
+		Push(rbp),
+		Mov(rbp, rsp),
+		Sub(rsp, &ImmediateInt{Value: int64(layout.AlignUp(uint64(g.frameSize), STACK_ALIGN))}))
+
+	for _, r := range CALLEE_SAVED {
+		if slot, ok := g.saved[r]; ok {
+			g.emit(Mov(slot, reg(r, ir.I64)))
+		}
+	}
+
+	if g.f.IR.Variadic {
+		g.saveArgumentRegisters()
+	}
+
+	for i, p := range g.f.IR.Params {
+		if i < len(ARG_REGS) {
+			g.emit(Mov(g.location(p), reg(ARG_REGS[i], p.T)))
+			continue
+		}
+
+		rax := reg(REG_RAX, p.T)
+		g.emit(
+			Mov(rax, &Address{Base: REG_RBP,
+				Displacement: int64(16 + 8*(i-len(ARG_REGS))), DataType: rax.DataType}),
+			Mov(g.location(p), rax))
+	}
+}
+
+// Store the argument registers so that va_arg can find unnamed arguments.
+// al holds the number of vector registers used by the caller.
+func (g *generator) saveArgumentRegisters() {
+	for i, r := range ARG_REGS {
+		g.emit(Mov(&Address{Base: REG_RBP, Displacement: g.regSaveArea + int64(8*i),
+			DataType: longType}, &RegisterOperand{Register: r, DataType: longType}))
+	}
+
+	al := &RegisterOperand{Register: REG_RAX, DataType: charType}
+	skip := g.newLabel()
+	g.emit(Test(al, al), Je(skip))
+	for i, r := range REG_XMM {
+		g.emit(Movaps(&Address{Base: REG_RBP, Displacement: g.regSaveArea +
+			int64(6*8+16*i)}, &RegisterOperand{Register: r, DataType: vectorType}))
+	}
+	g.emit(Label(skip))
+}
+
+// Move v into register r
+func (g *generator) load(r *Register, v ir.Value) *RegisterOperand {
+	dest := reg(r, v.Type())
+	switch v := v.(type) {
+	case *ir.Const:
+		g.emit(Mov(dest, imm(v.Value, v.T)))
+	case *ir.Global:
+		if v.Extern { // look the address up in the GOT
+			g.emit(Mov(dest, &Address{
+				Symbol: fmt.Sprintf("%s wrt ..gotpcrel", v.Name), DataType: dest.DataType}))
+		} else {
+			g.emit(Lea(dest, &Address{Symbol: v.Name}))
+		}
+	case *ir.Register:
+		if address, ok := g.allocas[v]; ok {
+			g.emit(Lea(dest, address))
+		} else if g.registers[v] != r {
+			g.emit(Mov(dest, g.location(v)))
+		}
+	}
+	return dest
+}
+
+// Move an integer into register r, extending it to the size of t
+func (g *generator) loadAs(r *Register, v ir.Value, t ir.Type, signed bool) *RegisterOperand {
+	if v.Type() == t {
+		return g.load(r, v)
+	}
+
+	dest := reg(r, t)
+	if c, ok := v.(*ir.Const); ok {
+		g.emit(Mov(dest, imm(c.Value, t)))
+	} else if signed {
+		g.emit(Movsx(dest, g.source(v, r)))
+	} else {
+		g.emit(Movzx(dest, g.source(v, r)))
+	}
+	return dest
+}
+
+// The operand to read v from, v is only moved into the scratch register if
+// it can't be used where it is
+func (g *generator) source(v ir.Value, scratch *Register) Operand {
+	switch v := v.(type) {
+	case *ir.Const:
+		if fitsInt32(v.Value) {
+			return imm(v.Value, v.T)
+		}
+	case *ir.Register:
+		if _, ok := g.allocas[v]; !ok {
+			return g.location(v)
+		}
+	}
+	return g.load(scratch, v)
+}
+
+// The memory of type t that p points to
+func (g *generator) memory(p ir.Value, t ir.Type, scratch *Register) *Address {
+	switch p := p.(type) {
+	case *ir.Register:
+		if address, ok := g.allocas[p]; ok {
+			return &Address{Base: REG_RBP, Displacement: address.Displacement,
+				DataType: operandType(t)}
+		} else if base, ok := g.registers[p]; ok {
+			return &Address{Base: base, DataType: operandType(t)}
+		}
+	case *ir.Global:
+		if !p.Extern {
+			return &Address{Symbol: p.Name, DataType: operandType(t)}
+		}
+	}
+
+	base := g.load(scratch, p)
+	return &Address{Base: base.Register, DataType: operandType(t)}
+}
+
+// Move register r to where the result of instr is kept
+func (g *generator) result(instr *ir.Instruction, r *Register) {
+	if g.registers[instr.Dest] != r {
+		g.emit(Mov(g.location(instr.Dest), reg(r, instr.Dest.T)))
+	}
+}
+
+var arithmeticInstructions = map[ir.Op]func(Operand, Operand) *Instruction{
+	ir.Add:  Add,
+	ir.Sub:  Sub,
+	ir.Mul:  Imul,
+	ir.And:  And,
+	ir.Or:   Or,
+	ir.Xor:  Xor,
+	ir.Shl:  Shl,
+	ir.LShr: Shr,
+	ir.AShr: Sar,
+}
+
+var conditionCodes = map[ir.Op]string{
+	ir.Eq:  "e",
+	ir.Ne:  "ne",
+	ir.SLt: "l",
+	ir.SLe: "le",
+	ir.SGt: "g",
+	ir.SGe: "ge",
+	ir.ULt: "b",
+	ir.ULe: "be",
+	ir.UGt: "a",
+	ir.UGe: "ae",
+}
+
+func (g *generator) instruction(instr *ir.Instruction) {
+	args := instr.Args
+	switch op := instr.Op; {
+	case op == ir.SDiv || op == ir.UDiv || op == ir.SRem || op == ir.URem:
+		g.divide(instr)
+	case op.IsBinary():
+		g.binary(instr)
+	case op == ir.Neg || op == ir.Not:
+		rax := g.load(REG_RAX, args[0])
+		if op == ir.Neg {
+			g.emit(Neg(rax))
+		} else {
+			g.emit(Not(rax))
+		}
+		g.result(instr, REG_RAX)
+	case op.IsComparison():
+		a := g.load(REG_RAX, args[0])
+		g.emit(
+			Cmp(a, g.source(args[1], REG_RCX)),
+			Set(conditionCodes[op], reg(REG_RAX, ir.I8)),
+			Movzx(reg(REG_RAX, ir.I32), reg(REG_RAX, ir.I8)))
+		g.result(instr, REG_RAX)
+	case op == ir.SExt || op == ir.ZExt:
+		g.extend(instr)
+	case op == ir.Trunc || op == ir.PtrToInt || op == ir.IntToPtr || op == ir.Copy:
+		// the low bytes of a register are its truncated value
+		if r, ok := g.registers[instr.Dest]; ok {
+			g.load(r, args[0])
+			break
+		}
+		g.load(REG_RAX, args[0])
+		g.result(instr, REG_RAX)
+	case op == ir.Alloca:
+		// laid out with the frame
+	case op == ir.Load:
+		g.emit(Mov(reg(REG_RAX, instr.Dest.T), g.memory(args[0], instr.Dest.T, REG_RCX)))
+		g.result(instr, REG_RAX)
+	case op == ir.Store:
+		address := g.memory(args[1], args[0].Type(), REG_RCX)
+		var v Operand
+		if c, ok := args[0].(*ir.Const); ok && fitsInt32(c.Value) {
+			v = imm(c.Value, c.T)
+		} else {
+			v = g.load(REG_RAX, args[0])
+		}
+		g.emit(Mov(address, v))
+	case op == ir.Call:
+		g.call(instr)
+	case op == ir.DynAlloca:
+		rax, rsp := g.load(REG_RAX, args[0]), reg(REG_RSP, ir.Ptr)
+		g.emit(
+			Add(rax, imm(instr.Align-1, ir.I64)),
+			And(rax, imm(-instr.Align, ir.I64)),
+			Sub(rsp, rax),
+			Mov(g.location(instr.Dest), rsp))
+	case op == ir.StackSave:
+		g.emit(Mov(g.location(instr.Dest), reg(REG_RSP, ir.Ptr)))
+	case op == ir.StackRestore:
+		g.emit(Mov(reg(REG_RSP, ir.Ptr), g.load(REG_RAX, args[0])))
+	case op == ir.VaStart:
+		g.vaStart(instr)
+	case op == ir.VaArg:
+		g.vaArg(instr)
+	case op == ir.VaCopy:
+		g.load(REG_RCX, args[0])
+		g.load(REG_RDX, args[1])
+		rax := reg(REG_RAX, ir.I64)
+		for offset := int64(0); offset < int64(layout.TypeToSize["va_list"]); offset += 8 {
+			g.emit(
+				Mov(rax, &Address{Base: REG_RDX, Displacement: offset, DataType: longType}),
+				Mov(&Address{Base: REG_RCX, Displacement: offset, DataType: longType}, rax))
+		}
+	case op == ir.Jmp:
+		if instr.Blocks[0] != g.next {
+			g.emit(Jmp(blockLabel(instr.Blocks[0])))
+		}
+	case op == ir.Br:
+		cond := g.load(REG_RAX, args[0])
+		then, els := instr.Blocks[0], instr.Blocks[1]
+		g.emit(Test(cond, cond))
+		if then == g.next {
+			g.emit(Je(blockLabel(els)))
+			break
+		}
+		g.emit(Jne(blockLabel(then)))
+		if els != g.next {
+			g.emit(Jmp(blockLabel(els)))
+		}
+	case op == ir.Ret:
+		if len(args) > 0 {
+			g.load(REG_RAX, args[0])
+		}
+		g.restoreCalleeSaved()
+		g.emit(Leave(), Ret())
+	default:
+		g.f.err(fmt.Sprintf("internal compiler error: can not generate code for '%s'",
+			instr))
+	}
+}
+
+// Operations on 8 and 16 bit integers are done on 32 bit registers
+func operationType(t ir.Type) ir.Type {
+	if t.Size() < 4 {
+		return ir.I32
+	}
+	return t
+}
+
+func (g *generator) binary(instr *ir.Instruction) {
+	a, b := instr.Args[0], instr.Args[1]
+	t := operationType(instr.Dest.T)
+	signed := instr.Op == ir.AShr
+	rax := g.loadAs(REG_RAX, a, t, signed)
+
+	var source Operand
+	switch {
+	case instr.Op == ir.Shl || instr.Op == ir.LShr || instr.Op == ir.AShr:
+		// the count is an immediate or in cl
+		if c, ok := b.(*ir.Const); ok {
+			source = imm(c.Value&63, ir.I8)
+		} else {
+			g.load(REG_RCX, b)
+			source = reg(REG_RCX, ir.I8)
+		}
+	case t != instr.Dest.T:
+		source = g.loadAs(REG_RCX, b, t, signed)
+	default:
+		source = g.source(b, REG_RCX)
+	}
+
+	g.emit(arithmeticInstructions[instr.Op](rax, source))
+	g.result(instr, REG_RAX)
+}
+
+// The dividend goes in rdx:rax, the quotient ends up in rax and the
+// remainder in rdx
+func (g *generator) divide(instr *ir.Instruction) {
+	t := operationType(instr.Dest.T)
+	signed := instr.Op == ir.SDiv || instr.Op == ir.SRem
+	g.loadAs(REG_RAX, instr.Args[0], t, signed)
+	divisor := g.loadAs(REG_RCX, instr.Args[1], t, signed)
+
+	switch {
+	case !signed:
+		g.emit(Xor(reg(REG_RDX, ir.I32), reg(REG_RDX, ir.I32)), Div(divisor))
+	case t.Size() == 8:
+		g.emit(Cqo(), Idiv(divisor))
+	default:
+		g.emit(Cdq(), Idiv(divisor))
+	}
+
+	if instr.Op == ir.SDiv || instr.Op == ir.UDiv {
+		g.result(instr, REG_RAX)
+	} else {
+		g.result(instr, REG_RDX)
+	}
+}
+
+func (g *generator) extend(instr *ir.Instruction) {
+	from, to := instr.Args[0].Type(), instr.Dest.T
+	source := g.source(instr.Args[0], REG_RCX)
+	if isImmediate(source) {
+		source = g.load(REG_RCX, instr.Args[0])
+	}
+
+	rax := reg(REG_RAX, to)
+	switch {
+	case from.Size() == 4 && instr.Op == ir.SExt:
+		g.emit(Movsxd(rax, source))
+	case from.Size() == 4:
+		// writing to a 32 bit register clears the upper half
+		g.emit(Mov(reg(REG_RAX, ir.I32), source))
+	case instr.Op == ir.SExt:
+		g.emit(Movsx(rax, source))
+	default:
+		g.emit(Movzx(rax, source))
+	}
+	g.result(instr, REG_RAX)
+}
+
+// SysV calls pass the first 6 arguments in registers and push the rest from
+// right to left. r11 isn't used to pass arguments, so it holds the address
+// of an indirect call.
+func (g *generator) call(instr *ir.Instruction) {
+	callee, args := instr.Args[0], instr.Args[1:]
+	rsp := reg(REG_RSP, ir.Ptr)
+
+	// rsp has to be 16 byte aligned once the arguments are on the stack
+	var stackArgs, padding int
+	if len(args) > len(ARG_REGS) {
+		stackArgs = len(args) - len(ARG_REGS)
+	}
+	if stackArgs%2 != 0 {
+		padding = 1
+		g.emit(Sub(rsp, &ImmediateInt{Value: 8}))
+	}
+
+	for i := len(args) - 1; i >= len(ARG_REGS); i-- {
+		g.load(REG_RAX, args[i])
+		g.emit(Push(reg(REG_RAX, ir.I64)))
+	}
+	for i := 0; i < len(args) && i < len(ARG_REGS); i++ {
+		g.load(ARG_REGS[i], args[i])
+	}
+
+	var target Operand
+	if global, ok := callee.(*ir.Global); ok {
+		label := &LabelOperand{Name: global.Name}
+		g.f.compiler.calls = append(g.f.compiler.calls, label)
+		target = label
+	} else {
+		target = g.load(REG_R11, callee)
+	}
+
+	if instr.Variadic { // no arguments are passed in vector registers
+		g.emit(Mov(reg(REG_RAX, ir.I32), &ImmediateInt{Value: 0}))
+	}
+	g.emit(Call(target))
+
+	if n := stackArgs + padding; n > 0 {
+		g.emit(Add(rsp, &ImmediateInt{Value: int64(8 * n)}))
+	}
+
+	if instr.Dest != nil {
+		g.result(instr, REG_RAX)
+	}
+}
+
+// Jump to the callee of a tail call once the stack frame is torn down,
+// instead of calling it, so that it returns straight to our caller with
+// the stack no deeper than before. The arguments that go on the stack take
+// the place of ours, which are only read in the prologue, so the callee
+// can't take more of them than we do. Calls through a pointer are made as
+// usual.
+func (g *generator) siblingCall(instr *ir.Instruction) bool {
+	callee, ok := instr.Args[0].(*ir.Global)
+	args := instr.Args[1:]
+	if !ok || !g.f.compiler.SiblingCalls ||
+		len(args) > len(ARG_REGS) && len(args) > len(g.f.IR.Params) {
+		return false
+	}
+
+	for i := len(ARG_REGS); i < len(args); i++ {
+		g.load(REG_RAX, args[i])
+		g.emit(Mov(&Address{Base: REG_RBP, Displacement: int64(16 + 8*(i-len(ARG_REGS))),
+			DataType: longType}, reg(REG_RAX, ir.I64)))
+	}
+	for i := 0; i < len(args) && i < len(ARG_REGS); i++ {
+		g.load(ARG_REGS[i], args[i])
+	}
+
+	label := &LabelOperand{Name: callee.Name}
+	g.f.compiler.calls = append(g.f.compiler.calls, label)
+	if instr.Variadic {
+		g.emit(Mov(reg(REG_RAX, ir.I32), &ImmediateInt{Value: 0}))
+	}
+	g.restoreCalleeSaved()
+	g.emit(Leave(), Jmp(label))
+	return true
+}
+
+func (g *generator) restoreCalleeSaved() {
+	for _, r := range CALLEE_SAVED {
+		if slot, ok := g.saved[r]; ok {
+			g.emit(Mov(reg(r, ir.I64), slot))
+		}
+	}
+}
+
+func vaField(p *Register, offset int64, t ast.Declaration) *Address {
+	return &Address{Base: p, Displacement: offset, DataType: t}
+}
+
+// Point the va_list at the first unnamed argument
+func (g *generator) vaStart(instr *ir.Instruction) {
+	named := len(g.f.IR.Params)
+	var stackParams int
+	if named > len(ARG_REGS) {
+		named, stackParams = len(ARG_REGS), named-len(ARG_REGS)
+	}
+
+	p := g.load(REG_RCX, instr.Args[0]).Register
+	rax := reg(REG_RAX, ir.I64)
+	g.emit(
+		Mov(vaField(p, VA_GP_OFFSET, intType), &ImmediateInt{Value: int64(8 * named)}),
+		Mov(vaField(p, VA_FP_OFFSET, intType), &ImmediateInt{Value: 6 * 8}),
+		Lea(rax, &Address{Base: REG_RBP, Displacement: int64(16 + 8*stackParams)}),
+		Mov(vaField(p, VA_OVERFLOW_AREA, longType), rax),
+		Lea(rax, &Address{Base: REG_RBP, Displacement: g.regSaveArea}),
+		Mov(vaField(p, VA_REG_SAVE_AREA, longType), rax))
+}
+
+// Fetch the next unnamed argument from the register save area, or from the
+// stack once the argument registers are used up
+func (g *generator) vaArg(instr *ir.Instruction) {
+	p := g.load(REG_RCX, instr.Args[0]).Register
+	argAddress := reg(REG_RAX, ir.I64)
+	offset := reg(REG_RAX, ir.I32)
+	overflow, done := g.newLabel(), g.newLabel()
+	g.emit(
+		Mov(offset, vaField(p, VA_GP_OFFSET, intType)),
+		Cmp(offset, &ImmediateInt{Value: int64(8 * len(ARG_REGS))}),
+		Jae(overflow),
+		Add(vaField(p, VA_GP_OFFSET, intType), &ImmediateInt{Value: 8}),
+		Add(argAddress, vaField(p, VA_REG_SAVE_AREA, longType)),
+		Jmp(done),
+		Label(overflow),
+		Mov(argAddress, vaField(p, VA_OVERFLOW_AREA, longType)),
+		Add(vaField(p, VA_OVERFLOW_AREA, longType), &ImmediateInt{Value: 8}),
+		Label(done),
+		Mov(reg(REG_RAX, instr.Dest.T),
+			&Address{Base: REG_RAX, DataType: operandType(instr.Dest.T)}))
+	g.result(instr, REG_RAX)
+}
@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// Generate code for the function and return the assembly
+func generate(t *testing.T, f *ir.Function) string {
+	t.Helper()
+	if err := f.Verify(); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	fn := &Function{Name: f.Name, IR: f, compiler: &Compiler{}}
+	fn.generate()
+	return fn.Assembly()
+}
+
+// The number of bytes the prologue reserves below the frame pointer
+func frameSize(assembly string) string {
+	for _, line := range strings.Split(assembly, "\n") {
+		if strings.HasPrefix(line, "\tsub\trsp, ") {
+			return strings.TrimPrefix(line, "\tsub\trsp, ")
+		}
+	}
+	return ""
+}
+
+func TestFrameLayout(t *testing.T) {
+	// a function storing to a slot of every size, declared in the scope at
+	// the same position
+	build := func(scopes func(f *ir.Function) []*ir.Scope, sizes ...int64) *ir.Function {
+		f := ir.NewFunction("f", ir.I32, nil, false)
+		b := ir.NewBuilder(f)
+		for i, scope := range scopes(f) {
+			b.Scope = scope
+			b.Store(&ir.Const{Value: 1, T: ir.I64}, b.Alloca(sizes[i], 8))
+		}
+		b.Ret(&ir.Const{Value: 0, T: ir.I32})
+		return f
+	}
+
+	tests := []struct {
+		name     string
+		f        *ir.Function
+		expected string
+	}{
+		{
+			"blocks next to each other",
+			build(func(f *ir.Function) []*ir.Scope {
+				body := f.NewScope(nil)
+				return []*ir.Scope{f.NewScope(body), f.NewScope(body)}
+			}, 800, 800),
+			"0x320",
+		},
+		{
+			"the larger of the two",
+			build(func(f *ir.Function) []*ir.Scope {
+				body := f.NewScope(nil)
+				return []*ir.Scope{f.NewScope(body), f.NewScope(body)}
+			}, 16, 800),
+			"0x320",
+		},
+		{
+			"a block inside another",
+			build(func(f *ir.Function) []*ir.Scope {
+				body := f.NewScope(nil)
+				return []*ir.Scope{body, f.NewScope(body)}
+			}, 800, 800),
+			"0x640",
+		},
+		{
+			"the enclosing block after the inner ones",
+			build(func(f *ir.Function) []*ir.Scope {
+				body := f.NewScope(nil)
+				return []*ir.Scope{f.NewScope(body), f.NewScope(body), body}
+			}, 800, 800, 16),
+			"0x330",
+		},
+		{
+			// like the slots of an inlined call
+			"scopes of another function",
+			build(func(f *ir.Function) []*ir.Scope {
+				other := ir.NewFunction("g", ir.I32, nil, false)
+				return []*ir.Scope{f.NewScope(f.NewScope(nil)),
+					other.NewScope(other.NewScope(nil))}
+			}, 800, 800),
+			"0x640",
+		},
+		{
+			"unknown scopes",
+			build(func(f *ir.Function) []*ir.Scope { return []*ir.Scope{nil, nil} }, 800, 800),
+			"0x640",
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := frameSize(generate(t, tt.f)); actual != tt.expected {
+			t.Errorf("%s: expected a frame of %s bytes, got %s", tt.name, tt.expected, actual)
+		}
+	}
+}
@@ -1,19 +1,41 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/diag"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
+	"github.com/tjarjoura/cc/pkg/opt"
+	"github.com/tjarjoura/cc/pkg/sema"
 	"github.com/tjarjoura/cc/pkg/token"
 )
 
 type Compiler struct {
+	// The passes that run over the IR of the functions before code is
+	// generated for them, none if nil
+	Passes *opt.Manager
+
+	// Clean up the generated instructions with the peephole optimizer
+	Peephole bool
+
+	// Jump to the callee of calls that the passes marked as tail calls,
+	// see siblingCall
+	SiblingCalls bool
+
 	translationUnit *ast.TranslationUnit
+	info            *sema.Info
 	symbolMap       map[string]CompilationObject
 	functions       []*Function
-	registers       []bool
+	module          *ir.Module
+
+	calls   []*LabelOperand // targets of every call instruction
+	externs []string        // functions that are called but not defined
 
 	errors []CompileError
 }
@@ -31,17 +53,13 @@ func (c *Compiler) Errors() map[string][]CompileError {
 }
 
 type CompileError struct {
-	token token.Token
-	msg   string
-	warn  bool
+	diag.Diagnostic
 }
 
-func (c *CompileError) String() string {
-	var prefix = "[ERROR]"
-	if c.warn {
-		prefix = "[WARN]"
-	}
-	return fmt.Sprintf("%s %s", prefix, c.msg)
+func (c *CompileError) Warning() bool { return c.Severity == diag.Warning }
+
+func newError(pos token.Pos, severity diag.Severity, msg string) CompileError {
+	return CompileError{diag.Diagnostic{Severity: severity, Pos: pos, Message: msg}}
 }
 
 type CompilationObject interface {
@@ -51,72 +69,130 @@ type CompilationObject interface {
 
 type Function struct {
 	Name         string
-	Instructions []*Instruction
+	IR           *ir.Function
+	Instructions []*Instruction // generated from the IR
 	Type         ast.Declaration
-
-	variables map[string]*Address
-	registers map[*Register]bool
-	frameSize int64
-	errors    []CompileError
-
-	infixOperations  map[string]InfixOperation
-	prefixOperations map[string]PrefixOperation
+	Declaration  *ast.FunctionDeclaration
+
+	compiler *Compiler
+	b        *ir.Builder
+	scope    *scope
+	loop     *loop     // the innermost loop being compiled, nil if there is none
+	pos      token.Pos // where errors are reported
+	errors   []CompileError
+
+	// the storage of the local variables and parameters, by the
+	// declarations that the checker resolved their names to
+	variables map[ast.Declaration]*value
+	vlas      map[ast.Declaration]*vla
+
+	infixOperations  map[string]infixCompileFn
+	prefixOperations map[string]prefixCompileFn
 }
 
-func NewFunction(t ast.Declaration) *Function {
+func NewFunction(c *Compiler, decl *ast.FunctionDeclaration) *Function {
+	params := []ir.Type{}
+	for _, param := range decl.Parameters {
+		params = append(params, irType(ast.ParameterType(param)))
+	}
+
 	fn := &Function{
-		Type:      t,
-		variables: map[string]*Address{},
-		registers: map[*Register]bool{},
+		Name:        decl.Name,
+		IR:          ir.NewFunction(decl.Name, irType(decl.Type()), params, decl.Variadic),
+		Type:        decl.Type(),
+		Declaration: decl,
+		compiler:    c,
+		scope:       newScope(nil),
+		variables:   map[ast.Declaration]*value{},
+		vlas:        map[ast.Declaration]*vla{},
+		pos:         decl.NamePos,
 	}
+	fn.IR.Static = decl.StorageClass == "static"
+	fn.IR.Inline = decl.Inline
+	fn.b = ir.NewBuilder(fn.IR)
+	fn.b.Scope = fn.IR.NewScope(nil)
 	fn.registerOperations()
 	return fn
 }
 
 func (f *Function) Errors() []CompileError { return f.errors }
 
+// Whether any errors, as opposed to warnings, were reported
+func (f *Function) failed() bool {
+	for _, e := range f.errors {
+		if !e.Warning() {
+			return true
+		}
+	}
+	return false
+}
+
 func (f *Function) err(msg string) {
-	f.errors = append(f.errors, CompileError{msg: msg, warn: false})
+	f.errors = append(f.errors, newError(f.pos, diag.Error, msg))
 }
 
 func (f *Function) warn(msg string) {
-	f.errors = append(f.errors, CompileError{msg: msg, warn: true})
+	f.errors = append(f.errors, newError(f.pos, diag.Warning, msg))
+}
+
+// Report errors at pos until the returned function is called, which is
+// usually deferred
+func (f *Function) at(pos token.Pos) func() {
+	prev := f.pos
+	if pos.IsValid() {
+		f.pos = pos
+	}
+	return func() { f.pos = prev }
 }
 
 func (f *Function) Assembly() string {
 	var out strings.Builder
 
 	for _, instr := range f.Instructions {
-		out.WriteString("\t")
+		if !instr.IsLabel() {
+			out.WriteString("\t")
+		}
 		out.WriteString(instr.Assembly())
 		out.WriteString("\n")
 	}
 	return out.String()
 }
 
-func (f *Function) allocNextReg() *Register {
-	for _, reg := range REG_ORDER {
-		if !f.registers[reg] {
-			f.registers[reg] = true
-			return reg
-		}
+// Reserve a stack slot for a value of type t
+func (f *Function) alloca(t ast.Declaration, align uint64) *ir.Register {
+	if align > STACK_ALIGN {
+		f.warn(fmt.Sprintf(
+			"requested alignment %d is larger than the stack alignment %d",
+			align, STACK_ALIGN))
 	}
 
-	panic("ran out of registers to use! (will fix this in the future)")
+	return f.b.Alloca(int64(layout.SizeOf(t)), int64(align))
 }
 
-func (f *Function) allocReg(r *Register) { f.registers[r] = true }
-func (f *Function) freeReg(r *Register)  { f.registers[r] = false }
+// A variable length array lives below the fixed size stack frame, so we only
+// know its address and size at runtime
+type vla struct {
+	arrayType ast.Declaration
+	pointer   ir.Value // where the address of the array is stored
+
+	// where the sizes in bytes of the array and of its variable length
+	// elements are stored, they don't change when the bounds do
+	sizes map[*ast.Array]ir.Value
+}
 
 type Variable struct {
 	size    int
 	initial []byte
 }
 
+// rsp is 16 byte aligned at every call site in the SysV ABI
+const STACK_ALIGN = 16
+
 const (
-	TEXT = ".text"
-	DATA = ".data"
-	BSS  = ".bss"
+	TEXT   = ".text"
+	DATA   = ".data"
+	RODATA = ".rodata"
+	BSS    = ".bss"
 )
 
 // Needs to generate the raw bytes itself and also the relocation entries
@@ -124,27 +200,52 @@ func (v *Variable) generateBinary() []byte {
 	return v.initial
 }
 
-func New(tUnit *ast.TranslationUnit) *Compiler {
-	compiler := &Compiler{translationUnit: tUnit, symbolMap: map[string]CompilationObject{}}
+// The translation unit has to have passed the semantic checker, which
+// provides the types of its expressions
+func New(tUnit *ast.TranslationUnit, info *sema.Info) *Compiler {
+	compiler := &Compiler{
+		translationUnit: tUnit,
+		info:            info,
+		symbolMap:       map[string]CompilationObject{},
+		module:          &ir.Module{},
+	}
 	return compiler
 }
 
 func (c *Compiler) WriteAssembly(w io.StringWriter) error {
 	sections := map[string]*strings.Builder{
-		TEXT: &strings.Builder{},
-		//DATA: &strings.Builder{},
-	}
-	for symbol, obj := range c.symbolMap {
-		switch o := obj.(type) {
-		case *Function:
-			// TODO only if storage class != "static"
-			sections[TEXT].WriteString(fmt.Sprintf("GLOBAL %s\n", symbol))
-			sections[TEXT].WriteString(fmt.Sprintf("%s:\n", symbol))
-			sections[TEXT].WriteString(o.Assembly())
+		TEXT:   &strings.Builder{},
+		RODATA: &strings.Builder{},
+	}
+	for _, symbol := range c.externs {
+		sections[TEXT].WriteString(fmt.Sprintf("EXTERN %s\n", symbol))
+	}
+
+	for _, f := range c.functions {
+		if f.Declaration.StorageClass != "static" {
+			sections[TEXT].WriteString(fmt.Sprintf("GLOBAL %s\n", f.Name))
 		}
+		sections[TEXT].WriteString(fmt.Sprintf("%s:\n", f.Name))
+		sections[TEXT].WriteString(f.Assembly())
 	}
 
-	for section, data := range sections {
+	for _, str := range c.module.Strings {
+		bytes := []string{}
+		for _, b := range []byte(str.Value) {
+			bytes = append(bytes, fmt.Sprintf("%d", b))
+		}
+		bytes = append(bytes, "0")
+
+		sections[RODATA].WriteString(fmt.Sprintf("%s:\n\tdb\t%s\n",
+			str.Name, strings.Join(bytes, ", ")))
+	}
+
+	for _, section := range []string{TEXT, RODATA} {
+		data := sections[section]
+		if data.Len() == 0 {
+			continue
+		}
+
 		if _, err := w.WriteString(fmt.Sprintf("SECTION %s\n", section)); err != nil {
 			return err
 		}
@@ -157,25 +258,79 @@ func (c *Compiler) WriteAssembly(w io.StringWriter) error {
 	return nil
 }
 
+// The IR of the translation unit, once it is compiled
+func (c *Compiler) IR() *ir.Module { return c.module }
+
 func (c *Compiler) compileFunction(fnDecl *ast.FunctionDeclaration) {
-	f := NewFunction(fnDecl.Type())
+	if fnDecl.Body == nil {
+		return
+	}
+
+	f := NewFunction(c, fnDecl)
 	c.symbolMap[fnDecl.Name] = f
+	c.functions = append(c.functions, f)
 
-	if fnDecl.Body != nil {
-		for _, stmt := range fnDecl.Body.Statements {
-			f.compileStatement(stmt)
+	f.compileParameters()
+	for _, stmt := range fnDecl.Body.Statements {
+		f.compileStatement(stmt)
+	}
+
+	// falling off the end of main returns 0
+	if !f.b.Terminated() {
+		if f.Name == "main" && f.IR.Return != ir.Void {
+			f.b.Ret(&ir.Const{Value: 0, T: f.IR.Return})
+		} else {
+			f.b.Ret(nil)
 		}
 	}
 
-	vp := &ast.Pointer{PointsTo: &ast.BaseType{Name: token.VOID}}
-	rbp := &RegisterOperand{REG_RBP, vp}
-	rsp := &RegisterOperand{REG_RSP, vp}
+	// statements after a return are lowered into blocks that nothing jumps
+	// to, there is no point in generating code for them
+	f.IR.RemoveUnreachable()
+
+	c.module.Functions = append(c.module.Functions, f.IR)
+	if f.failed() {
+		return
+	} else if err := f.IR.Verify(); err != nil {
+		f.err(fmt.Sprintf("internal compiler error: %s", err))
+	}
+}
+
+func (c *Compiler) failed() bool {
+	for _, f := range c.functions {
+		if f.failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// Run the passes over the functions, which were all lowered without errors,
+// and leave out the functions that the inliner removed
+func (c *Compiler) optimize() {
+	if err := c.Passes.RunModule(c.module); err != nil {
+		var passErr *opt.Error
+		if errors.As(err, &passErr) {
+			for _, f := range c.functions {
+				if f.IR == passErr.Function {
+					f.err(fmt.Sprintf("internal compiler error: %s", err))
+				}
+			}
+		}
+		return
+	}
 
-	f.Instructions = append([]*Instruction{
-		Push(rbp),
-		Mov(rbp, rsp),
-		Sub(rsp, &ImmediateInt{Value: f.frameSize}),
-	}, f.Instructions...)
+	kept := map[*ir.Function]bool{}
+	for _, fn := range c.module.Functions {
+		kept[fn] = true
+	}
+	functions := []*Function{}
+	for _, f := range c.functions {
+		if kept[f.IR] {
+			functions = append(functions, f)
+		}
+	}
+	c.functions = functions
 }
 
 func (c *Compiler) Compile() {
@@ -189,4 +344,32 @@ func (c *Compiler) Compile() {
 			}
 		}
 	}
+
+	// the inliner looks at every function at once, so they all have to be
+	// lowered without errors before the passes run
+	if c.failed() {
+		return
+	} else if c.Passes != nil {
+		if c.optimize(); c.failed() {
+			return
+		}
+	}
+
+	for _, f := range c.functions {
+		f.generate()
+	}
+
+	// functions that aren't defined here are resolved by the dynamic linker
+	externs := map[string]bool{}
+	for _, label := range c.calls {
+		if _, ok := c.symbolMap[label.Name]; !ok {
+			label.Plt = true
+			externs[label.Name] = true
+		}
+	}
+
+	for name := range externs {
+		c.externs = append(c.externs, name)
+	}
+	sort.Strings(c.externs)
 }
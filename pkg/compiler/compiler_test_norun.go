@@ -6,6 +6,7 @@ import (
 
 	"github.com/tjarjoura/cc/pkg/lexer"
 	"github.com/tjarjoura/cc/pkg/parser"
+	"github.com/tjarjoura/cc/pkg/sema"
 )
 
 func checkParserErrors(t *testing.T, p *parser.Parser) bool {
@@ -18,6 +19,16 @@ func checkParserErrors(t *testing.T, p *parser.Parser) bool {
 	return ret
 }
 
+func checkSemaErrors(t *testing.T, c *sema.Checker) bool {
+	var ret = true
+	for _, err := range c.Errors() {
+		t.Errorf("semantic error: %s", err.String())
+		ret = false
+	}
+
+	return ret
+}
+
 func checkCompilerErrors(t *testing.T, c *Compiler) bool {
 	var ret = true
 	for _, err := range c.errors {
@@ -67,7 +78,13 @@ func TestReturnStatement(t *testing.T) {
 			t.FailNow()
 		}
 
-		c := New(tUnit)
+		checker := sema.New(tUnit)
+		info := checker.Check()
+		if !checkSemaErrors(t, checker) {
+			t.FailNow()
+		}
+
+		c := New(tUnit, info)
 		c.Compile()
 		if !checkCompilerErrors(t, c) {
 			t.FailNow()
@@ -157,7 +174,13 @@ main:
 			t.FailNow()
 		}
 
-		c := New(tUnit)
+		checker := sema.New(tUnit)
+		info := checker.Check()
+		if !checkSemaErrors(t, checker) {
+			t.FailNow()
+		}
+
+		c := New(tUnit, info)
 		c.Compile()
 		if !checkCompilerErrors(t, c) {
 			t.FailNow()
@@ -1,23 +1,91 @@
 package compiler
 
-import "github.com/tjarjoura/cc/pkg/ast"
+import (
+	"fmt"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
+)
 
 func (f *Function) compileVariableDeclaration(varDecl *ast.VariableDeclaration) {
+	defer f.at(varDecl.NamePos)()
 	t := varDecl.Type()
-	f.frameSize += int64(SizeOf(t))
-	address := &Address{
-		Base:         REG_RBP,
-		Displacement: -1 * f.frameSize,
-		DataType:     t,
+	if layout.IsVLA(t) {
+		f.compileVLADeclaration(varDecl)
+		return
+	} else if !ast.IsComplete(t) {
+		f.err(fmt.Sprintf("storage size of '%s' isn't known", varDecl.Name))
+		return
 	}
 
-	f.variables[varDecl.Name] = address
+	address := f.alloca(t, layout.DeclAlignment(varDecl))
+	f.declareVariable(varDecl, &value{val: address, dataType: t, lvalue: true})
 
 	if varDecl.Definition != nil {
 		result := f.compileExpression(varDecl.Definition)
-		f.Instructions = append(f.Instructions, Mov(address, result))
-		if reg, ok := result.(*RegisterOperand); ok {
-			f.freeReg(reg.Register)
+		if result == nil {
+			return
+		}
+		result = f.compileTypeConversion(t, result.Type(), result)
+		if result == nil {
+			return
+		} else if result.lvalue {
+			f.err(fmt.Sprintf("initialization of '%s' is not supported yet",
+				ast.TypeString(t)))
+			return
 		}
+
+		f.store(result, address)
+	}
+}
+
+func (f *Function) compileVLADeclaration(varDecl *ast.VariableDeclaration) {
+	t := varDecl.Type()
+	v := &vla{
+		arrayType: t,
+		pointer:   f.alloca(&ast.Pointer{PointsTo: t}, layout.PtrSize),
+		sizes:     map[*ast.Array]ir.Value{},
+	}
+
+	size := f.compileVLASizes(t, v.sizes)
+	if size == nil {
+		return
+	}
+
+	// keep rsp aligned when making room for the array
+	align := layout.DeclAlignment(varDecl)
+	if align < STACK_ALIGN {
+		align = STACK_ALIGN
 	}
+
+	if f.scope.stackPointer == nil { // so that leaving the scope frees the array
+		f.scope.stackPointer = f.b.StackSave()
+	}
+	f.b.Store(f.b.DynAlloca(size.val, int64(align)), v.pointer)
+
+	f.declareVLA(varDecl, v)
+}
+
+// Compute the sizes of the variable length arrays in t like compileVLASize(),
+// storing each of them in a slot of sizes
+func (f *Function) compileVLASizes(t ast.Declaration, sizes map[*ast.Array]ir.Value) *value {
+	arr, ok := t.(*ast.Array)
+	if !ok || !layout.IsVLA(t) {
+		return f.constant(int64(layout.SizeOf(t)), ast.SizeType)
+	}
+
+	elemSize := f.compileVLASizes(arr.ArrayOf, sizes)
+	if elemSize == nil {
+		return nil
+	}
+
+	size := f.compileArraySize(arr, elemSize)
+	if size == nil {
+		return nil
+	}
+
+	sizes[arr] = f.alloca(ast.SizeType, layout.AlignOf(ast.SizeType))
+	f.b.Store(size.val, sizes[arr])
+	return size
 }
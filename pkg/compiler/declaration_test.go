@@ -0,0 +1,40 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/opt"
+)
+
+// The constant that f returns, once the passes have folded it
+func returnedConstant(f *ir.Function) (int64, bool) {
+	for _, b := range f.Blocks {
+		if term := b.Terminator(); term.Op == ir.Ret {
+			c, ok := term.Args[0].(*ir.Const)
+			return c.Value, ok
+		}
+	}
+	return 0, false
+}
+
+// The sizes of a variable length array don't change when its bounds do
+func TestVLASizeof(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"unsigned long f() { int n = 4, m = 5; int v[n][m]; m = 100; return sizeof v[0]; }", 20},
+		{"unsigned long f() { int n = 4, m = 5; int v[n][m]; n = 1; m = 1; return sizeof v; }", 80},
+		{"unsigned long f() { int n = 4, m = 5; int v[n][m]; m = 100; return sizeof *v; }", 20},
+		{"unsigned long f() { int n = 4; int v[n]; n = 100; { int n = 2; return sizeof v; } }", 16},
+		{"unsigned long f() { int n = 4; int v[n]; n = 6; return sizeof(int[n]); }", 24},
+	}
+
+	for _, tt := range tests {
+		f := compileWith(t, tt.input, opt.NewPipeline(1)).IR().Function("f")
+		if c, ok := returnedConstant(f); !ok || c != tt.expected {
+			t.Errorf("%q: expected to return %d:\n%s", tt.input, tt.expected, f)
+		}
+	}
+}
@@ -4,93 +4,423 @@ import (
 	"fmt"
 
 	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/constant"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
+	"github.com/tjarjoura/cc/pkg/token"
 )
 
-func (f *Function) compileInfixExpression(inf *ast.InfixExpression) Operand {
-	leftE := f.compileExpression(inf.Left)
-	rightE := f.compileExpression(inf.Right)
+// The result of compiling an expression. The value of an lvalue is the
+// address of the object it designates, which is only loaded once the value
+// is needed.
+type value struct {
+	val      ir.Value
+	dataType ast.Declaration
+	lvalue   bool
+}
+
+func (v *value) Type() ast.Declaration { return v.dataType }
+
+func (f *Function) constant(n int64, t ast.Declaration) *value {
+	return &value{val: &ir.Const{Value: n, T: irType(t)}, dataType: t}
+}
+
+// The constant that v holds, if it is known at compile time
+func constantOf(v *value) (*ir.Const, bool) {
+	c, ok := v.val.(*ir.Const)
+	return c, ok && !v.lvalue
+}
+
+func (f *Function) compileInfixExpression(inf *ast.InfixExpression) *value {
+	switch inf.Operator {
+	case token.COMMA:
+		return f.compileComma(inf)
+	case token.ASSIGN:
+		return f.compileAssignment(inf)
+	}
 
-	if leftE == nil || rightE == nil {
+	leftE := f.compileExpression(inf.Left)
+	if leftE == nil {
 		return nil
 	}
+	leftE = f.rvalue(leftE)
 
-	op, ok := f.infixOperations[inf.Operator]
-	if !ok {
-		f.err(fmt.Sprintf(
-			"Can not handle %s operators!", inf.Operator))
+	rightE := f.compileExpression(inf.Right)
+	if rightE == nil {
 		return nil
 	}
+	rightE = f.rvalue(rightE)
 
-	if isImmediate(leftE) && isImmediate(rightE) {
-		if op.CompileImmediate == nil {
-			f.err(fmt.Sprintf(
-				"Cannot handle %s operator for immediate operands",
-				inf.Operator))
-			return nil
-		}
-		return op.CompileImmediate(inf.Operator,
-			leftE.(Immediate), rightE.(Immediate))
+	if v, ok := f.foldInfix(inf.Operator, leftE, rightE); ok {
+		return v
 	}
 
-	if op.CompileRuntime == nil {
-		f.err(fmt.Sprintf("Cannot handle %s operators at runtime!",
-			inf.Operator))
+	compile, ok := f.infixOperations[inf.Operator]
+	if !ok {
+		f.err(fmt.Sprintf(
+			"Can not handle %s operators!", inf.Operator))
 		return nil
 	}
-
-	return op.CompileRuntime(inf.Operator, leftE, rightE)
+	return compile(inf.Operator, leftE, rightE)
 }
 
-func (f *Function) compilePrefixExpression(p *ast.PrefixExpression) Operand {
+func (f *Function) compilePrefixExpression(p *ast.PrefixExpression) *value {
 	rightOp := f.compileExpression(p.Right)
 	if rightOp == nil {
 		return nil
 	}
 
-	op, ok := f.prefixOperations[p.Operator]
+	if v, ok := f.foldPrefix(p.Operator, rightOp); ok {
+		return v
+	}
+
+	compile, ok := f.prefixOperations[p.Operator]
 	if !ok {
 		f.err(fmt.Sprintf(
 			"can not handle prefix operator '%s'", p.Operator))
 		return nil
 	}
+	return compile(p.Operator, rightOp)
+}
 
-	if rightOp.OperandType() == OP_TYPE_IMMEDIATE {
-		if op.CompileImmediate == nil {
-			f.err(fmt.Sprintf(
-				"cannot handle prefix operator '%s' at compile time",
-				p.Operator))
-			return nil
-		}
+// Compute an operation on integer constants at compile time with the rules
+// of C. Operations whose result is undefined, like a division by zero, are
+// left for runtime.
+func (f *Function) foldInfix(op string, a *value, b *value) (*value, bool) {
+	x, okX := constantOf(a)
+	y, okY := constantOf(b)
+	if !okX || !okY {
+		return nil, false
+	}
 
-		return op.CompileImmediate(p.Operator, rightOp.(Immediate))
+	v, err := constant.Binary(op, constant.Value{Int: x.Value, Type: a.Type()},
+		constant.Value{Int: y.Value, Type: b.Type()})
+	if err != nil {
+		return nil, false
 	}
+	return f.constant(v.Int, v.Type), true
+}
 
-	if op.CompileRuntime == nil {
-		f.err(fmt.Sprintf(
-			"cannot handle prefix operator '%s' at runtime",
-			p.Operator))
-		return nil
+func (f *Function) foldPrefix(op string, a *value) (*value, bool) {
+	x, ok := constantOf(a)
+	if !ok {
+		return nil, false
 	}
-	return op.CompileRuntime(p.Operator, rightOp)
+
+	v, err := constant.Unary(op, constant.Value{Int: x.Value, Type: a.Type()})
+	if err != nil {
+		return nil, false
+	}
+	return f.constant(v.Int, v.Type), true
 }
 
 /*
 *
-Generate machine instructions for the expression and return an operand
-representing either the result or where the result will be stored
+Lower the expression to IR and return the value it computes, or the address
+of the object it designates for an lvalue
 *
 */
-func (f *Function) compileExpression(expr ast.Expression) Operand {
+func (f *Function) compileExpression(expr ast.Expression) *value {
+	if expr == nil {
+		return nil
+	}
+
+	defer f.at(expr.Pos())()
 	switch e := expr.(type) {
 	case *ast.InfixExpression:
 		return f.compileInfixExpression(e)
 	case *ast.PrefixExpression:
 		return f.compilePrefixExpression(e)
 	case *ast.Identifier:
-		return f.variables[e.Value]
+		return f.compileIdentifier(e)
+	case *ast.ConditionalExpression:
+		return f.compileConditional(e)
 	case *ast.IntegerLiteral:
-		return &ImmediateInt{Value: e.Value}
+		return f.constant(e.Value, f.typeOf(e))
+	case *ast.SizeofExpression:
+		return f.compileSizeof(e)
+	case *ast.AlignofExpression:
+		return f.compileAlignof(e)
+	case *ast.CallExpression:
+		return f.compileCall(e)
+	case *ast.VaArgExpression:
+		return f.compileVaArg(e)
+	case *ast.StringLiteral:
+		return f.compileStringLiteral(e)
+	case *ast.IndexExpression:
+		return f.compileIndex(e)
+	case *ast.MemberExpression:
+		return f.compileMember(e)
+	case *ast.ImplicitConversion:
+		return f.compileImplicitConversion(e)
 	}
 
 	return nil
 }
+
+// Replace an array (or va_list) with a pointer to its first element, and a
+// function with a pointer to it
+func (f *Function) decay(v *value) *value {
+	if !v.lvalue {
+		return v
+	}
+
+	t := ast.Decay(v.dataType)
+	if ast.IsVaList(v.dataType) {
+		t = &ast.Pointer{PointsTo: v.dataType}
+	} else if t == v.dataType {
+		return v
+	}
+
+	return &value{val: v.val, dataType: t}
+}
+
+// The value of an expression, which has to be loaded if it is an lvalue.
+// Arrays and functions decay to pointers instead.
+func (f *Function) rvalue(v *value) *value {
+	v = f.decay(v)
+	if !v.lvalue || !ast.IsScalar(v.dataType) {
+		return v
+	}
+
+	return &value{val: f.b.Load(irType(v.dataType), v.val), dataType: v.dataType}
+}
+
+// Store a scalar rvalue at address
+func (f *Function) store(v *value, address ir.Value) {
+	f.b.Store(v.val, address)
+}
+
+// The left operand is evaluated only for its side effects
+func (f *Function) compileComma(inf *ast.InfixExpression) *value {
+	left := f.compileExpression(inf.Left)
+	if left == nil {
+		return nil
+	}
+
+	return f.compileExpression(inf.Right)
+}
+
+func (f *Function) compileAssignment(inf *ast.InfixExpression) *value {
+	left := f.compileExpression(inf.Left)
+	if left == nil {
+		return nil
+	}
+
+	if !ast.IsScalar(left.dataType) {
+		f.err(fmt.Sprintf("assignment of type '%s' is not supported yet",
+			ast.TypeString(left.dataType)))
+		return nil
+	}
+
+	right := f.compileExpression(inf.Right)
+	if right == nil {
+		return nil
+	}
+
+	right = f.compileTypeConversion(left.dataType, right.Type(), right)
+	if right == nil {
+		return nil
+	}
+
+	f.store(right, left.val)
+	return left
+}
+
+// Only one of the second and third operands is evaluated, depending on the
+// value of the first one
+func (f *Function) compileConditional(c *ast.ConditionalExpression) *value {
+	resultType := f.typeOf(c)
+	if !ast.IsScalar(resultType) {
+		f.err(fmt.Sprintf(
+			"conditional expressions of type '%s' are not supported yet",
+			ast.TypeString(resultType)))
+		return nil
+	}
+
+	cond := f.compileExpression(c.Condition)
+	if cond == nil {
+		return nil
+	}
+	cond = f.rvalue(cond)
+
+	if imm, ok := constantOf(cond); ok { // no need to evaluate the other arm
+		if imm.Value != 0 {
+			return f.compileArm(c.Consequence, resultType)
+		}
+		return f.compileArm(c.Alternative, resultType)
+	}
+
+	// both arms store their value in the same slot
+	result := f.alloca(resultType, layout.AlignOf(resultType))
+	then, els, end := f.IR.NewBlock(), f.IR.NewBlock(), f.IR.NewBlock()
+	f.b.Br(cond.val, then, els)
+
+	f.b.StartBlock(then)
+	consequence := f.compileArm(c.Consequence, resultType)
+	if consequence == nil {
+		return nil
+	}
+	f.store(consequence, result)
+	f.b.Jmp(end)
+
+	f.b.StartBlock(els)
+	alternative := f.compileArm(c.Alternative, resultType)
+	if alternative == nil {
+		return nil
+	}
+	f.store(alternative, result)
+	f.b.Jmp(end)
+
+	f.b.StartBlock(end)
+	return f.rvalue(&value{val: result, dataType: resultType, lvalue: true})
+}
+
+func (f *Function) compileArm(expr ast.Expression, t ast.Declaration) *value {
+	v := f.compileExpression(expr)
+	if v == nil {
+		return nil
+	}
+
+	return f.compileTypeConversion(t, v.Type(), v)
+}
+
+// String literals are arrays of char stored in a read only section
+func (f *Function) compileStringLiteral(s *ast.StringLiteral) *value {
+	return &value{val: f.compiler.module.AddString(s.Value), dataType: f.typeOf(s),
+		lvalue: true}
+}
+
+// Identifiers are lowered to what the checker resolved them to
+func (f *Function) compileIdentifier(ident *ast.Identifier) *value {
+	decl := f.compiler.info.Uses[ident]
+	if fnDecl, ok := decl.(*ast.FunctionDeclaration); ok {
+		return f.compileFunctionDesignator(fnDecl)
+	}
+
+	variable, v := f.lookup(decl)
+	if v != nil {
+		pointer := f.b.Load(ir.Ptr, v.pointer)
+		return &value{val: pointer, dataType: v.arrayType, lvalue: true}
+	} else if variable == nil {
+		f.err(fmt.Sprintf("internal compiler error: '%s' has no storage", ident.Value))
+	}
+	return variable
+}
+
+// The address offset bytes past p
+func (f *Function) offset(p ir.Value, offset int64) ir.Value {
+	if offset == 0 {
+		return p
+	}
+	return f.b.Binary(ir.Add, p, &ir.Const{Value: offset, T: ir.I64})
+}
+
+// The address of the element, scaled by its size, as an lvalue of the
+// element type. Either side can be the pointer.
+func (f *Function) compileIndex(e *ast.IndexExpression) *value {
+	left := f.compileExpression(e.Left)
+	if left == nil {
+		return nil
+	}
+	left = f.rvalue(left)
+
+	right := f.compileExpression(e.Index)
+	if right == nil {
+		return nil
+	}
+	right = f.rvalue(right)
+
+	if ast.IsInteger(left.Type()) && ast.IsPointer(right.Type()) {
+		left, right = right, left
+	}
+
+	p := left.Type().(*ast.Pointer)
+	if layout.IsVLA(p.PointsTo) {
+		f.err(fmt.Sprintf("subscripting a pointer to '%s' is not supported",
+			ast.TypeString(p.PointsTo)))
+		return nil
+	}
+
+	size := int64(layout.SizeOf(p.PointsTo))
+	if imm, ok := constantOf(right); ok {
+		return &value{val: f.offset(left.val, imm.Value*size), dataType: p.PointsTo,
+			lvalue: true}
+	}
+
+	index := f.loadLong(right).val
+	if size != 1 {
+		index = f.b.Binary(ir.Mul, index, &ir.Const{Value: size, T: ir.I64})
+	}
+
+	return &value{val: f.b.Binary(ir.Add, left.val, index), dataType: p.PointsTo,
+		lvalue: true}
+}
+
+func (f *Function) compileMember(e *ast.MemberExpression) *value {
+	left := f.compileExpression(e.Left)
+	if left == nil {
+		return nil
+	}
+
+	if e.Arrow {
+		left = f.rvalue(left)
+		p := left.Type().(*ast.Pointer)
+		left = &value{val: left.val, dataType: p.PointsTo, lvalue: true}
+	} else if !left.lvalue {
+		f.err(fmt.Sprintf("member '%s' of a structure that is not in memory is not supported yet",
+			e.Member))
+		return nil
+	}
+
+	s := left.dataType.(*ast.StructOrUnionSpecification)
+	member, offset := structMember(s, e.Member)
+	return &value{val: f.offset(left.val, int64(offset)),
+		dataType: ast.Qualify(member.Type(), ast.IsConst(s), ast.IsVolatile(s)),
+		lvalue:   true}
+}
+
+func (f *Function) compileSizeof(s *ast.SizeofExpression) *value {
+	t := s.TypeName
+	if t == nil {
+		t = f.typeOf(s.Right)
+	}
+
+	return f.compileVLASize(t)
+}
+
+func (f *Function) compileAlignof(a *ast.AlignofExpression) *value {
+	return f.constant(int64(layout.AlignOf(a.TypeName)), ast.SizeType)
+}
+
+// Compute the size of a (possibly variable length) array type at runtime. The
+// sizes of the arrays in a declaration are fixed when it is declared, so they
+// are loaded from where the declaration stored them.
+func (f *Function) compileVLASize(t ast.Declaration) *value {
+	arr, ok := t.(*ast.Array)
+	if !ok || !layout.IsVLA(t) {
+		return f.constant(int64(layout.SizeOf(t)), ast.SizeType)
+	}
+
+	if slot := f.lookupVLASize(arr); slot != nil {
+		return &value{val: f.b.Load(ir.I64, slot), dataType: ast.SizeType}
+	}
+
+	elemSize := f.compileVLASize(arr.ArrayOf)
+	if elemSize == nil {
+		return nil
+	}
+
+	return f.compileArraySize(arr, elemSize)
+}
+
+// The size of arr given the size of its elements
+func (f *Function) compileArraySize(arr *ast.Array, elemSize *value) *value {
+	length := f.compileExpression(arr.ArraySize)
+	if length == nil {
+		return nil
+	}
+
+	size := f.loadLong(f.rvalue(length))
+	return &value{val: f.b.Binary(ir.Mul, size.val, elemSize.val), dataType: ast.SizeType}
+}
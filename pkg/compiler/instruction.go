@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/layout"
 )
 
 /* AMD64 */
@@ -18,10 +19,35 @@ var (
 	REG_RSI = &Register{map[uint64]string{8: "rsi", 4: "esi", 2: "si", 1: "sil"}}
 	REG_RSP = &Register{map[uint64]string{8: "rsp", 4: "esp", 2: "sp", 1: "spl"}}
 	REG_RBP = &Register{map[uint64]string{8: "rbp", 4: "ebp", 2: "bp", 1: "bpl"}}
+	REG_R8  = &Register{map[uint64]string{8: "r8", 4: "r8d", 2: "r8w", 1: "r8b"}}
+	REG_R9  = &Register{map[uint64]string{8: "r9", 4: "r9d", 2: "r9w", 1: "r9b"}}
+	REG_R10 = &Register{map[uint64]string{8: "r10", 4: "r10d", 2: "r10w", 1: "r10b"}}
+	REG_R11 = &Register{map[uint64]string{8: "r11", 4: "r11d", 2: "r11w", 1: "r11b"}}
+	REG_R12 = &Register{map[uint64]string{8: "r12", 4: "r12d", 2: "r12w", 1: "r12b"}}
+	REG_R13 = &Register{map[uint64]string{8: "r13", 4: "r13d", 2: "r13w", 1: "r13b"}}
+	REG_R14 = &Register{map[uint64]string{8: "r14", 4: "r14d", 2: "r14w", 1: "r14b"}}
+	REG_R15 = &Register{map[uint64]string{8: "r15", 4: "r15d", 2: "r15w", 1: "r15b"}}
+
+	REG_XMM = []*Register{
+		{map[uint64]string{16: "xmm0"}}, {map[uint64]string{16: "xmm1"}},
+		{map[uint64]string{16: "xmm2"}}, {map[uint64]string{16: "xmm3"}},
+		{map[uint64]string{16: "xmm4"}}, {map[uint64]string{16: "xmm5"}},
+		{map[uint64]string{16: "xmm6"}}, {map[uint64]string{16: "xmm7"}},
+	}
+
+	// registers used to pass the first integer arguments of a call
+	ARG_REGS = []*Register{REG_RDI, REG_RSI, REG_RDX, REG_RCX, REG_R8, REG_R9}
+
+	// registers that a call may change, the rest keep their values
+	CALLER_SAVED = []*Register{REG_RAX, REG_RCX, REG_RDX, REG_RSI, REG_RDI,
+		REG_R8, REG_R9, REG_R10, REG_R11}
+	CALLEE_SAVED = []*Register{REG_RBX, REG_R12, REG_R13, REG_R14, REG_R15}
 
-	// order that registers will be used in for computing generic
-	// expressions
-	REG_ORDER = []*Register{REG_RAX, REG_RCX, REG_RDX}
+	// order that registers are given to virtual registers in, the caller
+	// saved ones that instructions don't use as scratch registers come first
+	// and the callee saved ones, which have to be saved in the prologue, last
+	REG_ORDER = []*Register{REG_R10, REG_R11, REG_R8, REG_R9, REG_RDI, REG_RSI,
+		REG_RDX, REG_RCX, REG_RAX, REG_RBX, REG_R12, REG_R13, REG_R14, REG_R15}
 )
 
 type OperandType string
@@ -30,6 +56,7 @@ const (
 	OP_TYPE_REGISTER  OperandType = "register"
 	OP_TYPE_ADDRESS               = "address"
 	OP_TYPE_IMMEDIATE             = "immediate"
+	OP_TYPE_LABEL                 = "label"
 )
 
 type Register struct {
@@ -43,6 +70,7 @@ type Instruction struct {
 	neumonic string
 	operandA Operand
 	operandB Operand
+	label    string // if set, this is not an instruction but a label
 }
 
 type Operand interface {
@@ -62,14 +90,14 @@ type RegisterOperand struct {
 }
 
 func (r *RegisterOperand) String() string {
-	name, ok := r.Register.NameMap[SizeOf(r.Type())]
+	name, ok := r.Register.NameMap[layout.SizeOf(r.Type())]
 	if !ok {
 		return "???"
 	}
 
 	return name
 }
-func (r *RegisterOperand) Size() uint64             { return SizeOf(r.DataType) }
+func (r *RegisterOperand) Size() uint64             { return layout.SizeOf(r.DataType) }
 func (r *RegisterOperand) Type() ast.Declaration    { return r.DataType }
 func (r *RegisterOperand) OperandType() OperandType { return OP_TYPE_REGISTER }
 
@@ -79,37 +107,68 @@ type Immediate interface {
 }
 
 type ImmediateInt struct {
-	Value int64
+	Value    int64
+	DataType ast.Declaration // if nil, the smallest type that fits the value
 }
 
-func (i *ImmediateInt) immediateOperand()        {}
-func (i *ImmediateInt) String() string           { return fmt.Sprintf("0x%x", i.Value) }
-func (i *ImmediateInt) Size() uint64             { return IntSize(uint64(i.Value)) }
-func (i *ImmediateInt) Type() ast.Declaration    { return IntType(i.Value) }
+func (i *ImmediateInt) immediateOperand() {}
+func (i *ImmediateInt) String() string {
+	if i.Value < 0 {
+		return fmt.Sprintf("-0x%x", -i.Value)
+	}
+	return fmt.Sprintf("0x%x", i.Value)
+}
+func (i *ImmediateInt) Size() uint64 { return IntSize(uint64(i.Value)) }
+func (i *ImmediateInt) Type() ast.Declaration {
+	if i.DataType != nil {
+		return i.DataType
+	}
+	return IntType(i.Value)
+}
 func (i *ImmediateInt) OperandType() OperandType { return OP_TYPE_IMMEDIATE }
 
+type LabelOperand struct {
+	Name  string
+	Plt   bool // the label is a function defined in another object file
+	Short bool // the label is close enough to jump to with an 8 bit displacement
+}
+
+func (l *LabelOperand) String() string {
+	if l.Plt {
+		return fmt.Sprintf("%s wrt ..plt", l.Name)
+	} else if l.Short {
+		return fmt.Sprintf("short %s", l.Name)
+	}
+	return l.Name
+}
+func (l *LabelOperand) Size() uint64             { return layout.PtrSize }
+func (l *LabelOperand) Type() ast.Declaration    { return nil }
+func (l *LabelOperand) OperandType() OperandType { return OP_TYPE_LABEL }
+
 type Address struct {
 	Base         *Register
-	Scale        *Register
+	Scale        int64 // multiplies the index, which is used as is if 0
 	Index        *Register
 	Displacement int64
+	Symbol       string // address relative to a symbol instead of a register
 	DataType     ast.Declaration
 }
 
-func (a *Address) Size() uint64             { return SizeOf(a.DataType) }
+func (a *Address) Size() uint64             { return layout.SizeOf(a.DataType) }
 func (a *Address) Type() ast.Declaration    { return a.DataType }
 func (a *Address) OperandType() OperandType { return OP_TYPE_ADDRESS }
 func (a *Address) String() string {
 	var result string
-	if a.Base != nil {
+	if a.Symbol != "" {
+		result = fmt.Sprintf("rel %s", a.Symbol)
+	} else if a.Base != nil {
 		result = a.Base.String()
 	}
 
 	if a.Index != nil {
 		var scaledIndex string
-		if a.Scale != nil {
-			scaledIndex = fmt.Sprintf("%s*%s", a.Scale.String(),
-				a.Index.String())
+		if a.Scale > 1 {
+			scaledIndex = fmt.Sprintf("%s*%d", a.Index.String(), a.Scale)
 		} else {
 			scaledIndex = a.Index.String()
 		}
@@ -148,10 +207,20 @@ func (a *Address) String() string {
 	}
 
 	sizeMap := map[uint64]string{8: "qword", 4: "dword", 2: "word", 1: "byte"}
-	return fmt.Sprintf("%s [%s]", sizeMap[SizeOf(a.DataType)], result)
+	size, ok := sizeMap[layout.SizeOf(a.DataType)]
+	if !ok {
+		return fmt.Sprintf("[%s]", result)
+	}
+	return fmt.Sprintf("%s [%s]", size, result)
 }
 
+func (i *Instruction) IsLabel() bool { return i.label != "" }
+
 func (i *Instruction) Assembly() string {
+	if i.IsLabel() {
+		return fmt.Sprintf("%s:", i.label)
+	}
+
 	var out strings.Builder
 	out.WriteString(fmt.Sprintf("%s", i.neumonic))
 	if i.operandA != nil {
@@ -167,6 +236,76 @@ func Add(opA Operand, opB Operand) *Instruction {
 	return &Instruction{neumonic: "add", operandA: opA, operandB: opB}
 }
 
+func And(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "and", operandA: opA, operandB: opB}
+}
+
+// The target is either a label or a register holding the address to call
+func Call(target Operand) *Instruction {
+	return &Instruction{neumonic: "call", operandA: target}
+}
+
+// Sign extend eax into edx:eax before a 32 bit division
+func Cdq() *Instruction {
+	return &Instruction{neumonic: "cdq"}
+}
+
+// Sign extend rax into rdx:rax before a 64 bit division
+func Cqo() *Instruction {
+	return &Instruction{neumonic: "cqo"}
+}
+
+func Cmp(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "cmp", operandA: opA, operandB: opB}
+}
+
+// Unsigned division of rdx:rax by the operand
+func Div(op Operand) *Instruction {
+	return &Instruction{neumonic: "div", operandA: op}
+}
+
+// Signed division of rdx:rax by the operand
+func Idiv(op Operand) *Instruction {
+	return &Instruction{neumonic: "idiv", operandA: op}
+}
+
+func Imul(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "imul", operandA: opA, operandB: opB}
+}
+
+func Je(label *LabelOperand) *Instruction {
+	return &Instruction{neumonic: "je", operandA: label}
+}
+
+// Jump if above or equal, for unsigned comparisons
+func Jae(label *LabelOperand) *Instruction {
+	return &Instruction{neumonic: "jae", operandA: label}
+}
+
+func Jne(label *LabelOperand) *Instruction {
+	return &Instruction{neumonic: "jne", operandA: label}
+}
+
+// Jump if the condition holds, cond is the suffix of the jcc instruction
+// like for Set
+func Jcc(cond string, label *LabelOperand) *Instruction {
+	return &Instruction{neumonic: "j" + cond, operandA: label}
+}
+
+func Jmp(label *LabelOperand) *Instruction {
+	return &Instruction{neumonic: "jmp", operandA: label}
+}
+
+func Label(label *LabelOperand) *Instruction {
+	return &Instruction{label: label.Name}
+}
+
+func Lea(opA Operand, opB *Address) *Instruction {
+	address := *opB
+	address.DataType = nil // only the address is used, not the size
+	return &Instruction{neumonic: "lea", operandA: opA, operandB: &address}
+}
+
 func Leave() *Instruction {
 	return &Instruction{neumonic: "leave"}
 }
@@ -175,10 +314,38 @@ func Mov(opA Operand, opB Operand) *Instruction {
 	return &Instruction{neumonic: "mov", operandA: opA, operandB: opB}
 }
 
+func Movaps(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "movaps", operandA: opA, operandB: opB}
+}
+
+func Movsx(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "movsx", operandA: opA, operandB: opB}
+}
+
+func Movsxd(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "movsxd", operandA: opA, operandB: opB}
+}
+
+func Movzx(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "movzx", operandA: opA, operandB: opB}
+}
+
 func Neg(op Operand) *Instruction {
 	return &Instruction{neumonic: "neg", operandA: op}
 }
 
+func Not(op Operand) *Instruction {
+	return &Instruction{neumonic: "not", operandA: op}
+}
+
+func Or(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "or", operandA: opA, operandB: opB}
+}
+
+func Pop(op Operand) *Instruction {
+	return &Instruction{neumonic: "pop", operandA: op}
+}
+
 func Push(op Operand) *Instruction {
 	return &Instruction{neumonic: "push", operandA: op}
 }
@@ -187,6 +354,34 @@ func Ret() *Instruction {
 	return &Instruction{neumonic: "ret"}
 }
 
+// Arithmetic shift right, which keeps the sign
+func Sar(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "sar", operandA: opA, operandB: opB}
+}
+
+// Set the byte operand to 0 or 1 depending on the flags, cond is the suffix
+// of the setcc instruction, e.g. "l" for setl
+func Set(cond string, op Operand) *Instruction {
+	return &Instruction{neumonic: "set" + cond, operandA: op}
+}
+
+func Shl(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "shl", operandA: opA, operandB: opB}
+}
+
+// Logical shift right, which fills in zeros
+func Shr(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "shr", operandA: opA, operandB: opB}
+}
+
 func Sub(opA Operand, opB Operand) *Instruction {
 	return &Instruction{neumonic: "sub", operandA: opA, operandB: opB}
 }
+
+func Test(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "test", operandA: opA, operandB: opB}
+}
+
+func Xor(opA Operand, opB Operand) *Instruction {
+	return &Instruction{neumonic: "xor", operandA: opA, operandB: opB}
+}
@@ -2,150 +2,152 @@ package compiler
 
 import (
 	"fmt"
-	"log"
 
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
 	"github.com/tjarjoura/cc/pkg/token"
 )
 
+// Operations on constants are folded before these are called, so they only
+// generate code for values that are known at runtime
 type (
-	infixImmediateCompileFn  func(string, Immediate, Immediate) Immediate
-	infixRuntimeCompileFn    func(string, Operand, Operand) Operand
-	prefixImmediateCompileFn func(string, Immediate) Immediate
-	prefixRuntimeCompileFn   func(string, Operand) Operand
+	infixCompileFn  func(string, *value, *value) *value
+	prefixCompileFn func(string, *value) *value
 )
 
-type InfixOperation struct {
-	CompileImmediate infixImmediateCompileFn
-	CompileRuntime   infixRuntimeCompileFn
-}
-
-type PrefixOperation struct {
-	CompileImmediate prefixImmediateCompileFn
-	CompileRuntime   prefixRuntimeCompileFn
-}
-
-func (f *Function) compilePrefixArithmetic(operator string, operand Operand,
-) Operand {
-	var resultReg Operand = operand
-	if operand.OperandType() != OP_TYPE_REGISTER {
-		resultReg = &RegisterOperand{Register: f.allocNextReg(),
-			DataType: operand.Type()}
-		f.Instructions = append(f.Instructions, Mov(resultReg, operand))
-	}
+func (f *Function) compilePrefixArithmetic(operator string, operand *value) *value {
+	operand = f.rvalue(operand)
 
 	switch operator {
 	case token.MINUS:
-		f.Instructions = append(f.Instructions, Neg(resultReg))
-	default:
-		f.err(fmt.Sprintf(
-			"cannot handle prefix operator '%s' at runtime",
-			operator))
-		return nil
+		return &value{val: f.b.Unary(ir.Neg, operand.val), dataType: operand.Type()}
 	}
 
-	return resultReg
+	f.err(fmt.Sprintf(
+		"cannot handle prefix operator '%s' at runtime",
+		operator))
+	return nil
 }
 
-func (f *Function) compilePrefixArithmeticImm(operator string, operand Immediate,
-) Immediate {
-	log.Println("called compilePrefixArithmeticImm")
-	if isFloat(operand.Type()) {
-		f.err("floating point arithmetic is not supported")
-		return nil
-	}
+func (f *Function) compileAddressOf(operator string, operand *value) *value {
+	return &value{val: operand.val, dataType: &ast.Pointer{PointsTo: operand.dataType}}
+}
 
-	val := operand.(*ImmediateInt).Value
+func (f *Function) compileDereference(operator string, operand *value) *value {
+	operand = f.rvalue(operand)
+	p := operand.Type().(*ast.Pointer)
+	return &value{val: operand.val, dataType: p.PointsTo, lvalue: true}
+}
 
-	switch operator {
-	case token.MINUS:
-		return &ImmediateInt{Value: -1 * val}
-	}
+var arithmeticOps = map[string]ir.Op{
+	token.PLUS:     ir.Add,
+	token.MINUS:    ir.Sub,
+	token.ASTERISK: ir.Mul,
+	token.SLASH:    ir.SDiv,
+	token.MOD:      ir.SRem,
+	token.LSHIFT:   ir.Shl,
+	token.RSHIFT:   ir.AShr,
+}
 
-	f.err(fmt.Sprintf(
-		"cannot handle prefix operator '%s' at compile time",
-		operator))
-	return nil
+// The operations whose result depends on the signedness of the operands
+var unsignedOps = map[ir.Op]ir.Op{
+	ir.SDiv: ir.UDiv,
+	ir.SRem: ir.URem,
+	ir.AShr: ir.LShr,
 }
 
-func (f *Function) compileArithmetic(op string, a Operand, b Operand) Operand {
-	if isPointer(a.Type()) || isPointer(b.Type()) {
+func (f *Function) compileArithmetic(op string, a *value, b *value) *value {
+	if ast.IsPointer(a.Type()) || ast.IsPointer(b.Type()) {
 		f.err("pointer arithmetic is not supported yet")
 		return nil
-	} else if isFloat(a.Type()) || isFloat(b.Type()) {
+	} else if ast.IsFloat(a.Type()) || ast.IsFloat(b.Type()) {
 		f.err("foating point arithmetic is not supported yet")
 		return nil
 	}
 
-	// find the register we are storing the result in
-	var resultReg *RegisterOperand
-	if regA, ok := a.(*RegisterOperand); ok {
-		resultReg = regA
-	} else if regB, ok := b.(*RegisterOperand); ok {
-		resultReg = regB
-		b = a
-	} else {
-		resultReg = &RegisterOperand{Register: f.allocNextReg(),
-			DataType: biggestType(a.Type(), b.Type())}
-		f.Instructions = append(f.Instructions, Mov(resultReg, a))
-	}
-
-	switch op {
-	case token.PLUS:
-		f.Instructions = append(f.Instructions, Add(resultReg, b))
-	case token.MINUS:
-		f.Instructions = append(f.Instructions, Sub(resultReg, b))
-	default:
+	irOp, ok := arithmeticOps[op]
+	if !ok {
 		f.err(fmt.Sprintf(
 			"cannot handle infix operator %s at runtime", op))
 		return nil
 	}
 
-	if regB, ok := b.(*RegisterOperand); ok {
-		f.freeReg(regB.Register)
+	// the type of a shift is the type of its left operand
+	t := ast.ArithmeticType(a.Type(), b.Type())
+	if op == token.LSHIFT || op == token.RSHIFT {
+		t = ast.Promote(a.Type())
+	}
+	if u, ok := unsignedOps[irOp]; ok && ast.IsUnsigned(t) {
+		irOp = u
 	}
 
-	return resultReg
+	a, b = f.convert(a, t), f.convert(b, t)
+	return &value{val: f.b.Binary(irOp, a.val, b.val), dataType: t}
 }
 
-func compileArithmeticImm(op string, a Immediate, b Immediate) Immediate {
-	immA, ok := a.(*ImmediateInt)
-	if !ok {
-		panic("compileArithmeticImm can only be called with *ImmediateInt!")
-	}
+var signedComparisons = map[string]ir.Op{
+	token.EQUALS:    ir.Eq,
+	token.NOTEQUALS: ir.Ne,
+	token.LT:        ir.SLt,
+	token.LTE:       ir.SLe,
+	token.GT:        ir.SGt,
+	token.GTE:       ir.SGe,
+}
 
-	immB, ok := b.(*ImmediateInt)
-	if !ok {
-		panic("compileArithmeticImm can only be called with *ImmediateInt!")
+var unsignedComparisons = map[string]ir.Op{
+	token.EQUALS:    ir.Eq,
+	token.NOTEQUALS: ir.Ne,
+	token.LT:        ir.ULt,
+	token.LTE:       ir.ULe,
+	token.GT:        ir.UGt,
+	token.GTE:       ir.UGe,
+}
+
+func (f *Function) compileComparison(op string, a *value, b *value) *value {
+	if ast.IsFloat(a.Type()) || ast.IsFloat(b.Type()) {
+		f.err("foating point comparisons are not supported yet")
+		return nil
 	}
 
-	switch op {
-	case token.PLUS:
-		return &ImmediateInt{Value: immA.Value + immB.Value}
-	case token.MINUS:
-		return &ImmediateInt{Value: immA.Value - immB.Value}
-	case token.ASTERISK:
-		return &ImmediateInt{Value: immA.Value * immB.Value}
-	case token.SLASH:
-		return &ImmediateInt{Value: immA.Value / immB.Value}
-	default:
-		panic(fmt.Sprintf(
-			"Called compileArithmeticImm with unexpected operator!: %s",
-			op))
+	var t ast.Declaration
+	if ast.IsPointer(a.Type()) {
+		t = a.Type()
+	} else if ast.IsPointer(b.Type()) {
+		t = b.Type()
+	} else {
+		t = ast.ArithmeticType(a.Type(), b.Type())
+	}
+	a, b = f.convert(a, t), f.convert(b, t)
 
+	comparisons := signedComparisons
+	if ast.IsPointer(t) || ast.IsUnsigned(t) {
+		comparisons = unsignedComparisons
 	}
 
-	return nil
+	return &value{val: f.b.Compare(comparisons[op], a.val, b.val), dataType: intType}
 }
 
 func (f *Function) registerOperations() {
-	f.infixOperations = map[string]InfixOperation{
-		token.PLUS:     {compileArithmeticImm, f.compileArithmetic},
-		token.MINUS:    {compileArithmeticImm, f.compileArithmetic},
-		token.ASTERISK: {compileArithmeticImm, f.compileArithmetic},
-		token.SLASH:    {compileArithmeticImm, f.compileArithmetic},
+	f.infixOperations = map[string]infixCompileFn{
+		token.PLUS:     f.compileArithmetic,
+		token.MINUS:    f.compileArithmetic,
+		token.ASTERISK: f.compileArithmetic,
+		token.SLASH:    f.compileArithmetic,
+		token.MOD:      f.compileArithmetic,
+		token.LSHIFT:   f.compileArithmetic,
+		token.RSHIFT:   f.compileArithmetic,
+
+		token.EQUALS:    f.compileComparison,
+		token.NOTEQUALS: f.compileComparison,
+		token.LT:        f.compileComparison,
+		token.LTE:       f.compileComparison,
+		token.GT:        f.compileComparison,
+		token.GTE:       f.compileComparison,
 	}
 
-	f.prefixOperations = map[string]PrefixOperation{
-		token.MINUS: {f.compilePrefixArithmeticImm, f.compilePrefixArithmetic},
+	f.prefixOperations = map[string]prefixCompileFn{
+		token.MINUS:    f.compilePrefixArithmetic,
+		token.AMP:      f.compileAddressOf,
+		token.ASTERISK: f.compileDereference,
 	}
 }
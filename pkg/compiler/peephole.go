@@ -0,0 +1,756 @@
+package compiler
+
+import (
+	"math"
+	"strings"
+)
+
+// The peephole optimizer looks at a few instructions at a time and replaces
+// them with fewer or cheaper ones that do the same thing. The code generator
+// moves every operand through scratch registers, which leaves a lot of
+// moves that only pass a value along. The rules run until none of them
+// match anymore, then the jumps are cleaned up.
+func peephole(instrs []*Instruction) []*Instruction {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(instrs); i++ {
+			for _, rule := range peepholeRules {
+				n, replacement := rule(instrs, i)
+				if n == 0 {
+					continue
+				}
+
+				out := append([]*Instruction{}, instrs[:i]...)
+				out = append(out, replacement...)
+				instrs = append(out, instrs[i+n:]...)
+				changed = true
+				break
+			}
+		}
+
+		var jumps bool
+		instrs, jumps = optimizeJumps(instrs)
+		changed = changed || jumps
+	}
+	return shortenJumps(instrs)
+}
+
+// A rule matches the instructions starting at instrs[i] and returns how many
+// of them to replace, and what with. It returns 0 if it doesn't match.
+type peepholeRule func(instrs []*Instruction, i int) (int, []*Instruction)
+
+var peepholeRules = []peepholeRule{
+	removeSelfMove,
+	removeDeadMove,
+	removeRedundantMove,
+	zeroWithXor,
+	forwardMove,
+	forwardCompare,
+	retarget,
+	opThroughScratch,
+	combineWithLea,
+	removePushPop,
+	removeAddZero,
+}
+
+func isOp(instr *Instruction, neumonics ...string) bool {
+	if instr.IsLabel() {
+		return false
+	}
+	for _, n := range neumonics {
+		if instr.neumonic == n {
+			return true
+		}
+	}
+	return false
+}
+
+func registerOf(o Operand) *Register {
+	if r, ok := o.(*RegisterOperand); ok {
+		return r.Register
+	}
+	return nil
+}
+
+func isAddress(o Operand) bool {
+	_, ok := o.(*Address)
+	return ok
+}
+
+func immediateValue(o Operand) (int64, bool) {
+	if i, ok := o.(*ImmediateInt); ok {
+		return i.Value, true
+	}
+	return 0, false
+}
+
+func sameOperand(a Operand, b Operand) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
+
+// The registers an operand reads, the register itself or the ones that make
+// up the address
+func operandRegisters(o Operand) []*Register {
+	switch o := o.(type) {
+	case *RegisterOperand:
+		return []*Register{o.Register}
+	case *Address:
+		registers := []*Register{}
+		if o.Base != nil {
+			registers = append(registers, o.Base)
+		}
+		if o.Index != nil {
+			registers = append(registers, o.Index)
+		}
+		return registers
+	}
+	return nil
+}
+
+func mentions(o Operand, r *Register) bool {
+	for _, used := range operandRegisters(o) {
+		if used == r {
+			return true
+		}
+	}
+	return false
+}
+
+type controlKind int
+
+const (
+	sequential controlKind = iota
+	jump                   // a jump, or a label that can be jumped to
+	ret
+	unknown // an instruction the optimizer knows nothing about
+)
+
+// What an instruction does to registers and flags. Writing the low 8 or 16
+// bits of a register keeps the rest, so it counts as a read as well, while
+// writing 32 bits clears the upper half.
+type effects struct {
+	reads       []*Register
+	writes      []*Register
+	readsFlags  bool
+	writesFlags bool
+	control     controlKind
+}
+
+func (e *effects) read(o Operand) {
+	e.reads = append(e.reads, operandRegisters(o)...)
+}
+
+func (e *effects) write(o Operand) {
+	if r, ok := o.(*RegisterOperand); ok && r.Size() >= 4 {
+		e.writes = append(e.writes, r.Register)
+		return
+	}
+	e.read(o)
+}
+
+func (e *effects) readsRegister(r *Register) bool {
+	for _, read := range e.reads {
+		if read == r {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *effects) writesRegister(r *Register) bool {
+	for _, written := range e.writes {
+		if written == r {
+			return true
+		}
+	}
+	return false
+}
+
+func effectsOf(instr *Instruction) *effects {
+	e := &effects{}
+	if instr.IsLabel() {
+		e.control = jump
+		return e
+	}
+
+	a, b := instr.operandA, instr.operandB
+	rsp := &RegisterOperand{Register: REG_RSP, DataType: longType}
+	switch n := instr.neumonic; {
+	case isOp(instr, "mov", "movzx", "movsx", "movsxd", "movaps", "lea"):
+		e.read(b)
+		e.write(a)
+	case isOp(instr, "add", "sub", "and", "or", "xor", "imul"):
+		e.read(a)
+		e.read(b)
+		e.write(a)
+		e.writesFlags = true
+	case isOp(instr, "shl", "shr", "sar"):
+		// shifting by 0 leaves the flags alone
+		e.read(a)
+		e.read(b)
+		e.write(a)
+		if count, ok := immediateValue(b); ok && count != 0 {
+			e.writesFlags = true
+		}
+	case isOp(instr, "cmp", "test"):
+		e.read(a)
+		e.read(b)
+		e.writesFlags = true
+	case isOp(instr, "neg", "not"):
+		e.read(a)
+		e.write(a)
+		e.writesFlags = n == "neg"
+	case isOp(instr, "push"):
+		e.read(a)
+		e.read(rsp)
+		e.write(rsp)
+	case isOp(instr, "pop"):
+		e.read(rsp)
+		e.write(rsp)
+		e.write(a)
+	case isOp(instr, "call"):
+		e.read(a)
+		e.read(rsp)
+		e.reads = append(e.reads, REG_RAX)
+		e.reads = append(e.reads, ARG_REGS...)
+		e.writes = append(e.writes, CALLER_SAVED...)
+		e.writesFlags = true
+	case isOp(instr, "cdq", "cqo"):
+		e.reads = append(e.reads, REG_RAX)
+		e.writes = append(e.writes, REG_RDX)
+	case isOp(instr, "div", "idiv"):
+		e.read(a)
+		e.reads = append(e.reads, REG_RAX, REG_RDX)
+		e.writes = append(e.writes, REG_RAX, REG_RDX)
+		e.writesFlags = true
+	case isOp(instr, "leave"):
+		e.reads = append(e.reads, REG_RBP)
+		e.writes = append(e.writes, REG_RSP, REG_RBP)
+	case isOp(instr, "ret"):
+		e.reads = append(e.reads, REG_RAX, REG_RSP, REG_RBP)
+		e.reads = append(e.reads, CALLEE_SAVED...)
+		e.control = ret
+	case isOp(instr, "jmp") && !localJump(instr):
+		// a sibling call leaves the function with the arguments in place
+		e.reads = append(e.reads, REG_RAX, REG_RSP)
+		e.reads = append(e.reads, ARG_REGS...)
+		e.reads = append(e.reads, CALLEE_SAVED...)
+		e.control = ret
+	case isOp(instr, "jmp"):
+		e.control = jump
+	case strings.HasPrefix(n, "j"):
+		e.readsFlags = true
+		e.control = jump
+	case strings.HasPrefix(n, "set"):
+		e.readsFlags = true
+		e.write(a)
+	default:
+		e.control = unknown
+	}
+	return e
+}
+
+// Whether a value is dead at instrs[from], used tells if an instruction
+// reads the value and if it overwrites it. Every path from there is
+// followed, through labels and to the targets of jumps.
+func dead(instrs []*Instruction, from int, used func(*effects) (bool, bool)) bool {
+	labels := map[string]int{}
+	for i, instr := range instrs {
+		if instr.IsLabel() {
+			labels[instr.label] = i
+		}
+	}
+
+	visited := map[int]bool{}
+	work := []int{from}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		for ; i < len(instrs) && !visited[i]; i++ {
+			visited[i] = true
+			e := effectsOf(instrs[i])
+			read, written := used(e)
+			if read || e.control == unknown {
+				return false
+			} else if written || e.control == ret {
+				break
+			}
+
+			if target, ok := jumpTarget(instrs[i]); ok {
+				j, ok := labels[target.Name]
+				if !ok {
+					return false
+				}
+				work = append(work, j)
+				if isOp(instrs[i], "jmp") {
+					break
+				}
+			}
+		}
+	}
+	return true
+}
+
+func registerDead(instrs []*Instruction, from int, r *Register) bool {
+	return dead(instrs, from, func(e *effects) (bool, bool) {
+		return e.readsRegister(r), e.writesRegister(r)
+	})
+}
+
+func flagsDead(instrs []*Instruction, from int) bool {
+	return dead(instrs, from, func(e *effects) (bool, bool) {
+		return e.readsFlags, e.writesFlags
+	})
+}
+
+// Whether instr writes all 32 bits of r, which clears its upper half
+func writes32(instr *Instruction, r *Register) bool {
+	dest, ok := instr.operandA.(*RegisterOperand)
+	return ok && dest.Register == r && dest.Size() == 4 &&
+		isOp(instr, "mov", "movzx", "movsx", "lea", "add", "sub", "and", "or",
+			"xor", "imul", "shl", "shr", "sar", "neg", "not")
+}
+
+// mov r, r does nothing, unless it is 32 bits wide and clears the upper half
+func removeSelfMove(instrs []*Instruction, i int) (int, []*Instruction) {
+	instr := instrs[i]
+	if isOp(instr, "mov") && registerOf(instr.operandA) != nil &&
+		sameOperand(instr.operandA, instr.operandB) && instr.operandA.Size() != 4 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// A register that is written but never read again
+func removeDeadMove(instrs []*Instruction, i int) (int, []*Instruction) {
+	instr := instrs[i]
+	r := registerOf(instr.operandA)
+	if isOp(instr, "mov", "movzx", "movsx", "movsxd", "lea") && r != nil &&
+		registerDead(instrs, i+1, r) {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// mov a, b followed by mov b, a or by the same move again
+func removeRedundantMove(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+1 >= len(instrs) {
+		return 0, nil
+	}
+	first, second := instrs[i], instrs[i+1]
+	if !isOp(first, "mov") || !isOp(second, "mov") {
+		return 0, nil
+	}
+
+	// the source has to be the same after the first move
+	if dest := registerOf(first.operandA); dest != nil && mentions(first.operandB, dest) {
+		return 0, nil
+	}
+
+	if sameOperand(first.operandA, second.operandA) && sameOperand(first.operandB, second.operandB) {
+		return 2, []*Instruction{first}
+	}
+
+	if !sameOperand(first.operandA, second.operandB) || !sameOperand(first.operandB, second.operandA) {
+		return 0, nil
+	}
+
+	// moving a 32 bit register into itself clears its upper half, which is
+	// only already clear if it was just written
+	if r, ok := second.operandA.(*RegisterOperand); ok && r.Size() == 4 &&
+		(i == 0 || !writes32(instrs[i-1], r.Register)) {
+		return 0, nil
+	}
+	return 2, []*Instruction{first}
+}
+
+// xor r, r is shorter than mov r, 0, but it sets the flags
+func zeroWithXor(instrs []*Instruction, i int) (int, []*Instruction) {
+	instr := instrs[i]
+	dest, ok := instr.operandA.(*RegisterOperand)
+	if !isOp(instr, "mov") || !ok || dest.Size() < 4 {
+		return 0, nil
+	}
+	if n, ok := immediateValue(instr.operandB); !ok || n != 0 || !flagsDead(instrs, i+1) {
+		return 0, nil
+	}
+
+	// writing 32 bits clears the upper half as well
+	r32 := &RegisterOperand{Register: dest.Register, DataType: intType}
+	return 1, []*Instruction{Xor(r32, r32)}
+}
+
+// Whether instr can take source as its second operand
+func validSource(instr *Instruction, source Operand) bool {
+	switch {
+	case isAddress(source):
+		return !isAddress(instr.operandA)
+	case isImmediate(source):
+		n, _ := immediateValue(source)
+		if isOp(instr, "movzx", "movsx", "movsxd") {
+			return false
+		} else if isOp(instr, "mov") && !isAddress(instr.operandA) {
+			return true
+		}
+		return instr.operandA.Size() < 8 || fitsInt32(n)
+	}
+	return true
+}
+
+// A value that is moved into a register only to be used once can be used
+// where it is, which folds loads into the instruction that uses them and
+// stores immediates directly
+func forwardMove(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+1 >= len(instrs) {
+		return 0, nil
+	}
+	move, use := instrs[i], instrs[i+1]
+	r := registerOf(move.operandA)
+	if !isOp(move, "mov") || r == nil ||
+		!isOp(use, "mov", "movzx", "movsx", "movsxd", "add", "sub", "and",
+			"or", "xor", "imul", "cmp", "test") ||
+		!sameOperand(use.operandB, move.operandA) || mentions(use.operandA, r) {
+		return 0, nil
+	}
+
+	source := move.operandB
+	if isAddress(source) && isAddress(use.operandA) || !validSource(use, source) ||
+		isOp(use, "imul") && isAddress(use.operandA) ||
+		!registerDead(instrs, i+2, r) {
+		return 0, nil
+	}
+
+	return 2, []*Instruction{
+		{neumonic: use.neumonic, operandA: use.operandA, operandB: source}}
+}
+
+// cmp and test only read their first operand, so it can be forwarded as
+// well, unless it is an immediate
+func forwardCompare(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+1 >= len(instrs) {
+		return 0, nil
+	}
+	move, use := instrs[i], instrs[i+1]
+	r, source := registerOf(move.operandA), move.operandB
+	if !isOp(move, "mov") || r == nil || isImmediate(source) ||
+		!isOp(use, "cmp", "test") || !sameOperand(use.operandA, move.operandA) {
+		return 0, nil
+	}
+
+	b := use.operandB
+	if sameOperand(b, move.operandA) {
+		b = source
+	} else if mentions(b, r) {
+		return 0, nil
+	}
+	if isAddress(source) && isAddress(b) || !registerDead(instrs, i+2, r) {
+		return 0, nil
+	}
+	return 2, []*Instruction{{neumonic: use.neumonic, operandA: source, operandB: b}}
+}
+
+// An extension or address computed into a scratch register and then moved
+// into another register can go there directly
+func retarget(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+1 >= len(instrs) {
+		return 0, nil
+	}
+	compute, move := instrs[i], instrs[i+1]
+	r := registerOf(compute.operandA)
+	dest := registerOf(move.operandA)
+	if !isOp(compute, "lea", "movzx", "movsx", "movsxd") || !isOp(move, "mov") ||
+		r == nil || dest == nil || dest == r ||
+		!sameOperand(move.operandB, compute.operandA) ||
+		!registerDead(instrs, i+2, r) {
+		return 0, nil
+	}
+
+	return 2, []*Instruction{
+		{neumonic: compute.neumonic, operandA: move.operandA, operandB: compute.operandB}}
+}
+
+// mov s, a; op s, b; mov a, s does the operation on a through the scratch
+// register s, it can be done on a directly
+func opThroughScratch(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+2 >= len(instrs) {
+		return 0, nil
+	}
+	load, op, store := instrs[i], instrs[i+1], instrs[i+2]
+	s := registerOf(load.operandA)
+	a := load.operandB
+	if !isOp(load, "mov") || !isOp(store, "mov") || s == nil ||
+		!isOp(op, "add", "sub", "and", "or", "xor", "imul", "shl", "shr", "sar") ||
+		!sameOperand(op.operandA, load.operandA) ||
+		!sameOperand(store.operandB, load.operandA) || !sameOperand(store.operandA, a) {
+		return 0, nil
+	}
+
+	b := op.operandB
+	if mentions(a, s) || mentions(b, s) || isAddress(a) && (isAddress(b) || isOp(op, "imul")) ||
+		!registerDead(instrs, i+3, s) {
+		return 0, nil
+	}
+
+	return 3, []*Instruction{{neumonic: op.neumonic, operandA: a, operandB: b}}
+}
+
+// The address that adds, subtracts or scales a register. lea computes it
+// without touching the flags, in one instruction instead of a move and an
+// operation.
+func leaAddress(op *Instruction, a *Register, r *Register) *Address {
+	n, isImm := immediateValue(op.operandB)
+	switch {
+	case isOp(op, "add") && isImm && fitsInt32(n):
+		return &Address{Base: a, Displacement: n}
+	case isOp(op, "sub") && isImm && fitsInt32(n) && n != math.MinInt32:
+		return &Address{Base: a, Displacement: -n}
+	case isOp(op, "add") && !isImm:
+		b := registerOf(op.operandB)
+		if b == nil || b == r || op.operandB.Size() != op.operandA.Size() {
+			return nil
+		}
+		// rsp can't be an index
+		if b == REG_RSP {
+			a, b = b, a
+		}
+		if b == REG_RSP {
+			return nil
+		}
+		return &Address{Base: a, Index: b}
+	}
+
+	// the rest scale a, which can't be rsp
+	if a == REG_RSP || !isImm {
+		return nil
+	}
+	if isOp(op, "shl") && n >= 1 && n <= 3 {
+		n = 1 << n
+	} else if !isOp(op, "imul") {
+		return nil
+	}
+	switch n {
+	case 2, 4, 8:
+		return &Address{Index: a, Scale: n}
+	case 3, 5, 9:
+		return &Address{Base: a, Index: a, Scale: n - 1}
+	}
+	return nil
+}
+
+func combineWithLea(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+1 >= len(instrs) {
+		return 0, nil
+	}
+	move, op := instrs[i], instrs[i+1]
+	r, a := registerOf(move.operandA), registerOf(move.operandB)
+	if !isOp(move, "mov") || r == nil || a == nil || r == a ||
+		move.operandA.Size() < 4 || !sameOperand(op.operandA, move.operandA) ||
+		!flagsDead(instrs, i+2) {
+		return 0, nil
+	}
+
+	address := leaAddress(op, a, r)
+	if address == nil {
+		return 0, nil
+	}
+	return 2, []*Instruction{Lea(move.operandA, address)}
+}
+
+// push a; pop b is a move through the stack
+func removePushPop(instrs []*Instruction, i int) (int, []*Instruction) {
+	if i+1 >= len(instrs) || !isOp(instrs[i], "push") || !isOp(instrs[i+1], "pop") {
+		return 0, nil
+	}
+	a, b := instrs[i].operandA, instrs[i+1].operandA
+	ra, rb := registerOf(a), registerOf(b)
+	if ra == nil || rb == nil || ra == REG_RSP || rb == REG_RSP {
+		return 0, nil
+	} else if ra == rb {
+		return 2, nil
+	}
+	return 2, []*Instruction{Mov(b, a)}
+}
+
+// Adding 0 only sets the flags, and clears the upper half of a 32 bit
+// register
+func removeAddZero(instrs []*Instruction, i int) (int, []*Instruction) {
+	instr := instrs[i]
+	n, ok := immediateValue(instr.operandB)
+	if !isOp(instr, "add", "sub") || !ok || n != 0 ||
+		!isAddress(instr.operandA) && instr.operandA.Size() != 8 ||
+		!flagsDead(instrs, i+1) {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+// The condition that holds when cond doesn't
+var invertedConditions = map[string]string{
+	"e": "ne", "ne": "e",
+	"l": "ge", "ge": "l",
+	"g": "le", "le": "g",
+	"b": "ae", "ae": "b",
+	"a": "be", "be": "a",
+}
+
+func jumpTarget(instr *Instruction) (*LabelOperand, bool) {
+	if instr.IsLabel() || !strings.HasPrefix(instr.neumonic, "j") {
+		return nil, false
+	}
+	label, ok := instr.operandA.(*LabelOperand)
+	return label, ok
+}
+
+// Whether the instruction jumps to a label of the function rather than to
+// another function
+func localJump(instr *Instruction) bool {
+	target, ok := jumpTarget(instr)
+	return ok && strings.HasPrefix(target.Name, ".L")
+}
+
+// A jump to another label, the label operands of jumps can be shared with
+// the label itself
+func retargeted(instr *Instruction, name string) *Instruction {
+	return &Instruction{neumonic: instr.neumonic, operandA: &LabelOperand{Name: name}}
+}
+
+// Whether the label comes before the next instruction at i
+func labelAt(instrs []*Instruction, i int, name string) bool {
+	for ; i < len(instrs) && instrs[i].IsLabel(); i++ {
+		if instrs[i].label == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Jumps to jumps go to the final target instead, jumps over a jump are
+// inverted, and jumps to the next instruction, code that can't be reached
+// and labels that nothing jumps to are removed
+func optimizeJumps(instrs []*Instruction) ([]*Instruction, bool) {
+	labels := map[string]int{}
+	for i, instr := range instrs {
+		if instr.IsLabel() {
+			labels[instr.label] = i
+		}
+	}
+
+	// the jump at the label, if there is only a jump there
+	jumpAt := func(name string) (string, bool) {
+		i, ok := labels[name]
+		for ok && i < len(instrs) && instrs[i].IsLabel() {
+			i++
+		}
+		if !ok || i >= len(instrs) || !isOp(instrs[i], "jmp") {
+			return "", false
+		}
+		if target, ok := jumpTarget(instrs[i]); ok {
+			return target.Name, true
+		}
+		return "", false
+	}
+
+	changed := false
+	out := []*Instruction{}
+	for i := 0; i < len(instrs); i++ {
+		instr := instrs[i]
+
+		// nothing falls through to the code after a jmp or a ret
+		if len(out) > 0 && isOp(out[len(out)-1], "jmp", "ret") && !instr.IsLabel() {
+			changed = true
+			continue
+		}
+
+		target, ok := jumpTarget(instr)
+		if !ok {
+			out = append(out, instr)
+			continue
+		}
+
+		name := target.Name
+		for seen := map[string]bool{name: true}; ; {
+			next, ok := jumpAt(name)
+			if !ok || seen[next] {
+				break
+			}
+			name, seen[next] = next, true
+		}
+		if name != target.Name {
+			instr, changed = retargeted(instr, name), true
+		}
+
+		cond := strings.TrimPrefix(instr.neumonic, "j")
+		if inverted, ok := invertedConditions[cond]; ok && i+1 < len(instrs) &&
+			isOp(instrs[i+1], "jmp") && labelAt(instrs, i+2, name) {
+			if other, ok := jumpTarget(instrs[i+1]); ok {
+				name = other.Name
+				instr = Jcc(inverted, &LabelOperand{Name: name})
+				i++
+				changed = true
+			}
+		}
+
+		if labelAt(instrs, i+1, name) {
+			changed = true
+			continue
+		}
+		out = append(out, instr)
+	}
+
+	used := map[string]bool{}
+	for _, instr := range out {
+		if target, ok := jumpTarget(instr); ok {
+			used[target.Name] = true
+		}
+	}
+	instrs = out[:0]
+	for _, instr := range out {
+		if instr.IsLabel() && strings.HasPrefix(instr.label, ".L") && !used[instr.label] {
+			changed = true
+			continue
+		}
+		instrs = append(instrs, instr)
+	}
+	return instrs, changed
+}
+
+// No instruction is longer than 15 bytes, so a target that at most 8
+// instructions lie between is within reach of the 8 bit displacement of a
+// short jump
+func shortenJumps(instrs []*Instruction) []*Instruction {
+	const maxInstructions = 8
+
+	labels := map[string]int{}
+	for i, instr := range instrs {
+		if instr.IsLabel() {
+			labels[instr.label] = i
+		}
+	}
+
+	for i, instr := range instrs {
+		target, ok := jumpTarget(instr)
+		if !ok || target.Short {
+			continue
+		}
+		j, defined := labels[target.Name]
+		if !defined {
+			continue
+		}
+
+		from, to := i+1, j
+		if j < i {
+			from, to = j, i
+		}
+		between := 0
+		for _, other := range instrs[from:to] {
+			if !other.IsLabel() {
+				between++
+			}
+		}
+		if between <= maxInstructions {
+			instrs[i] = &Instruction{neumonic: instr.neumonic,
+				operandA: &LabelOperand{Name: target.Name, Short: true}}
+		}
+	}
+	return instrs
+}
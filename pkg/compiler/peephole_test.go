@@ -0,0 +1,146 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+func TestPeephole(t *testing.T) {
+	eax, ecx, edx := reg(REG_RAX, ir.I32), reg(REG_RCX, ir.I32), reg(REG_RDX, ir.I32)
+	rax, rcx, rdi := reg(REG_RAX, ir.I64), reg(REG_RCX, ir.I64), reg(REG_RDI, ir.I64)
+	al := reg(REG_RAX, ir.I8)
+	slot := func(t ir.Type) *Address {
+		return &Address{Base: REG_RBP, Displacement: -8, DataType: operandType(t)}
+	}
+	deref := func(base *Register) *Address {
+		return &Address{Base: base, DataType: operandType(ir.I64)}
+	}
+	l1, l2 := &LabelOperand{Name: ".L1"}, &LabelOperand{Name: ".L2"}
+
+	tests := []struct {
+		name     string
+		before   []*Instruction
+		expected []string
+	}{
+		{
+			"self moves",
+			[]*Instruction{Mov(rax, rax), Mov(eax, eax)},
+			[]string{"mov\teax, eax"},
+		},
+		{
+			"zero with xor",
+			[]*Instruction{Mov(rax, imm(0, ir.I64))},
+			[]string{"xor\teax, eax"},
+		},
+		{
+			"zero while the flags are used",
+			[]*Instruction{Cmp(ecx, edx), Mov(eax, imm(0, ir.I32)), Set("l", al)},
+			[]string{"cmp\tecx, edx", "mov\teax, 0x0", "setl\tal"},
+		},
+		{
+			"store an immediate",
+			[]*Instruction{Mov(eax, imm(5, ir.I32)), Mov(slot(ir.I32), eax), Mov(eax, ecx)},
+			[]string{"mov\tdword [rbp - 0x8], 0x5", "mov\teax, ecx"},
+		},
+		{
+			"immediate too wide to store",
+			[]*Instruction{Mov(rax, imm(1<<40, ir.I64)), Mov(slot(ir.I64), rax), Mov(eax, ecx)},
+			[]string{"mov\trax, 0x10000000000", "mov\tqword [rbp - 0x8], rax", "mov\teax, ecx"},
+		},
+		{
+			"fold a load",
+			[]*Instruction{Mov(ecx, slot(ir.I32)), Add(eax, ecx)},
+			[]string{"add\teax, dword [rbp - 0x8]"},
+		},
+		{
+			"operate on memory",
+			[]*Instruction{Mov(eax, slot(ir.I32)), Add(eax, imm(1, ir.I32)), Mov(slot(ir.I32), eax),
+				Mov(eax, ecx)},
+			[]string{"add\tdword [rbp - 0x8], 0x1", "mov\teax, ecx"},
+		},
+		{
+			"operate on memory addressed by the scratch register",
+			[]*Instruction{Mov(rcx, deref(REG_RCX)), Add(rcx, imm(8, ir.I64)), Mov(deref(REG_RCX), rcx)},
+			[]string{"mov\trcx, qword [rcx]", "add\trcx, 0x8", "mov\tqword [rcx], rcx"},
+		},
+		{
+			"reload after store",
+			[]*Instruction{Add(eax, ecx), Mov(slot(ir.I32), eax), Mov(eax, slot(ir.I32))},
+			[]string{"add\teax, ecx", "mov\tdword [rbp - 0x8], eax"},
+		},
+		{
+			"reload that clears the upper half",
+			[]*Instruction{Mov(slot(ir.I32), eax), Mov(eax, slot(ir.I32))},
+			[]string{"mov\tdword [rbp - 0x8], eax", "mov\teax, dword [rbp - 0x8]"},
+		},
+		{
+			"lea for add",
+			[]*Instruction{Mov(rax, rcx), Add(rax, imm(8, ir.I64))},
+			[]string{"lea\trax, [rcx + 0x8]"},
+		},
+		{
+			"lea for multiply",
+			[]*Instruction{Mov(eax, ecx), Imul(eax, imm(5, ir.I32))},
+			[]string{"lea\teax, [rcx + rcx*4]"},
+		},
+		{
+			"lea for shift",
+			[]*Instruction{Mov(rax, rdi), Shl(rax, imm(3, ir.I8))},
+			[]string{"lea\trax, [rdi*8]"},
+		},
+		{
+			"push and pop",
+			[]*Instruction{Push(rdi), Pop(rax)},
+			[]string{"mov\trax, rdi"},
+		},
+		{
+			"dead moves",
+			[]*Instruction{Mov(rcx, rdi), Mov(edx, ecx), Mov(eax, imm(1, ir.I32))},
+			[]string{"mov\teax, 0x1"},
+		},
+		{
+			"jump over a jump",
+			[]*Instruction{Test(eax, eax), Jne(l1), Jmp(l2), Label(l1),
+				Mov(eax, imm(1, ir.I32)), Label(l2)},
+			[]string{"test\teax, eax", "je\tshort .L2", "mov\teax, 0x1", ".L2:"},
+		},
+		{
+			"jump to a jump",
+			[]*Instruction{Test(eax, eax), Je(l1), Mov(eax, imm(1, ir.I32)),
+				Label(l1), Jmp(l2), Mov(eax, imm(2, ir.I32)), Label(l2)},
+			[]string{"test\teax, eax", "je\tshort .L2", "mov\teax, 0x1", ".L2:"},
+		},
+		{
+			"jump too far to be short",
+			[]*Instruction{Test(eax, eax), Je(l1),
+				Add(slot(ir.I32), imm(1, ir.I32)), Add(slot(ir.I32), imm(2, ir.I32)),
+				Add(slot(ir.I32), imm(3, ir.I32)), Add(slot(ir.I32), imm(4, ir.I32)),
+				Add(slot(ir.I32), imm(5, ir.I32)), Add(slot(ir.I32), imm(6, ir.I32)),
+				Add(slot(ir.I32), imm(7, ir.I32)), Add(slot(ir.I32), imm(8, ir.I32)),
+				Add(slot(ir.I32), imm(9, ir.I32)), Label(l1)},
+			[]string{"test\teax, eax", "je\t.L1",
+				"add\tdword [rbp - 0x8], 0x1", "add\tdword [rbp - 0x8], 0x2",
+				"add\tdword [rbp - 0x8], 0x3", "add\tdword [rbp - 0x8], 0x4",
+				"add\tdword [rbp - 0x8], 0x5", "add\tdword [rbp - 0x8], 0x6",
+				"add\tdword [rbp - 0x8], 0x7", "add\tdword [rbp - 0x8], 0x8",
+				"add\tdword [rbp - 0x8], 0x9", ".L1:"},
+		},
+	}
+
+	for _, tt := range tests {
+		// rax holds the return value, the rest is dead
+		before := append(tt.before, Leave(), Ret())
+		expected := append(tt.expected, "leave", "ret")
+
+		actual := []string{}
+		for _, instr := range peephole(before) {
+			actual = append(actual, instr.Assembly())
+		}
+		if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name,
+				strings.Join(expected, "\n"), strings.Join(actual, "\n"))
+		}
+	}
+}
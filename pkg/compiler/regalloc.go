@@ -0,0 +1,248 @@
+package compiler
+
+import (
+	"sort"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// The positions of the instructions of a function, in the order the blocks
+// are laid out. Instruction k reads its operands at 2k and writes its result
+// at 2k+1. The prologue is instruction 0, which defines the parameters.
+type interval struct {
+	r          *ir.Register
+	start, end int
+}
+
+func (i *interval) cover(pos int) {
+	if pos < i.start {
+		i.start = pos
+	}
+	if pos > i.end {
+		i.end = pos
+	}
+}
+
+// Where the virtual registers of a function are kept: in a machine register,
+// or spilled to a stack slot if there weren't enough of them
+type allocation struct {
+	registers map[*ir.Register]*Register
+	spilled   []*ir.Register
+	used      map[*Register]bool
+}
+
+// The scratch registers that the code generated for instr uses, along with
+// the registers it reads or writes implicitly. Nothing that is live while
+// the instruction runs can be kept in them.
+func clobbers(instr *ir.Instruction) []*Register {
+	switch op := instr.Op; {
+	case op == ir.Call:
+		return CALLER_SAVED
+	case op == ir.SDiv || op == ir.UDiv || op == ir.SRem || op == ir.URem || op == ir.VaCopy:
+		return []*Register{REG_RAX, REG_RCX, REG_RDX}
+	case op.IsBinary() || op.IsComparison() || op == ir.SExt || op == ir.ZExt ||
+		op == ir.Load || op == ir.Store || op == ir.VaStart || op == ir.VaArg:
+		return []*Register{REG_RAX, REG_RCX}
+	case op == ir.Alloca || op == ir.Jmp || op == ir.StackSave:
+		return nil
+	}
+	return []*Register{REG_RAX}
+}
+
+// Allocate machine registers to the virtual registers of the function with
+// linear scan. Every virtual register gets a single live interval that
+// covers all the places where it is live, and when more intervals overlap
+// than there are registers, the one that ends last is spilled.
+func allocateRegisters(f *ir.Function) *allocation {
+	intervals, clobbered := liveIntervals(f)
+
+	sort.SliceStable(intervals, func(i, j int) bool {
+		return intervals[i].start < intervals[j].start
+	})
+
+	// whether a clobber of register r falls inside the interval
+	blocked := func(r *Register, i *interval) bool {
+		points := clobbered[r]
+		k := sort.SearchInts(points, i.start)
+		return k < len(points) && points[k] <= i.end
+	}
+
+	a := &allocation{registers: map[*ir.Register]*Register{}, used: map[*Register]bool{}}
+	active := []*interval{}
+	owner := map[*Register]*interval{}
+	for _, current := range intervals {
+		kept := active[:0]
+		for _, i := range active {
+			if i.end < current.start {
+				delete(owner, a.registers[i.r])
+			} else {
+				kept = append(kept, i)
+			}
+		}
+		active = kept
+
+		var free *Register
+		for _, r := range REG_ORDER {
+			if owner[r] == nil && !blocked(r, current) {
+				free = r
+				break
+			}
+		}
+
+		if free == nil {
+			// take the register of the interval that lives the longest
+			var victim *interval
+			for _, i := range active {
+				r := a.registers[i.r]
+				if !blocked(r, current) && (victim == nil || i.end > victim.end) {
+					victim = i
+				}
+			}
+			if victim == nil || victim.end <= current.end {
+				a.spilled = append(a.spilled, current.r)
+				continue
+			}
+
+			free = a.registers[victim.r]
+			delete(a.registers, victim.r)
+			a.spilled = append(a.spilled, victim.r)
+			for k, i := range active {
+				if i == victim {
+					active = append(active[:k], active[k+1:]...)
+					break
+				}
+			}
+		}
+
+		a.registers[current.r] = free
+		a.used[free] = true
+		owner[free] = current
+		active = append(active, current)
+	}
+
+	return a
+}
+
+// Compute the live interval of every virtual register other than allocas,
+// whose addresses are fixed offsets in the frame, and the sorted positions
+// where each machine register is clobbered
+func liveIntervals(f *ir.Function) ([]*interval, map[*Register][]int) {
+	isAlloca := map[*ir.Register]bool{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op == ir.Alloca {
+				isAlloca[instr.Dest] = true
+			}
+		}
+	}
+	registers := func(values []ir.Value) []*ir.Register {
+		rs := []*ir.Register{}
+		for _, v := range values {
+			if r, ok := v.(*ir.Register); ok && !isAlloca[r] {
+				rs = append(rs, r)
+			}
+		}
+		return rs
+	}
+
+	// the registers each block reads before writing them, and writes
+	uses := map[*ir.Block]map[*ir.Register]bool{}
+	defs := map[*ir.Block]map[*ir.Register]bool{}
+	for _, b := range f.Blocks {
+		uses[b], defs[b] = map[*ir.Register]bool{}, map[*ir.Register]bool{}
+		for _, instr := range b.Instructions {
+			for _, r := range registers(instr.Args) {
+				if !defs[b][r] {
+					uses[b][r] = true
+				}
+			}
+			if instr.Dest != nil && !isAlloca[instr.Dest] {
+				defs[b][instr.Dest] = true
+			}
+		}
+	}
+
+	liveIn := map[*ir.Block]map[*ir.Register]bool{}
+	liveOut := map[*ir.Block]map[*ir.Register]bool{}
+	for _, b := range f.Blocks {
+		liveIn[b], liveOut[b] = map[*ir.Register]bool{}, map[*ir.Register]bool{}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := len(f.Blocks) - 1; i >= 0; i-- {
+			b := f.Blocks[i]
+			for _, succ := range b.Successors() {
+				for r := range liveIn[succ] {
+					if !liveOut[b][r] {
+						liveOut[b][r] = true
+						changed = true
+					}
+				}
+			}
+			for r := range uses[b] {
+				if !liveIn[b][r] {
+					liveIn[b][r] = true
+					changed = true
+				}
+			}
+			for r := range liveOut[b] {
+				if !defs[b][r] && !liveIn[b][r] {
+					liveIn[b][r] = true
+					changed = true
+				}
+			}
+		}
+	}
+
+	intervals := []*interval{}
+	byRegister := map[*ir.Register]*interval{}
+	cover := func(r *ir.Register, pos int) {
+		i, ok := byRegister[r]
+		if !ok {
+			i = &interval{r: r, start: pos, end: pos}
+			byRegister[r] = i
+			intervals = append(intervals, i)
+		}
+		i.cover(pos)
+	}
+
+	// the prologue moves the parameters out of the argument registers, so
+	// they can't be kept in any of them
+	clobbered := map[*Register][]int{REG_RAX: {0}}
+	for _, r := range ARG_REGS {
+		clobbered[r] = []int{0}
+	}
+	for _, p := range f.Params {
+		cover(p, 0)
+		cover(p, 1)
+	}
+
+	k := 1
+	for _, b := range f.Blocks {
+		start := 2 * k
+		for r := range liveIn[b] {
+			cover(r, start)
+		}
+		for _, instr := range b.Instructions {
+			for _, r := range registers(instr.Args) {
+				cover(r, 2*k)
+			}
+			if instr.Dest != nil && !isAlloca[instr.Dest] {
+				cover(instr.Dest, 2*k+1)
+			}
+			for _, r := range clobbers(instr) {
+				clobbered[r] = append(clobbered[r], 2*k)
+			}
+			k++
+		}
+		for r := range liveOut[b] {
+			cover(r, 2*k-1)
+		}
+	}
+
+	// map iteration doesn't give the same order twice
+	sort.Slice(intervals, func(i, j int) bool {
+		return intervals[i].r.ID < intervals[j].r.ID
+	})
+	return intervals, clobbered
+}
@@ -0,0 +1,197 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// Check that every virtual register has a place, that no two of them that
+// are live at the same time share a machine register and that no machine
+// register is clobbered while a virtual register is kept in it
+func checkAllocation(t *testing.T, f *ir.Function, a *allocation) {
+	t.Helper()
+	intervals, clobbered := liveIntervals(f)
+
+	spilled := map[*ir.Register]bool{}
+	for _, r := range a.spilled {
+		spilled[r] = true
+	}
+
+	for i, x := range intervals {
+		r, ok := a.registers[x.r]
+		if ok == spilled[x.r] {
+			t.Errorf("%s: %s is in a register: %t, spilled: %t", f.Name, x.r, ok, spilled[x.r])
+			continue
+		} else if !ok {
+			continue
+		}
+
+		for _, pos := range clobbered[r] {
+			if x.start <= pos && pos <= x.end {
+				t.Errorf("%s: %s is in %s, which is clobbered at %d", f.Name, x.r, r.String(), pos)
+			}
+		}
+		for _, y := range intervals[i+1:] {
+			if a.registers[y.r] == r && x.start <= y.end && y.start <= x.end {
+				t.Errorf("%s: %s and %s are both in %s", f.Name, x.r, y.r, r.String())
+			}
+		}
+	}
+}
+
+// The name of the machine register r is kept in, or "spilled"
+func placeOf(a *allocation, r *ir.Register) string {
+	if machine, ok := a.registers[r]; ok {
+		return machine.String()
+	}
+	return "spilled"
+}
+
+func keptIn(a *allocation, r *ir.Register, registers []*Register) bool {
+	for _, machine := range registers {
+		if a.registers[r] == machine {
+			return true
+		}
+	}
+	return false
+}
+
+// int f(int p) { int v0 = p + 0, v1 = p + 1, ...; return v0 + v1 + ...; }
+func buildPressure(n int) *ir.Function {
+	f := ir.NewFunction("pressure", ir.I32, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	values := []*ir.Register{}
+	for i := 0; i < n; i++ {
+		values = append(values, b.Binary(ir.Add, f.Params[0], &ir.Const{Value: int64(i), T: ir.I32}))
+	}
+
+	sum := values[0]
+	for _, v := range values[1:] {
+		sum = b.Binary(ir.Add, sum, v)
+	}
+	b.Ret(sum)
+	return f
+}
+
+func TestSpilling(t *testing.T) {
+	few := buildPressure(4)
+	a := allocateRegisters(few)
+	checkAllocation(t, few, a)
+	if len(a.spilled) != 0 {
+		t.Errorf("expected nothing to be spilled with 4 values, got %d", len(a.spilled))
+	}
+
+	// every value is live until the sums at the end
+	n := len(REG_ORDER) + 6
+	many := buildPressure(n)
+	a = allocateRegisters(many)
+	checkAllocation(t, many, a)
+	if len(a.spilled) < n-len(REG_ORDER) {
+		t.Fatalf("expected at least %d of %d values to be spilled, got %d",
+			n-len(REG_ORDER), n, len(a.spilled))
+	}
+
+	g := &generator{
+		f:         &Function{IR: many, compiler: &Compiler{}},
+		registers: a.registers,
+		slots:     map[*ir.Register]*Address{},
+		allocas:   map[*ir.Register]*Address{},
+		saved:     map[*Register]*Address{},
+	}
+	g.layoutFrame(a)
+	offsets := map[int64]bool{}
+	for _, r := range a.spilled {
+		slot := g.slots[r]
+		if slot == nil || offsets[slot.Displacement] {
+			t.Errorf("expected spilled %s to get a slot of its own, got %v", r, slot)
+			continue
+		}
+		offsets[slot.Displacement] = true
+	}
+}
+
+// int f(int a, int b, int c) { return (a op b) + c; }, c is live while
+// a op b is computed
+func buildAcross(op ir.Op) *ir.Function {
+	f := ir.NewFunction(string(op), ir.I32, []ir.Type{ir.I32, ir.I32, ir.I32}, false)
+	b := ir.NewBuilder(f)
+	x := b.Binary(op, f.Params[0], f.Params[1])
+	b.Ret(b.Binary(ir.Add, x, f.Params[2]))
+	return f
+}
+
+// Division uses rax and rdx, shifts take their count in cl, and both need a
+// scratch register for the operands
+func TestScratchRegisters(t *testing.T) {
+	tests := []struct {
+		op       ir.Op
+		excluded []*Register
+	}{
+		{ir.SDiv, []*Register{REG_RAX, REG_RCX, REG_RDX}},
+		{ir.URem, []*Register{REG_RAX, REG_RCX, REG_RDX}},
+		{ir.Shl, []*Register{REG_RAX, REG_RCX}},
+		{ir.AShr, []*Register{REG_RAX, REG_RCX}},
+	}
+
+	for _, tt := range tests {
+		f := buildAcross(tt.op)
+		a := allocateRegisters(f)
+		checkAllocation(t, f, a)
+
+		// the operands, and c, which is live across the instruction
+		for _, p := range f.Params {
+			if keptIn(a, p, tt.excluded) {
+				t.Errorf("%s: expected %s not to be in %s", tt.op, p, placeOf(a, p))
+			}
+		}
+	}
+}
+
+// The index of the first line of the assembly with the prefix and the
+// suffix, or -1
+func findLine(lines []string, prefix, suffix string) int {
+	for i, line := range lines {
+		if strings.HasPrefix(line, prefix) && strings.HasSuffix(line, suffix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// int f(int a, int b) { int x = a + b; g(); return x + a; }
+func TestCalleeSaved(t *testing.T) {
+	f := ir.NewFunction("f", ir.I32, []ir.Type{ir.I32, ir.I32}, false)
+	b := ir.NewBuilder(f)
+	x := b.Binary(ir.Add, f.Params[0], f.Params[1])
+	b.Call(ir.Void, &ir.Global{Name: "g"}, nil, false)
+	b.Ret(b.Binary(ir.Add, x, f.Params[0]))
+
+	a := allocateRegisters(f)
+	checkAllocation(t, f, a)
+	for _, r := range []*ir.Register{x, f.Params[0]} {
+		if keptIn(a, r, CALLER_SAVED) {
+			t.Errorf("expected %s, which is live across the call, not to be in %s", r, placeOf(a, r))
+		}
+	}
+
+	// the prologue saves the ones that are used and the epilogue puts them
+	// back before leaving
+	asm := generate(t, f)
+	lines := strings.Split(asm, "\n")
+	leave := findLine(lines, "\tleave", "")
+	for _, r := range CALLEE_SAVED {
+		save := findLine(lines, "\tmov\tqword [rbp - ", ", "+r.String())
+		restore := findLine(lines, "\tmov\t"+r.String()+", qword [rbp - ", "]")
+		if !a.used[r] && (save >= 0 || restore >= 0) {
+			t.Errorf("expected %s, which isn't used, not to be saved:\n%s", r.String(), asm)
+		} else if a.used[r] && (save < 0 || save > restore || restore > leave) {
+			t.Errorf("expected %s to be saved and restored before leaving:\n%s", r.String(), asm)
+		}
+	}
+	if !a.used[REG_RBX] {
+		t.Errorf("expected the values live across the call in rbx and the next ones, got %s and %s",
+			placeOf(a, x), placeOf(a, f.Params[0]))
+	}
+}
@@ -0,0 +1,75 @@
+package compiler
+
+import (
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// A block scope. The checker has resolved the names already, so it only
+// keeps track of the stack.
+type scope struct {
+	parent *scope
+
+	// the top of the stack before the first variable length array in the
+	// scope was allocated, nil if there are none
+	stackPointer ir.Value
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{parent: parent}
+}
+
+// The storage of the local variable or parameter that the checker resolved
+// an identifier to. At most one of the results is non-nil.
+func (f *Function) lookup(decl ast.Declaration) (*value, *vla) {
+	if variable, ok := f.variables[decl]; ok {
+		return variable, nil
+	}
+	return nil, f.vlas[decl]
+}
+
+func (f *Function) declareVariable(decl ast.Declaration, variable *value) {
+	f.variables[decl] = variable
+}
+
+// Where the size of arr was stored when a variable length array was declared
+// with it, nil if it wasn't
+func (f *Function) lookupVLASize(arr *ast.Array) ir.Value {
+	for _, v := range f.vlas {
+		if slot, ok := v.sizes[arr]; ok {
+			return slot
+		}
+	}
+	return nil
+}
+
+func (f *Function) declareVLA(decl ast.Declaration, v *vla) {
+	f.vlas[decl] = v
+}
+
+// Enter a block scope, the stack slots of its variables can be shared with
+// the ones of the blocks next to it
+func (f *Function) enterScope() {
+	f.scope = newScope(f.scope)
+	f.b.Scope = f.IR.NewScope(f.b.Scope)
+}
+
+// Leave the current scope, releasing any variable length arrays allocated in
+// it
+func (f *Function) leaveScope() {
+	if f.scope.stackPointer != nil {
+		f.b.StackRestore(f.scope.stackPointer)
+	}
+
+	f.scope = f.scope.parent
+	f.b.Scope = f.b.Scope.Parent
+}
+
+func (f *Function) compileBlockStatement(block *ast.BlockStatement) {
+	f.enterScope()
+	defer f.leaveScope()
+
+	for _, stmt := range block.Statements {
+		f.compileStatement(stmt)
+	}
+}
@@ -0,0 +1,59 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/opt"
+)
+
+// Compile the source without optimizations and return the assembly
+func compileSource(t *testing.T, input string) string {
+	t.Helper()
+	var out strings.Builder
+	if err := compileWith(t, input, nil).WriteAssembly(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestScopeFrameSize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"int main() { { long a[100]; a[0] = 1; } { long b[100]; b[0] = 2; } return 0; }", "0x320"},
+		{"int main() { { long a[100]; a[0] = 1; { long b[100]; b[0] = 2; } } return 0; }", "0x640"},
+		{"int main() { long a[100]; a[0] = 1; { long b[100]; b[0] = 2; } return 0; }", "0x640"},
+		{"int main() { { long a[100]; a[0] = 1; } { char c[8]; c[0] = 2; { long b[90]; b[0] = 3; } } return 0; }", "0x320"},
+		{"int main() { { char c[8]; c[0] = 1; } long a[100]; a[0] = 2; return 0; }", "0x330"},
+	}
+
+	for _, tt := range tests {
+		if actual := frameSize(compileSource(t, tt.input)); actual != tt.expected {
+			t.Errorf("%q: expected a frame of %s bytes, got %s", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+// Identifiers refer to the declarations the checker resolved them to, even
+// when an inner block hides them
+func TestNameResolution(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"int f() { int x = 1; { int x = 2; x = 3; } return x; }", 1},
+		{"int f() { int x = 1; { x = 2; int x = 3; x = 4; } return x; }", 2},
+		{"int f() { int x = 1; for (int x = 5; x < 6; x = x + 1) {} return x; }", 1},
+		{"int g(int n) { return n; } int f() { int g = 4; return g; }", 4},
+		{"int f() { int n = 2; { int n = 3; int a[n]; n = 5; return sizeof a; } }", 12},
+	}
+
+	for _, tt := range tests {
+		f := compileWith(t, tt.input, opt.NewPipeline(1)).IR().Function("f")
+		if c, ok := returnedConstant(f); !ok || c != tt.expected {
+			t.Errorf("%q: expected to return %d:\n%s", tt.input, tt.expected, f)
+		}
+	}
+}
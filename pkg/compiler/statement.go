@@ -4,9 +4,15 @@ import (
 	"fmt"
 
 	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/ir"
 )
 
 func (f *Function) compileStatement(stmt ast.Statement) {
+	if stmt == nil {
+		return
+	}
+
+	defer f.at(stmt.Pos())()
 	switch s := stmt.(type) {
 	case *ast.DeclarationStatement:
 		for _, d := range s.Declarations {
@@ -15,11 +21,25 @@ func (f *Function) compileStatement(stmt ast.Statement) {
 				f.compileVariableDeclaration(decl)
 			case *ast.FunctionDeclaration:
 				f.err("cannot declare a function inside another function")
+			case *ast.StructOrUnionSpecification:
+				// only declares a tag
 			default:
 				f.warn("declaration does not declare anything")
 			}
 		}
+	case *ast.BlockStatement:
+		f.compileBlockStatement(s)
+	case *ast.ExpressionStatement:
+		result := f.compileExpression(s.Expression)
+		if result == nil {
+			f.err(fmt.Sprintf("Could not compile '%s'", s.Expression))
+		}
 	case *ast.ReturnStatement:
+		if s.ReturnValue == nil {
+			f.b.Ret(nil)
+			return
+		}
+
 		returnValue := f.compileExpression(s.ReturnValue)
 		if returnValue == nil {
 			f.err(fmt.Sprintf("Could not compile '%s'", s.ReturnValue))
@@ -32,11 +52,134 @@ func (f *Function) compileStatement(stmt ast.Statement) {
 			return
 		}
 
-		// TODO if return value is already in RAX, no need to mov()
-		returnReg := &RegisterOperand{Register: REG_RAX, DataType: f.Type}
-		f.Instructions = append(f.Instructions,
-			Mov(returnReg, returnValue),
-			Leave(),
-			Ret())
+		f.b.Ret(returnValue.val)
+	case *ast.IfStatement:
+		f.compileIf(s)
+	case *ast.WhileStatement:
+		cond, body, end := f.IR.NewBlock(), f.IR.NewBlock(), f.IR.NewBlock()
+		f.b.Jmp(cond)
+		f.b.StartBlock(cond)
+		f.compileCondition(s.Condition, body, end)
+		f.b.StartBlock(body)
+		f.compileLoopBody(s.Body, end, cond)
+		f.b.Jmp(cond)
+		f.b.StartBlock(end)
+	case *ast.DoWhileStatement:
+		body, cond, end := f.IR.NewBlock(), f.IR.NewBlock(), f.IR.NewBlock()
+		f.b.Jmp(body)
+		f.b.StartBlock(body)
+		f.compileLoopBody(s.Body, end, cond)
+		f.b.Jmp(cond)
+		f.b.StartBlock(cond)
+		f.compileCondition(s.Condition, body, end)
+		f.b.StartBlock(end)
+	case *ast.ForStatement:
+		f.compileFor(s)
+	case *ast.BreakStatement:
+		f.jumpOut(f.loop.brk)
+	case *ast.ContinueStatement:
+		f.jumpOut(f.loop.cont)
+	}
+}
+
+// Where break and continue go in a loop
+type loop struct {
+	brk  *ir.Block
+	cont *ir.Block
+	// the scope around the body, anything inside it is left by a jump
+	scope *scope
+}
+
+func (f *Function) compileIf(s *ast.IfStatement) {
+	then, end := f.IR.NewBlock(), f.IR.NewBlock()
+	els := end
+	if s.Alternative != nil {
+		els = f.IR.NewBlock()
+	}
+
+	f.compileCondition(s.Condition, then, els)
+	f.b.StartBlock(then)
+	f.compileStatement(s.Consequence)
+	f.b.Jmp(end)
+
+	if s.Alternative != nil {
+		f.b.StartBlock(els)
+		f.compileStatement(s.Alternative)
+		f.b.Jmp(end)
+	}
+	f.b.StartBlock(end)
+}
+
+// The condition is checked before every iteration and the last clause is
+// evaluated after it, which is where continue goes
+func (f *Function) compileFor(s *ast.ForStatement) {
+	f.enterScope()
+	defer f.leaveScope()
+	f.compileStatement(s.Init)
+
+	cond, body, post, end := f.IR.NewBlock(), f.IR.NewBlock(), f.IR.NewBlock(),
+		f.IR.NewBlock()
+	f.b.Jmp(cond)
+	f.b.StartBlock(cond)
+	if s.Condition != nil {
+		f.compileCondition(s.Condition, body, end)
+	} else {
+		f.b.Jmp(body)
+	}
+
+	f.b.StartBlock(body)
+	f.compileLoopBody(s.Body, end, post)
+	f.b.Jmp(post)
+
+	f.b.StartBlock(post)
+	if s.Post != nil && f.compileExpression(s.Post) == nil {
+		f.err(fmt.Sprintf("Could not compile '%s'", s.Post))
+	}
+	f.b.Jmp(cond)
+	f.b.StartBlock(end)
+}
+
+// Branch to then if the controlling expression of an if or a loop is
+// nonzero and to els otherwise. A constant only needs a jump.
+func (f *Function) compileCondition(expr ast.Expression, then, els *ir.Block) {
+	cond := f.compileExpression(expr)
+	if cond == nil {
+		f.err(fmt.Sprintf("Could not compile '%s'", expr))
+		return
+	}
+	cond = f.rvalue(cond)
+
+	if imm, ok := constantOf(cond); ok {
+		if imm.Value != 0 {
+			f.b.Jmp(then)
+		} else {
+			f.b.Jmp(els)
+		}
+		return
+	}
+	f.b.Br(cond.val, then, els)
+}
+
+func (f *Function) compileLoopBody(body ast.Statement, brk, cont *ir.Block) {
+	outer := f.loop
+	f.loop = &loop{brk: brk, cont: cont, scope: f.scope}
+	defer func() { f.loop = outer }()
+
+	f.compileStatement(body)
+}
+
+// Jump out of the scopes in the loop, releasing the variable length arrays
+// that were allocated in them
+func (f *Function) jumpOut(target *ir.Block) {
+	var stackPointer ir.Value
+	for s := f.scope; s != f.loop.scope; s = s.parent {
+		if s.stackPointer != nil {
+			stackPointer = s.stackPointer
+		}
+	}
+
+	if stackPointer != nil {
+		f.b.StackRestore(stackPointer)
 	}
+	f.b.Jmp(target)
 }
@@ -0,0 +1,125 @@
+package compiler
+
+import (
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/opt"
+	"github.com/tjarjoura/cc/pkg/parser"
+	"github.com/tjarjoura/cc/pkg/sema"
+)
+
+// Compile the source, running the passes over the IR if they aren't nil
+func compileWith(t *testing.T, input string, passes *opt.Manager) *Compiler {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	tUnit := p.Parse()
+	for _, err := range p.Errors() {
+		t.Fatalf("parser error in %q: %s", input, err.String())
+	}
+
+	checker := sema.New(tUnit)
+	info := checker.Check()
+	for _, err := range checker.Errors() {
+		if !err.Warning() {
+			t.Fatalf("semantic error in %q: %s", input, err.String())
+		}
+	}
+
+	c := New(tUnit, info)
+	c.Passes = passes
+	c.Compile()
+	for _, errors := range c.Errors() {
+		for _, err := range errors {
+			if !err.Warning() {
+				t.Fatalf("compiler error in %q: %s", input, err.String())
+			}
+		}
+	}
+	return c
+}
+
+// The multiplications that are left in the blocks after the entry, which is
+// where the ones that are hoisted out of a loop end up
+func multiplications(f *ir.Function) int {
+	n := 0
+	for _, b := range f.Blocks[1:] {
+		for _, instr := range b.Instructions {
+			if instr.Op == ir.Mul {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// Loops that are lowered from C get to the loop passes
+func TestLoopPasses(t *testing.T) {
+	tests := []struct {
+		level int
+		input string
+		check func(f *ir.Function) bool
+	}{
+		{
+			// k * 3 is the same in every iteration
+			1,
+			"long f(long n, long k) { long s = 0; for (long i = 0; i < n; i = i + 1) s = s + k * 3; return s; }",
+			func(f *ir.Function) bool { return multiplications(f) == 0 },
+		},
+		{
+			// the index is scaled by the size of the elements
+			2,
+			"long f(long *xs, long n) { long s = 0; long i = 0; while (i < n) { s = s + xs[i]; i = i + 1; } return s; }",
+			func(f *ir.Function) bool { return multiplications(f) == 0 },
+		},
+		{
+			// unrolled and folded into a constant
+			2,
+			"int f() { int s = 0; for (int i = 0; i < 4; i = i + 1) s = s + i * 5; return s; }",
+			func(f *ir.Function) bool {
+				ret := f.Entry().Terminator()
+				c, ok := ret.Args[0].(*ir.Const)
+				return len(f.Blocks) == 1 && ok && c.Value == 30
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		c := compileWith(t, tt.input, opt.NewPipeline(tt.level))
+		if f := c.IR().Function("f"); !tt.check(f) {
+			t.Errorf("-O%d %q: the loop wasn't optimized:\n%s", tt.level, tt.input, f)
+		}
+	}
+}
+
+// Leaving a loop early releases the variable length arrays of the blocks it
+// leaves, but not the ones around the loop
+func TestJumpOutOfLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		restores int
+	}{
+		{"int f(int n) { while (n) { int a[n]; a[0] = 1; if (a[0]) break; n = n - 1; } return n; }", 2},
+		{"int f(int n) { for (;;) { { int a[n]; a[0] = 1; if (n) { continue; } } n = n - 1; } }", 2},
+		{"int f(int n) { int a[n]; a[0] = 1; while (n) break; return a[0]; }", 0},
+		{"int f(int n) { do { break; int a[n]; a[0] = 1; } while (n); return n; }", 0},
+	}
+
+	for _, tt := range tests {
+		f := compileWith(t, tt.input, nil).IR().Function("f")
+		restores := 0
+		for _, b := range f.Blocks {
+			for _, instr := range b.Instructions {
+				if instr.Op == ir.StackRestore {
+					restores++
+				}
+			}
+		}
+
+		if restores != tt.restores {
+			t.Errorf("%q: expected %d stack restores, got %d:\n%s", tt.input,
+				tt.restores, restores, f)
+		}
+	}
+}
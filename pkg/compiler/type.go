@@ -2,32 +2,19 @@ package compiler
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/constant"
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/layout"
 )
 
-var (
-	SizeToType = map[uint64]string{
-		1: "char",
-		2: "short",
-		4: "int",
-		8: "long",
-	}
-
-	TypeToSize = map[string]uint64{
-		"char":          1,
-		"short int":     2,
-		"int":           4,
-		"long int":      8,
-		"long long int": 8,
-		"float":         4,
-		"double":        8,
-		"long double":   16,
-	}
-
-	PtrSize uint64 = 8
-)
+var SizeToType = map[uint64]string{
+	1: "char",
+	2: "short int",
+	4: "int",
+	8: "long int",
+}
 
 func IntSize(val uint64) uint64 {
 	if val <= 0xFF {
@@ -47,103 +34,140 @@ func IntType(val int64) *ast.BaseType {
 	return &ast.BaseType{Name: SizeToType[size]}
 }
 
-func SizeOf(decl ast.Declaration) uint64 {
-	switch d := decl.(type) {
-	case *ast.Pointer:
-		return PtrSize
-	case *ast.Array:
-		panic("Sizeof(array) not implemented yet!")
-	case *ast.BaseType:
-		return TypeToSize[d.Name]
-	case *ast.VariableDeclaration:
-		return SizeOf(d.Type())
-	case *ast.FunctionDeclaration:
-		// This is a nonsense operation, but other compilers seem to return 1 here
-		return 1
-	default:
-		// Should never get here
-		return 0
+var (
+	charType   = ast.CharType
+	intType    = ast.IntType
+	longType   = ast.LongType
+	doubleType = ast.DoubleType
+	voidType   = ast.VoidType
+)
+
+// Find a member of a struct or union along with its offset
+func structMember(s *ast.StructOrUnionSpecification,
+	name string) (*ast.VariableDeclaration, uint64) {
+	member, i := s.Member(name)
+	if member == nil {
+		return nil, 0
 	}
+
+	offsets, _, _ := layout.StructLayout(s)
+	return member, offsets[i]
 }
 
-func isPointer(d ast.Declaration) bool {
-	_, ok := d.(*ast.Pointer)
-	return ok
+// The type of an expression as worked out by the semantic checker
+func (f *Function) typeOf(expr ast.Expression) ast.Declaration {
+	return f.compiler.info.Types[expr].Type
 }
 
-func isFloat(d ast.Declaration) bool {
-	b, ok := d.(*ast.BaseType)
-	if !ok {
-		return false
+// The IR type holding values of a scalar type. Arrays and functions are
+// only used through pointers to them.
+func irType(t ast.Declaration) ir.Type {
+	switch {
+	case t == nil || ast.IsVoid(t):
+		return ir.Void
+	case ast.IsPointer(ast.Decay(t)) || ast.IsVaList(t):
+		return ir.Ptr
+	case ast.IsInteger(t):
+		return ir.IntType(int64(layout.SizeOf(t)))
 	}
-
-	return strings.Contains(b.Name, "double") ||
-		strings.Contains(b.Name, "float")
-
+	return ir.Void
 }
 
-func biggestType(a ast.Declaration, b ast.Declaration) ast.Declaration {
-	if SizeOf(b) > SizeOf(a) {
-		return b
+// Lower a conversion inserted by the semantic checker
+func (f *Function) compileImplicitConversion(c *ast.ImplicitConversion) *value {
+	v := f.compileExpression(c.Expression)
+	if v == nil {
+		return nil
+	}
+
+	switch c.Kind {
+	case ast.ArrayToPointer, ast.FunctionToPointer:
+		return f.decay(v)
+	case ast.IntegerConversion, ast.IntegerToPointer, ast.PointerToInteger:
+		return f.convert(f.rvalue(v), c.To)
+	case ast.FloatingConversion:
+		f.err("floating point conversions are not supported yet")
+		return nil
 	}
 
-	return a
+	// the representation doesn't change, only the type does
+	if v.lvalue {
+		return &value{val: v.val, dataType: c.To, lvalue: true}
+	}
+	return f.convert(v, c.To)
 }
 
+// Convert the value for an assignment to toType, loading it if it is an
+// lvalue
 func (f *Function) compileTypeConversion(toType ast.Declaration,
-	fromType ast.Declaration, value Operand) Operand {
+	fromType ast.Declaration, v *value) *value {
+	switch fromType.(type) {
+	case *ast.Array, *ast.FunctionDeclaration:
+		if ast.IsPointer(toType) {
+			v = f.decay(v)
+			fromType = v.Type()
+		}
+	}
+
 	if ast.ConvertError(toType, fromType) {
 		f.err(fmt.Sprintf(
-			"incompatible types when converting from %s to %s",
-			fromType.String(), toType.String()))
+			"incompatible types when converting from '%s' to '%s'",
+			ast.TypeString(fromType), ast.TypeString(toType)))
 		return nil
 	} else if ast.ConvertWarn(toType, fromType) {
 		f.warn(fmt.Sprintf(
-			"converting from %s to %s without a cast",
-			fromType.String(), toType.String()))
+			"converting from '%s' to '%s' without a cast",
+			ast.TypeString(fromType), ast.TypeString(toType)))
 		return nil
 	}
 
-	return value
-
-	sizeDifference := SizeOf(fromType) - SizeOf(toType)
-	if sizeDifference == 0 {
-		return value
-	} else {
-		panic(fmt.Sprintf("can't handle type conversions: %s to %s!",
-			fromType.String(), toType.String()))
+	v = f.rvalue(v)
+	if ast.IsInteger(toType) && ast.IsInteger(fromType) {
+		return f.convert(v, toType)
 	}
-	switch value.(type) {
-	case *ImmediateInt: // TODO
-		if sizeDifference > 0 { // getting smaller
-		} else { // getting bigger
+
+	return v
+}
+
+// Convert a scalar rvalue to toType. Constants are converted at compile
+// time, and only the type changes if the representation stays the same.
+func (f *Function) convert(v *value, toType ast.Declaration) *value {
+	from, to := irType(v.dataType), irType(toType)
+	if c, ok := constantOf(v); ok {
+		if to == ir.Void {
+			return &value{val: c, dataType: toType}
 		}
+		return f.constant(constant.Wrap(c.Value, toType), toType)
+	} else if from == to || from == ir.Void || to == ir.Void {
+		return &value{val: v.val, dataType: toType}
+	}
 
+	val := v.val
+	if from == ir.Ptr { // pointers are converted like 64 bit integers
+		val, from = f.b.Convert(ir.PtrToInt, val, ir.I64), ir.I64
 	}
 
-	/*
-		sizeDifference := SizeOf(fromType) - SizeOf(toType)
-		if !(fromType.Unsigned) {
+	wide := to
+	if to == ir.Ptr {
+		wide = ir.I64
+	}
 
-		}
-		if SizeOf(toType) == SizeOf(fromType) {
-			// nothing to do
-			return value
-		} else if SizeOf(toType) < SizeOf(fromType) {
-			switch v := value.(type) {
-			case *ImmediateInt:
-				return &ImmediateInt{Value: value & Bitmask(SizeOf(toType))}
-			case *Register:
-				f.Instructions = append(f.Instructions,
-					Shl(v),
-					Sar(v),
-				)
-			default:
-				panic("Can't handle operands of this type!")
-			}
-		} else {
-		}
-	*/
+	switch {
+	case wide.Size() < from.Size():
+		val = f.b.Convert(ir.Trunc, val, wide)
+	case wide.Size() > from.Size() && ast.IsUnsigned(v.dataType):
+		val = f.b.Convert(ir.ZExt, val, wide)
+	case wide.Size() > from.Size():
+		val = f.b.Convert(ir.SExt, val, wide)
+	}
+
+	if to == ir.Ptr {
+		val = f.b.Convert(ir.IntToPtr, val, ir.Ptr)
+	}
+	return &value{val: val, dataType: toType}
+}
 
-	return value
+// Sign or zero extend an integer to 64 bits
+func (f *Function) loadLong(v *value) *value {
+	return f.convert(v, ast.SizeType)
 }
@@ -0,0 +1,348 @@
+// Package constant evaluates C integer constant expressions with the rules of
+// the language: operands are promoted and converted to a common type, the
+// result wraps around to the width of its type and signed overflow,
+// division by zero and bad shift counts are detected.
+package constant
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+// The value of an integer constant expression, sign or zero extended from the
+// width of its type
+type Value struct {
+	Int  int64
+	Type ast.Declaration
+
+	// The operation that produced the value overflowed its signed type, so
+	// Int is only what the hardware would give
+	Overflow bool
+}
+
+// Why an expression couldn't be evaluated
+type Error struct {
+	Expr ast.Expression // the innermost expression at fault, nil in Unary and Binary
+	Msg  string
+
+	// The expression is constant, but C leaves its value undefined
+	Undefined bool
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+func notConstant() *Error {
+	return &Error{Msg: "expression is not an integer constant expression"}
+}
+
+func undefined(format string, a ...interface{}) *Error {
+	return &Error{Msg: fmt.Sprintf(format, a...), Undefined: true}
+}
+
+// Wrap n around to the width of the integer type t
+func Wrap(n int64, t ast.Declaration) int64 {
+	width := ast.IntegerWidth(t)
+	if width == 0 || width >= 64 {
+		return n
+	}
+
+	shift := 64 - width
+	if ast.IsUnsigned(t) {
+		return int64(uint64(n) << shift >> shift)
+	}
+	return n << shift >> shift
+}
+
+// Convert the value to the integer type t
+func Convert(v Value, t ast.Declaration) Value {
+	return Value{Int: Wrap(v.Int, t), Type: t}
+}
+
+func boolean(b bool) Value {
+	if b {
+		return Value{Int: 1, Type: ast.IntType}
+	}
+	return Value{Int: 0, Type: ast.IntType}
+}
+
+// Evaluates expressions, with hooks for the parts that depend on who is
+// asking. Expressions that need a hook which isn't set are not constant.
+type Evaluator struct {
+	// The size and alignment of a type, false if it doesn't have a constant
+	// one, like incomplete types and variable length arrays
+	SizeOf  func(ast.Declaration) (int64, bool)
+	AlignOf func(ast.Declaration) (int64, bool)
+
+	// The type of the operand of sizeof expr
+	TypeOf func(ast.Expression) ast.Declaration
+
+	// The value of an identifier, for enumeration constants
+	Identifier func(*ast.Identifier) (Value, bool)
+}
+
+// Eval evaluates an integer constant expression. Operands that are not
+// evaluated, like the arm of a conditional that isn't chosen or the right
+// side of a && that is decided by its left side, may have undefined values
+// but must still be constant.
+func (e *Evaluator) Eval(expr ast.Expression) (Value, error) {
+	return e.eval(expr, true)
+}
+
+func (e *Evaluator) eval(expr ast.Expression, evaluated bool) (Value, error) {
+	v, err := e.evalNode(expr, evaluated)
+	if err, ok := err.(*Error); ok {
+		if err.Undefined && !evaluated {
+			return Value{Int: 0, Type: v.Type}, nil
+		} else if err.Expr == nil {
+			err.Expr = expr
+		}
+	}
+	return v, err
+}
+
+func (e *Evaluator) evalNode(expr ast.Expression, evaluated bool) (Value, error) {
+	switch x := expr.(type) {
+	case *ast.IntegerLiteral:
+		if x.Value > math.MaxInt32 {
+			return Value{Int: x.Value, Type: ast.LongType}, nil
+		}
+		return Value{Int: x.Value, Type: ast.IntType}, nil
+	case *ast.Identifier:
+		if e.Identifier != nil {
+			if v, ok := e.Identifier(x); ok {
+				return v, nil
+			}
+		}
+	case *ast.ImplicitConversion:
+		v, err := e.eval(x.Expression, evaluated)
+		if err != nil {
+			return v, err
+		}
+		switch x.Kind {
+		case ast.IntegerConversion:
+			if ast.IsInteger(x.To) {
+				return Convert(v, x.To), nil
+			}
+		case ast.NullToPointer:
+			return v, nil
+		}
+	case *ast.SizeofExpression:
+		t := x.TypeName
+		if t == nil && e.TypeOf != nil {
+			t = e.TypeOf(x.Right)
+		}
+		if t != nil && e.SizeOf != nil {
+			if size, ok := e.SizeOf(t); ok {
+				return Value{Int: size, Type: ast.SizeType}, nil
+			}
+		}
+	case *ast.AlignofExpression:
+		if e.AlignOf != nil {
+			if align, ok := e.AlignOf(x.TypeName); ok {
+				return Value{Int: align, Type: ast.SizeType}, nil
+			}
+		}
+	case *ast.PrefixExpression:
+		right, err := e.eval(x.Right, evaluated)
+		if err != nil {
+			return right, err
+		}
+		return Unary(x.Operator, right)
+	case *ast.InfixExpression:
+		return e.evalInfix(x, evaluated)
+	case *ast.ConditionalExpression:
+		cond, err := e.eval(x.Condition, evaluated)
+		if err != nil {
+			return cond, err
+		}
+		a, err := e.eval(x.Consequence, evaluated && cond.Int != 0)
+		if err != nil {
+			return a, err
+		}
+		b, err := e.eval(x.Alternative, evaluated && cond.Int == 0)
+		if err != nil {
+			return b, err
+		}
+
+		t := ast.ArithmeticType(a.Type, b.Type)
+		if cond.Int != 0 {
+			return Convert(a, t), nil
+		}
+		return Convert(b, t), nil
+	}
+
+	return Value{}, notConstant()
+}
+
+func (e *Evaluator) evalInfix(x *ast.InfixExpression, evaluated bool) (Value, error) {
+	left, err := e.eval(x.Left, evaluated)
+	if err != nil {
+		return left, err
+	}
+
+	// the right side of && and || is only evaluated if the left doesn't
+	// already decide the result
+	switch x.Operator {
+	case token.AND:
+		evaluated = evaluated && left.Int != 0
+	case token.OR:
+		evaluated = evaluated && left.Int == 0
+	}
+
+	right, err := e.eval(x.Right, evaluated)
+	if err != nil {
+		return right, err
+	}
+	return Binary(x.Operator, left, right)
+}
+
+// Unary applies a prefix operator to a constant
+func Unary(op string, x Value) (Value, error) {
+	if !ast.IsInteger(x.Type) {
+		return Value{}, notConstant()
+	}
+
+	t := ast.Promote(x.Type)
+	x = Convert(x, t)
+	switch op {
+	case token.PLUS:
+		return x, nil
+	case token.MINUS:
+		return arithmetic(token.MINUS, Value{Int: 0, Type: t}, x, t)
+	case token.BITNOT:
+		return Value{Int: Wrap(^x.Int, t), Type: t}, nil
+	case token.NOT:
+		return boolean(x.Int == 0), nil
+	}
+
+	return Value{}, notConstant()
+}
+
+// Binary applies an infix operator to two constants
+func Binary(op string, x Value, y Value) (Value, error) {
+	if !ast.IsInteger(x.Type) || !ast.IsInteger(y.Type) {
+		return Value{}, notConstant()
+	}
+
+	switch op {
+	case token.AND:
+		return boolean(x.Int != 0 && y.Int != 0), nil
+	case token.OR:
+		return boolean(x.Int != 0 || y.Int != 0), nil
+	case token.LSHIFT, token.RSHIFT:
+		return shift(op, x, y)
+	}
+
+	t := ast.ArithmeticType(x.Type, y.Type)
+	x, y = Convert(x, t), Convert(y, t)
+	switch op {
+	case token.EQUALS:
+		return boolean(x.Int == y.Int), nil
+	case token.NOTEQUALS:
+		return boolean(x.Int != y.Int), nil
+	case token.LT, token.LTE, token.GT, token.GTE:
+		return boolean(compare(op, x.Int, y.Int, ast.IsUnsigned(t))), nil
+	}
+
+	return arithmetic(op, x, y, t)
+}
+
+func compare(op string, a int64, b int64, unsigned bool) bool {
+	less, equal := a < b, a == b
+	if unsigned {
+		less = uint64(a) < uint64(b)
+	}
+
+	switch op {
+	case token.LT:
+		return less
+	case token.LTE:
+		return less || equal
+	case token.GT:
+		return !less && !equal
+	}
+	return !less
+}
+
+// An arithmetic or bitwise operation on two values of the type t
+func arithmetic(op string, x Value, y Value, t ast.Declaration) (Value, error) {
+	a, b := x.Int, y.Int
+	unsigned := ast.IsUnsigned(t)
+
+	var n int64
+	overflow := false
+	switch op {
+	case token.PLUS:
+		n = a + b
+		overflow = (a >= 0) == (b >= 0) && (n >= 0) != (a >= 0)
+	case token.MINUS:
+		n = a - b
+		overflow = (a >= 0) != (b >= 0) && (n >= 0) != (a >= 0)
+	case token.ASTERISK:
+		n = a * b
+		overflow = a != 0 && (n/a != b || a == -1 && b == math.MinInt64)
+	case token.SLASH, token.MOD:
+		if b == 0 {
+			return Value{Type: t}, undefined("division by zero")
+		}
+		if unsigned {
+			if op == token.SLASH {
+				n = int64(uint64(a) / uint64(b))
+			} else {
+				n = int64(uint64(a) % uint64(b))
+			}
+		} else if op == token.SLASH {
+			n = a / b
+			overflow = a == math.MinInt64 && b == -1
+		} else {
+			n = a % b
+		}
+	case token.AMP:
+		n = a & b
+	case token.BITOR:
+		n = a | b
+	case token.BITXOR:
+		n = a ^ b
+	default:
+		return Value{}, notConstant()
+	}
+
+	// the operands fit in the type, so for types narrower than 64 bits the
+	// result is exact and overflows if it doesn't fit
+	wrapped := Wrap(n, t)
+	if unsigned {
+		overflow = false
+	} else if wrapped != n {
+		overflow = true
+	}
+	return Value{Int: wrapped, Type: t, Overflow: overflow}, nil
+}
+
+// A shift has the promoted type of its left operand, and is undefined if
+// the count is negative or not less than the width of that type
+func shift(op string, x Value, y Value) (Value, error) {
+	t := ast.Promote(x.Type)
+	x = Convert(x, t)
+	count := Convert(y, ast.Promote(y.Type)).Int
+	width := int64(ast.IntegerWidth(t))
+
+	if count < 0 && !ast.IsUnsigned(y.Type) {
+		return Value{Type: t}, undefined("shift count is negative")
+	} else if count < 0 || count >= width {
+		return Value{Type: t}, undefined("shift count >= width of type")
+	}
+
+	if op == token.RSHIFT {
+		if ast.IsUnsigned(t) {
+			return Value{Int: int64(uint64(x.Int) >> count), Type: t}, nil
+		}
+		return Value{Int: x.Int >> count, Type: t}, nil
+	}
+
+	n := Wrap(x.Int<<count, t)
+	overflow := !ast.IsUnsigned(t) && n>>count != x.Int
+	return Value{Int: n, Type: t, Overflow: overflow}, nil
+}
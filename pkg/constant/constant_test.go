@@ -0,0 +1,109 @@
+package constant_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/constant"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/parser"
+)
+
+func parse(t *testing.T, input string) ast.Expression {
+	p := parser.New(lexer.New("int main() { return " + input + "; }"))
+	tUnit := p.Parse()
+	for _, err := range p.Errors() {
+		t.Fatalf("parser error in %q: %s", input, err.String())
+	}
+
+	fn := tUnit.DeclarationStatements[0].Declarations[0].(*ast.FunctionDeclaration)
+	return fn.Body.Statements[0].(*ast.ReturnStatement).ReturnValue
+}
+
+var evaluator = &constant.Evaluator{
+	SizeOf: func(t ast.Declaration) (int64, bool) {
+		return int64(ast.IntegerWidth(t) / 8), ast.IsInteger(t)
+	},
+	Identifier: func(id *ast.Identifier) (constant.Value, bool) {
+		if id.Value == "RED" {
+			return constant.Value{Int: 5, Type: ast.IntType}, true
+		}
+		return constant.Value{}, false
+	},
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+		unsigned bool
+		overflow bool
+	}{
+		{"1 + 2 * 3", 7, false, false},
+		{"-2147483647 - 1", math.MinInt32, false, false},
+		{"2147483647 + 1", math.MinInt32, false, true},
+		{"4294967296 * 2", 1 << 33, false, false},
+		{"9223372036854775807 + 1", math.MinInt64, false, true},
+		{"~0", -1, false, false},
+		{"!5", 0, false, false},
+		{"-7 / 2", -3, false, false},
+		{"-7 % 2", -1, false, false},
+		{"(6 & 3) | (8 ^ 1)", 11, false, false},
+		{"1 << 31", math.MinInt32, false, true},
+		{"-1 >> 1", -1, false, false},
+		{"3 > 2 == 1", 1, false, false},
+		{"1 ? 2 : 3", 2, false, false},
+		{"0 ? 1 / 0 : 4", 4, false, false},
+		{"0 && 1 / 0", 0, false, false},
+		{"1 || 1 << 40", 1, false, false},
+		{"RED + 1", 6, false, false},
+		{"sizeof(int) * 2", 8, true, false},
+		{"sizeof(long) - 9", -1, true, false},
+		{"-1 < sizeof(int)", 0, false, false},
+		{"1 ? -1 : sizeof(int)", -1, true, false},
+	}
+
+	for _, tt := range tests {
+		v, err := evaluator.Eval(parse(t, tt.input))
+		if err != nil {
+			t.Errorf("%s: %s", tt.input, err)
+			continue
+		}
+		if v.Int != tt.expected || ast.IsUnsigned(v.Type) != tt.unsigned ||
+			v.Overflow != tt.overflow {
+			t.Errorf("%s: expected %d (unsigned %t, overflow %t), got %d of type '%s' (overflow %t)",
+				tt.input, tt.expected, tt.unsigned, tt.overflow,
+				v.Int, ast.TypeString(v.Type), v.Overflow)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		at        string
+		undefined bool
+	}{
+		{"2 + 1 / 0", "division by zero", "(1 / 0)", true},
+		{"1 << 32", "shift count >= width of type", "(1 << 32)", true},
+		{"1 >> -1", "shift count is negative", "(1 >> (-1))", true},
+		{"x + 1", "expression is not an integer constant expression", "x", false},
+		{"0 && x", "expression is not an integer constant expression", "x", false},
+		{"sizeof(double)", "expression is not an integer constant expression", "sizeof(double)", false},
+	}
+
+	for _, tt := range tests {
+		_, err := evaluator.Eval(parse(t, tt.input))
+		e, ok := err.(*constant.Error)
+		if !ok {
+			t.Errorf("%s: expected an error, got %v", tt.input, err)
+			continue
+		}
+		if e.Msg != tt.expected || e.Expr.String() != tt.at || e.Undefined != tt.undefined {
+			t.Errorf("%s: expected %q at %q (undefined %t), got %q at %q (undefined %t)",
+				tt.input, tt.expected, tt.at, tt.undefined, e.Msg, e.Expr, e.Undefined)
+		}
+	}
+}
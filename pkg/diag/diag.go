@@ -0,0 +1,83 @@
+// Package diag formats the errors and warnings of the compiler. Diagnostics
+// are printed like GCC and Clang do, with the offending source line, a caret
+// and the ranges that are involved, or as JSON or SARIF for other tools.
+package diag
+
+import (
+	"fmt"
+
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+	Note
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Warning:
+		return "warning"
+	case Note:
+		return "note"
+	}
+	return "error"
+}
+
+// A part of the source that the diagnostic is about, End is just past the
+// last character
+type Range struct {
+	Start token.Pos
+	End   token.Pos
+}
+
+// A suggested edit that fixes the problem, replacing the range with the
+// text. Start and End are the same for insertions.
+type FixIt struct {
+	Range
+	Replacement string
+}
+
+type Diagnostic struct {
+	Severity Severity
+	Pos      token.Pos // where the caret goes
+	Ranges   []Range   // underlined around the caret
+	Message  string
+	Flag     Flag          // the -W option that controls a warning, if any
+	Notes    []*Diagnostic // extra information, like a previous declaration
+	FixIts   []FixIt
+}
+
+// The diagnostic on one line, "file:line:col: error: msg"
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s%s", d.Pos, d.Severity, d.Message, d.option())
+}
+
+// The option that controls the diagnostic, shown after the message like
+// " [-Wshadow]", or " [-Werror=shadow]" if it was made an error
+func (d *Diagnostic) option() string {
+	switch {
+	case d.Flag == "":
+		return ""
+	case d.Severity == Error:
+		return fmt.Sprintf(" [-Werror=%s]", d.Flag)
+	}
+	return fmt.Sprintf(" [-W%s]", d.Flag)
+}
+
+func (d *Diagnostic) AddNote(pos token.Pos, msg string) {
+	d.Notes = append(d.Notes, &Diagnostic{Severity: Note, Pos: pos, Message: msg})
+}
+
+// Suggest inserting the text at pos
+func (d *Diagnostic) Insert(pos token.Pos, text string) {
+	d.FixIts = append(d.FixIts, FixIt{Range: Range{pos, pos}, Replacement: text})
+}
+
+// Suggest replacing the range with the text
+func (d *Diagnostic) Replace(r Range, text string) {
+	d.FixIts = append(d.FixIts, FixIt{Range: r, Replacement: text})
+}
@@ -0,0 +1,175 @@
+package diag
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+func pos(line int, col int) token.Pos {
+	return token.Pos{File: "t.c", Line: line, Column: col}
+}
+
+const source = "int main() {\n\treturn p * 2\n}\n"
+
+// An error with a range on each operand, a note and a fix-it
+func example() *Diagnostic {
+	d := &Diagnostic{
+		Severity: Error,
+		Pos:      pos(2, 11),
+		Ranges:   []Range{{pos(2, 9), pos(2, 10)}, {pos(2, 13), pos(2, 14)}},
+		Message:  "invalid operands to binary *",
+	}
+	d.AddNote(pos(1, 5), "in this function")
+	d.Insert(pos(2, 14), ";")
+	return d
+}
+
+func TestText(t *testing.T) {
+	var out bytes.Buffer
+	p := NewPrinter(&out)
+	p.AddSource("t.c", source)
+	p.Print(example())
+	p.Print(&Diagnostic{Severity: Warning, Pos: pos(9, 1), Message: "past the end"})
+	p.Print(&Diagnostic{Severity: Error, Message: "no input files"})
+
+	expected := strings.Join([]string{
+		"t.c:2:11: error: invalid operands to binary *",
+		"    2 | \treturn p * 2",
+		"      | \t       ~ ^ ~",
+		"      | \t            ;",
+		"t.c:1:5: note: in this function",
+		"    1 | int main() {",
+		"      |     ^",
+		"t.c:9:1: warning: past the end",
+		"cc: error: no input files",
+		"",
+	}, "\n")
+
+	if out.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestColor(t *testing.T) {
+	var out bytes.Buffer
+	p := NewPrinter(&out)
+	p.Color = true
+	p.Print(&Diagnostic{Severity: Warning, Pos: pos(1, 1), Message: "msg"})
+
+	expected := "\x1b[1mt.c:1:1:\x1b[0m \x1b[1;35mwarning:\x1b[0m msg\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var out bytes.Buffer
+	p := NewPrinter(&out)
+	p.Format = JSON
+	p.Print(example())
+	if out.Len() != 0 {
+		t.Fatalf("expected nothing before Flush, got %q", out.String())
+	}
+
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []jsonDiagnostic
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %s", out.String(), err)
+	}
+
+	if len(got) != 1 || got[0].Kind != "error" || len(got[0].Locations) != 3 ||
+		len(got[0].Children) != 1 || len(got[0].FixIts) != 1 {
+		t.Fatalf("unexpected diagnostics %+v", got)
+	}
+
+	if finish := got[0].Locations[1].Finish; finish == nil || finish.Column != 9 {
+		t.Errorf("expected the first range to finish at column 9, got %+v", finish)
+	} else if fix := got[0].FixIts[0]; fix.String != ";" || fix.Start.Column != 14 {
+		t.Errorf("unexpected fix-it %+v", fix)
+	}
+}
+
+func TestSARIF(t *testing.T) {
+	var out bytes.Buffer
+	p := NewPrinter(&out)
+	p.Format = SARIF
+	p.Print(example())
+	if err := p.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got sarifLog
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid SARIF %q: %s", out.String(), err)
+	}
+
+	if got.Version != "2.1.0" || len(got.Runs) != 1 || len(got.Runs[0].Results) != 1 {
+		t.Fatalf("unexpected log %+v", got)
+	}
+
+	result := got.Runs[0].Results[0]
+	region := result.Locations[0].PhysicalLocation.Region
+	if result.Level != "error" || region.StartLine != 2 || region.StartColumn != 11 {
+		t.Errorf("unexpected result %+v", result)
+	} else if len(result.RelatedLocations) != 1 || len(result.Fixes) != 1 {
+		t.Errorf("expected a related location and a fix, got %+v", result)
+	}
+}
+
+func TestWarningOptions(t *testing.T) {
+	tests := []struct {
+		options  []string
+		flag     Flag
+		expected string // the diagnostic after the options, "" if it is dropped
+	}{
+		{nil, ReturnType, "t.c:1:1: warning: w [-Wreturn-type]"},
+		{nil, UnusedVariable, ""},
+		{nil, "", "t.c:1:1: warning: w"},
+		{[]string{"-Wall"}, UnusedVariable, "t.c:1:1: warning: w [-Wunused-variable]"},
+		{[]string{"-Wall"}, SignCompare, ""},
+		{[]string{"-Wextra"}, SignCompare, "t.c:1:1: warning: w [-Wsign-compare]"},
+		{[]string{"-Wall", "-Wno-unused-variable"}, UnusedVariable, ""},
+		{[]string{"-Wno-return-type"}, ReturnType, ""},
+		{[]string{"-Wshadow"}, Shadow, "t.c:1:1: warning: w [-Wshadow]"},
+		{[]string{"-Werror"}, ReturnType, "t.c:1:1: error: w [-Werror=return-type]"},
+		{[]string{"-Werror"}, "", "t.c:1:1: error: w"},
+		{[]string{"-Werror", "-Wno-error"}, ReturnType, "t.c:1:1: warning: w [-Wreturn-type]"},
+		{[]string{"-Werror=shadow"}, Shadow, "t.c:1:1: error: w [-Werror=shadow]"},
+		{[]string{"-Werror=shadow"}, ReturnType, "t.c:1:1: warning: w [-Wreturn-type]"},
+		{[]string{"-Werror", "-Wno-error=shadow", "-Wshadow"}, Shadow, "t.c:1:1: warning: w [-Wshadow]"},
+		{[]string{"-w"}, ReturnType, ""},
+		{[]string{"-w"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		o := NewWarningOptions()
+		for _, option := range tt.options {
+			if err := o.Set(option); err != nil {
+				t.Fatalf("%v: %s", tt.options, err)
+			}
+		}
+
+		d := &Diagnostic{Severity: Warning, Pos: pos(1, 1), Message: "w", Flag: tt.flag}
+		actual := ""
+		if o.Apply(d) {
+			actual = d.String()
+		}
+
+		if actual != tt.expected {
+			t.Errorf("%v %q: expected %q, got %q", tt.options, tt.flag, tt.expected, actual)
+		}
+	}
+
+	for _, option := range []string{"-Wbogus", "-Wno-bogus", "-Werror=bogus"} {
+		if err := NewWarningOptions().Set(option); err == nil {
+			t.Errorf("%s: expected an error", option)
+		}
+	}
+}
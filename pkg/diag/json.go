@@ -0,0 +1,229 @@
+package diag
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+// The layout of GCC's -fdiagnostics-format=json, columns are 1 based and
+// "finish" is the last character of a range
+type jsonPosition struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type jsonLocation struct {
+	Caret  jsonPosition  `json:"caret"`
+	Finish *jsonPosition `json:"finish,omitempty"`
+}
+
+type jsonFixIt struct {
+	Start  jsonPosition `json:"start"`
+	Next   jsonPosition `json:"next"`
+	String string       `json:"string"`
+}
+
+type jsonDiagnostic struct {
+	Kind      string           `json:"kind"`
+	Message   string           `json:"message"`
+	Option    string           `json:"option,omitempty"`
+	Locations []jsonLocation   `json:"locations"`
+	Children  []jsonDiagnostic `json:"children"`
+	FixIts    []jsonFixIt      `json:"fixits,omitempty"`
+}
+
+func toJSONPosition(pos token.Pos) jsonPosition {
+	return jsonPosition{File: pos.File, Line: pos.Line, Column: pos.Column}
+}
+
+func toJSON(d *Diagnostic) jsonDiagnostic {
+	out := jsonDiagnostic{
+		Kind:      d.Severity.String(),
+		Message:   d.Message,
+		Locations: []jsonLocation{},
+		Children:  []jsonDiagnostic{},
+	}
+
+	if d.Flag != "" {
+		out.Option = "-W" + string(d.Flag)
+	}
+
+	if d.Pos.IsValid() {
+		out.Locations = append(out.Locations, jsonLocation{Caret: toJSONPosition(d.Pos)})
+	}
+
+	for _, r := range d.Ranges {
+		finish := toJSONPosition(r.End)
+		finish.Column--
+		out.Locations = append(out.Locations,
+			jsonLocation{Caret: toJSONPosition(r.Start), Finish: &finish})
+	}
+
+	for _, note := range d.Notes {
+		out.Children = append(out.Children, toJSON(note))
+	}
+
+	for _, fix := range d.FixIts {
+		out.FixIts = append(out.FixIts, jsonFixIt{Start: toJSONPosition(fix.Start),
+			Next: toJSONPosition(fix.End), String: fix.Replacement})
+	}
+
+	return out
+}
+
+func writeJSON(w io.Writer, diagnostics []*Diagnostic) error {
+	out := []jsonDiagnostic{}
+	for _, d := range diagnostics {
+		out = append(out, toJSON(d))
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(out)
+}
+
+// The parts of SARIF 2.1.0 that we need, regions use 1 based columns and
+// endColumn is just past the last character
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver struct {
+		Name string `json:"name"`
+	} `json:"driver"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId,omitempty"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	Fixes            []sarifFix      `json:"fixes,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	Message          *sarifMessage         `json:"message,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           sarifRegion   `json:"region"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine,omitempty"`
+	EndColumn   int `json:"endColumn,omitempty"`
+}
+
+type sarifFix struct {
+	ArtifactChanges []sarifArtifactChange `json:"artifactChanges"`
+}
+
+type sarifArtifactChange struct {
+	ArtifactLocation sarifArtifact      `json:"artifactLocation"`
+	Replacements     []sarifReplacement `json:"replacements"`
+}
+
+type sarifReplacement struct {
+	DeletedRegion   sarifRegion  `json:"deletedRegion"`
+	InsertedContent sarifMessage `json:"insertedContent"`
+}
+
+func toSARIFRegion(start token.Pos, end token.Pos) sarifRegion {
+	return sarifRegion{StartLine: start.Line, StartColumn: start.Column,
+		EndLine: end.Line, EndColumn: end.Column}
+}
+
+// The location of a diagnostic is its caret, or its first range if there is
+// one since SARIF can only highlight one region
+func toSARIFLocation(d *Diagnostic) sarifLocation {
+	region := sarifRegion{StartLine: d.Pos.Line, StartColumn: d.Pos.Column}
+	for _, r := range d.Ranges {
+		if r.contains(d.Pos.File, d.Pos.Line, d.Pos.Column) {
+			region = toSARIFRegion(r.Start, r.End)
+		}
+	}
+
+	return sarifLocation{PhysicalLocation: sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifact{URI: d.Pos.File},
+		Region:           region,
+	}}
+}
+
+func toSARIF(d *Diagnostic) sarifResult {
+	result := sarifResult{
+		Level:     d.Severity.String(),
+		Message:   sarifMessage{Text: d.Message},
+		Locations: []sarifLocation{},
+	}
+
+	if d.Flag != "" {
+		result.RuleID = "-W" + string(d.Flag)
+	}
+
+	if d.Pos.IsValid() {
+		result.Locations = append(result.Locations, toSARIFLocation(d))
+	}
+
+	for _, note := range d.Notes {
+		location := toSARIFLocation(note)
+		location.Message = &sarifMessage{Text: note.Message}
+		result.RelatedLocations = append(result.RelatedLocations, location)
+	}
+
+	for _, fix := range d.FixIts {
+		result.Fixes = append(result.Fixes, sarifFix{
+			ArtifactChanges: []sarifArtifactChange{{
+				ArtifactLocation: sarifArtifact{URI: fix.Start.File},
+				Replacements: []sarifReplacement{{
+					DeletedRegion:   toSARIFRegion(fix.Start, fix.End),
+					InsertedContent: sarifMessage{Text: fix.Replacement},
+				}},
+			}},
+		})
+	}
+
+	return result
+}
+
+func writeSARIF(w io.Writer, diagnostics []*Diagnostic) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "cc"
+	for _, d := range diagnostics {
+		run.Results = append(run.Results, toSARIF(d))
+	}
+
+	log := sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
@@ -0,0 +1,183 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+type Format string
+
+const (
+	Text  Format = "text"
+	JSON  Format = "json"
+	SARIF Format = "sarif"
+)
+
+// ANSI escape codes, the same colors that GCC uses
+const (
+	colorReset   = "\x1b[0m"
+	colorBold    = "\x1b[1m"
+	colorError   = "\x1b[1;31m"
+	colorWarning = "\x1b[1;35m"
+	colorNote    = "\x1b[1;36m"
+	colorCaret   = "\x1b[1;32m"
+	colorFixIt   = "\x1b[32m"
+)
+
+// Prints diagnostics as they are reported. JSON and SARIF need a single
+// document, so in those formats they are collected until Flush.
+type Printer struct {
+	Format Format
+	Color  bool
+
+	w       io.Writer
+	sources map[string][]string // lines of each file, for the snippets
+	pending []*Diagnostic
+}
+
+func NewPrinter(w io.Writer) *Printer {
+	return &Printer{Format: Text, w: w, sources: map[string][]string{}}
+}
+
+// Whether the file is a terminal, so that colors can be used on it
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Make the source of a file available for snippets
+func (p *Printer) AddSource(file string, src string) {
+	p.sources[file] = strings.Split(src, "\n")
+}
+
+func (p *Printer) Print(d *Diagnostic) {
+	if p.Format != Text {
+		p.pending = append(p.pending, d)
+		return
+	}
+
+	io.WriteString(p.w, p.text(d))
+}
+
+// Write out the diagnostics collected for JSON or SARIF
+func (p *Printer) Flush() error {
+	defer func() { p.pending = nil }()
+	switch p.Format {
+	case JSON:
+		return writeJSON(p.w, p.pending)
+	case SARIF:
+		return writeSARIF(p.w, p.pending)
+	}
+
+	return nil
+}
+
+func (p *Printer) paint(color string, s string) string {
+	if !p.Color || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func severityColor(s Severity) string {
+	switch s {
+	case Warning:
+		return colorWarning
+	case Note:
+		return colorNote
+	}
+	return colorError
+}
+
+func (p *Printer) text(d *Diagnostic) string {
+	var out strings.Builder
+	location := "cc"
+	if d.Pos.IsValid() {
+		location = d.Pos.String()
+	}
+
+	out.WriteString(fmt.Sprintf("%s %s %s%s\n", p.paint(colorBold, location+":"),
+		p.paint(severityColor(d.Severity), d.Severity.String()+":"), d.Message,
+		d.option()))
+	out.WriteString(p.snippet(d))
+
+	for _, note := range d.Notes {
+		out.WriteString(p.text(note))
+	}
+
+	return out.String()
+}
+
+// The source line of the diagnostic, with the caret and ranges under it and
+// then any fix-its, like
+//
+//	3 |   return p * 2;
+//	  |          ~ ^ ~
+func (p *Printer) snippet(d *Diagnostic) string {
+	lines := p.sources[d.Pos.File]
+	if !d.Pos.IsValid() || d.Pos.Line > len(lines) {
+		return ""
+	}
+
+	line := strings.TrimRight(lines[d.Pos.Line-1], "\r")
+	gutter := strings.Repeat(" ", 6) + "|"
+
+	// markers are padded with the tabs of the source line so they line up
+	pad := func(markers []byte) string {
+		for i := range markers {
+			if markers[i] == ' ' && i < len(line) && line[i] == '\t' {
+				markers[i] = '\t'
+			}
+		}
+		return string(markers)
+	}
+
+	markers := []byte(strings.Repeat(" ", max(len(line), d.Pos.Column)))
+	for _, r := range d.Ranges {
+		for col := 1; col <= len(markers); col++ {
+			if r.contains(d.Pos.File, d.Pos.Line, col) {
+				markers[col-1] = '~'
+			}
+		}
+	}
+	markers[d.Pos.Column-1] = '^'
+
+	var out strings.Builder
+	out.WriteString(fmt.Sprintf("%5d | %s\n", d.Pos.Line, line))
+	caretLine := strings.TrimRight(pad(markers), " \t")
+	out.WriteString(fmt.Sprintf("%s %s\n", gutter, p.paint(colorCaret, caretLine)))
+
+	for _, fix := range d.FixIts {
+		start := fix.Start
+		if start.File != d.Pos.File || start.Line != d.Pos.Line {
+			continue
+		}
+
+		fixLine := []byte(strings.Repeat(" ", start.Column-1))
+		out.WriteString(fmt.Sprintf("%s %s%s\n", gutter, pad(fixLine),
+			p.paint(colorFixIt, fix.Replacement)))
+	}
+
+	return out.String()
+}
+
+// Whether the character at line:col of the file is in the range
+func (r Range) contains(file string, line int, col int) bool {
+	pos := token.Pos{File: file, Line: line, Column: col}
+	return r.Start.File == file && !before(pos, r.Start) && before(pos, r.End)
+}
+
+func before(a token.Pos, b token.Pos) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+}
+
+func max(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,144 @@
+package diag
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A named group of warnings that can be turned on and off with -W options,
+// the names are the ones GCC uses
+type Flag string
+
+const (
+	UnusedVariable              Flag = "unused-variable"
+	UnusedParameter             Flag = "unused-parameter"
+	SignCompare                 Flag = "sign-compare"
+	ImplicitConversion          Flag = "implicit-conversion"
+	ReturnType                  Flag = "return-type"
+	Shadow                      Flag = "shadow"
+	IntConversion               Flag = "int-conversion"
+	IncompatiblePointerTypes    Flag = "incompatible-pointer-types"
+	DiscardedQualifiers         Flag = "discarded-qualifiers"
+	CompareDistinctPointerTypes Flag = "compare-distinct-pointer-types"
+	Varargs                     Flag = "varargs"
+	Overflow                    Flag = "overflow"
+	DivByZero                   Flag = "div-by-zero"
+	ShiftCountNegative          Flag = "shift-count-negative"
+	ShiftCountOverflow          Flag = "shift-count-overflow"
+	UnreachableCode             Flag = "unreachable-code"
+)
+
+// Every flag along with whether it is on without any options
+var flags = map[Flag]bool{
+	UnusedVariable:              false,
+	UnusedParameter:             false,
+	SignCompare:                 false,
+	ImplicitConversion:          false,
+	ReturnType:                  true,
+	Shadow:                      false,
+	IntConversion:               true,
+	IncompatiblePointerTypes:    true,
+	DiscardedQualifiers:         true,
+	CompareDistinctPointerTypes: true,
+	Varargs:                     true,
+	Overflow:                    true,
+	DivByZero:                   true,
+	ShiftCountNegative:          true,
+	ShiftCountOverflow:          true,
+	UnreachableCode:             false,
+}
+
+// The flags that -Wall and -Wextra turn on
+var groups = map[string][]Flag{
+	"all":   {UnusedVariable, ReturnType, UnreachableCode},
+	"extra": {SignCompare, UnusedParameter},
+}
+
+// Which warnings are reported and which of them are errors. Warnings without
+// a flag are always reported, unless all warnings are turned off.
+type WarningOptions struct {
+	enabled   map[Flag]bool
+	errors    map[Flag]bool // set by -Werror=X and -Wno-error=X
+	allErrors bool          // -Werror
+	none      bool          // -w
+}
+
+func NewWarningOptions() *WarningOptions {
+	o := &WarningOptions{enabled: map[Flag]bool{}, errors: map[Flag]bool{}}
+	for flag, on := range flags {
+		o.enabled[flag] = on
+	}
+	return o
+}
+
+// Whether the argument is a warning option, to pick them out of the command
+// line before the other flags are parsed
+func IsWarningOption(arg string) bool {
+	return strings.HasPrefix(arg, "-W") || arg == "-w"
+}
+
+// Apply a warning option like -Wall, -Wno-shadow, -Werror or
+// -Werror=sign-compare
+func (o *WarningOptions) Set(option string) error {
+	if option == "-w" {
+		o.none = true
+		return nil
+	} else if option == "-Werror" || option == "-Wno-error" {
+		o.allErrors = option == "-Werror"
+		return nil
+	}
+
+	name := strings.TrimPrefix(option, "-W")
+	on := !strings.HasPrefix(name, "no-")
+	name = strings.TrimPrefix(name, "no-")
+
+	if flag, ok := strings.CutPrefix(name, "error="); ok {
+		if _, ok := flags[Flag(flag)]; !ok {
+			return fmt.Errorf("-Werror=%s: no option -W%s", flag, flag)
+		}
+
+		// -Werror=X also turns X on, -Wno-error=X leaves it alone
+		o.errors[Flag(flag)] = on
+		if on {
+			o.enabled[Flag(flag)] = true
+		}
+		return nil
+	}
+
+	if group, ok := groups[name]; ok {
+		for _, flag := range group {
+			o.enabled[flag] = on
+		}
+		return nil
+	} else if _, ok := flags[Flag(name)]; ok {
+		o.enabled[Flag(name)] = on
+		return nil
+	}
+
+	return fmt.Errorf("unrecognized command-line option '%s'", option)
+}
+
+func (o *WarningOptions) Enabled(flag Flag) bool {
+	return !o.none && (flag == "" || o.enabled[flag])
+}
+
+// Decide what happens to a diagnostic, returns false if it is a warning that
+// is turned off and promotes it to an error if it should be one
+func (o *WarningOptions) Apply(d *Diagnostic) bool {
+	if d.Severity != Warning {
+		return true
+	} else if !o.Enabled(d.Flag) {
+		return false
+	}
+
+	// -Wno-error=X keeps X a warning even with -Werror
+	isError, ok := o.errors[d.Flag]
+	if !ok {
+		isError = o.allErrors
+	}
+
+	if isError {
+		d.Severity = Error
+	}
+	return true
+}
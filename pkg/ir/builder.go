@@ -0,0 +1,140 @@
+package ir
+
+// A Builder appends instructions to the end of the current block of a
+// function. Instructions that follow a terminator can never run, they go to
+// a new block that nothing branches to.
+type Builder struct {
+	Function *Function
+	Block    *Block
+	Scope    *Scope // where the stack slots that are created are declared
+}
+
+func NewBuilder(f *Function) *Builder {
+	return &Builder{Function: f, Block: f.Entry()}
+}
+
+// Add the block to the end of the function and continue there
+func (b *Builder) StartBlock(block *Block) {
+	b.Function.AddBlock(block)
+	b.Block = block
+}
+
+// Whether the current block already ends in a terminator
+func (b *Builder) Terminated() bool { return b.Block.Terminator() != nil }
+
+func (b *Builder) emit(instr *Instruction) *Instruction {
+	if b.Terminated() {
+		b.StartBlock(b.Function.NewBlock())
+	}
+	b.Block.Instructions = append(b.Block.Instructions, instr)
+	return instr
+}
+
+func (b *Builder) result(op Op, t Type, args ...Value) *Register {
+	dest := b.Function.NewRegister(t)
+	b.emit(&Instruction{Op: op, Dest: dest, Args: args})
+	return dest
+}
+
+// x op y for a binary operation
+func (b *Builder) Binary(op Op, x, y Value) *Register {
+	return b.result(op, x.Type(), x, y)
+}
+
+// op x for neg and not
+func (b *Builder) Unary(op Op, x Value) *Register {
+	return b.result(op, x.Type(), x)
+}
+
+func (b *Builder) Compare(op Op, x, y Value) *Register {
+	return b.result(op, I32, x, y)
+}
+
+// Convert x to type t with a conversion op
+func (b *Builder) Convert(op Op, x Value, t Type) *Register {
+	return b.result(op, t, x)
+}
+
+func (b *Builder) Copy(x Value) *Register {
+	return b.result(Copy, x.Type(), x)
+}
+
+// Reserve size bytes in the stack frame. Stack slots are created in the
+// entry block, so that their addresses are known everywhere in the function.
+func (b *Builder) Alloca(size, align int64) *Register {
+	dest := b.Function.NewRegister(Ptr)
+	alloca := &Instruction{Op: Alloca, Dest: dest, Size: size, Align: align,
+		Scope: b.Scope}
+
+	entry := b.Function.Entry()
+	i := 0
+	for i < len(entry.Instructions) && entry.Instructions[i].Op == Alloca {
+		i++
+	}
+	entry.Instructions = append(entry.Instructions[:i],
+		append([]*Instruction{alloca}, entry.Instructions[i:]...)...)
+	return dest
+}
+
+func (b *Builder) Load(t Type, p Value) *Register {
+	return b.result(Load, t, p)
+}
+
+func (b *Builder) Store(v, p Value) {
+	b.emit(&Instruction{Op: Store, Args: []Value{v, p}})
+}
+
+// Call the function that callee points to. The result is nil if ret is
+// Void.
+func (b *Builder) Call(ret Type, callee Value, args []Value, variadic bool) *Register {
+	call := &Instruction{Op: Call, Args: append([]Value{callee}, args...),
+		Variadic: variadic}
+	if ret != Void {
+		call.Dest = b.Function.NewRegister(ret)
+	}
+	b.emit(call)
+	return call.Dest
+}
+
+func (b *Builder) DynAlloca(size Value, align int64) *Register {
+	dest := b.Function.NewRegister(Ptr)
+	b.emit(&Instruction{Op: DynAlloca, Dest: dest, Args: []Value{size}, Align: align})
+	return dest
+}
+
+func (b *Builder) StackSave() *Register {
+	return b.result(StackSave, Ptr)
+}
+
+func (b *Builder) StackRestore(p Value) {
+	b.emit(&Instruction{Op: StackRestore, Args: []Value{p}})
+}
+
+func (b *Builder) VaStart(ap Value) {
+	b.emit(&Instruction{Op: VaStart, Args: []Value{ap}})
+}
+
+func (b *Builder) VaArg(t Type, ap Value) *Register {
+	return b.result(VaArg, t, ap)
+}
+
+func (b *Builder) VaCopy(dest, src Value) {
+	b.emit(&Instruction{Op: VaCopy, Args: []Value{dest, src}})
+}
+
+func (b *Builder) Jmp(target *Block) {
+	b.emit(&Instruction{Op: Jmp, Blocks: []*Block{target}})
+}
+
+func (b *Builder) Br(cond Value, then, els *Block) {
+	b.emit(&Instruction{Op: Br, Args: []Value{cond}, Blocks: []*Block{then, els}})
+}
+
+// Return v, or nothing if v is nil
+func (b *Builder) Ret(v Value) {
+	ret := &Instruction{Op: Ret}
+	if v != nil {
+		ret.Args = []Value{v}
+	}
+	b.emit(ret)
+}
@@ -0,0 +1,202 @@
+package ir
+
+// The blocks that can be reached from the entry, in reverse postorder. Every
+// block comes before its successors, except along the back edges of loops.
+func (f *Function) ReversePostorder() []*Block {
+	visited := map[*Block]bool{}
+	postorder := []*Block{}
+
+	var visit func(b *Block)
+	visit = func(b *Block) {
+		visited[b] = true
+		for _, succ := range b.Successors() {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		postorder = append(postorder, b)
+	}
+	visit(f.Entry())
+
+	for i, j := 0, len(postorder)-1; i < j; i, j = i+1, j-1 {
+		postorder[i], postorder[j] = postorder[j], postorder[i]
+	}
+	return postorder
+}
+
+// A DomTree records which blocks dominate which. Block a dominates block b if
+// every path from the entry to b goes through a. Blocks that can't be
+// reached from the entry are not part of the tree.
+type DomTree struct {
+	order    []*Block
+	idom     map[*Block]*Block
+	children map[*Block][]*Block
+	preds    map[*Block][]*Block
+
+	// numbering of a depth first walk over the tree, a dominates b if b's
+	// interval is inside a's
+	enter, exit map[*Block]int
+}
+
+// Compute the dominator tree with the iterative algorithm of Cooper, Harvey
+// and Kennedy
+func (f *Function) Dominators() *DomTree {
+	d := &DomTree{
+		order:    f.ReversePostorder(),
+		idom:     map[*Block]*Block{},
+		children: map[*Block][]*Block{},
+		preds:    f.Predecessors(),
+		enter:    map[*Block]int{},
+		exit:     map[*Block]int{},
+	}
+
+	index := map[*Block]int{}
+	for i, b := range d.order {
+		index[b] = i
+	}
+
+	intersect := func(a, b *Block) *Block {
+		for a != b {
+			for index[a] > index[b] {
+				a = d.idom[a]
+			}
+			for index[b] > index[a] {
+				b = d.idom[b]
+			}
+		}
+		return a
+	}
+
+	entry := f.Entry()
+	d.idom[entry] = entry
+	for changed := true; changed; {
+		changed = false
+		for _, b := range d.order[1:] {
+			var idom *Block
+			for _, p := range d.preds[b] {
+				if d.idom[p] == nil {
+					continue
+				} else if idom == nil {
+					idom = p
+				} else {
+					idom = intersect(p, idom)
+				}
+			}
+			if d.idom[b] != idom {
+				d.idom[b] = idom
+				changed = true
+			}
+		}
+	}
+
+	for _, b := range d.order[1:] {
+		d.children[d.idom[b]] = append(d.children[d.idom[b]], b)
+	}
+
+	n := 0
+	var number func(b *Block)
+	number = func(b *Block) {
+		d.enter[b] = n
+		n++
+		for _, child := range d.children[b] {
+			number(child)
+		}
+		d.exit[b] = n
+	}
+	number(entry)
+
+	return d
+}
+
+// The reachable blocks in reverse postorder
+func (d *DomTree) Blocks() []*Block { return d.order }
+
+func (d *DomTree) Reachable(b *Block) bool {
+	_, ok := d.idom[b]
+	return ok
+}
+
+// The immediate dominator of b, which is nil for the entry
+func (d *DomTree) Idom(b *Block) *Block {
+	if idom := d.idom[b]; idom != b {
+		return idom
+	}
+	return nil
+}
+
+// The blocks that b immediately dominates
+func (d *DomTree) Children(b *Block) []*Block { return d.children[b] }
+
+// Whether a dominates b, every block dominates itself
+func (d *DomTree) Dominates(a, b *Block) bool {
+	if !d.Reachable(a) || !d.Reachable(b) {
+		return false
+	}
+	return d.enter[a] <= d.enter[b] && d.exit[b] <= d.exit[a]
+}
+
+// The dominance frontier of every block: the blocks where its dominance
+// ends, which are where values defined in it meet other definitions
+func (d *DomTree) Frontiers() map[*Block][]*Block {
+	frontiers := map[*Block][]*Block{}
+	for _, b := range d.order {
+		if len(d.preds[b]) < 2 {
+			continue
+		}
+
+		for _, p := range d.preds[b] {
+			for runner := p; d.Reachable(runner) && runner != d.idom[b]; runner = d.idom[runner] {
+				if !contains(frontiers[runner], b) {
+					frontiers[runner] = append(frontiers[runner], b)
+				}
+			}
+		}
+	}
+	return frontiers
+}
+
+func contains(blocks []*Block, b *Block) bool {
+	for _, x := range blocks {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the blocks that can't be reached from the entry, and report
+// whether there were any
+func (f *Function) RemoveUnreachable() bool {
+	reachable := map[*Block]bool{}
+	for _, b := range f.ReversePostorder() {
+		reachable[b] = true
+	}
+	if len(reachable) == len(f.Blocks) {
+		return false
+	}
+
+	blocks := []*Block{}
+	for _, b := range f.Blocks {
+		if reachable[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	f.Blocks = blocks
+
+	// phis lose the arguments for the removed predecessors
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op != Phi {
+				continue
+			}
+			args, from := []Value{}, []*Block{}
+			for i, pred := range instr.Blocks {
+				if reachable[pred] {
+					args, from = append(args, instr.Args[i]), append(from, pred)
+				}
+			}
+			instr.Args, instr.Blocks = args, from
+		}
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package ir_test
+
+import (
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// L1 -> L2 -> L3 -> L2, L2 -> L4, and an unreachable L5 -> L4
+func buildLoop() *ir.Function {
+	f := ir.NewFunction("loop", ir.Void, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	cond, body, end, dead := f.NewBlock(), f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Jmp(cond)
+
+	b.StartBlock(cond)
+	b.Br(f.Params[0], body, end)
+
+	b.StartBlock(body)
+	b.Jmp(cond)
+
+	b.StartBlock(end)
+	b.Ret(nil)
+
+	b.StartBlock(dead)
+	b.Jmp(end)
+	return f
+}
+
+func names(blocks []*ir.Block) []string {
+	s := []string{}
+	for _, b := range blocks {
+		s = append(s, b.String())
+	}
+	return s
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDominators(t *testing.T) {
+	tests := []struct {
+		f         *ir.Function
+		idoms     map[int]int // block ID to the ID of its immediate dominator
+		frontiers map[int][]string
+	}{
+		{
+			buildMax(),
+			map[int]int{1: 0, 2: 1, 3: 1, 4: 1},
+			map[int][]string{2: {"L4"}, 3: {"L4"}},
+		},
+		{
+			buildLoop(),
+			map[int]int{1: 0, 2: 1, 3: 2, 4: 2},
+			map[int][]string{2: {"L2"}, 3: {"L2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		d := tt.f.Dominators()
+		for _, b := range tt.f.Blocks {
+			want, ok := tt.idoms[b.ID]
+			if !ok {
+				if d.Reachable(b) {
+					t.Errorf("%s: expected %s to be unreachable", tt.f.Name, b)
+				}
+				continue
+			}
+
+			got := 0
+			if idom := d.Idom(b); idom != nil {
+				got = idom.ID
+			}
+			if got != want {
+				t.Errorf("%s: expected the idom of %s to be L%d, got L%d",
+					tt.f.Name, b, want, got)
+			}
+			if !d.Dominates(tt.f.Entry(), b) || !d.Dominates(b, b) {
+				t.Errorf("%s: %s should be dominated by the entry and itself",
+					tt.f.Name, b)
+			}
+		}
+
+		frontiers := d.Frontiers()
+		for _, b := range d.Blocks() {
+			if got := names(frontiers[b]); !equal(got, tt.frontiers[b.ID]) {
+				t.Errorf("%s: expected the frontier of %s to be %v, got %v",
+					tt.f.Name, b, tt.frontiers[b.ID], got)
+			}
+		}
+	}
+}
+
+func TestRemoveUnreachable(t *testing.T) {
+	f := buildLoop()
+	end := f.Blocks[3]
+	phi := &ir.Instruction{Op: ir.Phi, Dest: f.NewRegister(ir.I32),
+		Args:   []ir.Value{&ir.Const{Value: 1, T: ir.I32}, &ir.Const{Value: 2, T: ir.I32}},
+		Blocks: []*ir.Block{f.Blocks[1], f.Blocks[4]}}
+	end.Instructions = append([]*ir.Instruction{phi}, end.Instructions...)
+
+	if !f.RemoveUnreachable() {
+		t.Fatalf("expected L5 to be removed")
+	}
+	if got := names(f.Blocks); !equal(got, []string{"L1", "L2", "L3", "L4"}) {
+		t.Errorf("expected the blocks L1 to L4, got %v", got)
+	}
+	if len(phi.Args) != 1 || phi.Blocks[0] != f.Blocks[1] {
+		t.Errorf("expected the phi to only come from L2, got '%s'", phi)
+	}
+	if err := f.Verify(); err != nil {
+		t.Errorf("expected the function to verify, got %s", err)
+	}
+	if f.RemoveUnreachable() {
+		t.Errorf("expected nothing else to be removed")
+	}
+}
@@ -0,0 +1,384 @@
+// Package ir is the target independent intermediate representation that the
+// AST is lowered into before machine code is generated. It is a three-address
+// code: a function is a list of basic blocks, each of which is a sequence of
+// instructions ending in a single terminator that transfers control. The
+// instructions operate on constants and on typed virtual registers, of which
+// there are as many as needed.
+//
+// Local variables live in stack slots created by alloca, and are accessed
+// through load and store.
+package ir
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// The type of a value. Integers don't carry a sign, the operations that
+// care about it, like division and comparisons, come in signed and unsigned
+// versions.
+type Type int
+
+const (
+	Void Type = iota
+	I8
+	I16
+	I32
+	I64
+	Ptr
+)
+
+var typeNames = map[Type]string{
+	Void: "void",
+	I8:   "i8",
+	I16:  "i16",
+	I32:  "i32",
+	I64:  "i64",
+	Ptr:  "ptr",
+}
+
+func (t Type) String() string { return typeNames[t] }
+
+// Size in bytes, pointers are 64 bits
+func (t Type) Size() int64 {
+	switch t {
+	case I8:
+		return 1
+	case I16:
+		return 2
+	case I32:
+		return 4
+	case I64, Ptr:
+		return 8
+	}
+	return 0
+}
+
+func (t Type) IsInteger() bool { return t >= I8 && t <= I64 }
+
+// The integer type with the given size in bytes
+func IntType(size int64) Type {
+	switch size {
+	case 1:
+		return I8
+	case 2:
+		return I16
+	case 4:
+		return I32
+	case 8:
+		return I64
+	}
+	return Void
+}
+
+// A Value is an operand of an instruction
+type Value interface {
+	Type() Type
+	String() string
+}
+
+// A virtual register, which is assigned by a single instruction or is a
+// parameter of the function
+type Register struct {
+	ID int
+	T  Type
+}
+
+func (r *Register) Type() Type     { return r.T }
+func (r *Register) String() string { return fmt.Sprintf("%%%d", r.ID) }
+
+type Const struct {
+	Value int64
+	T     Type
+}
+
+func (c *Const) Type() Type     { return c.T }
+func (c *Const) String() string { return strconv.FormatInt(c.Value, 10) }
+
+// The address of a function, or of data like a string literal
+type Global struct {
+	Name   string
+	Extern bool // may be defined in another object file
+}
+
+func (g *Global) Type() Type     { return Ptr }
+func (g *Global) String() string { return "@" + g.Name }
+
+type Op string
+
+const (
+	// Dest = Args[0] op Args[1], the operands and the result have the same
+	// type, except that add and sub can also offset a pointer by an i64
+	Add  Op = "add"
+	Sub  Op = "sub"
+	Mul  Op = "mul"
+	SDiv Op = "sdiv"
+	UDiv Op = "udiv"
+	SRem Op = "srem"
+	URem Op = "urem"
+	And  Op = "and"
+	Or   Op = "or"
+	Xor  Op = "xor"
+	Shl  Op = "shl"
+	LShr Op = "lshr" // logical shift, fills with zeros
+	AShr Op = "ashr" // arithmetic shift, fills with the sign bit
+
+	// Dest = op Args[0]
+	Neg Op = "neg"
+	Not Op = "not" // bitwise complement
+
+	// Dest is an i32 that is 1 if the comparison of Args[0] and Args[1] holds
+	// and 0 otherwise
+	Eq  Op = "eq"
+	Ne  Op = "ne"
+	SLt Op = "slt"
+	SLe Op = "sle"
+	SGt Op = "sgt"
+	SGe Op = "sge"
+	ULt Op = "ult"
+	ULe Op = "ule"
+	UGt Op = "ugt"
+	UGe Op = "uge"
+
+	// Dest = Args[0] converted to the type of Dest
+	SExt     Op = "sext"
+	ZExt     Op = "zext"
+	Trunc    Op = "trunc"
+	PtrToInt Op = "ptrtoint"
+	IntToPtr Op = "inttoptr"
+	Copy     Op = "copy"
+
+	// Dest = the address of Size bytes in the stack frame aligned to Align
+	Alloca Op = "alloca"
+	// Dest = the value that Args[0] points to
+	Load Op = "load"
+	// Store Args[0] where Args[1] points
+	Store Op = "store"
+
+	// Dest = Args[0](Args[1:]...), Dest is nil for functions returning void
+	Call Op = "call"
+
+	// Variable length arrays are allocated below the stack frame. Dest =
+	// the address of Args[0] bytes aligned to Align.
+	DynAlloca    Op = "dynalloca"
+	StackSave    Op = "stacksave"    // Dest = the top of the stack
+	StackRestore Op = "stackrestore" // free everything allocated after Args[0] was saved
+
+	// Access to the unnamed arguments of a variadic function, Args[0] points
+	// to a va_list
+	VaStart Op = "vastart" // point the va_list at the first unnamed argument
+	VaArg   Op = "vaarg"   // Dest = the next unnamed argument
+	VaCopy  Op = "vacopy"  // copy the va_list Args[1] points to into Args[0]
+
+	// Dest = the argument for the block that control came from, Args[i] is
+	// for Blocks[i]
+	Phi Op = "phi"
+
+	// Terminators
+	Jmp Op = "jmp" // go to Blocks[0]
+	Br  Op = "br"  // go to Blocks[0] if Args[0] isn't 0, otherwise Blocks[1]
+	Ret Op = "ret" // return Args[0] if there is one
+)
+
+var comparisons = map[Op]bool{
+	Eq: true, Ne: true, SLt: true, SLe: true, SGt: true, SGe: true,
+	ULt: true, ULe: true, UGt: true, UGe: true,
+}
+
+var binaryOps = map[Op]bool{
+	Add: true, Sub: true, Mul: true, SDiv: true, UDiv: true, SRem: true,
+	URem: true, And: true, Or: true, Xor: true, Shl: true, LShr: true, AShr: true,
+}
+
+var conversions = map[Op]bool{
+	SExt: true, ZExt: true, Trunc: true, PtrToInt: true, IntToPtr: true,
+}
+
+func (op Op) IsBinary() bool     { return binaryOps[op] }
+func (op Op) IsComparison() bool { return comparisons[op] }
+func (op Op) IsConversion() bool { return conversions[op] }
+func (op Op) IsTerminator() bool { return op == Jmp || op == Br || op == Ret }
+
+// Whether the instruction does anything besides computing Dest, so that it
+// has to be kept even if Dest is never used
+func (op Op) HasSideEffects() bool {
+	switch op {
+	case Store, Call, DynAlloca, StackRestore, VaStart, VaArg, VaCopy, Jmp, Br, Ret:
+		return true
+	}
+	return false
+}
+
+type Instruction struct {
+	Op       Op
+	Dest     *Register // the result, nil if there is none
+	Args     []Value
+	Blocks   []*Block // the targets of a branch, or where the arguments of a phi come from
+	Size     int64    // bytes reserved by alloca
+	Align    int64    // alignment of alloca and dynalloca
+	Variadic bool     // a call of a function taking a variable number of arguments
+	Tail     bool     // a call whose result is returned right after it, see TailCall
+	Scope    *Scope   // the block an alloca was declared in, nil if it isn't known
+}
+
+// A block of the source. The objects declared in blocks that don't contain
+// one another are never alive at the same time, so they can share their
+// place in the stack frame.
+type Scope struct {
+	ID     int
+	Parent *Scope // the enclosing block, nil for the outermost one
+}
+
+// Whether t is s or a block inside of it
+func (s *Scope) Contains(t *Scope) bool {
+	for ; t != nil; t = t.Parent {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the scopes are different branches of the same function, scopes
+// that came from another function, like the ones of an inlined call, are
+// not known to be disjoint
+func (s *Scope) Disjoint(t *Scope) bool {
+	if s == nil || t == nil || s.Contains(t) || t.Contains(s) {
+		return false
+	}
+	return s.root() == t.root()
+}
+
+func (s *Scope) root() *Scope {
+	for s.Parent != nil {
+		s = s.Parent
+	}
+	return s
+}
+
+// A basic block, control can only enter at the start and leave at the end
+type Block struct {
+	ID           int
+	Instructions []*Instruction
+}
+
+func (b *Block) String() string { return fmt.Sprintf("L%d", b.ID) }
+
+// The last instruction of the block if it is a terminator, otherwise nil
+func (b *Block) Terminator() *Instruction {
+	if n := len(b.Instructions); n > 0 && b.Instructions[n-1].Op.IsTerminator() {
+		return b.Instructions[n-1]
+	}
+	return nil
+}
+
+// The blocks that control can go to from the end of this one
+func (b *Block) Successors() []*Block {
+	if term := b.Terminator(); term != nil {
+		return term.Blocks
+	}
+	return nil
+}
+
+type Function struct {
+	Name     string
+	Params   []*Register
+	Return   Type
+	Variadic bool
+	Static   bool     // not visible outside of its object file
+	Inline   bool     // declared inline, a hint that calls should be inlined
+	Blocks   []*Block // in the order they are laid out, starting with the entry
+
+	// Set once phis are replaced by copies, after which a register can be
+	// assigned in more than one place
+	OutOfSSA bool
+
+	registers int
+	blocks    int
+	scopes    int
+}
+
+// Create a function with an empty entry block
+func NewFunction(name string, ret Type, params []Type, variadic bool) *Function {
+	f := &Function{Name: name, Return: ret, Variadic: variadic}
+	for _, t := range params {
+		f.Params = append(f.Params, f.NewRegister(t))
+	}
+	f.AddBlock(f.NewBlock())
+	return f
+}
+
+func (f *Function) Entry() *Block { return f.Blocks[0] }
+
+func (f *Function) NewRegister(t Type) *Register {
+	f.registers++
+	return &Register{ID: f.registers, T: t}
+}
+
+// NewBlock creates a block, which becomes part of the function once it is
+// passed to AddBlock
+func (f *Function) NewBlock() *Block {
+	f.blocks++
+	return &Block{ID: f.blocks}
+}
+
+// NewScope creates a block scope inside of parent, or an outermost one if
+// parent is nil
+func (f *Function) NewScope(parent *Scope) *Scope {
+	f.scopes++
+	return &Scope{ID: f.scopes, Parent: parent}
+}
+
+func (f *Function) AddBlock(b *Block) {
+	f.Blocks = append(f.Blocks, b)
+}
+
+// The blocks that control can come from for every block of the function
+func (f *Function) Predecessors() map[*Block][]*Block {
+	preds := map[*Block][]*Block{}
+	for _, b := range f.Blocks {
+		for _, succ := range b.Successors() {
+			preds[succ] = append(preds[succ], b)
+		}
+	}
+	return preds
+}
+
+type Module struct {
+	Functions []*Function
+	Strings   []*String
+}
+
+// A string literal, which is stored with a terminating null byte
+type String struct {
+	Name  string
+	Value string
+}
+
+// Add a string literal to the module and return its address
+func (m *Module) AddString(value string) *Global {
+	s := &String{Name: fmt.Sprintf("str.%d", len(m.Strings)), Value: value}
+	m.Strings = append(m.Strings, s)
+	return &Global{Name: s.Name}
+}
+
+func (m *Module) Function(name string) *Function {
+	for _, f := range m.Functions {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// Whether the call is in tail position: the last instruction of the block
+// before a return of its result, or of nothing. The caller has nothing left
+// to do once it returns, which lets it jump to the callee instead.
+func TailCall(b *Block, call *Instruction) bool {
+	n := len(b.Instructions)
+	if n < 2 || b.Instructions[n-2] != call || b.Instructions[n-1].Op != Ret {
+		return false
+	}
+	ret := b.Instructions[n-1]
+	return len(ret.Args) == 0 || call.Dest != nil && ret.Args[0] == call.Dest
+}
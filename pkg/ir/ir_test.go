@@ -0,0 +1,242 @@
+package ir_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// int max(int a, int b) { return a > b ? a : b; }
+func buildMax() *ir.Function {
+	f := ir.NewFunction("max", ir.I32, []ir.Type{ir.I32, ir.I32}, false)
+	b := ir.NewBuilder(f)
+	a, c := f.Params[0], f.Params[1]
+
+	result := b.Alloca(4, 4)
+	then, els, end := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Br(b.Compare(ir.SGt, a, c), then, els)
+
+	b.StartBlock(then)
+	b.Store(a, result)
+	b.Jmp(end)
+
+	b.StartBlock(els)
+	b.Store(c, result)
+	b.Jmp(end)
+
+	b.StartBlock(end)
+	b.Ret(b.Load(ir.I32, result))
+	return f
+}
+
+func TestString(t *testing.T) {
+	m := &ir.Module{}
+	hello := m.AddString("hi\n")
+	m.Functions = append(m.Functions, buildMax())
+
+	f := ir.NewFunction("main", ir.I32, nil, false)
+	b := ir.NewBuilder(f)
+	n := b.Call(ir.I32, &ir.Global{Name: "printf", Extern: true},
+		[]ir.Value{hello}, true)
+	wide := b.Convert(ir.SExt, n, ir.I64)
+	b.Call(ir.Void, &ir.Global{Name: "exit"}, []ir.Value{wide}, false)
+	b.Ret(&ir.Const{Value: 0, T: ir.I32})
+	m.Functions = append(m.Functions, f)
+
+	expected := `@str.0 = "hi\n"
+
+function i32 @max(i32 %1, i32 %2) {
+L1:
+	%3 = alloca 4, align 4
+	%4 = sgt i32 %1, %2
+	br %4, L2, L3
+L2:
+	store i32 %1, %3
+	jmp L4
+L3:
+	store i32 %2, %3
+	jmp L4
+L4:
+	%5 = load i32 %3
+	ret i32 %5
+}
+
+function i32 @main() {
+L1:
+	%1 = call i32 @printf(ptr @str.0, ...)
+	%2 = sext i32 %1 to i64
+	call void @exit(i64 %2)
+	ret i32 0
+}
+`
+	if actual := m.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+
+	for _, f := range m.Functions {
+		if err := f.Verify(); err != nil {
+			t.Errorf("%s does not verify: %s", f.Name, err)
+		}
+	}
+}
+
+func TestBuilderAfterTerminator(t *testing.T) {
+	f := ir.NewFunction("f", ir.Void, nil, false)
+	b := ir.NewBuilder(f)
+	b.Ret(nil)
+	b.StackSave()
+	b.Ret(nil)
+
+	if len(f.Blocks) != 2 || len(f.Blocks[1].Instructions) != 2 {
+		t.Fatalf("expected the unreachable code in a new block, got\n%s", f)
+	}
+	if err := f.Verify(); err != nil {
+		t.Errorf("expected the function to verify, got %s", err)
+	}
+}
+
+func TestScopes(t *testing.T) {
+	f, g := ir.NewFunction("f", ir.Void, nil, false), ir.NewFunction("g", ir.Void, nil, false)
+	body := f.NewScope(nil)
+	a, b := f.NewScope(body), f.NewScope(body)
+	inner := f.NewScope(a)
+	other := g.NewScope(g.NewScope(nil))
+
+	tests := []struct {
+		name     string
+		x, y     *ir.Scope
+		disjoint bool
+	}{
+		{"next to each other", a, b, true},
+		{"inside the one next to it", inner, b, true},
+		{"the same", a, a, false},
+		{"inside", inner, a, false},
+		{"enclosing", body, inner, false},
+		{"another function", a, other, false},
+		{"unknown", a, nil, false},
+	}
+
+	for _, tt := range tests {
+		if tt.x.Disjoint(tt.y) != tt.disjoint || tt.y.Disjoint(tt.x) != tt.disjoint {
+			t.Errorf("%s: expected disjoint=%t", tt.name, tt.disjoint)
+		}
+	}
+
+	builder := ir.NewBuilder(f)
+	builder.Scope = inner
+	builder.Alloca(4, 4)
+	if actual := f.Entry().Instructions[0].String(); actual != "%1 = alloca 4, align 4, scope 4" {
+		t.Errorf("expected the alloca to print its scope, got %q", actual)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		breakIt  func(f *ir.Function)
+		expected string
+	}{
+		{
+			func(f *ir.Function) {
+				last := f.Blocks[3]
+				last.Instructions = last.Instructions[:1]
+			},
+			"L4: block does not end in a terminator",
+		},
+		{
+			func(f *ir.Function) {
+				f.Blocks[1].Instructions = append(f.Blocks[1].Instructions,
+					&ir.Instruction{Op: ir.Ret})
+			},
+			"L2: 'jmp L4': terminator in the middle of a block",
+		},
+		{
+			func(f *ir.Function) { f.Blocks = f.Blocks[:3] },
+			"L2: 'jmp L4': L4 is not a block of the function",
+		},
+		{
+			func(f *ir.Function) {
+				load := f.Blocks[3].Instructions[0]
+				f.Blocks[3].Instructions[1].Args[0] = &ir.Register{ID: 9, T: ir.I32}
+				load.Dest = f.Params[0]
+			},
+			"%1 is assigned more than once",
+		},
+		{
+			func(f *ir.Function) {
+				f.Blocks[3].Instructions[1].Args[0] = &ir.Register{ID: 9, T: ir.I32}
+			},
+			"L4: 'ret i32 %9': %9 is never assigned",
+		},
+		{
+			func(f *ir.Function) {
+				f.Blocks[1].Instructions[0].Args[0] = f.Blocks[3].Instructions[0].Dest
+			},
+			"L2: 'store i32 %5, %3': %5 is used before it is assigned",
+		},
+		{
+			func(f *ir.Function) {
+				f.OutOfSSA = true
+				phi := &ir.Instruction{Op: ir.Phi, Dest: f.NewRegister(ir.I32),
+					Args:   []ir.Value{f.Params[0], f.Params[1]},
+					Blocks: []*ir.Block{f.Blocks[1], f.Blocks[2]}}
+				f.Blocks[3].Instructions = append([]*ir.Instruction{phi},
+					f.Blocks[3].Instructions...)
+			},
+			"phi in a function that is out of SSA form",
+		},
+		{
+			func(f *ir.Function) {
+				f.Blocks[0].Instructions[1].Args[1] = &ir.Const{Value: 1, T: ir.I64}
+			},
+			"operands must be integers or pointers of the same type",
+		},
+		{
+			func(f *ir.Function) { f.Return = ir.I64 },
+			"L4: 'ret i32 %5': function returns i64",
+		},
+		{
+			func(f *ir.Function) {
+				store := f.Blocks[1].Instructions[0]
+				store.Args[0], store.Args[1] = store.Args[1], store.Args[0]
+			},
+			"stores a value through a ptr",
+		},
+		{
+			func(f *ir.Function) {
+				f.Blocks[0].Instructions[0].Align = 3
+			},
+			"needs a size and an alignment that is a power of two",
+		},
+		{
+			func(f *ir.Function) {
+				phi := &ir.Instruction{Op: ir.Phi, Dest: f.NewRegister(ir.I32),
+					Args:   []ir.Value{f.Params[0]},
+					Blocks: []*ir.Block{f.Blocks[1]}}
+				f.Blocks[3].Instructions = append([]*ir.Instruction{phi},
+					f.Blocks[3].Instructions...)
+			},
+			"needs one argument for each of the 2 predecessors",
+		},
+		{
+			func(f *ir.Function) {
+				call := &ir.Instruction{Op: ir.Call, Tail: true,
+					Args: []ir.Value{&ir.Global{Name: "g"}}}
+				f.Blocks[1].Instructions = append([]*ir.Instruction{call},
+					f.Blocks[1].Instructions...)
+			},
+			"tail call must be followed by a return of its result",
+		},
+	}
+
+	for _, tt := range tests {
+		f := buildMax()
+		tt.breakIt(f)
+		err := f.Verify()
+		if err == nil {
+			t.Errorf("expected %q, but the function verified:\n%s", tt.expected, f)
+		} else if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("expected %q, got %q", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ir
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operands that are printed with their type, like "i32 %3"
+func typed(values ...Value) string {
+	parts := []string{}
+	for _, v := range values {
+		parts = append(parts, fmt.Sprintf("%s %s", v.Type(), v))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func (i *Instruction) String() string {
+	var out strings.Builder
+	if i.Dest != nil {
+		fmt.Fprintf(&out, "%s = ", i.Dest)
+	}
+	if i.Tail {
+		out.WriteString("tail ")
+	}
+	out.WriteString(string(i.Op))
+
+	switch {
+	case i.Op.IsBinary() || i.Op.IsComparison():
+		fmt.Fprintf(&out, " %s, %s", typed(i.Args[0]), i.Args[1])
+	case i.Op.IsConversion():
+		fmt.Fprintf(&out, " %s to %s", typed(i.Args[0]), i.Dest.T)
+	case i.Op == Alloca:
+		fmt.Fprintf(&out, " %d, align %d", i.Size, i.Align)
+		if i.Scope != nil {
+			fmt.Fprintf(&out, ", scope %d", i.Scope.ID)
+		}
+	case i.Op == DynAlloca:
+		fmt.Fprintf(&out, " %s, align %d", typed(i.Args[0]), i.Align)
+	case i.Op == Load || i.Op == VaArg:
+		fmt.Fprintf(&out, " %s %s", i.Dest.T, i.Args[0])
+	case i.Op == Store:
+		fmt.Fprintf(&out, " %s, %s", typed(i.Args[0]), i.Args[1])
+	case i.Op == Call:
+		ret := Void
+		if i.Dest != nil {
+			ret = i.Dest.T
+		}
+		args := typed(i.Args[1:]...)
+		if i.Variadic {
+			args = strings.TrimPrefix(args+", ...", ", ")
+		}
+		fmt.Fprintf(&out, " %s %s(%s)", ret, i.Args[0], args)
+	case i.Op == Phi:
+		incoming := []string{}
+		for j, v := range i.Args {
+			incoming = append(incoming, fmt.Sprintf("[%s, %s]", v, i.Blocks[j]))
+		}
+		fmt.Fprintf(&out, " %s %s", i.Dest.T, strings.Join(incoming, ", "))
+	case i.Op == Br:
+		fmt.Fprintf(&out, " %s, %s, %s", i.Args[0], i.Blocks[0], i.Blocks[1])
+	case i.Op == Jmp:
+		fmt.Fprintf(&out, " %s", i.Blocks[0])
+	case i.Op == Ret || i.Op == Neg || i.Op == Not || i.Op == Copy:
+		if len(i.Args) > 0 {
+			fmt.Fprintf(&out, " %s", typed(i.Args...))
+		}
+	default:
+		for j, arg := range i.Args {
+			if j > 0 {
+				out.WriteString(",")
+			}
+			fmt.Fprintf(&out, " %s", arg)
+		}
+	}
+
+	return out.String()
+}
+
+func (b *Block) Format() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s:\n", b)
+	for _, instr := range b.Instructions {
+		fmt.Fprintf(&out, "\t%s\n", instr)
+	}
+	return out.String()
+}
+
+func (f *Function) String() string {
+	var out strings.Builder
+	out.WriteString("function ")
+	if f.Static {
+		out.WriteString("static ")
+	}
+	if f.Inline {
+		out.WriteString("inline ")
+	}
+
+	params := typed(registerValues(f.Params)...)
+	if f.Variadic {
+		params = strings.TrimPrefix(params+", ...", ", ")
+	}
+	fmt.Fprintf(&out, "%s @%s(%s) {\n", f.Return, f.Name, params)
+
+	for _, b := range f.Blocks {
+		out.WriteString(b.Format())
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+func registerValues(registers []*Register) []Value {
+	values := make([]Value, len(registers))
+	for i, r := range registers {
+		values[i] = r
+	}
+	return values
+}
+
+func (m *Module) String() string {
+	var out strings.Builder
+	for _, s := range m.Strings {
+		fmt.Fprintf(&out, "@%s = %q\n", s.Name, s.Value)
+	}
+
+	for i, f := range m.Functions {
+		if i > 0 || len(m.Strings) > 0 {
+			out.WriteString("\n")
+		}
+		out.WriteString(f.String())
+	}
+	return out.String()
+}
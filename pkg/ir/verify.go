@@ -0,0 +1,299 @@
+package ir
+
+import (
+	"errors"
+	"fmt"
+)
+
+type verifier struct {
+	f       *Function
+	blocks  map[*Block]bool
+	defined map[*Register]bool
+	dom     *DomTree
+	errs    []error
+
+	// where each register is assigned, nil for parameters
+	defBlock map[*Register]*Block
+	defIndex map[*Register]int
+}
+
+// Verify checks that the function is well formed: every block ends in its
+// only terminator, branches stay inside the function, every register is
+// assigned exactly once before it is used, and operands have the types their
+// instructions need. Once the function is out of SSA form registers can be
+// assigned more than once, but there can't be any phis. All the problems
+// found are returned together.
+func (f *Function) Verify() error {
+	v := &verifier{f: f, blocks: map[*Block]bool{}, defined: map[*Register]bool{},
+		defBlock: map[*Register]*Block{}, defIndex: map[*Register]int{}}
+	if len(f.Blocks) == 0 {
+		return fmt.Errorf("%s: function has no blocks", f.Name)
+	}
+
+	for _, b := range f.Blocks {
+		if v.blocks[b] {
+			v.errorf(b, nil, "block appears more than once")
+		}
+		v.blocks[b] = true
+	}
+
+	for _, p := range f.Params {
+		v.define(nil, nil, p)
+	}
+	for _, b := range f.Blocks {
+		for i, instr := range b.Instructions {
+			if instr.Dest != nil {
+				v.define(b, instr, instr.Dest)
+				v.defBlock[instr.Dest], v.defIndex[instr.Dest] = b, i
+			}
+		}
+	}
+	if len(v.errs) > 0 { // broken control flow would trip up the dominators
+		return errors.Join(v.errs...)
+	}
+
+	v.dom = f.Dominators()
+	preds := f.Predecessors()
+	for _, b := range f.Blocks {
+		v.block(b, preds[b])
+	}
+
+	return errors.Join(v.errs...)
+}
+
+func (v *verifier) errorf(b *Block, instr *Instruction, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if instr != nil {
+		msg = fmt.Sprintf("'%s': %s", instr, msg)
+	}
+	if b != nil {
+		msg = fmt.Sprintf("%s: %s", b, msg)
+	}
+	v.errs = append(v.errs, fmt.Errorf("%s: %s", v.f.Name, msg))
+}
+
+func (v *verifier) define(b *Block, instr *Instruction, r *Register) {
+	if v.defined[r] && !v.f.OutOfSSA {
+		v.errorf(b, instr, "%s is assigned more than once", r)
+	}
+	v.defined[r] = true
+}
+
+func (v *verifier) block(b *Block, preds []*Block) {
+	if b.Terminator() == nil {
+		v.errorf(b, nil, "block does not end in a terminator")
+	}
+
+	phis := true
+	for i, instr := range b.Instructions {
+		if instr.Op.IsTerminator() && i != len(b.Instructions)-1 {
+			v.errorf(b, instr, "terminator in the middle of a block")
+		}
+
+		if instr.Op != Phi {
+			phis = false
+		} else if v.f.OutOfSSA {
+			v.errorf(b, instr, "phi in a function that is out of SSA form")
+		} else if !phis {
+			v.errorf(b, instr, "phi after the start of a block")
+		}
+
+		for _, target := range instr.Blocks {
+			if !v.blocks[target] {
+				v.errorf(b, instr, "%s is not a block of the function", target)
+			}
+		}
+		for j, arg := range instr.Args {
+			if arg == nil {
+				v.errorf(b, instr, "missing operand")
+				return
+			} else if r, ok := arg.(*Register); ok && !v.defined[r] {
+				v.errorf(b, instr, "%s is never assigned", r)
+			} else if ok && !v.f.OutOfSSA && !v.available(r, b, i, instr, j) {
+				v.errorf(b, instr, "%s is used before it is assigned", r)
+			}
+		}
+
+		v.instruction(b, instr, preds)
+	}
+}
+
+// Whether the assignment of r dominates its use as argument j of the i-th
+// instruction of b. The arguments of a phi are used at the end of the block
+// they come from. Uses in unreachable blocks are never checked.
+func (v *verifier) available(r *Register, b *Block, i int, instr *Instruction, j int) bool {
+	def := v.defBlock[r]
+	if def == nil || !v.dom.Reachable(b) {
+		return true
+	}
+
+	if instr.Op == Phi {
+		if j >= len(instr.Blocks) {
+			return true // reported as a malformed phi
+		}
+		return v.dom.Dominates(def, instr.Blocks[j])
+	} else if def == b {
+		return v.defIndex[r] < i
+	}
+	return v.dom.Dominates(def, b)
+}
+
+// Check the number and the types of the operands
+func (v *verifier) instruction(b *Block, instr *Instruction, preds []*Block) {
+	args := instr.Args
+	nArgs := map[Op]int{
+		Neg: 1, Not: 1, Copy: 1, Alloca: 0, Load: 1, Store: 2,
+		DynAlloca: 1, StackSave: 0, StackRestore: 1,
+		VaStart: 1, VaArg: 1, VaCopy: 2, Jmp: 0, Br: 1,
+	}
+	n, ok := nArgs[instr.Op]
+	switch {
+	case instr.Op.IsBinary() || instr.Op.IsComparison():
+		n, ok = 2, true
+	case instr.Op.IsConversion():
+		n, ok = 1, true
+	}
+	if ok && len(args) != n {
+		v.errorf(b, instr, "expected %d operands, got %d", n, len(args))
+		return
+	}
+
+	hasDest := instr.Dest != nil
+	switch instr.Op {
+	case Call:
+	case Store, StackRestore, VaStart, VaCopy, Jmp, Br, Ret:
+		if hasDest {
+			v.errorf(b, instr, "%s has no result", instr.Op)
+			return
+		}
+	default:
+		if !hasDest {
+			v.errorf(b, instr, "%s needs a result", instr.Op)
+			return
+		}
+	}
+
+	isPtr := func(val Value) bool { return val.Type() == Ptr }
+	switch op := instr.Op; {
+	case op.IsBinary():
+		x, y := args[0].Type(), args[1].Type()
+		if (op == Add || op == Sub) && x == Ptr && y == I64 && instr.Dest.T == Ptr {
+			break
+		}
+		if !x.IsInteger() || x != y || instr.Dest.T != x {
+			v.errorf(b, instr, "operands and result must be integers of the same type")
+		}
+	case op == Neg || op == Not:
+		if !args[0].Type().IsInteger() || instr.Dest.T != args[0].Type() {
+			v.errorf(b, instr, "operand and result must be integers of the same type")
+		}
+	case op.IsComparison():
+		x, y := args[0].Type(), args[1].Type()
+		if x != y || !x.IsInteger() && x != Ptr {
+			v.errorf(b, instr, "operands must be integers or pointers of the same type")
+		} else if instr.Dest.T != I32 {
+			v.errorf(b, instr, "comparisons produce an i32")
+		}
+	case op == SExt || op == ZExt || op == Trunc:
+		from, to := args[0].Type(), instr.Dest.T
+		if !from.IsInteger() || !to.IsInteger() {
+			v.errorf(b, instr, "can only convert between integers")
+		} else if op == Trunc && to.Size() >= from.Size() ||
+			op != Trunc && to.Size() <= from.Size() {
+			v.errorf(b, instr, "can not %s %s to %s", op, from, to)
+		}
+	case op == PtrToInt:
+		if !isPtr(args[0]) || instr.Dest.T != I64 {
+			v.errorf(b, instr, "converts a ptr to an i64")
+		}
+	case op == IntToPtr:
+		if args[0].Type() != I64 || instr.Dest.T != Ptr {
+			v.errorf(b, instr, "converts an i64 to a ptr")
+		}
+	case op == Copy:
+		if args[0].Type() != instr.Dest.T {
+			v.errorf(b, instr, "operand and result must have the same type")
+		}
+	case op == Alloca:
+		if instr.Dest.T != Ptr || instr.Size < 0 || !isPowerOfTwo(instr.Align) {
+			v.errorf(b, instr, "needs a size and an alignment that is a power of two")
+		}
+	case op == Load:
+		if !isPtr(args[0]) || instr.Dest.T == Void {
+			v.errorf(b, instr, "loads a value through a ptr")
+		}
+	case op == Store:
+		if !isPtr(args[1]) || args[0].Type() == Void {
+			v.errorf(b, instr, "stores a value through a ptr")
+		}
+	case op == Call:
+		if len(args) == 0 || !isPtr(args[0]) {
+			v.errorf(b, instr, "callee must be a ptr")
+		} else if hasDest && instr.Dest.T == Void {
+			v.errorf(b, instr, "result can not be void")
+		} else if instr.Tail && !TailCall(b, instr) {
+			v.errorf(b, instr, "tail call must be followed by a return of its result")
+		}
+	case op == DynAlloca:
+		if args[0].Type() != I64 || instr.Dest.T != Ptr || !isPowerOfTwo(instr.Align) {
+			v.errorf(b, instr, "allocates an i64 number of bytes with a power of two alignment")
+		}
+	case op == StackSave || op == StackRestore:
+		if hasDest && instr.Dest.T != Ptr || !hasDest && !isPtr(args[0]) {
+			v.errorf(b, instr, "the stack pointer is a ptr")
+		}
+	case op == VaStart || op == VaArg || op == VaCopy:
+		for _, arg := range args {
+			if !isPtr(arg) {
+				v.errorf(b, instr, "operands must point to a va_list")
+			}
+		}
+		if op == VaStart && !v.f.Variadic {
+			v.errorf(b, instr, "vastart in a function with fixed arguments")
+		}
+	case op == Phi:
+		v.phi(b, instr, preds)
+	case op == Jmp:
+		if len(instr.Blocks) != 1 {
+			v.errorf(b, instr, "needs one target")
+		}
+	case op == Br:
+		if len(instr.Blocks) != 2 {
+			v.errorf(b, instr, "needs two targets")
+		} else if !args[0].Type().IsInteger() && !isPtr(args[0]) {
+			v.errorf(b, instr, "condition must be an integer or a ptr")
+		}
+	case op == Ret:
+		if len(args) > 1 {
+			v.errorf(b, instr, "returns at most one value")
+		} else if len(args) == 1 && args[0].Type() != v.f.Return {
+			v.errorf(b, instr, "function returns %s", v.f.Return)
+		}
+	default:
+		v.errorf(b, instr, "unknown operation")
+	}
+}
+
+// A phi has one argument for each predecessor of its block
+func (v *verifier) phi(b *Block, instr *Instruction, preds []*Block) {
+	if len(instr.Args) != len(instr.Blocks) || len(instr.Args) != len(preds) {
+		v.errorf(b, instr, "needs one argument for each of the %d predecessors",
+			len(preds))
+		return
+	}
+
+	isPred := map[*Block]bool{}
+	for _, p := range preds {
+		isPred[p] = true
+	}
+	for i, arg := range instr.Args {
+		if arg.Type() != instr.Dest.T {
+			v.errorf(b, instr, "arguments must have the type of the result")
+		}
+		if !isPred[instr.Blocks[i]] {
+			v.errorf(b, instr, "%s is not a predecessor", instr.Blocks[i])
+		}
+	}
+}
+
+func isPowerOfTwo(n int64) bool { return n > 0 && n&(n-1) == 0 }
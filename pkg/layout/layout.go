@@ -0,0 +1,162 @@
+// Package layout gives the size and alignment of types on x86-64, which the
+// semantic checker needs for constant expressions like sizeof and _Alignof
+// and the compiler needs to lay out objects in memory.
+package layout
+
+import (
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/constant"
+)
+
+var (
+	TypeToSize = map[string]uint64{
+		"char":          1,
+		"short int":     2,
+		"int":           4,
+		"long int":      8,
+		"long long int": 8,
+		"float":         4,
+		"double":        8,
+		"long double":   16,
+		// struct { unsigned gp_offset, fp_offset; void *overflow, *save; }
+		"va_list": 24,
+	}
+
+	PtrSize uint64 = 8
+)
+
+func SizeOf(decl ast.Declaration) uint64 {
+	switch d := decl.(type) {
+	case *ast.Pointer:
+		return PtrSize
+	case *ast.Array:
+		length, ok := evalInt(d.ArraySize)
+		if !ok || length < 0 { // incomplete or variable length array
+			return 0
+		}
+		return uint64(length) * SizeOf(d.ArrayOf)
+	case *ast.BaseType:
+		return TypeToSize[d.Name]
+	case *ast.StructOrUnionSpecification:
+		_, size, _ := StructLayout(d)
+		return size
+	case *ast.VariableDeclaration:
+		return SizeOf(d.Type())
+	case *ast.FunctionDeclaration:
+		// This is a nonsense operation, but other compilers seem to return 1 here
+		return 1
+	default:
+		// Should never get here
+		return 0
+	}
+}
+
+func AlignOf(decl ast.Declaration) uint64 {
+	switch d := decl.(type) {
+	case *ast.Array:
+		return AlignOf(d.ArrayOf)
+	case *ast.StructOrUnionSpecification:
+		_, _, align := StructLayout(d)
+		return align
+	case *ast.VariableDeclaration:
+		return AlignOf(d.Type())
+	case *ast.FunctionDeclaration:
+		return 1
+	case *ast.BaseType:
+		if ast.IsVaList(d) {
+			return PtrSize
+		}
+		if size := SizeOf(d); size > 0 {
+			return size
+		}
+		return 1
+	default:
+		if size := SizeOf(d); size > 0 {
+			return size
+		}
+		return 1
+	}
+}
+
+// Round n up to a multiple of align
+func AlignUp(n uint64, align uint64) uint64 {
+	return (n + align - 1) / align * align
+}
+
+// Alignment of a variable or member, taking _Alignas into account. The
+// semantic checker makes sure that the requested alignment is a power of two
+// that is at least the one of the type, or 0, which has no effect.
+func DeclAlignment(decl *ast.VariableDeclaration) uint64 {
+	if decl.AlignAs != nil {
+		if alignAs, ok := evalInt(decl.AlignAs); ok && alignAs != 0 {
+			return uint64(alignAs)
+		}
+	}
+
+	return AlignOf(decl.Type())
+}
+
+// Compute the offset of every member along with the size and alignment of the
+// whole struct or union. Incomplete types have a size of 0.
+func StructLayout(s *ast.StructOrUnionSpecification) ([]uint64, uint64, uint64) {
+	fields := s.Fields()
+	offsets := make([]uint64, len(fields))
+	var size, align uint64 = 0, 1
+
+	for i, field := range fields {
+		fieldAlign := DeclAlignment(field)
+		if fieldAlign > align {
+			align = fieldAlign
+		}
+
+		fieldSize := SizeOf(field.Type())
+		if s.Kind == "union" {
+			if fieldSize > size {
+				size = fieldSize
+			}
+			continue
+		}
+
+		offsets[i] = AlignUp(size, fieldAlign)
+		size = offsets[i] + fieldSize
+	}
+
+	return offsets, AlignUp(size, align), align
+}
+
+// Whether the type has a size that can only be known at runtime
+func IsVLA(decl ast.Declaration) bool {
+	arr, ok := decl.(*ast.Array)
+	if !ok {
+		return false
+	}
+
+	if _, ok := evalInt(arr.ArraySize); !ok && arr.ArraySize != nil {
+		return true
+	}
+
+	return IsVLA(arr.ArrayOf)
+}
+
+// Evaluate an integer constant expression, in which sizeof and _Alignof of
+// types with a constant layout are constants too
+func Eval(expr ast.Expression) (constant.Value, error) {
+	e := &constant.Evaluator{SizeOf: constantSize, AlignOf: constantAlign}
+	return e.Eval(expr)
+}
+
+func evalInt(expr ast.Expression) (int64, bool) {
+	v, err := Eval(expr)
+	return v.Int, err == nil
+}
+
+func constantSize(t ast.Declaration) (int64, bool) {
+	if IsVLA(t) || !ast.IsComplete(t) {
+		return 0, false
+	}
+	return int64(SizeOf(t)), true
+}
+
+func constantAlign(t ast.Declaration) (int64, bool) {
+	return int64(AlignOf(t)), true
+}
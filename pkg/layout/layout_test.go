@@ -0,0 +1,72 @@
+package layout_test
+
+import (
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/layout"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/parser"
+)
+
+// The variable declared last in the input
+func parse(t *testing.T, input string) *ast.VariableDeclaration {
+	p := parser.New(lexer.New(input))
+	tUnit := p.Parse()
+	for _, err := range p.Errors() {
+		t.Fatalf("parser error in %q: %s", input, err.String())
+	}
+
+	stmts := tUnit.DeclarationStatements
+	decls := stmts[len(stmts)-1].Declarations
+	return decls[len(decls)-1].(*ast.VariableDeclaration)
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		input string
+		size  uint64
+		align uint64
+	}{
+		{"char c;", 1, 1},
+		{"long *p;", 8, 8},
+		{"short a[3][5];", 30, 2},
+		{"int a[sizeof(long) + 1];", 36, 4},
+		{"struct { char c; int i; char d; } s;", 12, 4},
+		{"struct { char c; long l; } s;", 16, 8},
+		{"union { char c[5]; int i; } u;", 8, 4},
+		{"struct { char c; _Alignas(16) char d; } s;", 32, 16},
+		{"_Alignas(32) int i;", 4, 32},
+		{"_Alignas(0) long l;", 8, 8},
+		{"struct s { int a; } v; struct s w[2];", 8, 4},
+	}
+
+	for _, tt := range tests {
+		decl := parse(t, tt.input)
+		if size := layout.SizeOf(decl.Type()); size != tt.size {
+			t.Errorf("%q: expected size %d, got %d", tt.input, tt.size, size)
+		}
+		if align := layout.DeclAlignment(decl); align != tt.align {
+			t.Errorf("%q: expected alignment %d, got %d", tt.input, tt.align, align)
+		}
+	}
+}
+
+func TestIsVLA(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"int a[4];", false},
+		{"int a[sizeof(int)];", false},
+		{"int a[];", false},
+		{"int n; int a[n];", true},
+		{"int n; int a[2][n];", true},
+	}
+
+	for _, tt := range tests {
+		if vla := layout.IsVLA(parse(t, tt.input).Type()); vla != tt.expected {
+			t.Errorf("%q: expected IsVLA=%t, got %t", tt.input, tt.expected, vla)
+		}
+	}
+}
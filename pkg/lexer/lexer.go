@@ -9,6 +9,7 @@ import (
 )
 
 type Lexer struct {
+	file   string
 	input  string
 	pos    int
 	peek   int
@@ -16,17 +17,19 @@ type Lexer struct {
 	char   byte
 	line   int
 	column int
+
+	comments []token.Token // the comments skipped so far
 }
 
 func (l *Lexer) peek2Char() byte {
-	if l.peek2 > len(l.input) {
+	if l.peek2 >= len(l.input) {
 		return 0
 	}
 	return l.input[l.peek2]
 }
 
 func (l *Lexer) peekChar() byte {
-	if l.peek > len(l.input) {
+	if l.peek >= len(l.input) {
 		return 0
 	}
 	return l.input[l.peek]
@@ -61,12 +64,16 @@ func isDigit(char byte) bool {
 }
 
 func (l *Lexer) skipLineComment() {
+	defer l.recordComment(l.pos, l.line, l.column)()
 	for l.char != '\n' && l.char != 0 {
 		l.readChar()
 	}
 }
 
 func (l *Lexer) skipBlockComment() {
+	defer l.recordComment(l.pos, l.line, l.column)()
+	l.readChar() // the '*' in "/*/" doesn't end the comment
+	l.readChar()
 	for !(l.char == '*' && l.peekChar() == '/') && l.char != 0 {
 		l.readChar()
 	}
@@ -75,6 +82,26 @@ func (l *Lexer) skipBlockComment() {
 	l.readChar()
 }
 
+// Remember the comment that starts at the current character, once the
+// returned function is called at its end
+func (l *Lexer) recordComment(start int, line int, column int) func() {
+	return func() {
+		end := l.pos
+		if end > len(l.input) {
+			end = len(l.input)
+		}
+		l.comments = append(l.comments, token.Token{Type: token.COMMENT,
+			Literal: l.input[start:end], File: l.file, Line: line, Column: column,
+			Offset: start})
+	}
+}
+
+// The comments the lexer skipped over so far, in the order they appear in
+// the input
+func (l *Lexer) Comments() []token.Token {
+	return l.comments
+}
+
 func (l *Lexer) skipWhitespaceAndComments() {
 	for {
 		if l.char == '/' {
@@ -165,6 +192,9 @@ func (l *Lexer) readString() string {
 	l.readChar()
 
 	for l.char != '"' && l.char != 0 {
+		if l.char == '\\' && l.peekChar() != 0 { // skip escaped quotes
+			l.readChar()
+		}
 		l.readChar()
 	}
 
@@ -172,8 +202,12 @@ func (l *Lexer) readString() string {
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input, line: 1, column: 1}
-	l.readChar()
+	return NewFile("", input)
+}
+
+// Like New, but the tokens record the name of the file they came from
+func NewFile(file string, input string) *Lexer {
+	l := &Lexer{file: file, input: input, line: 1, column: 0, peek2: 1}
 	l.readChar()
 	return l
 }
@@ -184,7 +218,7 @@ func (l *Lexer) NextToken() token.Token {
 
 	l.skipWhitespaceAndComments()
 
-	line, column := l.line, l.column
+	line, column, offset := l.line, l.column, l.pos
 	switch l.char {
 	case '"':
 		literal := l.readString()
@@ -290,7 +324,10 @@ func (l *Lexer) NextToken() token.Token {
 	case '?':
 		tok = token.Token{Type: token.QUESTION, Literal: string(l.char)}
 	case '.':
-		tok = token.Token{Type: token.DOT, Literal: string(l.char)}
+		tok, ok = l.checkMultiCharOp('.', '.', token.ELLIPSIS)
+		if !ok {
+			tok = token.Token{Type: token.DOT, Literal: string(l.char)}
+		}
 	case '(':
 		tok = token.Token{Type: token.LPAREN, Literal: string(l.char)}
 	case ')':
@@ -316,7 +353,7 @@ func (l *Lexer) NextToken() token.Token {
 			ident := l.readIdent()
 			tokenType := token.LookupIdent(ident)
 			return token.Token{Type: tokenType, Literal: ident,
-				Line: line, Column: column}
+				File: l.file, Line: line, Column: column, Offset: offset}
 		} else if isDigit(l.char) {
 			number := l.readNumber()
 			var tokenType token.TokenType = token.INTL
@@ -324,7 +361,7 @@ func (l *Lexer) NextToken() token.Token {
 				tokenType = token.FLOATL
 			}
 			return token.Token{Type: tokenType, Literal: number,
-				Line: line, Column: column}
+				File: l.file, Line: line, Column: column, Offset: offset}
 		} else {
 			tok = token.Token{Type: token.ILLEGAL,
 				Literal: string(l.char)}
@@ -333,6 +370,6 @@ func (l *Lexer) NextToken() token.Token {
 	}
 
 	l.readChar()
-	tok.Line, tok.Column = line, column
+	tok.File, tok.Line, tok.Column, tok.Offset = l.file, line, column, offset
 	return tok
 }
@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/tjarjoura/cc/pkg/token"
@@ -463,5 +464,168 @@ exit:
 			t.Fatalf("[%d] tok.Column != %d, got=%d", i, expected.Column,
 				tok.Column)
 		}
+
+		if offset := offsetOf(input, tok.Line, tok.Column); tok.Offset != offset {
+			t.Fatalf("[%d] tok.Offset != %d, got=%d", i, offset, tok.Offset)
+		}
+	}
+}
+
+// The byte offset of a line and column in the input
+func offsetOf(input string, line int, column int) int {
+	offset := 0
+	for ; line > 1; line-- {
+		offset += strings.IndexByte(input[offset:], '\n') + 1
+	}
+	return offset + column - 1
+}
+
+func TestLexerEllipsis(t *testing.T) {
+	input := `int printf(const char *fmt, ...); s.x; .. .`
+	expectedTokens := []token.Token{
+		{Type: token.INT, Literal: "int", Line: 1, Column: 1},
+		{Type: token.IDENTIFIER, Literal: "printf", Line: 1, Column: 5},
+		{Type: token.LPAREN, Literal: "(", Line: 1, Column: 11},
+		{Type: token.CONST, Literal: "const", Line: 1, Column: 12},
+		{Type: token.CHAR, Literal: "char", Line: 1, Column: 18},
+		{Type: token.ASTERISK, Literal: "*", Line: 1, Column: 23},
+		{Type: token.IDENTIFIER, Literal: "fmt", Line: 1, Column: 24},
+		{Type: token.COMMA, Literal: ",", Line: 1, Column: 27},
+		{Type: token.ELLIPSIS, Literal: "...", Line: 1, Column: 29},
+		{Type: token.RPAREN, Literal: ")", Line: 1, Column: 32},
+		{Type: token.SEMICOLON, Literal: ";", Line: 1, Column: 33},
+		{Type: token.IDENTIFIER, Literal: "s", Line: 1, Column: 35},
+		{Type: token.DOT, Literal: ".", Line: 1, Column: 36},
+		{Type: token.IDENTIFIER, Literal: "x", Line: 1, Column: 37},
+		{Type: token.SEMICOLON, Literal: ";", Line: 1, Column: 38},
+		{Type: token.DOT, Literal: ".", Line: 1, Column: 40},
+		{Type: token.DOT, Literal: ".", Line: 1, Column: 41},
+		{Type: token.DOT, Literal: ".", Line: 1, Column: 43},
+		{Type: token.EOF, Literal: "", Line: 1, Column: 44},
+	}
+
+	l := New(input)
+	for i, expected := range expectedTokens {
+		expected.Offset = expected.Column - 1 // the input is a single line
+		tok := l.NextToken()
+		if tok != expected {
+			t.Fatalf("[%d] expected token=%+v, got=%+v", i, expected, tok)
+		}
+	}
+}
+
+func TestLexerPositions(t *testing.T) {
+	input := "int x;\n\t\"ab\" y"
+	expected := []struct {
+		pos string
+		end string
+	}{
+		{"a.c:1:1", "a.c:1:4"},
+		{"a.c:1:5", "a.c:1:6"},
+		{"a.c:1:6", "a.c:1:7"},
+		{"a.c:2:2", "a.c:2:6"},
+		{"a.c:2:7", "a.c:2:8"},
+	}
+
+	l := NewFile("a.c", input)
+	for i, tt := range expected {
+		tok := l.NextToken()
+		if tok.Pos().String() != tt.pos || tok.End().String() != tt.end {
+			t.Errorf("[%d] expected %q to span %s-%s, got %s-%s", i, tok.Literal,
+				tt.pos, tt.end, tok.Pos(), tok.End())
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	input := "int x;\n"
+	expected := []token.Token{
+		{Type: token.INT, Literal: "int", Line: 1, Column: 1, Offset: 0},
+		{Type: token.IDENTIFIER, Literal: "x", Line: 1, Column: 5, Offset: 4},
+		{Type: token.SEMICOLON, Literal: ";", Line: 1, Column: 6, Offset: 5},
+		{Type: token.EOF, Literal: "", Line: 2, Column: 1, Offset: 7},
+	}
+
+	tokens := New(input).Tokens()
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %d: %+v", len(expected), len(tokens), tokens)
+	}
+	for i := range expected {
+		if tokens[i] != expected[i] {
+			t.Errorf("[%d] expected token=%+v, got=%+v", i, expected[i], tokens[i])
+		}
+		if tok := tokens[i]; input[tok.Offset:tok.Offset+len(tok.Literal)] != tok.Literal {
+			t.Errorf("[%d] offset %d does not point at %q", i, tok.Offset, tok.Literal)
+		}
+	}
+}
+
+// Comments are left out of the tokens, and kept on the side
+func TestComments(t *testing.T) {
+	input := "int x; // line\n/* block\n over lines */ x /*/ not closed yet */;\n// at the end"
+	expected := []token.Token{
+		{Type: token.COMMENT, Literal: "// line", Line: 1, Column: 8, Offset: 7},
+		{Type: token.COMMENT, Literal: "/* block\n over lines */", Line: 2, Column: 1, Offset: 15},
+		{Type: token.COMMENT, Literal: "/*/ not closed yet */", Line: 3, Column: 18, Offset: 41},
+		{Type: token.COMMENT, Literal: "// at the end", Line: 4, Column: 1, Offset: 64},
+	}
+
+	l := New(input)
+	tokens := l.Tokens()
+	if len(tokens) != 6 {
+		t.Fatalf("expected 6 tokens, got %d: %+v", len(tokens), tokens)
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expected) {
+		t.Fatalf("expected %d comments, got %d: %+v", len(expected), len(comments), comments)
+	}
+	for i := range expected {
+		if comments[i] != expected[i] {
+			t.Errorf("[%d] expected comment=%+v, got=%+v", i, expected[i], comments[i])
+		}
+		if c := comments[i]; input[c.Offset:c.Offset+len(c.Literal)] != c.Literal {
+			t.Errorf("[%d] offset %d does not point at %q", i, c.Offset, c.Literal)
+		}
+	}
+
+	if end := comments[1].End(); end.Line != 3 || end.Column != 15 {
+		t.Errorf("expected the block comment to end at 3:15, got %s", end)
+	}
+}
+
+func TestStream(t *testing.T) {
+	s := NewStream(New("a = b;"))
+
+	tests := []struct {
+		op       string // "peek" or "next"
+		n        int
+		expected string
+	}{
+		{"peek", 3, ";"},
+		{"peek", 2, "b"},
+		{"peek", 0, "a"},
+		{"next", 0, "a"},
+		{"peek", 0, "="},
+		{"peek", 10, ""},
+		{"next", 0, "="},
+		{"next", 0, "b"},
+		{"next", 0, ";"},
+		{"next", 0, ""},
+		{"next", 0, ""},
+		{"peek", 1, ""},
+	}
+
+	for i, tt := range tests {
+		var tok token.Token
+		if tt.op == "peek" {
+			tok = s.Peek(tt.n)
+		} else {
+			tok = s.Next()
+		}
+
+		if tok.Literal != tt.expected || (tt.expected == "" && tok.Type != token.EOF) {
+			t.Errorf("[%d] %s(%d): expected %q, got %+v", i, tt.op, tt.n, tt.expected, tok)
+		}
 	}
 }
@@ -0,0 +1,48 @@
+package lexer
+
+import "github.com/tjarjoura/cc/pkg/token"
+
+// Tokens reads the rest of the input, the last token is always EOF
+func (l *Lexer) Tokens() []token.Token {
+	tokens := []token.Token{}
+	for {
+		tok := l.NextToken()
+		tokens = append(tokens, tok)
+		if tok.Type == token.EOF {
+			return tokens
+		}
+	}
+}
+
+// A Stream reads tokens from a lexer as they are needed, and can look any
+// number of tokens ahead. Once the input runs out it keeps returning EOF.
+type Stream struct {
+	l      *Lexer
+	tokens []token.Token // read from the lexer but not consumed yet
+}
+
+func NewStream(l *Lexer) *Stream {
+	return &Stream{l: l}
+}
+
+// Peek returns the token n tokens ahead without consuming anything, Peek(0)
+// is the token that Next returns
+func (s *Stream) Peek(n int) token.Token {
+	for len(s.tokens) <= n {
+		if last := len(s.tokens) - 1; last >= 0 && s.tokens[last].Type == token.EOF {
+			s.tokens = append(s.tokens, s.tokens[last])
+		} else {
+			s.tokens = append(s.tokens, s.l.NextToken())
+		}
+	}
+
+	return s.tokens[n]
+}
+
+func (s *Stream) Next() token.Token {
+	tok := s.Peek(0)
+	if tok.Type != token.EOF || len(s.tokens) > 1 {
+		s.tokens = s.tokens[1:]
+	}
+	return tok
+}
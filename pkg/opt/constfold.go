@@ -0,0 +1,237 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// FoldConstants computes the instructions whose operands are all constants
+// and replaces their results with the values, until there is nothing left to
+// fold. Branches on a constant become jumps, and the blocks that can no
+// longer be reached are removed, which can leave phis with a single
+// constant argument to fold in turn. Operations that are undefined, like a
+// division by zero, are left for the program to run into.
+func FoldConstants(f *ir.Function) bool {
+	// out of SSA form a register can have more than one value
+	if f.OutOfSSA {
+		return false
+	}
+
+	changed := false
+	for {
+		replace := map[*ir.Register]ir.Value{}
+		for _, b := range f.Blocks {
+			kept := b.Instructions[:0]
+			for _, instr := range b.Instructions {
+				if c, ok := fold(instr); ok {
+					replace[instr.Dest] = c
+					continue
+				}
+				kept = append(kept, instr)
+			}
+			b.Instructions = kept
+		}
+		replaceUses(f, replace)
+
+		branches := foldBranches(f)
+		unreachable := f.RemoveUnreachable()
+		if len(replace) == 0 && !branches && !unreachable {
+			return changed
+		}
+		changed = true
+	}
+}
+
+// Sign extend n from the width of t, which is how constants are kept
+func signed(n int64, t ir.Type) int64 {
+	shift := 64 - 8*t.Size()
+	return n << shift >> shift
+}
+
+// Zero extend n from the width of t
+func unsigned(n int64, t ir.Type) uint64 {
+	shift := 64 - 8*t.Size()
+	return uint64(n) << shift >> shift
+}
+
+func constants(args []ir.Value) ([]*ir.Const, bool) {
+	cs := []*ir.Const{}
+	for _, arg := range args {
+		c, ok := arg.(*ir.Const)
+		if !ok {
+			return nil, false
+		}
+		cs = append(cs, c)
+	}
+	return cs, true
+}
+
+// The constant that the instruction computes, if it can be known
+func fold(instr *ir.Instruction) (*ir.Const, bool) {
+	if instr.Op == ir.Phi {
+		return foldPhi(instr)
+	}
+
+	args, ok := constants(instr.Args)
+	if !ok || instr.Dest == nil || len(args) == 0 {
+		return nil, false
+	}
+
+	t := instr.Dest.T
+	result := func(n int64) (*ir.Const, bool) {
+		return &ir.Const{Value: signed(n, t), T: t}, true
+	}
+
+	switch op := instr.Op; {
+	case op.IsBinary():
+		if n, ok := binary(op, args[0], args[1]); ok {
+			return result(n)
+		}
+	case op.IsComparison():
+		if compare(op, args[0], args[1]) {
+			return result(1)
+		}
+		return result(0)
+	case op == ir.Neg:
+		return result(-args[0].Value)
+	case op == ir.Not:
+		return result(^args[0].Value)
+	case op == ir.SExt || op == ir.Trunc || op == ir.Copy:
+		return result(signed(args[0].Value, args[0].T))
+	case op == ir.ZExt:
+		return result(int64(unsigned(args[0].Value, args[0].T)))
+	}
+	return nil, false
+}
+
+func binary(op ir.Op, x *ir.Const, y *ir.Const) (int64, bool) {
+	t := x.T
+	a, b := signed(x.Value, t), signed(y.Value, y.T)
+	ua, ub := unsigned(x.Value, t), unsigned(y.Value, y.T)
+	width := uint64(8 * t.Size())
+
+	switch op {
+	case ir.Add:
+		return a + b, true
+	case ir.Sub:
+		return a - b, true
+	case ir.Mul:
+		return a * b, true
+	case ir.And:
+		return a & b, true
+	case ir.Or:
+		return a | b, true
+	case ir.Xor:
+		return a ^ b, true
+	case ir.SDiv, ir.SRem:
+		// the hardware traps on the quotient that doesn't fit too
+		if b == 0 || b == -1 && a == signed(1<<(width-1), t) {
+			return 0, false
+		} else if op == ir.SDiv {
+			return a / b, true
+		}
+		return a % b, true
+	case ir.UDiv, ir.URem:
+		if ub == 0 {
+			return 0, false
+		} else if op == ir.UDiv {
+			return int64(ua / ub), true
+		}
+		return int64(ua % ub), true
+	case ir.Shl, ir.LShr, ir.AShr:
+		if ub >= width {
+			return 0, false
+		} else if op == ir.Shl {
+			return a << ub, true
+		} else if op == ir.LShr {
+			return int64(ua >> ub), true
+		}
+		return a >> ub, true
+	}
+	return 0, false
+}
+
+func compare(op ir.Op, x *ir.Const, y *ir.Const) bool {
+	a, b := signed(x.Value, x.T), signed(y.Value, y.T)
+	ua, ub := unsigned(x.Value, x.T), unsigned(y.Value, y.T)
+
+	switch op {
+	case ir.Eq:
+		return a == b
+	case ir.Ne:
+		return a != b
+	case ir.SLt:
+		return a < b
+	case ir.SLe:
+		return a <= b
+	case ir.SGt:
+		return a > b
+	case ir.SGe:
+		return a >= b
+	case ir.ULt:
+		return ua < ub
+	case ir.ULe:
+		return ua <= ub
+	case ir.UGt:
+		return ua > ub
+	}
+	return ua >= ub
+}
+
+// A phi whose arguments are all the same constant
+func foldPhi(phi *ir.Instruction) (*ir.Const, bool) {
+	args, ok := constants(phi.Args)
+	if !ok || len(args) == 0 {
+		return nil, false
+	}
+
+	for _, c := range args[1:] {
+		if signed(c.Value, c.T) != signed(args[0].Value, args[0].T) {
+			return nil, false
+		}
+	}
+	return &ir.Const{Value: signed(args[0].Value, phi.Dest.T), T: phi.Dest.T}, true
+}
+
+// Turn branches on a constant into jumps, the target that isn't taken loses
+// its phi arguments for the block
+func foldBranches(f *ir.Function) bool {
+	changed := false
+	for _, b := range f.Blocks {
+		br := b.Terminator()
+		if br == nil || br.Op != ir.Br {
+			continue
+		}
+		c, ok := br.Args[0].(*ir.Const)
+		if !ok {
+			continue
+		}
+
+		taken, dropped := br.Blocks[0], br.Blocks[1]
+		if c.Value == 0 {
+			taken, dropped = dropped, taken
+		}
+		if dropped != taken {
+			removePhiArgs(dropped, b)
+		}
+
+		b.Instructions[len(b.Instructions)-1] = &ir.Instruction{Op: ir.Jmp,
+			Blocks: []*ir.Block{taken}}
+		changed = true
+	}
+	return changed
+}
+
+// Remove the arguments of the phis of b that come from pred
+func removePhiArgs(b *ir.Block, pred *ir.Block) {
+	for _, instr := range b.Instructions {
+		if instr.Op != ir.Phi {
+			break
+		}
+
+		args, from := []ir.Value{}, []*ir.Block{}
+		for i, p := range instr.Blocks {
+			if p != pred {
+				args, from = append(args, instr.Args[i]), append(from, p)
+			}
+		}
+		instr.Args, instr.Blocks = args, from
+	}
+}
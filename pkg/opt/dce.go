@@ -0,0 +1,143 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// EliminateDeadCode removes what can't affect the behaviour of the function:
+// blocks that control never reaches, stores to stack slots that are never
+// read, and instructions whose results aren't used by anything that has a
+// side effect. It works both in and out of SSA form.
+func EliminateDeadCode(f *ir.Function) bool {
+	changed := f.RemoveUnreachable()
+	changed = removeDeadStores(f) || changed
+	return removeDeadInstructions(f) || changed
+}
+
+// The instructions that use each register
+func uses(f *ir.Function) map[*ir.Register][]*ir.Instruction {
+	users := map[*ir.Register][]*ir.Instruction{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			for _, arg := range instr.Args {
+				if r, ok := arg.(*ir.Register); ok {
+					users[r] = append(users[r], instr)
+				}
+			}
+		}
+	}
+	return users
+}
+
+// Remove the stores to stack slots that are only ever written. Addresses
+// computed from the slot count as the slot, anything else that is done with
+// them, like loading from them or passing them to a call, could read it.
+// Out of SSA form a copy can merge the address with others.
+func removeDeadStores(f *ir.Function) bool {
+	users := uses(f)
+	dead := map[*ir.Instruction]bool{}
+	for _, instr := range f.Entry().Instructions {
+		if instr.Op != ir.Alloca {
+			continue
+		}
+
+		stores := []*ir.Instruction{}
+		addresses := map[*ir.Register]bool{instr.Dest: true}
+		work := []*ir.Register{instr.Dest}
+		read := false
+		for len(work) > 0 && !read {
+			p := work[len(work)-1]
+			work = work[:len(work)-1]
+
+			for _, use := range users[p] {
+				switch {
+				case use.Op == ir.Store && use.Args[1] == p && use.Args[0] != p:
+					stores = append(stores, use)
+				case use.Op == ir.Add || use.Op == ir.Sub ||
+					use.Op == ir.Copy && !f.OutOfSSA:
+					if !addresses[use.Dest] {
+						addresses[use.Dest] = true
+						work = append(work, use.Dest)
+					}
+				default:
+					read = true
+				}
+			}
+		}
+
+		if !read {
+			for _, store := range stores {
+				dead[store] = true
+			}
+		}
+	}
+
+	if len(dead) == 0 {
+		return false
+	}
+	for _, b := range f.Blocks {
+		kept := b.Instructions[:0]
+		for _, instr := range b.Instructions {
+			if !dead[instr] {
+				kept = append(kept, instr)
+			}
+		}
+		b.Instructions = kept
+	}
+	return true
+}
+
+// Remove the instructions that neither have side effects nor compute a
+// value that something with side effects depends on. Everything starts out
+// dead, and the instructions with side effects bring the ones that define
+// their operands to life.
+func removeDeadInstructions(f *ir.Function) bool {
+	defs := map[*ir.Register][]*ir.Instruction{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Dest != nil {
+				defs[instr.Dest] = append(defs[instr.Dest], instr)
+			}
+		}
+	}
+
+	live := map[*ir.Instruction]bool{}
+	work := []*ir.Instruction{}
+	mark := func(instr *ir.Instruction) {
+		if !live[instr] {
+			live[instr] = true
+			work = append(work, instr)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op.HasSideEffects() {
+				mark(instr)
+			}
+		}
+	}
+	for len(work) > 0 {
+		instr := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range instr.Args {
+			if r, ok := arg.(*ir.Register); ok {
+				for _, def := range defs[r] {
+					mark(def)
+				}
+			}
+		}
+	}
+
+	changed := false
+	for _, b := range f.Blocks {
+		kept := b.Instructions[:0]
+		for _, instr := range b.Instructions {
+			if live[instr] {
+				kept = append(kept, instr)
+			} else {
+				changed = true
+			}
+		}
+		b.Instructions = kept
+	}
+	return changed
+}
@@ -0,0 +1,503 @@
+package opt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// The default for -finline-limit
+const DefaultInlineLimit = 40
+
+// An Inliner replaces calls between the functions of a module with copies
+// of the callees, for functions that are static or declared inline. Callees
+// are inlined before their callers, so a caller gets the callee with the
+// calls that were inlined into it. Static functions that nothing uses
+// anymore are removed from the module.
+type Inliner struct {
+	// The cost up to which functions declared inline, and static functions
+	// that are only called once, are inlined. Other static functions are
+	// inlined up to a quarter of it.
+	Limit int
+
+	// If set, why every call of a function of the module was inlined or
+	// not is written here
+	Report io.Writer
+}
+
+// Run inlines the calls in the functions of the module, which have to be in
+// SSA form, and returns the functions that changed
+func (in *Inliner) Run(m *ir.Module) []*ir.Function {
+	functions := map[string]*ir.Function{}
+	for _, f := range m.Functions {
+		functions[f.Name] = f
+	}
+	scc := components(m, functions)
+
+	changed := map[*ir.Function]bool{}
+	for _, f := range bottomUp(m, functions) {
+		if f.OutOfSSA {
+			continue
+		}
+
+		for _, call := range calls(f) {
+			callee := functions[calleeName(call)]
+			if callee == nil {
+				continue
+			}
+
+			cost, threshold, reason := in.decide(m, f, callee, call, scc)
+			if reason != "" {
+				in.report("not inlining '%s' into '%s': %s", callee.Name, f.Name, reason)
+				continue
+			}
+			in.report("inlined '%s' into '%s' (cost %d, threshold %d)",
+				callee.Name, f.Name, cost, threshold)
+			inlineCall(f, call, callee)
+			changed[f] = true
+		}
+
+		if changed[f] {
+			// the code after a call of a function that never returns
+			f.RemoveUnreachable()
+			mergeBlocks(f)
+		}
+	}
+	in.removeUnused(m)
+
+	result := []*ir.Function{}
+	for _, f := range m.Functions {
+		if changed[f] {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+func (in *Inliner) report(format string, args ...interface{}) {
+	if in.Report != nil {
+		fmt.Fprintf(in.Report, format+"\n", args...)
+	}
+}
+
+// The name of the function that a call calls directly, "" for calls
+// through a pointer
+func calleeName(call *ir.Instruction) string {
+	if g, ok := call.Args[0].(*ir.Global); ok {
+		return g.Name
+	}
+	return ""
+}
+
+func calls(f *ir.Function) []*ir.Instruction {
+	result := []*ir.Instruction{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op == ir.Call {
+				result = append(result, instr)
+			}
+		}
+	}
+	return result
+}
+
+// The functions of the module with the functions they call coming first,
+// as far as recursion allows
+func bottomUp(m *ir.Module, functions map[string]*ir.Function) []*ir.Function {
+	order := []*ir.Function{}
+	visited := map[*ir.Function]bool{}
+	var visit func(f *ir.Function)
+	visit = func(f *ir.Function) {
+		visited[f] = true
+		for _, call := range calls(f) {
+			if callee := functions[calleeName(call)]; callee != nil && !visited[callee] {
+				visit(callee)
+			}
+		}
+		order = append(order, f)
+	}
+
+	for _, f := range m.Functions {
+		if !visited[f] {
+			visit(f)
+		}
+	}
+	return order
+}
+
+// Number the strongly connected components of the call graph, functions
+// that can call each other, directly or not, get the same number. Only a
+// function that calls itself is recursive on its own.
+func components(m *ir.Module, functions map[string]*ir.Function) map[*ir.Function]int {
+	index, low := map[*ir.Function]int{}, map[*ir.Function]int{}
+	onStack := map[*ir.Function]bool{}
+	stack := []*ir.Function{}
+	component := map[*ir.Function]int{}
+
+	// Tarjan's algorithm
+	var visit func(f *ir.Function)
+	visit = func(f *ir.Function) {
+		index[f], low[f] = len(index), len(index)
+		stack = append(stack, f)
+		onStack[f] = true
+
+		for _, call := range calls(f) {
+			callee := functions[calleeName(call)]
+			if callee == nil {
+				continue
+			} else if _, ok := index[callee]; !ok {
+				visit(callee)
+				if low[callee] < low[f] {
+					low[f] = low[callee]
+				}
+			} else if onStack[callee] && index[callee] < low[f] {
+				low[f] = index[callee]
+			}
+		}
+
+		if low[f] == index[f] {
+			id := len(component)
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				component[top] = id
+				if top == f {
+					break
+				}
+			}
+		}
+	}
+
+	for _, f := range m.Functions {
+		if _, ok := index[f]; !ok {
+			visit(f)
+		}
+	}
+	return component
+}
+
+// Whether f calls itself, directly or through other functions
+func recursive(f *ir.Function, caller *ir.Function, scc map[*ir.Function]int) bool {
+	return f == caller || scc[f] == scc[caller]
+}
+
+// The size of the code that inlining f copies into the caller: the
+// instructions of its body, without the stack slots, phis and returns
+// which don't turn into code of their own
+func inlineCost(f *ir.Function) int {
+	cost := 0
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op != ir.Alloca && instr.Op != ir.Phi && instr.Op != ir.Ret {
+				cost++
+			}
+		}
+	}
+	return cost
+}
+
+// How many calls of f there are in the module, and whether its address is
+// used for anything else
+func callSites(m *ir.Module, f *ir.Function) (int, bool) {
+	calls, addressTaken := 0, false
+	for _, g := range m.Functions {
+		for _, b := range g.Blocks {
+			for _, instr := range b.Instructions {
+				for i, arg := range instr.Args {
+					if global, ok := arg.(*ir.Global); !ok || global.Name != f.Name {
+						continue
+					} else if instr.Op == ir.Call && i == 0 {
+						calls++
+					} else {
+						addressTaken = true
+					}
+				}
+			}
+		}
+	}
+	return calls, addressTaken
+}
+
+// Whether the call of callee from caller is inlined, with the cost of
+// inlining it and how much it may cost. The reason is "" if it is inlined.
+func (in *Inliner) decide(m *ir.Module, caller *ir.Function, callee *ir.Function,
+	call *ir.Instruction, scc map[*ir.Function]int) (int, int, string) {
+	switch {
+	case !callee.Static && !callee.Inline:
+		return 0, 0, "it is neither static nor inline"
+	case callee.Variadic:
+		return 0, 0, "it takes a variable number of arguments"
+	case recursive(callee, caller, scc):
+		return 0, 0, "it is recursive"
+	case !matches(call, callee):
+		return 0, 0, "the call doesn't match its type"
+	case len(callee.Predecessors()[callee.Entry()]) > 0:
+		return 0, 0, "it loops back to its entry"
+	}
+	for _, b := range callee.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op == ir.DynAlloca {
+				return 0, 0, "it allocates a variable length array"
+			}
+		}
+	}
+
+	// a static function that is only called here disappears when it is
+	// inlined, so the code doesn't grow
+	cost, threshold := inlineCost(callee), in.Limit/4
+	if n, addressTaken := callSites(m, callee); callee.Inline ||
+		callee.Static && n == 1 && !addressTaken {
+		threshold = in.Limit
+	}
+	if cost > threshold {
+		return cost, threshold, fmt.Sprintf("cost %d exceeds the threshold %d",
+			cost, threshold)
+	}
+	return cost, threshold, ""
+}
+
+// Whether the arguments and result of the call have the types of the
+// parameters and result of the callee, calls through an unprototyped
+// declaration don't have to. Integer arguments are passed at least as wide
+// as the parameter, which only looks at its own bits.
+func matches(call *ir.Instruction, callee *ir.Function) bool {
+	args := call.Args[1:]
+	if len(args) != len(callee.Params) {
+		return false
+	}
+	for i, arg := range args {
+		t := callee.Params[i].T
+		if arg.Type() != t && !(arg.Type().IsInteger() && t.IsInteger() &&
+			arg.Type().Size() > t.Size()) {
+			return false
+		}
+	}
+	if call.Dest == nil {
+		return true
+	}
+	return call.Dest.T == callee.Return
+}
+
+// Replace the call with a copy of the body of the callee. The block of the
+// call is split in two, with the copy in between: the first half jumps to
+// the entry of the copy, and the returns of the copy jump to the second
+// half, where a phi merges the return values.
+func inlineCall(f *ir.Function, call *ir.Instruction, callee *ir.Function) {
+	var b *ir.Block
+	var at int
+	for _, block := range f.Blocks {
+		for i, instr := range block.Instructions {
+			if instr == call {
+				b, at = block, i
+			}
+		}
+	}
+
+	rest := f.NewBlock()
+	rest.Instructions = append([]*ir.Instruction{}, b.Instructions[at+1:]...)
+	b.Instructions = b.Instructions[:at]
+	for _, succ := range rest.Successors() {
+		for _, instr := range succ.Instructions {
+			if instr.Op != ir.Phi {
+				break
+			}
+			for i, from := range instr.Blocks {
+				if from == b {
+					instr.Blocks[i] = rest
+				}
+			}
+		}
+	}
+
+	// the parameters are replaced by the arguments, and every register of
+	// the callee gets a new one in the caller
+	values := map[*ir.Register]ir.Value{}
+	for i, param := range callee.Params {
+		arg := call.Args[i+1]
+		if arg.Type() != param.T {
+			r := f.NewRegister(param.T)
+			b.Instructions = append(b.Instructions,
+				&ir.Instruction{Op: ir.Trunc, Dest: r, Args: []ir.Value{arg}})
+			arg = r
+		}
+		values[param] = arg
+	}
+	value := func(v ir.Value) ir.Value {
+		r, ok := v.(*ir.Register)
+		if !ok {
+			return v
+		}
+		if values[r] == nil {
+			values[r] = f.NewRegister(r.T)
+		}
+		return values[r]
+	}
+
+	blocks := map[*ir.Block]*ir.Block{}
+	clones := []*ir.Block{}
+	for _, cb := range callee.Blocks {
+		blocks[cb] = f.NewBlock()
+		clones = append(clones, blocks[cb])
+	}
+
+	allocas := []*ir.Instruction{}
+	returned, from := []ir.Value{}, []*ir.Block{}
+	for _, cb := range callee.Blocks {
+		clone := blocks[cb]
+		for _, instr := range cb.Instructions {
+			if instr.Op == ir.Ret {
+				// falling off the end of a function leaves the result
+				// undefined
+				if call.Dest != nil && len(instr.Args) > 0 {
+					returned = append(returned, value(instr.Args[0]))
+				} else if call.Dest != nil {
+					returned = append(returned, &ir.Const{Value: 0, T: call.Dest.T})
+				}
+				from = append(from, clone)
+				clone.Instructions = append(clone.Instructions,
+					&ir.Instruction{Op: ir.Jmp, Blocks: []*ir.Block{rest}})
+				continue
+			}
+
+			copied := &ir.Instruction{Op: instr.Op, Size: instr.Size,
+				Align: instr.Align, Variadic: instr.Variadic, Scope: instr.Scope}
+			if instr.Dest != nil {
+				copied.Dest = value(instr.Dest).(*ir.Register)
+			}
+			for _, arg := range instr.Args {
+				copied.Args = append(copied.Args, value(arg))
+			}
+			for _, target := range instr.Blocks {
+				copied.Blocks = append(copied.Blocks, blocks[target])
+			}
+
+			if instr.Op == ir.Alloca {
+				allocas = append(allocas, copied)
+			} else {
+				clone.Instructions = append(clone.Instructions, copied)
+			}
+		}
+	}
+	b.Instructions = append(b.Instructions,
+		&ir.Instruction{Op: ir.Jmp, Blocks: []*ir.Block{blocks[callee.Entry()]}})
+
+	// the copy is laid out between the halves of the block
+	laidOut := []*ir.Block{}
+	for _, block := range f.Blocks {
+		laidOut = append(laidOut, block)
+		if block == b {
+			laidOut = append(append(laidOut, clones...), rest)
+		}
+	}
+	f.Blocks = laidOut
+
+	// stack slots belong in the entry block, after the ones already there
+	entry := f.Entry()
+	i := 0
+	for i < len(entry.Instructions) && entry.Instructions[i].Op == ir.Alloca {
+		i++
+	}
+	entry.Instructions = append(entry.Instructions[:i],
+		append(allocas, entry.Instructions[i:]...)...)
+
+	if call.Dest == nil {
+		return
+	} else if len(returned) == 1 {
+		replaceUses(f, map[*ir.Register]ir.Value{call.Dest: returned[0]})
+		return
+	}
+	phi := &ir.Instruction{Op: ir.Phi, Dest: call.Dest, Args: returned, Blocks: from}
+	rest.Instructions = append([]*ir.Instruction{phi}, rest.Instructions...)
+}
+
+// Merge the blocks that are only reached by a jump from the block before
+// them into it, which undoes the splitting of blocks around the calls
+func mergeBlocks(f *ir.Function) {
+	preds := f.Predecessors()
+	merged := map[*ir.Block]bool{}
+	for _, b := range f.Blocks {
+		if merged[b] {
+			continue
+		}
+		for {
+			term := b.Terminator()
+			if term == nil || term.Op != ir.Jmp {
+				break
+			}
+			next := term.Blocks[0]
+			if next == f.Entry() || next == b || len(preds[next]) != 1 {
+				break
+			}
+
+			// a phi with a single predecessor has a single argument
+			replace := map[*ir.Register]ir.Value{}
+			instrs := next.Instructions
+			for len(instrs) > 0 && instrs[0].Op == ir.Phi {
+				replace[instrs[0].Dest] = instrs[0].Args[0]
+				instrs = instrs[1:]
+			}
+			b.Instructions = append(b.Instructions[:len(b.Instructions)-1], instrs...)
+			replaceUses(f, replace)
+
+			for _, succ := range next.Successors() {
+				for i, pred := range preds[succ] {
+					if pred == next {
+						preds[succ][i] = b
+					}
+				}
+				for _, instr := range succ.Instructions {
+					if instr.Op != ir.Phi {
+						break
+					}
+					for i, from := range instr.Blocks {
+						if from == next {
+							instr.Blocks[i] = b
+						}
+					}
+				}
+			}
+			merged[next] = true
+		}
+	}
+
+	kept := []*ir.Block{}
+	for _, b := range f.Blocks {
+		if !merged[b] {
+			kept = append(kept, b)
+		}
+	}
+	f.Blocks = kept
+}
+
+// Remove the static functions that nothing calls or takes the address of,
+// other than themselves. They can only be used from this module.
+func (in *Inliner) removeUnused(m *ir.Module) {
+	for removed := true; removed; {
+		used := map[string]bool{}
+		for _, f := range m.Functions {
+			for _, b := range f.Blocks {
+				for _, instr := range b.Instructions {
+					for _, arg := range instr.Args {
+						if g, ok := arg.(*ir.Global); ok && g.Name != f.Name {
+							used[g.Name] = true
+						}
+					}
+				}
+			}
+		}
+
+		removed = false
+		kept := []*ir.Function{}
+		for _, f := range m.Functions {
+			if f.Static && !used[f.Name] {
+				in.report("removed unused function '%s'", f.Name)
+				removed = true
+				continue
+			}
+			kept = append(kept, f)
+		}
+		m.Functions = kept
+	}
+}
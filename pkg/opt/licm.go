@@ -0,0 +1,62 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// HoistInvariants moves the computations of loops whose operands don't
+// change while the loop runs to its preheader, so that they are done once.
+// Only instructions that can't trap or have side effects are moved, they
+// may end up being computed when the loop body wouldn't have run them.
+// Loads stay where they are, since the loop could store to the memory.
+func HoistInvariants(f *ir.Function) bool {
+	if f.OutOfSSA {
+		return false
+	}
+
+	changed := false
+	for _, l := range findLoops(f) {
+		defs := definitions(l)
+		for moved := true; moved; {
+			moved = false
+			for _, b := range l.ordered(f) {
+				kept := b.Instructions[:0]
+				for _, instr := range b.Instructions {
+					if !hoistable(instr) || !invariant(instr, defs) {
+						kept = append(kept, instr)
+						continue
+					}
+					insertBeforeTerminator(l.preheader, instr)
+					delete(defs, instr.Dest)
+					moved, changed = true, true
+				}
+				b.Instructions = kept
+			}
+		}
+	}
+	return changed
+}
+
+// Whether the instruction computes a value from its operands and nothing
+// else, without the chance of trapping
+func hoistable(instr *ir.Instruction) bool {
+	switch op := instr.Op; {
+	case op == ir.SDiv || op == ir.SRem || op == ir.UDiv || op == ir.URem:
+		// the quotient that doesn't fit traps like a division by zero
+		c, ok := instr.Args[1].(*ir.Const)
+		return ok && signed(c.Value, c.T) != 0 && signed(c.Value, c.T) != -1
+	case op.IsBinary() || op.IsComparison() || op.IsConversion():
+		return true
+	case op == ir.Neg || op == ir.Not || op == ir.Copy:
+		return true
+	}
+	return false
+}
+
+// Whether none of the operands are computed inside the loop
+func invariant(instr *ir.Instruction, defs map[*ir.Register]*ir.Instruction) bool {
+	for _, arg := range instr.Args {
+		if r, ok := arg.(*ir.Register); ok && defs[r] != nil {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package opt
+
+import (
+	"sort"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// A natural loop: the header, which dominates every block of the loop, and
+// the blocks that can reach one of the latches without going through it.
+// The latches are the blocks that jump back to the header.
+type loop struct {
+	header    *ir.Block
+	latches   []*ir.Block
+	blocks    map[*ir.Block]bool
+	preheader *ir.Block // the only block outside the loop that enters it
+}
+
+// The blocks of the loop in the order they are laid out
+func (l *loop) ordered(f *ir.Function) []*ir.Block {
+	blocks := []*ir.Block{}
+	for _, b := range f.Blocks {
+		if l.blocks[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// Find the natural loops of the function, inner loops before the loops that
+// contain them, and give each one a preheader. Loops that share a header
+// are treated as one. A loop around the entry block can't be entered from
+// anywhere, so it is left out.
+func findLoops(f *ir.Function) []*loop {
+	d := f.Dominators()
+	preds := f.Predecessors()
+
+	byHeader := map[*ir.Block]*loop{}
+	loops := []*loop{}
+	for _, b := range d.Blocks() {
+		for _, succ := range b.Successors() {
+			if !d.Dominates(succ, b) || succ == f.Entry() {
+				continue
+			}
+
+			l := byHeader[succ]
+			if l == nil {
+				l = &loop{header: succ, blocks: map[*ir.Block]bool{succ: true}}
+				byHeader[succ] = l
+				loops = append(loops, l)
+			}
+			l.latches = append(l.latches, b)
+
+			// walk backwards from the latch until the header
+			work := []*ir.Block{b}
+			for len(work) > 0 {
+				block := work[len(work)-1]
+				work = work[:len(work)-1]
+				if l.blocks[block] {
+					continue
+				}
+				l.blocks[block] = true
+				work = append(work, preds[block]...)
+			}
+		}
+	}
+
+	// a loop inside another one has fewer blocks
+	sort.SliceStable(loops, func(i, j int) bool {
+		return len(loops[i].blocks) < len(loops[j].blocks)
+	})
+	for _, l := range loops {
+		addPreheader(f, l, loops)
+	}
+	return loops
+}
+
+// Make sure the loop is entered from a single block which does nothing but
+// jump to the header, so that there is a place for the code that runs
+// before the loop. The new block is part of the loops around this one.
+func addPreheader(f *ir.Function, l *loop, loops []*loop) {
+	outside := []*ir.Block{}
+	for _, pred := range f.Predecessors()[l.header] {
+		if !l.blocks[pred] {
+			outside = append(outside, pred)
+		}
+	}
+	if len(outside) == 1 && len(outside[0].Successors()) == 1 {
+		l.preheader = outside[0]
+		return
+	}
+
+	p := f.NewBlock()
+	p.Instructions = []*ir.Instruction{{Op: ir.Jmp, Blocks: []*ir.Block{l.header}}}
+	for _, pred := range outside {
+		for i, target := range pred.Terminator().Blocks {
+			if target == l.header {
+				pred.Terminator().Blocks[i] = p
+			}
+		}
+	}
+
+	// the arguments of the header's phis from outside the loop are merged
+	// in the preheader
+	for _, instr := range l.header.Instructions {
+		if instr.Op != ir.Phi {
+			break
+		}
+
+		merged := &ir.Instruction{Op: ir.Phi, Dest: f.NewRegister(instr.Dest.T)}
+		args, from := []ir.Value{merged.Dest}, []*ir.Block{p}
+		for i, pred := range instr.Blocks {
+			if l.blocks[pred] {
+				args, from = append(args, instr.Args[i]), append(from, pred)
+			} else {
+				merged.Args = append(merged.Args, instr.Args[i])
+				merged.Blocks = append(merged.Blocks, pred)
+			}
+		}
+		instr.Args, instr.Blocks = args, from
+		insertBeforeTerminator(p, merged)
+	}
+
+	blocks := []*ir.Block{}
+	for _, b := range f.Blocks {
+		if b == l.header {
+			blocks = append(blocks, p)
+		}
+		blocks = append(blocks, b)
+	}
+	f.Blocks = blocks
+
+	for _, other := range loops {
+		if other != l && other.blocks[l.header] {
+			other.blocks[p] = true
+		}
+	}
+	l.preheader = p
+}
+
+// The registers that instructions of the loop assign
+func definitions(l *loop) map[*ir.Register]*ir.Instruction {
+	defs := map[*ir.Register]*ir.Instruction{}
+	for b := range l.blocks {
+		for _, instr := range b.Instructions {
+			if instr.Dest != nil {
+				defs[instr.Dest] = instr
+			}
+		}
+	}
+	return defs
+}
+
+// Insert instructions at the end of a block, before its terminator
+func insertBeforeTerminator(b *ir.Block, instrs ...*ir.Instruction) {
+	n := len(b.Instructions) - 1
+	term := b.Instructions[n]
+	b.Instructions = append(append(b.Instructions[:n], instrs...), term)
+}
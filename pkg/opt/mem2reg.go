@@ -0,0 +1,284 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// A stack slot that is only ever loaded and stored as a whole, which can
+// live in registers instead
+type variable struct {
+	alloca *ir.Instruction
+	t      ir.Type
+	stores []*ir.Block // the blocks that assign it
+}
+
+// PromoteAllocas turns the function into SSA form: stack slots whose address
+// is only used to load and store them are replaced by registers, with phis
+// where different assignments meet. This is the algorithm of Cytron et al.,
+// phis are placed on the iterated dominance frontiers of the stores and
+// loads are renamed with a walk over the dominator tree.
+func PromoteAllocas(f *ir.Function) bool {
+	changed := f.RemoveUnreachable()
+
+	vars := promotable(f)
+	if len(vars) == 0 {
+		return changed
+	}
+
+	d := f.Dominators()
+	preds := f.Predecessors()
+	phis := placePhis(f, d, preds, vars)
+
+	r := &renamer{
+		vars:    vars,
+		index:   map[*ir.Register]int{},
+		phis:    phis,
+		dom:     d,
+		replace: map[*ir.Register]ir.Value{},
+		dead:    map[*ir.Instruction]bool{},
+	}
+	for i, v := range vars {
+		r.index[v.alloca.Dest] = i
+	}
+	r.rename(f.Entry(), make([]ir.Value, len(vars)))
+
+	for _, v := range vars {
+		r.dead[v.alloca] = true
+	}
+	for _, b := range f.Blocks {
+		kept := b.Instructions[:0]
+		for _, instr := range b.Instructions {
+			if !r.dead[instr] {
+				kept = append(kept, instr)
+			}
+		}
+		b.Instructions = kept
+	}
+
+	replaceUses(f, r.replace)
+	removeDeadPhis(f, phis)
+	return true
+}
+
+// The stack slots that are only used as the address of loads and stores of
+// values of one type that fills the slot
+func promotable(f *ir.Function) []*variable {
+	vars := []*variable{}
+	byAlloca := map[*ir.Register]*variable{}
+	for _, instr := range f.Entry().Instructions {
+		if instr.Op == ir.Alloca {
+			v := &variable{alloca: instr}
+			vars = append(vars, v)
+			byAlloca[instr.Dest] = v
+		}
+	}
+
+	escaped := map[*variable]bool{}
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			for i, arg := range instr.Args {
+				r, ok := arg.(*ir.Register)
+				v := byAlloca[r]
+				if !ok || v == nil {
+					continue
+				}
+
+				var t ir.Type
+				switch {
+				case instr.Op == ir.Load:
+					t = instr.Dest.T
+				case instr.Op == ir.Store && i == 1:
+					t = instr.Args[0].Type()
+					if !contains(v.stores, b) {
+						v.stores = append(v.stores, b)
+					}
+				}
+
+				if t == ir.Void || t.Size() != v.alloca.Size || v.t != ir.Void && v.t != t {
+					escaped[v] = true
+				}
+				v.t = t
+			}
+		}
+	}
+
+	promoted := []*variable{}
+	for _, v := range vars {
+		if !escaped[v] {
+			promoted = append(promoted, v)
+		}
+	}
+	return promoted
+}
+
+func contains(blocks []*ir.Block, b *ir.Block) bool {
+	for _, x := range blocks {
+		if x == b {
+			return true
+		}
+	}
+	return false
+}
+
+// Insert empty phis for every variable at the iterated dominance frontier of
+// the blocks that store it, and return which variable each phi is for
+func placePhis(f *ir.Function, d *ir.DomTree, preds map[*ir.Block][]*ir.Block,
+	vars []*variable) map[*ir.Instruction]int {
+	frontiers := d.Frontiers()
+	phis := map[*ir.Instruction]int{}
+	for i, v := range vars {
+		placed := map[*ir.Block]bool{}
+		work := append([]*ir.Block{}, v.stores...)
+		for len(work) > 0 {
+			b := work[len(work)-1]
+			work = work[:len(work)-1]
+
+			for _, frontier := range frontiers[b] {
+				if placed[frontier] {
+					continue
+				}
+				placed[frontier] = true
+
+				phi := &ir.Instruction{Op: ir.Phi, Dest: f.NewRegister(v.t),
+					Args:   make([]ir.Value, len(preds[frontier])),
+					Blocks: append([]*ir.Block{}, preds[frontier]...)}
+				frontier.Instructions = append([]*ir.Instruction{phi},
+					frontier.Instructions...)
+				phis[phi] = i
+				work = append(work, frontier)
+			}
+		}
+	}
+	return phis
+}
+
+type renamer struct {
+	vars    []*variable
+	index   map[*ir.Register]int // of the variable in vars by its alloca
+	phis    map[*ir.Instruction]int
+	dom     *ir.DomTree
+	replace map[*ir.Register]ir.Value // the values of removed loads
+	dead    map[*ir.Instruction]bool  // loads and stores to remove
+}
+
+// Walk the dominator tree from b with the value of each variable at the
+// start of b. Loads before any store see an uninitialized variable, which
+// is given the value 0.
+func (r *renamer) rename(b *ir.Block, values []ir.Value) {
+	values = append([]ir.Value{}, values...)
+	value := func(i int) ir.Value {
+		if values[i] == nil {
+			return &ir.Const{Value: 0, T: r.vars[i].t}
+		}
+		return values[i]
+	}
+
+	for _, instr := range b.Instructions {
+		if i, ok := r.phis[instr]; ok {
+			values[i] = instr.Dest
+			continue
+		}
+
+		switch instr.Op {
+		case ir.Load:
+			if p, ok := instr.Args[0].(*ir.Register); ok {
+				if i, ok := r.index[p]; ok {
+					r.replace[instr.Dest] = value(i)
+					r.dead[instr] = true
+				}
+			}
+		case ir.Store:
+			if p, ok := instr.Args[1].(*ir.Register); ok {
+				if i, ok := r.index[p]; ok {
+					values[i] = instr.Args[0]
+					r.dead[instr] = true
+				}
+			}
+		}
+	}
+
+	for _, succ := range b.Successors() {
+		for _, instr := range succ.Instructions {
+			i, ok := r.phis[instr]
+			if !ok {
+				continue
+			}
+			for j, pred := range instr.Blocks {
+				if pred == b {
+					instr.Args[j] = value(i)
+				}
+			}
+		}
+	}
+
+	for _, child := range r.dom.Children(b) {
+		r.rename(child, values)
+	}
+}
+
+// Replace every use of a register in replace with its value, following
+// chains of replacements
+func replaceUses(f *ir.Function, replace map[*ir.Register]ir.Value) {
+	resolve := func(v ir.Value) ir.Value {
+		for {
+			r, ok := v.(*ir.Register)
+			if !ok || replace[r] == nil {
+				return v
+			}
+			v = replace[r]
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			for i, arg := range instr.Args {
+				instr.Args[i] = resolve(arg)
+			}
+		}
+	}
+}
+
+// Remove the phis that were placed but whose value is never used, other
+// than by phis that are themselves unused
+func removeDeadPhis(f *ir.Function, phis map[*ir.Instruction]int) {
+	byDest := map[*ir.Register]*ir.Instruction{}
+	for phi := range phis {
+		byDest[phi.Dest] = phi
+	}
+
+	live := map[*ir.Instruction]bool{}
+	work := []*ir.Instruction{}
+	use := func(arg ir.Value) {
+		if r, ok := arg.(*ir.Register); ok {
+			if phi := byDest[r]; phi != nil && !live[phi] {
+				live[phi] = true
+				work = append(work, phi)
+			}
+		}
+	}
+
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if _, ok := phis[instr]; !ok {
+				for _, arg := range instr.Args {
+					use(arg)
+				}
+			}
+		}
+	}
+	for len(work) > 0 {
+		phi := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, arg := range phi.Args {
+			use(arg)
+		}
+	}
+
+	for _, b := range f.Blocks {
+		kept := b.Instructions[:0]
+		for _, instr := range b.Instructions {
+			if _, ok := phis[instr]; !ok || live[instr] {
+				kept = append(kept, instr)
+			}
+		}
+		b.Instructions = kept
+	}
+}
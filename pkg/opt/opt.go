@@ -0,0 +1,147 @@
+// Package opt transforms the IR of functions between lowering and code
+// generation. Every transformation is a Pass, and a Manager runs a pipeline
+// of them, checking that the IR is still well formed after each one.
+package opt
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+)
+
+// A Pass transforms a function in place and reports whether it changed
+// anything
+type Pass struct {
+	Name string
+	Run  func(f *ir.Function) bool
+}
+
+var (
+	Mem2Reg   = Pass{"mem2reg", PromoteAllocas}
+	ConstFold = Pass{"constfold", FoldConstants}
+	DCE       = Pass{"dce", EliminateDeadCode}
+	LICM      = Pass{"licm", HoistInvariants}
+	Reduce    = Pass{"loop-reduce", ReduceStrength}
+	Unroll    = Pass{"loop-unroll", UnrollLoops}
+	TailCalls = Pass{"tailcall", MarkTailCalls}
+	OutOfSSA  = Pass{"out-of-ssa", DestructSSA}
+)
+
+type Manager struct {
+	Passes []Pass
+
+	// Inlines calls between the functions of a module in RunModule, once
+	// Passes ran over all of them. The callers it changes go through Passes
+	// again. Nil to not inline.
+	Inliner *Inliner
+
+	// The passes that run last, like taking the function out of SSA form,
+	// which the inliner needs
+	Late []Pass
+
+	// If set, the function is printed here after every pass, even the ones
+	// that changed nothing
+	PrintAfterAll io.Writer
+}
+
+// The pipeline for an optimization level. At -O0 the IR goes straight to
+// code generation, -O1 puts variables in registers, folds the constants that
+// become visible, removes the code that turns out to be dead, hoists
+// invariant code out of loops and finds the calls in tail position, and -O2
+// is the place for the more expensive passes, like unrolling loops and
+// reducing the strength of their multiplications, which are followed by
+// another round of folding.
+func NewPipeline(level int) *Manager {
+	m := &Manager{}
+	if level > 0 {
+		m.Passes = append(m.Passes, Mem2Reg, ConstFold, DCE, LICM)
+		m.Inliner = &Inliner{Limit: DefaultInlineLimit}
+	}
+	if level > 1 {
+		m.Passes = append(m.Passes, Unroll, ConstFold, Reduce, DCE)
+	}
+
+	// after everything that could change what is in tail position
+	if level > 0 {
+		m.Passes = append(m.Passes, TailCalls)
+	}
+
+	// the code generator doesn't know about phis
+	if len(m.Passes) > 0 {
+		m.Late = append(m.Late, OutOfSSA)
+	}
+	return m
+}
+
+// A pass left behind a function that doesn't verify
+type Error struct {
+	Function *ir.Function
+	Pass     string
+	Err      error
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("after %s: %s", e.Pass, e.Err) }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Run the passes over the function in order, followed by the late ones. A
+// pass that leaves behind a function that doesn't verify is a bug, the
+// error says which one it was.
+func (m *Manager) Run(f *ir.Function) error {
+	if err := m.run(f, m.Passes); err != nil {
+		return err
+	}
+	return m.run(f, m.Late)
+}
+
+// RunModule runs the pipeline over every function of the module. The
+// inliner runs between the passes and the late passes, so that it sees the
+// functions it copies in their optimized form, and the callers it changes
+// are optimized again.
+func (m *Manager) RunModule(mod *ir.Module) error {
+	for _, f := range mod.Functions {
+		if err := m.run(f, m.Passes); err != nil {
+			return err
+		}
+	}
+
+	if m.Inliner != nil {
+		for _, f := range m.Inliner.Run(mod) {
+			if err := m.check(f, "inline"); err != nil {
+				return err
+			} else if err := m.run(f, m.Passes); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range mod.Functions {
+		if err := m.run(f, m.Late); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Manager) run(f *ir.Function, passes []Pass) error {
+	for _, pass := range passes {
+		pass.Run(f)
+		if err := m.check(f, pass.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Print the function after a pass if asked to, and verify it
+func (m *Manager) check(f *ir.Function, pass string) error {
+	if m.PrintAfterAll != nil {
+		fmt.Fprintf(m.PrintAfterAll, "*** IR Dump After %s (%s) ***\n%s\n",
+			pass, f.Name, f)
+	}
+
+	if err := f.Verify(); err != nil {
+		return &Error{Function: f, Pass: pass, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,746 @@
+package opt_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ir"
+	"github.com/tjarjoura/cc/pkg/opt"
+)
+
+func i32(n int64) *ir.Const { return &ir.Const{Value: n, T: ir.I32} }
+
+// int max(int a, int b) { int r; if (a > b) r = a; else r = b; return r; }
+func buildMax() *ir.Function {
+	f := ir.NewFunction("max", ir.I32, []ir.Type{ir.I32, ir.I32}, false)
+	b := ir.NewBuilder(f)
+	a, c := f.Params[0], f.Params[1]
+
+	r := b.Alloca(4, 4)
+	then, els, end := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Br(b.Compare(ir.SGt, a, c), then, els)
+
+	b.StartBlock(then)
+	b.Store(a, r)
+	b.Jmp(end)
+
+	b.StartBlock(els)
+	b.Store(c, r)
+	b.Jmp(end)
+
+	b.StartBlock(end)
+	b.Ret(b.Load(ir.I32, r))
+	return f
+}
+
+// int sum(int n) { int s = 0; int i = 0; while (i < n) { s += i; i++; }
+// use(&s); return i; }
+func buildSum() *ir.Function {
+	f := ir.NewFunction("sum", ir.I32, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	s, i := b.Alloca(4, 4), b.Alloca(4, 4)
+	b.Store(i32(0), s)
+	b.Store(i32(0), i)
+
+	cond, body, end := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Jmp(cond)
+
+	b.StartBlock(cond)
+	b.Br(b.Compare(ir.SLt, b.Load(ir.I32, i), f.Params[0]), body, end)
+
+	b.StartBlock(body)
+	b.Store(b.Binary(ir.Add, b.Load(ir.I32, s), b.Load(ir.I32, i)), s)
+	b.Store(b.Binary(ir.Add, b.Load(ir.I32, i), i32(1)), i)
+	b.Jmp(cond)
+
+	b.StartBlock(end)
+	b.Call(ir.Void, &ir.Global{Name: "use"}, []ir.Value{s}, false)
+	b.Ret(b.Load(ir.I32, i))
+	return f
+}
+
+func run(t *testing.T, f *ir.Function, passes ...opt.Pass) {
+	t.Helper()
+	m := &opt.Manager{Passes: passes}
+	if err := m.Run(f); err != nil {
+		t.Fatalf("%s", err)
+	}
+}
+
+func TestPromoteAllocas(t *testing.T) {
+	tests := []struct {
+		f        *ir.Function
+		expected string
+	}{
+		{
+			buildMax(),
+			`function i32 @max(i32 %1, i32 %2) {
+L1:
+	%4 = sgt i32 %1, %2
+	br %4, L2, L3
+L2:
+	jmp L4
+L3:
+	jmp L4
+L4:
+	%6 = phi i32 [%1, L2], [%2, L3]
+	ret i32 %6
+}
+`,
+		},
+		{
+			// s escapes through the call, so only i is promoted
+			buildSum(),
+			`function i32 @sum(i32 %1) {
+L1:
+	%2 = alloca 4, align 4
+	store i32 0, %2
+	jmp L2
+L2:
+	%12 = phi i32 [0, L1], [%10, L3]
+	%5 = slt i32 %12, %1
+	br %5, L3, L4
+L3:
+	%6 = load i32 %2
+	%8 = add i32 %6, %12
+	store i32 %8, %2
+	%10 = add i32 %12, 1
+	jmp L2
+L4:
+	call void @use(ptr %2)
+	ret i32 %12
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		run(t, tt.f, opt.Mem2Reg)
+		if actual := tt.f.String(); actual != tt.expected {
+			t.Errorf("expected\n%s\ngot\n%s", tt.expected, actual)
+		}
+	}
+}
+
+func TestPromoteUninitialized(t *testing.T) {
+	f := ir.NewFunction("f", ir.I64, nil, false)
+	b := ir.NewBuilder(f)
+	b.Ret(b.Load(ir.I64, b.Alloca(8, 8)))
+
+	run(t, f, opt.Mem2Reg)
+	if ret := f.Entry().Instructions; len(ret) != 1 || ret[0].String() != "ret i64 0" {
+		t.Errorf("expected an uninitialized variable to be 0, got\n%s", f)
+	}
+}
+
+func TestDestructSSA(t *testing.T) {
+	// a loop that swaps a and b on every iteration
+	f := ir.NewFunction("swap", ir.I32, []ir.Type{ir.I32, ir.I32, ir.I32}, false)
+	b := ir.NewBuilder(f)
+	loop, end := f.NewBlock(), f.NewBlock()
+	b.Jmp(loop)
+
+	b.StartBlock(loop)
+	a, c := f.NewRegister(ir.I32), f.NewRegister(ir.I32)
+	loop.Instructions = append(loop.Instructions,
+		&ir.Instruction{Op: ir.Phi, Dest: a, Args: []ir.Value{f.Params[0], c},
+			Blocks: []*ir.Block{f.Entry(), loop}},
+		&ir.Instruction{Op: ir.Phi, Dest: c, Args: []ir.Value{f.Params[1], a},
+			Blocks: []*ir.Block{f.Entry(), loop}})
+	b.Br(f.Params[2], loop, end)
+
+	b.StartBlock(end)
+	b.Ret(b.Binary(ir.Sub, a, c))
+
+	run(t, f, opt.OutOfSSA)
+	expected := `function i32 @swap(i32 %1, i32 %2, i32 %3) {
+L1:
+	%7 = copy i32 %1
+	%8 = copy i32 %2
+	jmp L2
+L2:
+	%4 = copy i32 %7
+	%5 = copy i32 %8
+	%7 = copy i32 %5
+	%8 = copy i32 %4
+	br %3, L2, L3
+L3:
+	%6 = sub i32 %4, %5
+	ret i32 %6
+}
+`
+	if actual := f.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+	if !f.OutOfSSA {
+		t.Errorf("expected the function to be marked as out of SSA form")
+	}
+}
+
+func TestPrintAfterAll(t *testing.T) {
+	var out strings.Builder
+	m := opt.NewPipeline(1)
+	m.PrintAfterAll = &out
+	if err := m.Run(buildMax()); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, pass := range append(m.Passes, m.Late...) {
+		header := "*** IR Dump After " + pass.Name + " (max) ***\n"
+		if !strings.Contains(out.String(), header) {
+			t.Errorf("expected %q in\n%s", header, out.String())
+		}
+	}
+	if m := opt.NewPipeline(0); len(m.Passes) != 0 || len(m.Late) != 0 || m.Inliner != nil {
+		t.Errorf("expected no passes at -O0")
+	}
+}
+
+func TestBrokenPass(t *testing.T) {
+	broken := opt.Pass{Name: "broken", Run: func(f *ir.Function) bool {
+		f.Blocks = f.Blocks[:1]
+		return true
+	}}
+	m := &opt.Manager{Passes: []opt.Pass{broken}}
+	err := m.Run(buildMax())
+	if err == nil || !strings.HasPrefix(err.Error(), "after broken: ") {
+		t.Errorf("expected the pass to be blamed, got %v", err)
+	}
+}
+
+func TestFoldConstants(t *testing.T) {
+	i8 := func(n int64) *ir.Const { return &ir.Const{Value: n, T: ir.I8} }
+	tests := []struct {
+		build    func(b *ir.Builder) ir.Value
+		expected string // the return instruction after folding
+	}{
+		{func(b *ir.Builder) ir.Value {
+			return b.Binary(ir.Mul, b.Binary(ir.Add, i32(2), i32(3)), i32(4))
+		}, "ret i32 20"},
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.Add, i32(2147483647), i32(1)) }, "ret i32 -2147483648"},
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.UDiv, i32(-1), i32(2)) }, "ret i32 2147483647"},
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.SDiv, i32(-7), i32(2)) }, "ret i32 -3"},
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.LShr, i32(-1), i32(28)) }, "ret i32 15"},
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.AShr, i32(-16), i32(2)) }, "ret i32 -4"},
+		{func(b *ir.Builder) ir.Value { return b.Compare(ir.ULt, i32(1), i32(-1)) }, "ret i32 1"},
+		{func(b *ir.Builder) ir.Value { return b.Compare(ir.SLt, i32(1), i32(-1)) }, "ret i32 0"},
+		{func(b *ir.Builder) ir.Value { return b.Unary(ir.Not, i32(0)) }, "ret i32 -1"},
+		{func(b *ir.Builder) ir.Value { return b.Convert(ir.ZExt, i8(-1), ir.I32) }, "ret i32 255"},
+		{func(b *ir.Builder) ir.Value { return b.Convert(ir.SExt, i8(-1), ir.I32) }, "ret i32 -1"},
+		{func(b *ir.Builder) ir.Value {
+			return b.Convert(ir.ZExt, b.Convert(ir.Trunc, i32(300), ir.I8), ir.I32)
+		}, "ret i32 44"},
+		// undefined at runtime, so left alone
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.SDiv, i32(1), i32(0)) }, "ret i32 %1"},
+		{func(b *ir.Builder) ir.Value { return b.Binary(ir.Shl, i32(1), i32(32)) }, "ret i32 %1"},
+	}
+
+	for _, tt := range tests {
+		f := ir.NewFunction("f", ir.I32, nil, false)
+		b := ir.NewBuilder(f)
+		b.Ret(tt.build(b))
+
+		run(t, f, opt.ConstFold)
+		instrs := f.Entry().Instructions
+		if actual := instrs[len(instrs)-1].String(); actual != tt.expected {
+			t.Errorf("expected %q, got\n%s", tt.expected, f)
+		}
+	}
+}
+
+func TestFoldBranches(t *testing.T) {
+	// int f(int n) { int r; if (2 > 1) r = 1; else r = n; return r; }
+	f := ir.NewFunction("f", ir.I32, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	then, els, end := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Br(b.Compare(ir.SGt, i32(2), i32(1)), then, els)
+
+	b.StartBlock(then)
+	b.Jmp(end)
+	b.StartBlock(els)
+	b.Jmp(end)
+
+	b.StartBlock(end)
+	r := f.NewRegister(ir.I32)
+	end.Instructions = append(end.Instructions, &ir.Instruction{Op: ir.Phi, Dest: r,
+		Args: []ir.Value{i32(1), f.Params[0]}, Blocks: []*ir.Block{then, els}})
+	b.Ret(r)
+
+	run(t, f, opt.ConstFold)
+	expected := `function i32 @f(i32 %1) {
+L1:
+	jmp L2
+L2:
+	jmp L4
+L4:
+	ret i32 1
+}
+`
+	if actual := f.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+func TestEliminateDeadCode(t *testing.T) {
+	f := ir.NewFunction("f", ir.I32, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	n := f.Params[0]
+
+	// an array that is only written, and a variable whose address escapes
+	a, v := b.Alloca(16, 4), b.Alloca(4, 4)
+	b.Store(n, a)
+	b.Store(n, b.Binary(ir.Add, a, &ir.Const{Value: 8, T: ir.I64}))
+	b.Store(n, v)
+	b.Binary(ir.Mul, b.Load(ir.I32, v), i32(2))
+	b.Call(ir.Void, &ir.Global{Name: "use"}, []ir.Value{v}, false)
+	b.Ret(n)
+
+	// the code after the return
+	b.Ret(b.Binary(ir.Add, n, i32(1)))
+
+	run(t, f, opt.DCE)
+	expected := `function i32 @f(i32 %1) {
+L1:
+	%3 = alloca 4, align 4
+	store i32 %1, %3
+	call void @use(ptr %3)
+	ret i32 %1
+}
+`
+	if actual := f.String(); actual != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, actual)
+	}
+}
+
+// static int clamp(int x) { if (x < 0) return 0; return x; }
+func buildClamp() *ir.Function {
+	f := ir.NewFunction("clamp", ir.I32, []ir.Type{ir.I32}, false)
+	f.Static = true
+	b := ir.NewBuilder(f)
+	neg, pos := f.NewBlock(), f.NewBlock()
+	b.Br(b.Compare(ir.SLt, f.Params[0], i32(0)), neg, pos)
+
+	b.StartBlock(neg)
+	b.Ret(i32(0))
+
+	b.StartBlock(pos)
+	b.Ret(f.Params[0])
+	return f
+}
+
+// int name(int a) { return callee(a) + 1; }
+func buildCaller(name string, callee string) *ir.Function {
+	f := ir.NewFunction(name, ir.I32, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	r := b.Call(ir.I32, &ir.Global{Name: callee}, []ir.Value{f.Params[0]}, false)
+	b.Ret(b.Binary(ir.Add, r, i32(1)))
+	return f
+}
+
+func TestInline(t *testing.T) {
+	tests := []struct {
+		name      string
+		functions []*ir.Function
+		limit     int
+		report    string
+		expected  string // the module after inlining
+	}{
+		{
+			"inlined",
+			[]*ir.Function{buildClamp(), buildCaller("f", "clamp")},
+			opt.DefaultInlineLimit,
+			`inlined 'clamp' into 'f' (cost 2, threshold 40)
+removed unused function 'clamp'
+`,
+			`function i32 @f(i32 %1) {
+L1:
+	%4 = slt i32 %1, 0
+	br %4, L4, L5
+L4:
+	jmp L2
+L5:
+	jmp L2
+L2:
+	%2 = phi i32 [0, L4], [%1, L5]
+	%3 = add i32 %2, 1
+	ret i32 %3
+}
+`,
+		},
+		{
+			"over the limit",
+			[]*ir.Function{buildClamp(), buildCaller("f", "clamp")},
+			1,
+			"not inlining 'clamp' into 'f': cost 2 exceeds the threshold 1\n",
+			"",
+		},
+		{
+			"called from two places",
+			[]*ir.Function{buildClamp(), buildCaller("f", "clamp"), buildCaller("g", "clamp")},
+			4,
+			`not inlining 'clamp' into 'f': cost 2 exceeds the threshold 1
+not inlining 'clamp' into 'g': cost 2 exceeds the threshold 1
+`,
+			"",
+		},
+		{
+			"not static",
+			[]*ir.Function{buildCaller("g", "f"), buildCaller("f", "g")},
+			opt.DefaultInlineLimit,
+			`not inlining 'g' into 'f': it is neither static nor inline
+not inlining 'f' into 'g': it is neither static nor inline
+`,
+			"",
+		},
+		{
+			"recursive",
+			func() []*ir.Function {
+				f, g := buildCaller("f", "g"), buildCaller("g", "f")
+				f.Static, g.Static = true, true
+				return []*ir.Function{f, g, buildCaller("main", "f")}
+			}(),
+			opt.DefaultInlineLimit,
+			`not inlining 'f' into 'g': it is recursive
+not inlining 'g' into 'f': it is recursive
+inlined 'f' into 'main' (cost 2, threshold 10)
+`,
+			"",
+		},
+	}
+
+	for _, tt := range tests {
+		var report strings.Builder
+		m := &ir.Module{Functions: tt.functions}
+		in := &opt.Inliner{Limit: tt.limit, Report: &report}
+		in.Run(m)
+
+		if report.String() != tt.report {
+			t.Errorf("%s: expected the report\n%s\ngot\n%s", tt.name, tt.report, report.String())
+		}
+		for _, f := range m.Functions {
+			if err := f.Verify(); err != nil {
+				t.Errorf("%s: %s", tt.name, err)
+			}
+		}
+		if actual := m.String(); tt.expected != "" && actual != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+// int s = 0; for (int i = 0; i < bound; i++) body; return s; with both
+// variables in stack slots
+func buildLoop(f *ir.Function, bound ir.Value,
+	body func(b *ir.Builder, i ir.Value, s *ir.Register)) *ir.Function {
+	b := ir.NewBuilder(f)
+	s, i := b.Alloca(4, 4), b.Alloca(4, 4)
+	b.Store(i32(0), s)
+	b.Store(i32(0), i)
+
+	cond, loop, end := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Jmp(cond)
+
+	b.StartBlock(cond)
+	b.Br(b.Compare(ir.SLt, b.Load(ir.I32, i), bound), loop, end)
+
+	b.StartBlock(loop)
+	body(b, b.Load(ir.I32, i), s)
+	b.Store(b.Binary(ir.Add, b.Load(ir.I32, i), i32(1)), i)
+	b.Jmp(cond)
+
+	b.StartBlock(end)
+	b.Ret(b.Load(ir.I32, s))
+	return f
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        *ir.Function
+		passes   []opt.Pass
+		expected string
+	}{
+		{
+			// s += a * b
+			"hoist",
+			func() *ir.Function {
+				f := ir.NewFunction("f", ir.I32, []ir.Type{ir.I32, ir.I32, ir.I32}, false)
+				return buildLoop(f, f.Params[0], func(b *ir.Builder, i ir.Value, s *ir.Register) {
+					ab := b.Binary(ir.Mul, f.Params[1], f.Params[2])
+					b.Store(b.Binary(ir.Add, b.Load(ir.I32, s), ab), s)
+				})
+			}(),
+			[]opt.Pass{opt.Mem2Reg, opt.LICM},
+			`function i32 @f(i32 %1, i32 %2, i32 %3) {
+L1:
+	%9 = mul i32 %2, %3
+	jmp L2
+L2:
+	%16 = phi i32 [0, L1], [%13, L3]
+	%15 = phi i32 [0, L1], [%11, L3]
+	%7 = slt i32 %16, %1
+	br %7, L3, L4
+L3:
+	%11 = add i32 %15, %9
+	%13 = add i32 %16, 1
+	jmp L2
+L4:
+	ret i32 %15
+}
+`,
+		},
+		{
+			// a loop entered from two places gets a preheader that merges
+			// the initial values of its phis
+			"preheader",
+			func() *ir.Function {
+				f := ir.NewFunction("f", ir.I32, []ir.Type{ir.I32, ir.I32, ir.I32}, false)
+				b := ir.NewBuilder(f)
+				then, els, header, body, end := f.NewBlock(), f.NewBlock(), f.NewBlock(),
+					f.NewBlock(), f.NewBlock()
+				b.Br(f.Params[0], then, els)
+				b.StartBlock(then)
+				b.Jmp(header)
+				b.StartBlock(els)
+				b.Jmp(header)
+
+				b.StartBlock(header)
+				i, next := f.NewRegister(ir.I32), f.NewRegister(ir.I32)
+				header.Instructions = append(header.Instructions, &ir.Instruction{Op: ir.Phi,
+					Dest: i, Args: []ir.Value{i32(0), i32(1), next},
+					Blocks: []*ir.Block{then, els, body}})
+				b.Br(b.Compare(ir.SLt, i, f.Params[1]), body, end)
+
+				b.StartBlock(body)
+				body.Instructions = append(body.Instructions, &ir.Instruction{Op: ir.Add,
+					Dest: next, Args: []ir.Value{i, b.Binary(ir.Add, f.Params[2], i32(7))}})
+				b.Jmp(header)
+
+				b.StartBlock(end)
+				b.Ret(i)
+				return f
+			}(),
+			[]opt.Pass{opt.LICM},
+			`function i32 @f(i32 %1, i32 %2, i32 %3) {
+L1:
+	br %1, L2, L3
+L2:
+	jmp L7
+L3:
+	jmp L7
+L7:
+	%8 = phi i32 [0, L2], [1, L3]
+	%7 = add i32 %3, 7
+	jmp L4
+L4:
+	%4 = phi i32 [%8, L7], [%5, L5]
+	%6 = slt i32 %4, %2
+	br %6, L5, L6
+L5:
+	%5 = add i32 %4, %7
+	jmp L4
+L6:
+	ret i32 %4
+}
+`,
+		},
+		{
+			// s += p[i]
+			"reduce",
+			func() *ir.Function {
+				f := ir.NewFunction("f", ir.I32, []ir.Type{ir.Ptr, ir.I32}, false)
+				return buildLoop(f, f.Params[1], func(b *ir.Builder, i ir.Value, s *ir.Register) {
+					offset := b.Binary(ir.Mul, b.Convert(ir.SExt, i, ir.I64), &ir.Const{Value: 4, T: ir.I64})
+					x := b.Load(ir.I32, b.Binary(ir.Add, f.Params[0], offset))
+					b.Store(b.Binary(ir.Add, b.Load(ir.I32, s), x), s)
+				})
+			}(),
+			[]opt.Pass{opt.Mem2Reg, opt.Reduce, opt.ConstFold, opt.DCE},
+			`function i32 @f(ptr %1, i32 %2) {
+L1:
+	jmp L2
+L2:
+	%21 = phi i64 [0, L1], [%22, L3]
+	%18 = phi i32 [0, L1], [%15, L3]
+	%17 = phi i32 [0, L1], [%13, L3]
+	%6 = slt i32 %18, %2
+	br %6, L3, L4
+L3:
+	%10 = add ptr %1, %21
+	%11 = load i32 %10
+	%13 = add i32 %17, %11
+	%15 = add i32 %18, 1
+	%22 = add i64 %21, 4
+	jmp L2
+L4:
+	ret i32 %17
+}
+`,
+		},
+		{
+			// s += i * 3
+			"unroll",
+			func() *ir.Function {
+				f := ir.NewFunction("f", ir.I32, nil, false)
+				return buildLoop(f, i32(4), func(b *ir.Builder, i ir.Value, s *ir.Register) {
+					b.Store(b.Binary(ir.Add, b.Load(ir.I32, s), b.Binary(ir.Mul, i, i32(3))), s)
+				})
+			}(),
+			[]opt.Pass{opt.Mem2Reg, opt.Unroll},
+			`function i32 @f() {
+L1:
+	%14 = slt i32 0, 4
+	%15 = mul i32 0, 3
+	%16 = add i32 0, %15
+	%17 = add i32 0, 1
+	%18 = slt i32 %17, 4
+	%19 = mul i32 %17, 3
+	%20 = add i32 %16, %19
+	%21 = add i32 %17, 1
+	%22 = slt i32 %21, 4
+	%23 = mul i32 %21, 3
+	%24 = add i32 %20, %23
+	%25 = add i32 %21, 1
+	%26 = slt i32 %25, 4
+	%27 = mul i32 %25, 3
+	%28 = add i32 %24, %27
+	%29 = add i32 %25, 1
+	%30 = slt i32 %29, 4
+	ret i32 %28
+}
+`,
+		},
+		{
+			"unroll and fold",
+			func() *ir.Function {
+				f := ir.NewFunction("f", ir.I32, nil, false)
+				return buildLoop(f, i32(4), func(b *ir.Builder, i ir.Value, s *ir.Register) {
+					b.Store(b.Binary(ir.Add, b.Load(ir.I32, s), b.Binary(ir.Mul, i, i32(3))), s)
+				})
+			}(),
+			[]opt.Pass{opt.Mem2Reg, opt.Unroll, opt.ConstFold, opt.DCE},
+			`function i32 @f() {
+L1:
+	ret i32 18
+}
+`,
+		},
+		{
+			"too many iterations to unroll",
+			func() *ir.Function {
+				f := ir.NewFunction("f", ir.I32, nil, false)
+				return buildLoop(f, i32(100), func(b *ir.Builder, i ir.Value, s *ir.Register) {
+					b.Store(b.Binary(ir.Add, b.Load(ir.I32, s), i), s)
+				})
+			}(),
+			[]opt.Pass{opt.Mem2Reg, opt.Unroll},
+			`function i32 @f() {
+L1:
+	jmp L2
+L2:
+	%12 = phi i32 [0, L1], [%9, L3]
+	%11 = phi i32 [0, L1], [%7, L3]
+	%4 = slt i32 %12, 100
+	br %4, L3, L4
+L3:
+	%7 = add i32 %11, %12
+	%9 = add i32 %12, 1
+	jmp L2
+L4:
+	ret i32 %11
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		run(t, tt.f, tt.passes...)
+		if actual := tt.f.String(); actual != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.expected, actual)
+		}
+	}
+}
+
+// int count(int n) { int r; if (n > 0) r = count(n - 1); else r = 0;
+// return r; }, which passes the address of another variable to use first if
+// escape is set
+func buildCount(escape bool) *ir.Function {
+	f := ir.NewFunction("count", ir.I32, []ir.Type{ir.I32}, false)
+	b := ir.NewBuilder(f)
+	r := b.Alloca(4, 4)
+	if escape {
+		b.Call(ir.Void, &ir.Global{Name: "use"}, []ir.Value{b.Alloca(4, 4)}, false)
+	}
+
+	then, els, end := f.NewBlock(), f.NewBlock(), f.NewBlock()
+	b.Br(b.Compare(ir.SGt, f.Params[0], i32(0)), then, els)
+
+	b.StartBlock(then)
+	n := b.Binary(ir.Sub, f.Params[0], i32(1))
+	b.Store(b.Call(ir.I32, &ir.Global{Name: "count"}, []ir.Value{n}, false), r)
+	b.Jmp(end)
+
+	b.StartBlock(els)
+	b.Store(i32(0), r)
+	b.Jmp(end)
+
+	b.StartBlock(end)
+	b.Ret(b.Load(ir.I32, r))
+	return f
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		f        *ir.Function
+		expected string
+	}{
+		{
+			"returned through a phi",
+			buildCount(false),
+			`function i32 @count(i32 %1) {
+L1:
+	%3 = sgt i32 %1, 0
+	br %3, L2, L3
+L2:
+	%4 = sub i32 %1, 1
+	%5 = tail call i32 @count(i32 %4)
+	ret i32 %5
+L3:
+	jmp L4
+L4:
+	%7 = phi i32 [0, L3]
+	ret i32 %7
+}
+`,
+		},
+		{
+			"escaping stack slot",
+			buildCount(true),
+			`function i32 @count(i32 %1) {
+L1:
+	%3 = alloca 4, align 4
+	call void @use(ptr %3)
+	%4 = sgt i32 %1, 0
+	br %4, L2, L3
+L2:
+	%5 = sub i32 %1, 1
+	%6 = call i32 @count(i32 %5)
+	ret i32 %6
+L3:
+	jmp L4
+L4:
+	%8 = phi i32 [0, L3]
+	ret i32 %8
+}
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		run(t, tt.f, opt.Mem2Reg, opt.TailCalls)
+		if actual := tt.f.String(); actual != tt.expected {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, tt.expected, actual)
+		}
+	}
+}
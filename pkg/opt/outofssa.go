@@ -0,0 +1,35 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// DestructSSA replaces the phis of the function with copies, so that the
+// code generator never sees one. Every phi gets a register of its own that
+// each predecessor copies its argument into just before branching, and the
+// phi becomes a copy of that register. Because the copies in a predecessor
+// all happen before any phi is replaced, phis that swap values or that sit
+// on critical edges keep their meaning.
+func DestructSSA(f *ir.Function) bool {
+	changed := false
+	for _, b := range f.Blocks {
+		for i, phi := range b.Instructions {
+			if phi.Op != ir.Phi {
+				break
+			}
+
+			tmp := f.NewRegister(phi.Dest.T)
+			for j, pred := range phi.Blocks {
+				c := &ir.Instruction{Op: ir.Copy, Dest: tmp, Args: []ir.Value{phi.Args[j]}}
+				n := len(pred.Instructions) - 1
+				pred.Instructions = append(pred.Instructions[:n],
+					c, pred.Instructions[n])
+			}
+
+			b.Instructions[i] = &ir.Instruction{Op: ir.Copy, Dest: phi.Dest,
+				Args: []ir.Value{tmp}}
+			changed = true
+		}
+	}
+
+	f.OutOfSSA = true
+	return changed
+}
@@ -0,0 +1,167 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// ReduceStrength replaces the multiplications of induction variables by
+// constants inside loops, like the scaling of an array index, with a
+// variable of their own that the latch increases by the product of the
+// step and the constant. An index that is sign extended before it is
+// scaled only keeps following its product if it doesn't overflow, which C
+// leaves undefined for signed integers.
+func ReduceStrength(f *ir.Function) bool {
+	if f.OutOfSSA {
+		return false
+	}
+
+	changed := false
+	for _, l := range findLoops(f) {
+		if len(l.latches) != 1 {
+			continue
+		}
+		latch := l.latches[0]
+		defs := definitions(l)
+
+		type scaledBy struct {
+			iv     *ir.Register
+			t      ir.Type
+			factor int64
+		}
+		reduced := map[scaledBy]*ir.Register{}
+		replace := map[*ir.Register]ir.Value{}
+
+		dead := map[*ir.Instruction]bool{}
+		for _, b := range l.ordered(f) {
+			for _, instr := range b.Instructions {
+				iv, factor, ok := scaledInduction(instr, defs)
+				if !ok {
+					continue
+				}
+				init, step, ok := induction(defs[iv], l, defs)
+				if !ok {
+					continue
+				}
+
+				t := instr.Dest.T
+				key := scaledBy{iv, t, factor}
+				if reduced[key] == nil {
+					reduced[key] = addScaled(f, l, latch, init, step, t, factor)
+				}
+				replace[instr.Dest] = reduced[key]
+				dead[instr] = true
+			}
+		}
+
+		for _, b := range l.ordered(f) {
+			kept := b.Instructions[:0]
+			for _, instr := range b.Instructions {
+				if !dead[instr] {
+					kept = append(kept, instr)
+				}
+			}
+			b.Instructions = kept
+		}
+		changed = changed || len(dead) > 0
+		replaceUses(f, replace)
+	}
+	return changed
+}
+
+// The initial value and the step of an induction variable, a phi of the
+// header that the latch increases or decreases by a constant
+func induction(phi *ir.Instruction, l *loop, defs map[*ir.Register]*ir.Instruction) (
+	ir.Value, int64, bool) {
+	if phi.Op != ir.Phi || len(l.latches) != 1 || len(phi.Args) != 2 {
+		return nil, 0, false
+	}
+
+	init, next := phi.Args[0], phi.Args[1]
+	if phi.Blocks[0] == l.latches[0] {
+		init, next = next, init
+	}
+	if !contains(phi.Blocks, l.preheader) || !contains(phi.Blocks, l.latches[0]) {
+		return nil, 0, false
+	}
+	r, ok := next.(*ir.Register)
+	if !ok {
+		return nil, 0, false
+	}
+
+	def := defs[r]
+	if def == nil || def.Op != ir.Add && def.Op != ir.Sub {
+		return nil, 0, false
+	}
+	x, c := def.Args[0], def.Args[1]
+	if def.Op == ir.Add && c == phi.Dest {
+		x, c = c, x
+	}
+	step, ok := c.(*ir.Const)
+	if x != phi.Dest || !ok {
+		return nil, 0, false
+	} else if def.Op == ir.Sub {
+		return init, -signed(step.Value, step.T), true
+	}
+	return init, signed(step.Value, step.T), true
+}
+
+// Whether the instruction multiplies a phi of the loop, or a sign extension
+// of it, by a constant, and by what
+func scaledInduction(instr *ir.Instruction, defs map[*ir.Register]*ir.Instruction) (
+	*ir.Register, int64, bool) {
+	if instr.Op != ir.Mul && instr.Op != ir.Shl {
+		return nil, 0, false
+	}
+
+	x, c := instr.Args[0], instr.Args[1]
+	if _, ok := x.(*ir.Const); ok && instr.Op == ir.Mul {
+		x, c = c, x
+	}
+	k, ok := c.(*ir.Const)
+	if !ok {
+		return nil, 0, false
+	}
+	factor := signed(k.Value, k.T)
+	if instr.Op == ir.Shl {
+		if factor < 0 || factor >= 8*instr.Dest.T.Size() {
+			return nil, 0, false
+		}
+		factor = 1 << factor
+	}
+
+	r, ok := x.(*ir.Register)
+	if !ok || defs[r] == nil {
+		return nil, 0, false
+	} else if def := defs[r]; def.Op == ir.SExt {
+		r, ok = def.Args[0].(*ir.Register)
+		if !ok || defs[r] == nil {
+			return nil, 0, false
+		}
+	}
+	if defs[r].Op != ir.Phi {
+		return nil, 0, false
+	}
+	return r, factor, true
+}
+
+// Add a variable of type t to the loop that starts out as init times factor
+// and increases by step times factor every iteration
+func addScaled(f *ir.Function, l *loop, latch *ir.Block, init ir.Value, step int64,
+	t ir.Type, factor int64) *ir.Register {
+	if init.Type() != t {
+		ext := &ir.Instruction{Op: ir.SExt, Dest: f.NewRegister(t), Args: []ir.Value{init}}
+		insertBeforeTerminator(l.preheader, ext)
+		init = ext.Dest
+	}
+	start := &ir.Instruction{Op: ir.Mul, Dest: f.NewRegister(t),
+		Args: []ir.Value{init, &ir.Const{Value: signed(factor, t), T: t}}}
+	insertBeforeTerminator(l.preheader, start)
+
+	phi := &ir.Instruction{Op: ir.Phi, Dest: f.NewRegister(t)}
+	next := &ir.Instruction{Op: ir.Add, Dest: f.NewRegister(t),
+		Args: []ir.Value{phi.Dest, &ir.Const{Value: signed(step*factor, t), T: t}}}
+	insertBeforeTerminator(latch, next)
+
+	phi.Args = []ir.Value{start.Dest, next.Dest}
+	phi.Blocks = []*ir.Block{l.preheader, latch}
+	l.header.Instructions = append([]*ir.Instruction{phi}, l.header.Instructions...)
+	return phi.Dest
+}
@@ -0,0 +1,121 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// MarkTailCalls marks the calls in tail position, which the code generator
+// can turn into jumps that reuse the stack frame. A block that calls a
+// function and jumps to a block that only returns what the call returned,
+// like the end of a conditional expression, gets a return of its own
+// first. Once the caller jumps to the callee its stack frame is gone, so
+// there are no tail calls in functions that could have let the address of
+// something in it escape.
+func MarkTailCalls(f *ir.Function) bool {
+	if f.OutOfSSA {
+		return false
+	}
+
+	changed := duplicateReturns(f)
+	if changed {
+		f.RemoveUnreachable()
+	}
+
+	escapes := frameEscapes(f)
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op != ir.Call {
+				continue
+			}
+			tail := !escapes && ir.TailCall(b, instr)
+			changed = changed || tail != instr.Tail
+			instr.Tail = tail
+		}
+	}
+	return changed
+}
+
+// Replace the jumps after calls to blocks that only return a phi, or
+// nothing, with a return of the value the phi would have had
+func duplicateReturns(f *ir.Function) bool {
+	changed := false
+	for _, b := range f.Blocks {
+		n := len(b.Instructions)
+		if n < 2 || b.Instructions[n-1].Op != ir.Jmp || b.Instructions[n-2].Op != ir.Call {
+			continue
+		}
+		call, target := b.Instructions[n-2], b.Instructions[n-1].Blocks[0]
+
+		phis := 0
+		for phis < len(target.Instructions) && target.Instructions[phis].Op == ir.Phi {
+			phis++
+		}
+		if phis != len(target.Instructions)-1 || target.Instructions[phis].Op != ir.Ret {
+			continue
+		}
+
+		ret := &ir.Instruction{Op: ir.Ret}
+		if args := target.Instructions[phis].Args; len(args) > 0 {
+			v := args[0]
+			for _, phi := range target.Instructions[:phis] {
+				if phi.Dest == v {
+					v = phi.Args[indexOf(phi.Blocks, b)]
+				}
+			}
+			if call.Dest == nil || v != call.Dest {
+				continue
+			}
+			ret.Args = []ir.Value{v}
+		}
+
+		removePhiArgs(target, b)
+		b.Instructions[n-1] = ret
+		changed = true
+	}
+	return changed
+}
+
+func indexOf(blocks []*ir.Block, b *ir.Block) int {
+	for i, x := range blocks {
+		if x == b {
+			return i
+		}
+	}
+	return -1
+}
+
+// Whether an address in the stack frame could be known outside of the
+// function: a stack slot whose address is used for anything but loading and
+// storing through it, or memory allocated on the stack at run time.
+func frameEscapes(f *ir.Function) bool {
+	users := uses(f)
+	for _, b := range f.Blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op == ir.DynAlloca || instr.Op == ir.VaStart {
+				return true
+			} else if instr.Op != ir.Alloca {
+				continue
+			}
+
+			addresses := map[*ir.Register]bool{instr.Dest: true}
+			work := []*ir.Register{instr.Dest}
+			for len(work) > 0 {
+				p := work[len(work)-1]
+				work = work[:len(work)-1]
+
+				for _, use := range users[p] {
+					switch {
+					case use.Op == ir.Load:
+					case use.Op == ir.Store && use.Args[1] == p && use.Args[0] != p:
+					case use.Op == ir.Add || use.Op == ir.Sub || use.Op == ir.Copy:
+						if !addresses[use.Dest] {
+							addresses[use.Dest] = true
+							work = append(work, use.Dest)
+						}
+					default:
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
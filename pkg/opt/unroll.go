@@ -0,0 +1,236 @@
+package opt
+
+import "github.com/tjarjoura/cc/pkg/ir"
+
+// The most iterations a loop can run to be unrolled, and the most
+// instructions the copies of its body can add up to
+const (
+	maxUnrollTrips = 8
+	maxUnrollSize  = 64
+)
+
+// UnrollLoops replaces the loops that run a small, constant number of times
+// with a copy of their body for every iteration, which gets rid of the
+// branches and makes the induction variable a constant in every copy. The
+// loop has to be left from its header only, by comparing an induction
+// variable that starts out as a constant with another constant.
+func UnrollLoops(f *ir.Function) bool {
+	if f.OutOfSSA {
+		return false
+	}
+
+	changed := false
+	for unrolled := true; unrolled; {
+		// unrolling a loop changes the loops around it
+		unrolled = false
+		for _, l := range findLoops(f) {
+			if n, ok := tripCount(f, l); ok {
+				unroll(f, l, n)
+				unrolled, changed = true, true
+				break
+			}
+		}
+	}
+
+	if changed {
+		mergeBlocks(f)
+	}
+	return changed
+}
+
+// How many times the body of the loop runs, if it is known and small enough
+// to unroll the loop
+func tripCount(f *ir.Function, l *loop) (int, bool) {
+	br := l.header.Terminator()
+	if len(l.latches) != 1 || br == nil || br.Op != ir.Br ||
+		l.blocks[br.Blocks[0]] == l.blocks[br.Blocks[1]] {
+		return 0, false
+	}
+	for b := range l.blocks {
+		for _, succ := range b.Successors() {
+			if b != l.header && !l.blocks[succ] {
+				return 0, false
+			}
+		}
+	}
+
+	defs := definitions(l)
+	r, ok := br.Args[0].(*ir.Register)
+	if !ok || defs[r] == nil || !defs[r].Op.IsComparison() {
+		return 0, false
+	}
+	cmp := defs[r]
+	iv, ok := cmp.Args[0].(*ir.Register)
+	bound, isConst := cmp.Args[1].(*ir.Const)
+	if !ok || !isConst || defs[iv] == nil {
+		return 0, false
+	}
+	init, step, ok := induction(defs[iv], l, defs)
+	v, isConst := init.(*ir.Const)
+	if !ok || !isConst {
+		return 0, false
+	}
+
+	// the loop goes on while the comparison is true if the branch goes into
+	// the loop when it is
+	goesOn := l.blocks[br.Blocks[0]]
+	n := 0
+	for compare(cmp.Op, v, bound) == goesOn {
+		if n++; n > maxUnrollTrips {
+			return 0, false
+		}
+		v = &ir.Const{Value: signed(v.Value+step, v.T), T: v.T}
+	}
+
+	size := 0
+	for b := range l.blocks {
+		for _, instr := range b.Instructions {
+			if instr.Op != ir.Phi {
+				size++
+			}
+		}
+	}
+	return n, size*(n+1) <= maxUnrollSize
+}
+
+// Replace the loop with n copies of it, each going on to the next one
+// instead of back to the header, followed by a copy of the header that
+// leaves the loop
+func unroll(f *ir.Function, l *loop, n int) {
+	latch, br := l.latches[0], l.header.Terminator()
+	next, exit := br.Blocks[0], br.Blocks[1]
+	if !l.blocks[next] {
+		next, exit = exit, next
+	}
+
+	body := []*ir.Block{l.header}
+	for _, b := range l.ordered(f) {
+		if b != l.header {
+			body = append(body, b)
+		}
+	}
+
+	copies := make([]map[*ir.Block]*ir.Block, n+1)
+	for k := range copies {
+		copies[k] = map[*ir.Block]*ir.Block{l.header: f.NewBlock()}
+		for _, b := range body[1:] {
+			if k < n {
+				copies[k][b] = f.NewBlock()
+			}
+		}
+	}
+
+	// every copy gets new registers for what the loop computes, and the
+	// phis of the header become the values of the copy before
+	defs := definitions(l)
+	var values map[*ir.Register]ir.Value
+	value := func(v ir.Value) ir.Value {
+		r, ok := v.(*ir.Register)
+		if !ok || defs[r] == nil {
+			return v
+		}
+		if values[r] == nil {
+			values[r] = f.NewRegister(r.T)
+		}
+		return values[r]
+	}
+
+	laidOut := []*ir.Block{}
+	for k, blocks := range copies {
+		previous := values
+		values = map[*ir.Register]ir.Value{}
+		for _, phi := range l.header.Instructions {
+			if phi.Op != ir.Phi {
+				break
+			}
+			for i, from := range phi.Blocks {
+				if k == 0 && from == l.preheader {
+					values[phi.Dest] = phi.Args[i]
+				} else if k > 0 && from == latch {
+					values[phi.Dest] = lookup(previous, phi.Args[i])
+				}
+			}
+		}
+
+		for _, b := range body {
+			clone := blocks[b]
+			if clone == nil {
+				continue
+			}
+			laidOut = append(laidOut, clone)
+
+			for _, instr := range b.Instructions {
+				if b == l.header && instr.Op == ir.Phi {
+					continue
+				} else if instr == br {
+					target := exit
+					if k < n && next == l.header {
+						target = copies[k+1][l.header]
+					} else if k < n {
+						target = blocks[next]
+					}
+					clone.Instructions = append(clone.Instructions,
+						&ir.Instruction{Op: ir.Jmp, Blocks: []*ir.Block{target}})
+					continue
+				}
+
+				copied := &ir.Instruction{Op: instr.Op, Size: instr.Size,
+					Align: instr.Align, Variadic: instr.Variadic}
+				if instr.Dest != nil {
+					copied.Dest = value(instr.Dest).(*ir.Register)
+				}
+				for _, arg := range instr.Args {
+					copied.Args = append(copied.Args, value(arg))
+				}
+				for _, target := range instr.Blocks {
+					if target == l.header {
+						target = copies[k+1][l.header]
+					} else if l.blocks[target] {
+						target = blocks[target]
+					}
+					copied.Blocks = append(copied.Blocks, target)
+				}
+				clone.Instructions = append(clone.Instructions, copied)
+			}
+		}
+	}
+
+	l.preheader.Terminator().Blocks[0] = copies[0][l.header]
+	for _, instr := range exit.Instructions {
+		if instr.Op != ir.Phi {
+			break
+		}
+		for i, from := range instr.Blocks {
+			if from == l.header {
+				instr.Blocks[i] = copies[n][l.header]
+			}
+		}
+	}
+
+	blocks := []*ir.Block{}
+	for _, b := range f.Blocks {
+		if b == l.header {
+			blocks = append(blocks, laidOut...)
+		} else if !l.blocks[b] {
+			blocks = append(blocks, b)
+		}
+	}
+	f.Blocks = blocks
+
+	// what the header computes can be used after the loop, which sees the
+	// values of the last copy
+	replace := map[*ir.Register]ir.Value{}
+	for r, v := range values {
+		replace[r] = v
+	}
+	replaceUses(f, replace)
+}
+
+// The value of v in a copy of the loop, which is v itself if the loop
+// doesn't compute it
+func lookup(values map[*ir.Register]ir.Value, v ir.Value) ir.Value {
+	if r, ok := v.(*ir.Register); ok && values[r] != nil {
+		return values[r]
+	}
+	return v
+}
@@ -18,8 +18,21 @@ func (p *Parser) peekTokenIsTypeQualifier() bool {
 	return p.peekTokenIs(token.CONST) || p.peekTokenIs(token.VOLATILE)
 }
 
+func (p *Parser) currTokenIsTypeQualifier() bool {
+	return p.currTokenIs(token.CONST) || p.currTokenIs(token.VOLATILE)
+}
+
+// Type names that the compiler provides without a typedef, <stdarg.h> can't be
+// included since there is no preprocessor
+var builtinTypeNames = map[string]string{
+	"va_list":           "va_list",
+	"__builtin_va_list": "va_list",
+}
+
 func (p *Parser) currTokenIsType() bool {
+	_, builtin := builtinTypeNames[p.currToken.Literal]
 	return p.currTokenIs(token.INT) ||
+		(p.currTokenIs(token.IDENTIFIER) && builtin) ||
 		p.currTokenIs(token.LONG) ||
 		p.currTokenIs(token.CHAR) ||
 		p.currTokenIs(token.SHORT) ||
@@ -27,11 +40,15 @@ func (p *Parser) currTokenIsType() bool {
 		p.currTokenIs(token.UNSIGNED) ||
 		p.currTokenIs(token.FLOAT) ||
 		p.currTokenIs(token.DOUBLE) ||
-		p.currTokenIs(token.VOID)
+		p.currTokenIs(token.VOID) ||
+		p.currTokenIs(token.STRUCT) ||
+		p.currTokenIs(token.UNION)
 }
 
 func (p *Parser) peekTokenIsType() bool {
+	_, builtin := builtinTypeNames[p.peekToken.Literal]
 	return p.peekTokenIs(token.INT) ||
+		(p.peekTokenIs(token.IDENTIFIER) && builtin) ||
 		p.peekTokenIs(token.LONG) ||
 		p.peekTokenIs(token.CHAR) ||
 		p.peekTokenIs(token.SHORT) ||
@@ -39,13 +56,23 @@ func (p *Parser) peekTokenIsType() bool {
 		p.peekTokenIs(token.UNSIGNED) ||
 		p.peekTokenIs(token.FLOAT) ||
 		p.peekTokenIs(token.DOUBLE) ||
-		p.peekTokenIs(token.VOID)
+		p.peekTokenIs(token.VOID) ||
+		p.peekTokenIs(token.STRUCT) ||
+		p.peekTokenIs(token.UNION)
+}
+
+// Whether the current token can start a declaration inside a block
+func (p *Parser) currTokenIsDeclarationSpecifier() bool {
+	return p.currTokenIsStorageClass() || p.currTokenIsType() ||
+		p.currTokenIsTypeQualifier() || p.currTokenIs(token.ALIGNAS) ||
+		p.currTokenIs(token.INLINE)
 }
 
 func (p *Parser) parseDeclaratorLeft(decl ast.Declaration, insideParen bool) ast.Declaration {
 	switch p.currToken.Type {
 	case token.ASTERISK:
 		pointer := &ast.Pointer{PointsTo: decl}
+		start := p.currToken.Pos()
 		for {
 			if p.peekTokenIs(token.CONST) {
 				pointer.Const = true
@@ -57,9 +84,11 @@ func (p *Parser) parseDeclaratorLeft(decl ast.Declaration, insideParen bool) ast
 
 			p.nextToken()
 		}
+		p.span(pointer, start)
 
 		if !p.peekTokenIs(token.IDENTIFIER, token.LPAREN, token.ASTERISK) {
-			return pointer
+			// abstract declarator, e.g. "int *[3]" or the "*" in "int (*)(int)"
+			return p.parseDeclaratorRight(pointer, insideParen)
 		}
 
 		p.nextToken()
@@ -75,6 +104,9 @@ func (p *Parser) parseDeclaratorLeft(decl ast.Declaration, insideParen bool) ast
 		}
 
 		right := p.parseDeclaratorRight(decl, insideParen)
+		if right == nil {
+			return nil
+		}
 
 		// We need to insert what we parsed from the right into the
 		// declaration tree
@@ -88,14 +120,20 @@ func (p *Parser) parseDeclaratorLeft(decl ast.Declaration, insideParen bool) ast
 		return interior
 
 	case token.IDENTIFIER:
-		name := p.currToken.Literal
+		name, namePos := p.currToken.Literal, p.currToken.Pos()
 		right := p.parseDeclaratorRight(decl, insideParen)
-		if fnDecl, ok := right.(*ast.FunctionDeclaration); ok {
-			fnDecl.Name = name
+		if right == nil {
+			return nil
+		} else if fnDecl, ok := right.(*ast.FunctionDeclaration); ok {
+			fnDecl.Name, fnDecl.NamePos = name, namePos
+			p.span(fnDecl, namePos)
 			return fnDecl
 		}
-		return &ast.VariableDeclaration{VarType: right,
-			Name: name}
+
+		varDecl := &ast.VariableDeclaration{VarType: right, Name: name,
+			NamePos: namePos}
+		p.span(varDecl, namePos)
+		return varDecl
 	default:
 		p.genericError(fmt.Sprintf("internal bug: called parseDeclaratorLeft with unexpected token: %s",
 			p.currToken.Literal))
@@ -107,24 +145,42 @@ func (p *Parser) parseDeclaratorRight(decl ast.Declaration, insideParen bool) as
 	switch p.peekToken.Type {
 	case token.LSQUARE:
 		p.nextToken()
+		start := p.currToken.Pos()
 		var expr ast.Expression
 		if !p.peekTokenIs(token.RSQUARE) {
 			p.nextToken()
-			expr = p.parseExpression(LOWEST)
+			if expr = p.parseAssignmentExpression(); expr == nil {
+				return nil
+			}
 		}
 
 		if !p.expectPeek(token.RSQUARE) {
 			return nil
 		}
 
-		right := p.parseDeclaratorRight(decl, insideParen)
-		return &ast.Array{ArrayOf: right, ArraySize: expr}
+		array := &ast.Array{ArraySize: expr}
+		p.span(array, start)
+		if array.ArrayOf = p.parseDeclaratorRight(decl, insideParen); array.ArrayOf == nil {
+			return nil
+		}
+		return array
 	case token.LPAREN: // function declaration
 		p.nextToken()
+		start := p.currToken.Pos()
 
 		params := []ast.Declaration{}
+		variadic := false
 		for !p.peekTokenIs(token.RPAREN) && !p.peekTokenIs(token.EOF) {
 			p.nextToken()
+			if p.currTokenIs(token.ELLIPSIS) { // has to be the last parameter
+				if len(params) == 0 {
+					p.genericError("ISO C requires a named parameter before '...'")
+					return nil
+				}
+				variadic = true
+				break
+			}
+
 			param := p.parseFunctionParam()
 			if param == nil {
 				return nil
@@ -140,7 +196,16 @@ func (p *Parser) parseDeclaratorRight(decl ast.Declaration, insideParen bool) as
 			return nil
 		}
 
-		fnDecl := &ast.FunctionDeclaration{ReturnType: decl, Parameters: params}
+		// f(void) takes no parameters
+		if len(params) == 1 && !variadic {
+			if base, ok := params[0].(*ast.BaseType); ok && base.Name == "void" {
+				params = []ast.Declaration{}
+			}
+		}
+
+		fnDecl := &ast.FunctionDeclaration{ReturnType: decl, Parameters: params,
+			Variadic: variadic}
+		p.span(fnDecl, start)
 		return p.parseDeclaratorRight(fnDecl, insideParen)
 	case token.RPAREN:
 		if insideParen {
@@ -153,16 +218,204 @@ func (p *Parser) parseDeclaratorRight(decl ast.Declaration, insideParen bool) as
 }
 
 func (p *Parser) parseFunctionParam() ast.Declaration {
+	start := p.currToken.Pos()
 	typeSpec := p.parseBaseType()
 	if typeSpec == nil {
 		return nil
 	}
+
+	var param ast.Declaration
 	if !p.peekTokenIs(token.IDENTIFIER, token.ASTERISK, token.LPAREN) {
-		return typeSpec
+		param = p.parseDeclaratorRight(typeSpec, false)
+	} else {
+		p.nextToken()
+		param = p.parseDeclaratorLeft(typeSpec, false)
+	}
+
+	p.span(param, start)
+	return param
+}
+
+// Parse a type name as used in sizeof, _Alignof and casts, e.g. "int (*)[3]".
+// Starts on the first token of the type name and ends on its last token.
+func (p *Parser) parseTypeName() ast.Declaration {
+	start := p.currToken.Pos()
+	typeSpec := p.parseBaseType()
+	if typeSpec == nil {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.ASTERISK, token.LPAREN) {
+		decl := p.parseDeclaratorRight(typeSpec, false)
+		p.span(decl, start)
+		return decl
 	}
 
 	p.nextToken()
-	return p.parseDeclaratorLeft(typeSpec, false)
+	decl := p.parseDeclaratorLeft(typeSpec, false)
+	switch d := decl.(type) {
+	case *ast.VariableDeclaration:
+		p.genericError(fmt.Sprintf("unexpected identifier %s in type name", d.Name))
+		return nil
+	case *ast.FunctionDeclaration:
+		if d.Name != "" {
+			p.genericError(fmt.Sprintf("unexpected identifier %s in type name", d.Name))
+			return nil
+		}
+	}
+
+	p.span(decl, start)
+	return decl
+}
+
+// Parse the argument of _Alignas, which is either a type name or a constant
+// expression. _Alignas(type-name) is turned into _Alignas(_Alignof(type-name))
+func (p *Parser) parseAlignas() ast.Expression {
+	tok := p.currToken
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+
+	if p.currTokenIsType() || p.currTokenIsTypeQualifier() {
+		typeName := p.parseTypeName()
+		if typeName == nil || !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		alignof := &ast.AlignofExpression{Token: tok, TypeName: typeName}
+		p.span(alignof, tok.Pos())
+		return alignof
+	}
+
+	expr := p.parseAssignmentExpression()
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return expr
+}
+
+func (p *Parser) lookupTag(tag string) *ast.StructOrUnionSpecification {
+	for i := len(p.tagScopes) - 1; i >= 0; i-- {
+		if def, ok := p.tagScopes[i][tag]; ok {
+			return def
+		}
+	}
+
+	return nil
+}
+
+func (p *Parser) pushTagScope() {
+	p.tagScopes = append(p.tagScopes, map[string]*ast.StructOrUnionSpecification{})
+}
+
+func (p *Parser) popTagScope() {
+	p.tagScopes = p.tagScopes[:len(p.tagScopes)-1]
+}
+
+func (p *Parser) parseStructMembers() []*ast.VariableDeclaration {
+	members := []*ast.VariableDeclaration{}
+	for !p.peekTokenIs(token.RBRACE, token.EOF) {
+		p.nextToken()
+		start := p.currToken.Pos()
+
+		var alignAs ast.Expression
+		if p.currTokenIs(token.ALIGNAS) {
+			alignAs = p.parseAlignas()
+			if alignAs == nil {
+				return nil
+			}
+			p.nextToken()
+		}
+
+		typeSpec := p.parseBaseType()
+		if typeSpec == nil {
+			return nil
+		}
+
+		for !p.peekTokenIs(token.SEMICOLON, token.EOF) {
+			if !p.expectPeek(token.IDENTIFIER, token.LPAREN, token.ASTERISK) {
+				return nil
+			}
+
+			member, ok := p.parseDeclaratorLeft(typeSpec, false).(*ast.VariableDeclaration)
+			if !ok {
+				p.genericError("expected a member declaration")
+				return nil
+			}
+			member.AlignAs = alignAs
+			p.span(member, start)
+			members = append(members, member)
+
+			if !p.peekTokenIs(token.SEMICOLON) && !p.expectPeek(token.COMMA) {
+				return nil
+			}
+		}
+
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return members
+}
+
+func (p *Parser) parseStructOrUnionSpecifier() *ast.StructOrUnionSpecification {
+	spec := &ast.StructOrUnionSpecification{Kind: p.currToken.Literal}
+	start := p.currToken.Pos()
+	if p.peekTokenIs(token.IDENTIFIER) {
+		p.nextToken()
+		spec.Tag = p.currToken.Literal
+	} else if !p.peekTokenIs(token.LBRACE) {
+		p.peekError(token.IDENTIFIER, token.LBRACE)
+		return nil
+	}
+
+	if !p.peekTokenIs(token.LBRACE) { // just a reference to the tag
+		spec.Definition = p.lookupTag(spec.Tag)
+		if spec.Definition == nil { // declares a new incomplete type
+			spec.Definition = &ast.StructOrUnionSpecification{
+				Kind: spec.Kind, Tag: spec.Tag}
+			p.tagScopes[len(p.tagScopes)-1][spec.Tag] = spec.Definition
+		}
+	} else {
+		spec.Definition = spec
+		if spec.Tag != "" {
+			scope := p.tagScopes[len(p.tagScopes)-1]
+			if prev, ok := scope[spec.Tag]; ok {
+				if prev.Members != nil {
+					p.genericError(fmt.Sprintf("redefinition of '%s %s'",
+						spec.Kind, spec.Tag))
+					return nil
+				}
+				spec.Definition = prev
+			} else {
+				scope[spec.Tag] = spec
+			}
+		}
+
+		p.nextToken()
+		members := p.parseStructMembers()
+		if members == nil {
+			return nil
+		}
+
+		spec.Members = members
+		spec.Definition.Members = members
+	}
+
+	if spec.Definition.Kind != spec.Kind {
+		p.genericError(fmt.Sprintf("'%s' defined as wrong kind of tag", spec.Tag))
+		return nil
+	}
+
+	p.span(spec, start)
+	return spec
 }
 
 // Kind of spaghetti code but the type naming rules in C are a bit all over the place
@@ -205,12 +458,32 @@ func (p *Parser) combineTypeSpecifier(typeSpec string) string {
 
 }
 
-func (p *Parser) parseBaseType() *ast.BaseType {
+func (p *Parser) parseBaseType() ast.Declaration {
 	typeSpec := &ast.BaseType{}
+	start := p.currToken.Pos()
+	var structSpec *ast.StructOrUnionSpecification
 	alreadySigned, alreadyTyped := false, false
 
 	for {
-		if p.currTokenIs(token.CONST) {
+		if p.currTokenIs(token.STRUCT) || p.currTokenIs(token.UNION) {
+			if alreadyTyped || alreadySigned || structSpec != nil {
+				p.genericError(fmt.Sprintf(
+					"conflicting type specifier %s",
+					p.currToken.Literal))
+				return nil
+			}
+
+			structSpec = p.parseStructOrUnionSpecifier()
+			if structSpec == nil {
+				return nil
+			}
+		} else if structSpec != nil && (p.currTokenIsType() ||
+			p.currTokenIs(token.SIGNED) || p.currTokenIs(token.UNSIGNED)) {
+			p.genericError(fmt.Sprintf(
+				"conflicting type specifier %s",
+				p.currToken.Literal))
+			return nil
+		} else if p.currTokenIs(token.CONST) {
 			typeSpec.Const = true
 		} else if p.currTokenIs(token.VOLATILE) {
 			typeSpec.Volatile = true
@@ -225,9 +498,19 @@ func (p *Parser) parseBaseType() *ast.BaseType {
 			} else {
 				alreadySigned = true
 				typeSpec.Signed = p.currTokenIs(token.SIGNED)
+				typeSpec.Unsigned = p.currTokenIs(token.UNSIGNED)
 			}
 		} else if p.currTokenIsType() {
-			if !alreadyTyped {
+			if builtin, ok := builtinTypeNames[p.currToken.Literal]; ok {
+				if alreadyTyped || alreadySigned {
+					p.genericError(fmt.Sprintf(
+						"conflicting type specifier %s",
+						p.currToken.Literal))
+					return nil
+				}
+				typeSpec.Name = builtin
+				alreadyTyped = true
+			} else if !alreadyTyped {
 				typeSpec.Name = p.currToken.Literal
 				alreadyTyped = true
 			} else {
@@ -245,6 +528,17 @@ func (p *Parser) parseBaseType() *ast.BaseType {
 		}
 	}
 
+	if structSpec != nil {
+		structSpec.Const = typeSpec.Const
+		structSpec.Volatile = typeSpec.Volatile
+		p.span(structSpec, start)
+		return structSpec
+	}
+
+	if typeSpec.Name == "" && alreadySigned { // "unsigned x" is an unsigned int
+		typeSpec.Name = "int"
+	}
+
 	if typeSpec.Name == "" {
 		p.genericError("type specifier missing. implicit int is not supported by this compiler")
 		return nil
@@ -252,20 +546,37 @@ func (p *Parser) parseBaseType() *ast.BaseType {
 		typeSpec.Name = fmt.Sprintf("%s int", typeSpec.Name)
 	}
 
+	p.span(typeSpec, start)
 	return typeSpec
 }
 
 func (p *Parser) parseDeclarations(topLevel bool) []ast.Declaration {
 	var decls = []ast.Declaration{}
 	var storageClass string
-	if p.currTokenIsStorageClass() {
-		storageClass = p.currToken.Literal
+	start := p.currToken.Pos()
+	var alignAs ast.Expression
+	var inline bool
+	for p.currTokenIsStorageClass() || p.currTokenIs(token.ALIGNAS) ||
+		p.currTokenIs(token.INLINE) {
+		if p.currTokenIs(token.INLINE) {
+			inline = true
+		} else if p.currTokenIs(token.ALIGNAS) {
+			alignAs = p.parseAlignas()
+			if alignAs == nil {
+				return nil
+			}
+		} else if storageClass != "" {
+			p.genericError("multiple storage classes in declaration specifiers")
+			return nil
+		} else {
+			storageClass = p.currToken.Literal
+		}
 		p.nextToken()
 	}
 
 	typeSpec := p.parseBaseType()
 	if typeSpec == nil {
-		return decls
+		return nil
 	}
 
 	for !p.peekTokenIs(token.SEMICOLON) && !p.peekTokenIs(token.EOF) {
@@ -274,34 +585,60 @@ func (p *Parser) parseDeclarations(topLevel bool) []ast.Declaration {
 		}
 
 		d := p.parseDeclaratorLeft(typeSpec, false)
+		if d == nil {
+			return nil
+		}
 		decls = append(decls, d)
 
 		switch decl := d.(type) {
 		case *ast.VariableDeclaration:
+			if inline {
+				p.genericError("'inline' can only appear on functions")
+				return nil
+			}
 			decl.StorageClass = storageClass
+			decl.AlignAs = alignAs
 			if p.peekTokenIs(token.ASSIGN) { // also define the variable
 				p.nextToken()
 				p.nextToken()
-				decl.Definition = p.parseExpression(LOWEST)
+				if decl.Definition = p.parseAssignmentExpression(); decl.Definition == nil {
+					return nil
+				}
 			}
+			p.span(decl, start) // every declarator starts with the specifiers
 		case *ast.FunctionDeclaration:
 			decl.StorageClass = storageClass
+			decl.Inline = inline
+			if alignAs != nil {
+				p.genericError("_Alignas cannot be applied to a function")
+				return nil
+			}
 
 			// if this is the first declaration, there can also be a function definition
+			p.span(decl, start)
 			if len(decls) == 1 && p.peekTokenIs(token.LBRACE) {
 				if !topLevel {
 					p.genericError("function definition not allowed here")
-					return decls
+					return nil
 				}
 				p.nextToken()
 				decl.Body = p.parseBlockStatement()
+				p.span(decl, start)
 				goto end // we don't allow more than one declaration if we defined a function
 			}
 		}
 
-		if p.peekTokenIs(token.COMMA) {
-			p.nextToken()
+		// anything other than a comma has to end the declaration
+		if !p.peekTokenIs(token.COMMA) {
+			break
 		}
+		p.nextToken()
+	}
+
+	// a declaration without declarators, like "struct s { int a; };", only
+	// declares the type
+	if len(decls) == 0 {
+		decls = append(decls, typeSpec)
 	}
 
 	if !p.expectPeek(token.SEMICOLON) {
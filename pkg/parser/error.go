@@ -4,21 +4,49 @@ import (
 	"bytes"
 	"fmt"
 
+	"github.com/tjarjoura/cc/pkg/diag"
 	"github.com/tjarjoura/cc/pkg/token"
 )
 
 type ParseError struct {
-	msg   string
-	token token.Token
+	diag.Diagnostic
 }
 
-func (p *ParseError) String() string {
-	return fmt.Sprintf("[%d:%d] %s", p.token.Line, p.token.Column, p.msg)
+// Report an error at the token. A second error at the same place is left
+// out since it follows from the first, and after too many errors the rest of
+// the input is skipped.
+func (p *Parser) tokenError(tok token.Token, msg string) {
+	if p.failedAt(tok) || p.tooManyErrors() {
+		return
+	}
+
+	p.errors = append(p.errors, ParseError{diag.Diagnostic{
+		Severity: diag.Error,
+		Pos:      tok.Pos(),
+		Ranges:   []diag.Range{{Start: tok.Pos(), End: tok.End()}},
+		Message:  msg,
+	}})
+}
+
+func (p *Parser) tooManyErrors() bool {
+	if p.ErrorLimit == 0 || len(p.errors) < p.ErrorLimit {
+		return false
+	}
+
+	if p.peekToken.Type != token.EOF {
+		p.errors = append(p.errors, ParseError{diag.Diagnostic{
+			Severity: diag.Error,
+			Pos:      p.peekToken.Pos(),
+			Message:  "too many errors emitted, stopping now",
+		}})
+		p.peekToken = token.Token{Type: token.EOF, File: p.peekToken.File,
+			Line: p.peekToken.Line, Column: p.peekToken.Column}
+	}
+	return true
 }
 
 func (p *Parser) genericError(msg string) {
-	err := ParseError{msg: msg, token: p.currToken}
-	p.errors = append(p.errors, err)
+	p.tokenError(p.currToken, msg)
 }
 
 func (p *Parser) peekError(ts ...token.TokenType) {
@@ -29,8 +57,28 @@ func (p *Parser) peekError(ts ...token.TokenType) {
 	}
 	expectedTokens.WriteString(string(ts[len(ts)-1]))
 
-	//panic("ahhhhh!!!")
 	msg := fmt.Sprintf("expected next token to be '%s', got '%s' instead",
 		expectedTokens.String(), p.peekToken.Literal)
-	p.errors = append(p.errors, ParseError{msg: msg, token: p.peekToken})
+	p.tokenError(p.peekToken, msg)
+
+	// a missing punctuator like ';' is most likely forgotten at the end of
+	// the previous token, suggest putting it there
+	if len(ts) == 1 && isCloser(ts[0]) {
+		err := &p.errors[len(p.errors)-1]
+		err.Pos = p.currToken.End()
+		err.Insert(p.currToken.End(), string(ts[0]))
+	}
+}
+
+func isCloser(t token.TokenType) bool {
+	switch t {
+	case token.SEMICOLON, token.RPAREN, token.RSQUARE, token.RBRACE, token.COLON:
+		return true
+	}
+	return false
+}
+
+// Whether the last error was reported at the token
+func (p *Parser) failedAt(tok token.Token) bool {
+	return len(p.errors) > 0 && p.errors[len(p.errors)-1].Pos == tok.Pos()
 }
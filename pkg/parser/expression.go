@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"github.com/tjarjoura/cc/pkg/ast"
 	"github.com/tjarjoura/cc/pkg/token"
@@ -49,21 +50,22 @@ const (
 	POSTINC      // var++
 )
 
+// All assignment operators share one precedence level so that they can be
+// chained, e.g. a = b += c
 var infixPrecedenceMap = map[token.TokenType]int{
 	token.COMMA:     COMMA,
-	token.BITANDA:   BITANDA,
-	token.BITORA:    BITANDA,
-	token.BITXORA:   BITANDA,
-	token.LSHIFTA:   BSHIFTA,
-	token.RSHIFTA:   BSHIFTA,
-	token.ASTERISKA: PRODA,
-	token.SLASHA:    PRODA,
-	token.MODA:      PRODA,
-	token.PLUSA:     PLUSA,
-	token.MINUSA:    PLUSA,
+	token.BITANDA:   ASSIGN,
+	token.BITORA:    ASSIGN,
+	token.BITXORA:   ASSIGN,
+	token.LSHIFTA:   ASSIGN,
+	token.RSHIFTA:   ASSIGN,
+	token.ASTERISKA: ASSIGN,
+	token.SLASHA:    ASSIGN,
+	token.MODA:      ASSIGN,
+	token.PLUSA:     ASSIGN,
+	token.MINUSA:    ASSIGN,
 	token.ASSIGN:    ASSIGN,
 	token.QUESTION:  TERNARY,
-	token.COLON:     TERNARY,
 	token.OR:        OR,
 	token.AND:       AND,
 	token.BITOR:     BITOR,
@@ -72,7 +74,9 @@ var infixPrecedenceMap = map[token.TokenType]int{
 	token.EQUALS:    EQUALS,
 	token.NOTEQUALS: EQUALS,
 	token.GT:        GT,
+	token.GTE:       GT,
 	token.LT:        LT,
+	token.LTE:       LT,
 	token.LSHIFT:    BSHIFT,
 	token.RSHIFT:    BSHIFT,
 	token.PLUS:      SUM,
@@ -107,6 +111,7 @@ func (p *Parser) registerParseFns() {
 	p.prefixParseFns[token.IDENTIFIER] = p.parseIdentifier
 	p.prefixParseFns[token.INTL] = p.parseIntegerLiteral
 	p.prefixParseFns[token.FLOATL] = p.parseFloatLiteral
+	p.prefixParseFns[token.STRINGL] = p.parseStringLiteral
 
 	p.prefixParseFns[token.LPAREN] = p.parseGroupedExpression // TODO handle type cast too
 
@@ -116,6 +121,8 @@ func (p *Parser) registerParseFns() {
 	p.prefixParseFns[token.AMP] = p.parsePrefixExpression
 	p.prefixParseFns[token.MINUS] = p.parsePrefixExpression
 	p.prefixParseFns[token.PLUS] = p.parsePrefixExpression
+	p.prefixParseFns[token.SIZEOF] = p.parseSizeofExpression
+	p.prefixParseFns[token.ALIGNOF] = p.parseAlignofExpression
 
 	p.infixParseFns[token.ASSIGN] = p.parseInfixExpression
 	p.infixParseFns[token.PLUS] = p.parseInfixExpression
@@ -146,11 +153,28 @@ func (p *Parser) registerParseFns() {
 	p.infixParseFns[token.BITORA] = p.parseInfixExpression
 	p.infixParseFns[token.BITXOR] = p.parseInfixExpression
 	p.infixParseFns[token.BITXORA] = p.parseInfixExpression
+	p.infixParseFns[token.COMMA] = p.parseInfixExpression
+	p.infixParseFns[token.QUESTION] = p.parseConditionalExpression
+	p.infixParseFns[token.LPAREN] = p.parseCallExpression
+	p.infixParseFns[token.LSQUARE] = p.parseIndexExpression
+	p.infixParseFns[token.DOT] = p.parseMemberExpression
+	p.infixParseFns[token.ARROW] = p.parseMemberExpression
+}
+
+func isAssignmentOperator(t token.TokenType) bool {
+	switch t {
+	case token.ASSIGN, token.PLUSA, token.MINUSA, token.ASTERISKA,
+		token.SLASHA, token.MODA, token.LSHIFTA, token.RSHIFTA,
+		token.BITANDA, token.BITORA, token.BITXORA:
+		return true
+	}
+
+	return false
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, ParseError{msg: msg, token: p.currToken})
+	p.genericError(msg)
 }
 
 func (p *Parser) currPrecedence() int {
@@ -173,7 +197,7 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	p.nextToken()
 	expr := p.parseExpression(LOWEST)
 
-	if !p.expectPeek(token.RPAREN) {
+	if expr == nil || !p.expectPeek(token.RPAREN) {
 		return nil
 	}
 
@@ -190,15 +214,57 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 	if !ok {
 		msg := fmt.Sprintf("couldn't find prefix precedence for %s operator",
 			p.currToken.Literal)
-		p.errors = append(p.errors, ParseError{token: p.currToken, msg: msg})
+		p.genericError(msg)
 		return nil
 	}
 
 	p.nextToken()
-	prefixExpr.Right = p.parseExpression(precedence)
+	if prefixExpr.Right = p.parseExpression(precedence); prefixExpr.Right == nil {
+		return nil
+	}
 	return prefixExpr
 }
 
+func (p *Parser) parseSizeofExpression() ast.Expression {
+	sizeofExpr := &ast.SizeofExpression{Token: p.currToken}
+	p.nextToken()
+
+	// sizeof(type-name) and sizeof (expr) both start with a parenthesis,
+	// the token after it tells them apart
+	if p.currTokenIs(token.LPAREN) &&
+		(p.peekTokenIsType() || p.peekTokenIsTypeQualifier()) {
+		p.nextToken()
+		sizeofExpr.TypeName = p.parseTypeName()
+		if sizeofExpr.TypeName == nil || !p.expectPeek(token.RPAREN) {
+			return nil
+		}
+
+		return sizeofExpr
+	}
+
+	sizeofExpr.Right = p.parseExpression(SIZEOF)
+	if sizeofExpr.Right == nil {
+		return nil
+	}
+
+	return sizeofExpr
+}
+
+func (p *Parser) parseAlignofExpression() ast.Expression {
+	alignofExpr := &ast.AlignofExpression{Token: p.currToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	alignofExpr.TypeName = p.parseTypeName()
+	if alignofExpr.TypeName == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return alignofExpr
+}
+
 func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	infixExpr := &ast.InfixExpression{
 		Token:    p.currToken,
@@ -207,21 +273,221 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.currPrecedence()
+	if isAssignmentOperator(p.currToken.Type) { // right associative
+		precedence--
+	}
+
 	p.nextToken()
-	infixExpr.Right = p.parseExpression(precedence)
+	if infixExpr.Right = p.parseExpression(precedence); infixExpr.Right == nil {
+		return nil
+	}
 
 	return infixExpr
 }
 
+func (p *Parser) parseConditionalExpression(condition ast.Expression) ast.Expression {
+	condExpr := &ast.ConditionalExpression{
+		Token:     p.currToken,
+		Condition: condition,
+	}
+
+	// anything can go between ? and :, even a comma expression
+	p.nextToken()
+	condExpr.Consequence = p.parseExpression(LOWEST)
+	if condExpr.Consequence == nil || !p.expectPeek(token.COLON) {
+		return nil
+	}
+
+	// right associative, a ? b : c ? d : e is a ? b : (c ? d : e)
+	p.nextToken()
+	if condExpr.Alternative = p.parseExpression(TERNARY - 1); condExpr.Alternative == nil {
+		return nil
+	}
+
+	return condExpr
+}
+
+// Parse an expression that can not contain a top level comma operator, so
+// that commas separating declarators and arguments are left alone
+func (p *Parser) parseAssignmentExpression() ast.Expression {
+	return p.parseExpression(COMMA)
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
+	if (p.currToken.Literal == "va_arg" || p.currToken.Literal == "__builtin_va_arg") &&
+		p.peekTokenIs(token.LPAREN) {
+		return p.parseVaArg()
+	}
+
 	return &ast.Identifier{Token: p.currToken, Value: p.currToken.Literal}
 }
 
+func (p *Parser) parseVaArg() ast.Expression {
+	vaArg := &ast.VaArgExpression{Token: p.currToken}
+	p.nextToken()
+	p.nextToken()
+
+	vaArg.VaList = p.parseAssignmentExpression()
+	if vaArg.VaList == nil || !p.expectPeek(token.COMMA) {
+		return nil
+	}
+
+	p.nextToken()
+	vaArg.TypeName = p.parseTypeName()
+	if vaArg.TypeName == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return vaArg
+}
+
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	call := &ast.CallExpression{
+		Token:     p.currToken,
+		Function:  function,
+		Arguments: []ast.Expression{},
+	}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return call
+	}
+
+	// the commas here separate arguments, they are not comma operators
+	for {
+		p.nextToken()
+		arg := p.parseAssignmentExpression()
+		if arg == nil {
+			return nil
+		}
+		call.Arguments = append(call.Arguments, arg)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	return call
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	index := &ast.IndexExpression{Token: p.currToken, Left: left}
+	p.nextToken()
+
+	index.Index = p.parseExpression(LOWEST)
+	if index.Index == nil || !p.expectPeek(token.RSQUARE) {
+		return nil
+	}
+
+	return index
+}
+
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	member := &ast.MemberExpression{Token: p.currToken, Left: left,
+		Arrow: p.currTokenIs(token.ARROW)}
+	if !p.expectPeek(token.IDENTIFIER) {
+		return nil
+	}
+	member.Member = p.currToken.Literal
+
+	return member
+}
+
+// Adjacent string literals are concatenated, "a" "b" is the same as "ab"
+func (p *Parser) parseStringLiteral() ast.Expression {
+	str := &ast.StringLiteral{Token: p.currToken}
+
+	var value strings.Builder
+	for {
+		literal := p.currToken.Literal
+		if len(literal) < 2 || literal[len(literal)-1] != '"' {
+			p.genericError("missing terminating '\"' character")
+			return nil
+		}
+
+		unescaped, err := unescape(literal[1 : len(literal)-1])
+		if err != nil {
+			p.genericError(err.Error())
+			return nil
+		}
+		value.WriteString(unescaped)
+
+		if !p.peekTokenIs(token.STRINGL) {
+			break
+		}
+		p.nextToken()
+	}
+
+	str.Value = value.String()
+	return str
+}
+
+var simpleEscapes = map[byte]byte{
+	'n': '\n', 't': '\t', 'r': '\r', 'a': '\a', 'b': '\b', 'f': '\f',
+	'v': '\v', '\\': '\\', '\'': '\'', '"': '"', '?': '?',
+}
+
+// Interpret the escape sequences in the contents of a string or character literal
+func unescape(s string) (string, error) {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			out.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if i >= len(s) {
+			return "", fmt.Errorf("incomplete escape sequence")
+		}
+
+		if c, ok := simpleEscapes[s[i]]; ok {
+			out.WriteByte(c)
+		} else if s[i] >= '0' && s[i] <= '7' { // up to 3 octal digits
+			var val int
+			for j := 0; j < 3 && i < len(s) && s[i] >= '0' && s[i] <= '7'; j++ {
+				val = val*8 + int(s[i]-'0')
+				i++
+			}
+			i--
+			out.WriteByte(byte(val))
+		} else if s[i] == 'x' {
+			start := i + 1
+			for i+1 < len(s) && isHexDigit(s[i+1]) {
+				i++
+			}
+
+			if start > i {
+				return "", fmt.Errorf("\\x used with no following hex digits")
+			}
+
+			val, err := strconv.ParseUint(s[start:i+1], 16, 64)
+			if err != nil || val > 0xFF {
+				return "", fmt.Errorf("hex escape sequence out of range")
+			}
+			out.WriteByte(byte(val))
+		} else {
+			return "", fmt.Errorf("unknown escape sequence: '\\%c'", s[i])
+		}
+	}
+
+	return out.String(), nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	val, err := strconv.ParseInt(p.currToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.currToken.Literal)
-		p.errors = append(p.errors, ParseError{token: p.currToken, msg: msg})
+		p.genericError(msg)
 		return nil
 	}
 
@@ -232,7 +498,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	val, err := strconv.ParseFloat(p.currToken.Literal, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as floating point", p.currToken.Literal)
-		p.errors = append(p.errors, ParseError{token: p.currToken, msg: msg})
+		p.genericError(msg)
 		return nil
 	}
 
@@ -246,7 +512,14 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		return nil
 	}
 
+	// an infix expression starts where its left operand does
+	start := p.currToken.Pos()
 	leftExp := prefix()
+	if leftExp == nil {
+		return nil
+	}
+
+	p.span(leftExp, start)
 	for p.peekPrecedence() > precedence {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
@@ -254,7 +527,10 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 		}
 
 		p.nextToken()
-		leftExp = infix(leftExp)
+		if leftExp = infix(leftExp); leftExp == nil {
+			return nil
+		}
+		p.span(leftExp, start)
 	}
 
 	return leftExp
@@ -10,17 +10,24 @@ type Parser struct {
 	l      *lexer.Lexer
 	errors []ParseError
 
+	// parsing stops after this many errors, 0 for no limit
+	ErrorLimit int
+
 	currToken token.Token
 	peekToken token.Token
 
+	// struct and union tags visible in each enclosing scope
+	tagScopes []map[string]*ast.StructOrUnionSpecification
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
-	p := Parser{l: l, errors: []ParseError{}}
+	p := Parser{l: l, errors: []ParseError{}, ErrorLimit: 20}
 
 	p.registerParseFns()
+	p.pushTagScope()
 
 	p.nextToken()
 	p.nextToken()
@@ -33,7 +40,9 @@ func (p *Parser) Errors() []ParseError {
 
 func (p *Parser) nextToken() {
 	p.currToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	if p.peekToken.Type != token.EOF {
+		p.peekToken = p.l.NextToken()
+	}
 }
 
 func (p *Parser) currTokenIs(t token.TokenType) bool {
@@ -56,19 +65,53 @@ func (p *Parser) expectPeek(ts ...token.TokenType) bool {
 	}
 
 	p.peekError(ts...)
-	return false
+
+	// a ';' missing at the end of a line was most likely just forgotten, so
+	// carry on as if it was there
+	return len(ts) == 1 && ts[0] == token.SEMICOLON && p.peekToken.Line > p.currToken.Line
+}
+
+// Record that the node covers everything from start to the end of the
+// current token
+func (p *Parser) span(node ast.Node, start token.Pos) {
+	if node != nil {
+		node.SetSpan(start, p.currToken.End())
+	}
 }
 
 func (p *Parser) Parse() *ast.TranslationUnit {
 	tUnit := &ast.TranslationUnit{DeclarationStatements: []*ast.DeclarationStatement{}}
+	start := p.currToken.Pos()
 	for !p.currTokenIs(token.EOF) {
 		for p.currTokenIs(token.SEMICOLON) { // skip blank statements
 			p.nextToken()
 		}
+		if p.currTokenIs(token.EOF) {
+			break
+		}
+
+		declStart := p.currToken.Pos()
+		declStmt := p.parseDeclarationStatement(true)
+		if declStmt == nil {
+			// keep going with the next declaration, so that all of the
+			// errors in the file are found at once
+			p.synchronize(false)
+			bad := &ast.BadDeclaration{}
+			p.span(bad, declStart)
+			declStmt = &ast.DeclarationStatement{Declarations: []ast.Declaration{bad}}
+			p.span(declStmt, declStart)
+		}
 
-		decl := p.parseDeclarationStatement(true)
-		tUnit.DeclarationStatements = append(tUnit.DeclarationStatements, decl)
+		tUnit.DeclarationStatements = append(tUnit.DeclarationStatements, declStmt)
 		p.nextToken()
 	}
+
+	for _, tok := range p.l.Comments() {
+		comment := &ast.Comment{Text: tok.Literal}
+		comment.SetSpan(tok.Pos(), tok.End())
+		tUnit.Comments = append(tUnit.Comments, comment)
+	}
+
+	p.span(tUnit, start)
 	return tUnit
 }
@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/tjarjoura/cc/pkg/ast"
@@ -159,6 +161,41 @@ func TestParseExpression(t *testing.T) {
 		{"int x = ~7 * 3;", "((~7) * 3)"},
 		{"int x = ~(7 * 3);", "(~(7 * 3))"},
 		{"int x = !~*&x*99;", "((!(~(*(&x)))) * 99)"},
+		{"int x = sizeof x + 1;", "((sizeof x) + 1)"},
+		{"int x = sizeof (x) * 2;", "((sizeof x) * 2)"},
+		{"int x = sizeof -x;", "(sizeof (-x))"},
+		{"int x = sizeof(int);", "sizeof(int)"},
+		{"int x = sizeof(unsigned);", "sizeof(int)"},
+		{"int x = sizeof(const char *);", "sizeof((const char) *)"},
+		{"int x = sizeof(int *[3]);", "sizeof(((int) *)[3])"},
+		{"int x = sizeof(int (*)[3]);", "sizeof(((int)[3]) *)"},
+		{"int x = sizeof(int[2][3]);", "sizeof(((int)[3])[2])"},
+		{"int x = sizeof(void (*)(int, char *));", "sizeof((void (int, (char) *)) *)"},
+		{"int x = sizeof(struct s *) * 2;", "(sizeof((struct s) *) * 2)"},
+		{"int x = _Alignof(long) + 1;", "(_Alignof(long int) + 1)"},
+		{"int x = a ? b : c;", "(a ? b : c)"},
+		{"int x = a || b ? c + 1 : d * 2;", "((a || b) ? (c + 1) : (d * 2))"},
+		{"int x = a ? b : c ? d : e;", "(a ? b : (c ? d : e))"},
+		{"int x = a ? b ? c : d : e;", "(a ? (b ? c : d) : e)"},
+		{"int x = a ? b, c : d;", "(a ? (b , c) : d)"},
+		{"int x = (a, b, c);", "((a , b) , c)"},
+		{"int x = (a = b = c);", "(a = (b = c))"},
+		{"int x = (a = b += c);", "(a = (b += c))"},
+		{"int x = (a = b ? c : d);", "(a = (b ? c : d))"},
+		{"int x = a <= b >= c;", "((a <= b) >= c)"},
+		{"int x = f();", "f()"},
+		{"int x = f(a, b = c, (d, e)) + 1;", "(f(a, (b = c), (d , e)) + 1)"},
+		{"int x = -f(g(1))(2);", "(-f(g(1))(2))"},
+		{"int x = va_arg(ap, int) * 2;", "(va_arg(ap, int) * 2)"},
+		{"int x = __builtin_va_arg(*ap, char *);", "va_arg((*ap), (char) *)"},
+		{`int x = printf("%d\n", 3);`, `printf("%d\n", 3)`},
+		{"int x = a[1] + b[i, j];", "(a[1] + b[(i , j)])"},
+		{"int x = *a[2];", "(*a[2])"},
+		{"int x = &s.m;", "(&s.m)"},
+		{"int x = -p->m[3].n;", "(-p->m[3].n)"},
+		{"int x = table[i](a, b);", "table[i](a, b)"},
+		{"int x = (*fp)(1) + s.run(2);", "((*fp)(1) + s.run(2))"},
+		{"int x = pick(0)(1, 1);", "pick(0)(1, 1)"},
 	}
 
 	for _, test := range tests {
@@ -208,6 +245,13 @@ func TestParseFunctionDeclaration(t *testing.T) {
 		{"int **f(int, int );", "((int) *) * f(int, int)"},
 		{"int ***f();", "(((int) *) *) * f()"},
 		{"char (*(*func())[5])();", "(((char ()) *)[5]) * func()"},
+		{"int f(void);", "int f()"},
+		{"int printf(const char *fmt, ...);", "int printf((const char) * fmt, ...)"},
+		{"void f(int, ...);", "void f(int, ...)"},
+		{"int vf(const char *, va_list ap);", "int vf((const char) *, va_list ap)"},
+		{"int apply(int (*op)(int, int), int a);", "int apply((int (int, int)) * op, int a)"},
+		{"int (*pick(int which))(int);", "(int (int)) * pick(int which)"},
+		{"static inline int f(int x);", "int f(int x)"},
 	}
 
 	for _, tt := range tests {
@@ -234,10 +278,183 @@ func TestParseFunctionDeclaration(t *testing.T) {
 		if fnDecl.String() != tt.expectedFn {
 			t.Fatalf("expected fnDecl to=%s, got=%s", tt.expectedFn, fnDecl.String())
 		}
+
+		if inline := strings.Contains(tt.input, "inline"); fnDecl.Inline != inline {
+			t.Fatalf("expected Inline=%t for %s", inline, tt.input)
+		}
+	}
+}
+
+func TestParseStructDeclaration(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType string
+	}{
+		{"struct s { int a; long b; } x;", "struct s { int a; long int b; }"},
+		{"struct s *x;", "(struct s) *"},
+		{"const union { int a; char b[4]; } x;", "const union { int a; (char)[4] b; }"},
+		{"struct list { struct list *next, *prev; } x;",
+			"struct list { (struct list) * next; (struct list) * prev; }"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		tUnit := p.Parse()
+		checkErrors(t, p)
+
+		if len(tUnit.DeclarationStatements) != 1 {
+			t.Fatalf("expected %d declaration statements, got=%d", 1,
+				len(tUnit.DeclarationStatements))
+		}
+
+		decl := tUnit.DeclarationStatements[0].Declarations[0]
+		testVariableDeclaration(t, decl, tt.expectedType, "x", "")
+	}
+}
+
+func TestParseStructTags(t *testing.T) {
+	input := `
+struct node *head;
+struct node { int value; struct node *next; };
+int f() {
+	struct node { char c; } inner;
+	struct node *outer;
+}`
+
+	p := New(lexer.New(input))
+	tUnit := p.Parse()
+	checkErrors(t, p)
+
+	if len(tUnit.DeclarationStatements) != 3 {
+		t.Fatalf("expected 3 declaration statements, got=%d",
+			len(tUnit.DeclarationStatements))
+	}
+
+	head := tUnit.DeclarationStatements[0].Declarations[0].(*ast.VariableDeclaration)
+	headTag := head.Type().(*ast.Pointer).PointsTo.(*ast.StructOrUnionSpecification)
+	if len(headTag.Fields()) != 2 {
+		t.Fatalf("expected forward declared struct node to have 2 fields, got=%d",
+			len(headTag.Fields()))
+	}
+
+	fnDecl := tUnit.DeclarationStatements[2].Declarations[0].(*ast.FunctionDeclaration)
+	stmts := fnDecl.Body.Statements
+	outer := stmts[1].(*ast.DeclarationStatement).Declarations[0].(*ast.VariableDeclaration)
+	outerTag := outer.Type().(*ast.Pointer).PointsTo.(*ast.StructOrUnionSpecification)
+	if len(outerTag.Fields()) != 1 {
+		t.Fatalf("expected inner struct node to shadow the outer one, got %d fields",
+			len(outerTag.Fields()))
+	}
+}
+
+func TestParseAlignas(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedAlignAs string
+	}{
+		{"_Alignas(16) int x;", "16"},
+		{"static _Alignas(long) char x[8];", "_Alignof(long int)"},
+		{"_Alignas(2 * 8) long x;", "(2 * 8)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		tUnit := p.Parse()
+		checkErrors(t, p)
+
+		varDecl, ok := tUnit.DeclarationStatements[0].Declarations[0].(*ast.VariableDeclaration)
+		if !ok {
+			t.Fatalf("expected decl to be *ast.VariableDeclaration, got=%T",
+				tUnit.DeclarationStatements[0].Declarations[0])
+		}
+
+		if varDecl.AlignAs == nil {
+			t.Fatalf("expected AlignAs to be set for %s", tt.input)
+		}
+
+		if varDecl.AlignAs.String() != tt.expectedAlignAs {
+			t.Fatalf("expected AlignAs=%s, got=%s", tt.expectedAlignAs,
+				varDecl.AlignAs.String())
+		}
+	}
+}
+
+func TestParseCommaSeparators(t *testing.T) {
+	input := "int x = 1, y[2, 3], z = (4, 5), w = a ? b, c : d;"
+	p := New(lexer.New(input))
+	p.Parse()
+
+	// an array size is a conditional expression, without commas
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected an error for the comma in the array size")
+	}
+
+	input = "int x = 1, z = (4, 5), w = a ? b, c : d;"
+	p = New(lexer.New(input))
+	tUnit := p.Parse()
+	checkErrors(t, p)
+
+	expectedDecls := []struct {
+		name       string
+		definition string
+	}{
+		{"x", "1"},
+		{"z", "(4 , 5)"},
+		{"w", "(a ? (b , c) : d)"},
+	}
+
+	decls := tUnit.DeclarationStatements[0].Declarations
+	if len(decls) != len(expectedDecls) {
+		t.Fatalf("expected len(decls)=%d, got=%d", len(expectedDecls),
+			len(decls))
+	}
+
+	for i, decl := range decls {
+		varDecl := decl.(*ast.VariableDeclaration)
+		if varDecl.Name != expectedDecls[i].name {
+			t.Errorf("expected name=%s, got=%s", expectedDecls[i].name,
+				varDecl.Name)
+		}
+
+		if varDecl.Definition.String() != expectedDecls[i].definition {
+			t.Errorf("expected definition=%s, got=%s",
+				expectedDecls[i].definition, varDecl.Definition.String())
+		}
 	}
 }
 
 // make sure different syntax errors don't crash the program and are handled gracefully
+func TestParseStringLiteral(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue string
+	}{
+		{`char *s = "hello";`, "hello"},
+		{`char *s = "";`, ""},
+		{`char *s = "a\tb\n\\\"";`, "a\tb\n\\\""},
+		{`char *s = "\x41\102\0";`, "AB\x00"},
+		{`char *s = "con" "cat"
+			"enated";`, "concatenated"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		tUnit := p.Parse()
+		checkErrors(t, p)
+
+		varDecl := tUnit.DeclarationStatements[0].Declarations[0].(*ast.VariableDeclaration)
+		str, ok := varDecl.Definition.(*ast.StringLiteral)
+		if !ok {
+			t.Fatalf("expected definition to be *ast.StringLiteral, got=%T",
+				varDecl.Definition)
+		}
+
+		if str.Value != tt.expectedValue {
+			t.Fatalf("expected Value=%q, got=%q", tt.expectedValue, str.Value)
+		}
+	}
+}
+
 func TestParseErrors(t *testing.T) {
 	tests := []struct {
 		input string
@@ -254,15 +471,180 @@ func TestParseErrors(t *testing.T) {
 		{"int (((()))*x);"},
 		{"int *const 3;"},
 		{"int a, b(){};"},
+		{"int x = sizeof(int x);"},
+		{"int x = _Alignof x;"},
+		{"struct { int a; } struct { int b; } x;"},
+		{"struct s { int a; }; struct s { int b; };"},
+		{"struct s x; union s y;"},
+		{"struct s { int f(); } x;"},
+		{"_Alignas(8) int f();"},
+		{"int x = a ? b;"},
+		{"int x = a ? : c;"},
+		{"int x = (a, );"},
+		{"int f(...);"},
+		{"int x = a[1;"},
+		{"int x = s.;"},
+		{"int x = p->3;"},
+		{"int f(int, ..., int);"},
+		{"int x = f(a,);"},
+		{"int x = va_arg(ap);"},
+		{"long va_list x;"},
+		{"inline int x;"},
+		{"int f(int a) { if a) return 1; }"},
+		{"int f(int a) { if (a) int x; }"},
+		{"int f(int a) { while (a) }"},
+		{"int f(int a) { do a = 1; }"},
+		{"int f(int a) { do a = 1; while (a) }"},
+		{"int f(int a) { for (a = 0; a) a = 1; }"},
+		{"int f(int a) { for (a = 0 a < 1;) a = 1; }"},
+		{"int f(int a) { break }"},
 	}
 
 	for _, tt := range tests {
 		p := New(lexer.New(tt.input))
-		p.Parse()
+		tUnit := p.Parse()
 
 		if len(p.Errors()) == 0 {
 			t.Fatalf("Expected errors from %s but got 0.", tt.input)
 		}
+		checkComplete(t, tt.input, tUnit)
+	}
+}
+
+// Make sure that a tree with errors in it has no nil declarations or
+// statements in it, they are replaced with bad nodes
+func checkComplete(t *testing.T, input string, tUnit *ast.TranslationUnit) {
+	var checkStatement func(stmt ast.Statement)
+	checkStatement = func(stmt ast.Statement) {
+		switch s := stmt.(type) {
+		case nil:
+			t.Errorf("%q: nil statement in the tree", input)
+		case *ast.BlockStatement:
+			for _, stmt := range s.Statements {
+				checkStatement(stmt)
+			}
+		case *ast.DeclarationStatement:
+			for _, decl := range s.Declarations {
+				if decl == nil {
+					t.Errorf("%q: nil declaration in the tree", input)
+				} else if fn, ok := decl.(*ast.FunctionDeclaration); ok && fn.Body != nil {
+					checkStatement(fn.Body)
+				}
+			}
+		}
+	}
+
+	for _, declStmt := range tUnit.DeclarationStatements {
+		checkStatement(declStmt)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	input := `int f(int a) {
+	int x = a +;
+	int y = 2
+	return x + y;
+}
+
+int g( { return 1; }
+
+struct s { int a; } 3;
+
+int main() {
+	f(1;
+	{ int z = ; }
+	return g() +
+}
+int h() { return 4; }`
+
+	p := New(lexer.New(input))
+	tUnit := p.Parse()
+	checkComplete(t, input, tUnit)
+
+	expectedErrors := []string{
+		"2:13: error: no prefix parse function for ; found",
+		"3:11: error: expected next token to be ';', got 'return' instead",
+		"7:8: error: type specifier missing. implicit int is not supported by this compiler",
+		"9:21: error: expected next token to be 'IDENTIFIER or ( or *', got '3' instead",
+		"12:5: error: expected next token to be ')', got ';' instead",
+		"13:12: error: no prefix parse function for ; found",
+		"15:1: error: no prefix parse function for } found",
+	}
+
+	errors := p.Errors()
+	if len(errors) != len(expectedErrors) {
+		t.Fatalf("expected %d errors, got %v", len(expectedErrors), errors)
+	}
+
+	for i, err := range errors {
+		if err.String() != expectedErrors[i] {
+			t.Errorf("expected %q, got %q", expectedErrors[i], err.String())
+		}
+	}
+
+	// every declaration is still there, and the statements in the bodies
+	// that could not be parsed are bad statements
+	expected := []struct {
+		declaration string
+		statements  []string
+	}{
+		{"f", []string{"*ast.BadStatement", "*ast.DeclarationStatement", "*ast.ReturnStatement"}},
+		{"*ast.BadDeclaration", nil},
+		{"*ast.BadDeclaration", nil},
+		{"main", []string{"*ast.BadStatement", "*ast.BlockStatement", "*ast.BadStatement"}},
+		{"h", []string{"*ast.ReturnStatement"}},
+	}
+
+	decls := tUnit.DeclarationStatements
+	if len(decls) != len(expected) {
+		t.Fatalf("expected %d declarations, got %d", len(expected), len(decls))
+	}
+
+	for i, tt := range expected {
+		decl := decls[i].Declarations[0]
+		fn, ok := decl.(*ast.FunctionDeclaration)
+		if !ok {
+			if fmt.Sprintf("%T", decl) != tt.declaration {
+				t.Errorf("expected %s, got %T", tt.declaration, decl)
+			}
+			continue
+		}
+
+		if fn.Name != tt.declaration || len(fn.Body.Statements) != len(tt.statements) {
+			t.Errorf("expected %s with %d statements, got %s with %d", tt.declaration,
+				len(tt.statements), fn.Name, len(fn.Body.Statements))
+			continue
+		}
+
+		for j, stmt := range fn.Body.Statements {
+			if fmt.Sprintf("%T", stmt) != tt.statements[j] {
+				t.Errorf("%s: expected statement %d to be %s, got %T", fn.Name, j,
+					tt.statements[j], stmt)
+			}
+		}
+	}
+}
+
+func TestErrorLimit(t *testing.T) {
+	input := strings.Repeat("int = 1;\n", 30)
+	p := New(lexer.New(input))
+	p.Parse()
+
+	errors := p.Errors()
+	if len(errors) != p.ErrorLimit+1 {
+		t.Fatalf("expected %d errors, got %d", p.ErrorLimit+1, len(errors))
+	}
+
+	last := errors[len(errors)-1].String()
+	if last != "21:5: error: too many errors emitted, stopping now" {
+		t.Errorf("expected to stop at line 21, got %q", last)
+	}
+
+	p = New(lexer.New(input))
+	p.ErrorLimit = 0
+	p.Parse()
+	if len(p.Errors()) != 30 {
+		t.Errorf("expected 30 errors without a limit, got %d", len(p.Errors()))
 	}
 }
 
@@ -320,3 +702,197 @@ int main(int argc, char **argv) {
 		}
 	}
 }
+
+func TestParseNestedBlocks(t *testing.T) {
+	input := `
+int main() {
+	int x = 1;
+	{
+		int x = 2;
+		{ x = 3; }
+	}
+	{}
+	return x;
+}`
+
+	p := New(lexer.New(input))
+	tUnit := p.Parse()
+	checkErrors(t, p)
+
+	fnDecl := tUnit.DeclarationStatements[0].Declarations[0].(*ast.FunctionDeclaration)
+	stmts := fnDecl.Body.Statements
+	if len(stmts) != 4 {
+		t.Fatalf("expected len(stmts)=4, got=%d", len(stmts))
+	}
+
+	outer, ok := stmts[1].(*ast.BlockStatement)
+	if !ok {
+		t.Fatalf("expected stmts[1] to be *ast.BlockStatement, got=%T", stmts[1])
+	} else if len(outer.Statements) != 2 {
+		t.Fatalf("expected 2 statements in the block, got=%d",
+			len(outer.Statements))
+	}
+
+	inner, ok := outer.Statements[1].(*ast.BlockStatement)
+	if !ok {
+		t.Fatalf("expected a nested *ast.BlockStatement, got=%T",
+			outer.Statements[1])
+	} else if inner.String() != "(x = 3);" {
+		t.Fatalf("expected inner block to be (x = 3);, got=%s", inner.String())
+	}
+
+	if empty, ok := stmts[2].(*ast.BlockStatement); !ok || len(empty.Statements) != 0 {
+		t.Fatalf("expected stmts[2] to be an empty block, got=%s", stmts[2])
+	}
+}
+
+func TestParseControlFlow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if (a) return 1;", "if (a) return 1;"},
+		{"if (a < b) x = 1; else x = 2;", "if ((a < b)) (x = 1); else (x = 2);"},
+		{"if (a) if (b) x = 1; else x = 2;", "if (a) if (b) (x = 1); else (x = 2);"},
+		{"if (a) x = 1; else if (b) x = 2; else { x = 3; }", "if (a) (x = 1); else if (b) (x = 2); else (x = 3);"},
+		{"while (n) n = n - 1;", "while (n) (n = (n - 1));"},
+		{"do { n = n - 1; } while (n > 0);", "do (n = (n - 1)); while ((n > 0));"},
+		{"for (i = 0; i < n; i = i + 1) break;", "for ((i = 0); (i < n); (i = (i + 1))) break;"},
+		{"for (int i = 0, j; ; ) continue;", "for (int i = 0, int j;;) continue;"},
+		{"for (;;) {}", "for (;;) "},
+	}
+
+	for _, tt := range tests {
+		input := fmt.Sprintf("int f(int a, int b, int n) { int i, x; %s }", tt.input)
+		p := New(lexer.New(input))
+		tUnit := p.Parse()
+		checkErrors(t, p)
+
+		fnDecl := tUnit.DeclarationStatements[0].Declarations[0].(*ast.FunctionDeclaration)
+		stmts := fnDecl.Body.Statements
+		if len(stmts) != 2 {
+			t.Fatalf("%q: expected 2 statements, got=%d", tt.input, len(stmts))
+		}
+		if stmts[1].String() != tt.expected {
+			t.Errorf("%q: expected %s, got=%s", tt.input, tt.expected, stmts[1].String())
+		}
+	}
+}
+
+// An else goes with the if that is closest to it
+func TestParseDanglingElse(t *testing.T) {
+	p := New(lexer.New("int f(int a, int b) { if (a) if (b) return 1; else return 2; return 3; }"))
+	tUnit := p.Parse()
+	checkErrors(t, p)
+
+	fnDecl := tUnit.DeclarationStatements[0].Declarations[0].(*ast.FunctionDeclaration)
+	outer, ok := fnDecl.Body.Statements[0].(*ast.IfStatement)
+	if !ok {
+		t.Fatalf("expected an *ast.IfStatement, got=%T", fnDecl.Body.Statements[0])
+	} else if outer.Alternative != nil {
+		t.Fatalf("expected the outer if not to have an else, got=%s", outer.Alternative)
+	}
+
+	inner, ok := outer.Consequence.(*ast.IfStatement)
+	if !ok || inner.Alternative == nil {
+		t.Fatalf("expected the inner if to have the else, got=%s", outer.Consequence)
+	}
+}
+
+func TestTypeString(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"int x;", "int"},
+		{"const char *x;", "const char *"},
+		{"char *const *x;", "char *const *"},
+		{"unsigned long x;", "unsigned long int"},
+		{"int (*x)[3];", "int (*)[3]"},
+		{"int *x[3];", "int *[3]"},
+		{"int x[2][3];", "int[2][3]"},
+		{"void (*x)(int, ...);", "void (*)(int, ...)"},
+		{"int (*x)(void);", "int (*)(void)"},
+		{"int x(char *s, int a[]);", "int(char *, int *)"},
+		{"int *(*x)(int);", "int *(*)(int)"},
+		{"int (*(*x)(int))[4];", "int (*(*)(int))[4]"},
+		{"struct s { int a; } *const volatile x;", "struct s *const volatile"},
+		{"struct { int a; } x;", "struct <anonymous>"},
+		{"volatile va_list x;", "volatile va_list"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		tUnit := p.Parse()
+		checkErrors(t, p)
+
+		decls := tUnit.DeclarationStatements[0].Declarations
+		decl := decls[len(decls)-1]
+		var typ ast.Declaration = decl
+		if v, ok := decl.(*ast.VariableDeclaration); ok {
+			typ = v.Type()
+		}
+
+		if got := ast.TypeString(typ); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	input := "int x = 1 + 2;\nint main() {\n\treturn (x) * f(3);\n}"
+	p := New(lexer.NewFile("t.c", input))
+	tUnit := p.Parse()
+	checkErrors(t, p)
+
+	varDecl := tUnit.DeclarationStatements[0].Declarations[0].(*ast.VariableDeclaration)
+	fn := tUnit.DeclarationStatements[1].Declarations[0].(*ast.FunctionDeclaration)
+	ret := fn.Body.Statements[0].(*ast.ReturnStatement)
+	mul := ret.ReturnValue.(*ast.InfixExpression)
+
+	tests := []struct {
+		node ast.Node
+		pos  string
+		end  string
+	}{
+		{tUnit, "t.c:1:1", "t.c:4:2"},
+		{tUnit.DeclarationStatements[0], "t.c:1:1", "t.c:1:15"},
+		{varDecl, "t.c:1:1", "t.c:1:14"},
+		{varDecl.Type(), "t.c:1:1", "t.c:1:4"},
+		{varDecl.Definition, "t.c:1:9", "t.c:1:14"},
+		{fn, "t.c:2:1", "t.c:4:2"},
+		{fn.Body, "t.c:2:12", "t.c:4:2"},
+		{ret, "t.c:3:2", "t.c:3:20"},
+		{mul, "t.c:3:9", "t.c:3:19"},
+		{mul.Left, "t.c:3:9", "t.c:3:12"},
+		{mul.Right, "t.c:3:15", "t.c:3:19"},
+	}
+
+	for i, tt := range tests {
+		if tt.node.Pos().String() != tt.pos || tt.node.End().String() != tt.end {
+			t.Errorf("[%d] expected %q to span %s-%s, got %s-%s", i,
+				tt.node.String(), tt.pos, tt.end, tt.node.Pos(), tt.node.End())
+		}
+	}
+
+	if varDecl.NamePos.String() != "t.c:1:5" || fn.NamePos.String() != "t.c:2:5" {
+		t.Errorf("expected names at t.c:1:5 and t.c:2:5, got %s and %s",
+			varDecl.NamePos, fn.NamePos)
+	}
+}
+
+func TestMissingSemicolonFixIt(t *testing.T) {
+	p := New(lexer.New("int main() {\n\tint x = 1\n\treturn x;\n}"))
+	p.Parse()
+
+	errors := p.Errors()
+	if len(errors) == 0 {
+		t.Fatalf("expected a parser error")
+	}
+
+	err := errors[0]
+	if err.Pos.String() != "2:11" || len(err.FixIts) != 1 || err.FixIts[0].Replacement != ";" {
+		t.Errorf("expected to insert ';' at 2:11, got %s with fix-its %v",
+			err.String(), err.FixIts)
+	}
+}
@@ -6,45 +6,275 @@ import (
 )
 
 func (p *Parser) parseDeclarationStatement(topLevel bool) *ast.DeclarationStatement {
+	start := p.currToken.Pos()
 	decls := p.parseDeclarations(topLevel)
-	return &ast.DeclarationStatement{Declarations: decls}
+	if decls == nil {
+		return nil
+	}
+
+	declStmt := &ast.DeclarationStatement{Declarations: decls}
+	p.span(declStmt, start)
+	return declStmt
 }
 
 func (p *Parser) parseStatement() ast.Statement {
+	start := p.currToken.Pos()
 	switch p.currToken.Type {
 	case token.RETURN:
-		p.nextToken()
-		returnStmt := &ast.ReturnStatement{ReturnValue: p.parseExpression(LOWEST)}
+		returnStmt := &ast.ReturnStatement{}
+		if !p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+			if returnStmt.ReturnValue = p.parseExpression(LOWEST); returnStmt.ReturnValue == nil {
+				return nil
+			}
+		}
+
 		if !p.expectPeek(token.SEMICOLON) {
 			return nil
 		}
 
+		p.span(returnStmt, start)
 		return returnStmt
+	case token.LBRACE:
+		return p.parseBlockStatement()
+	case token.IF:
+		return p.parseIfStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.DO:
+		return p.parseDoWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		var stmt ast.Statement = &ast.BreakStatement{}
+		if p.currTokenIs(token.CONTINUE) {
+			stmt = &ast.ContinueStatement{}
+		}
+		if !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+
+		p.span(stmt, start)
+		return stmt
 	default:
-		if p.currTokenIsStorageClass() || p.currTokenIsType() {
-			return p.parseDeclarationStatement(false)
+		if p.currTokenIsDeclarationSpecifier() {
+			if declStmt := p.parseDeclarationStatement(false); declStmt != nil {
+				return declStmt
+			}
+			return nil
 		}
 
 		exprStmt := &ast.ExpressionStatement{Expression: p.parseExpression(LOWEST)}
-		if !p.expectPeek(token.SEMICOLON) {
+		if exprStmt.Expression == nil || !p.expectPeek(token.SEMICOLON) {
 			return nil
 		}
 
+		p.span(exprStmt, start)
 		return exprStmt
 	}
 }
 
+// The parenthesized condition of an if or a loop, after its keyword
+func (p *Parser) parseCondition() ast.Expression {
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	cond := p.parseExpression(LOWEST)
+	if cond == nil || !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	return cond
+}
+
+// The statement that follows the head of an if, a loop or an else, which
+// can't be a declaration unless it is in a block
+func (p *Parser) parseBody() ast.Statement {
+	p.nextToken()
+	if p.currTokenIsDeclarationSpecifier() {
+		p.genericError("expected a statement, a declaration is only allowed in a block")
+		return nil
+	}
+	return p.parseStatement()
+}
+
+// An else belongs to the innermost if that doesn't have one yet
+func (p *Parser) parseIfStatement() ast.Statement {
+	ifStmt := &ast.IfStatement{}
+	start := p.currToken.Pos()
+	if ifStmt.Condition = p.parseCondition(); ifStmt.Condition == nil {
+		return nil
+	}
+	if ifStmt.Consequence = p.parseBody(); ifStmt.Consequence == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.ELSE) {
+		p.nextToken()
+		if ifStmt.Alternative = p.parseBody(); ifStmt.Alternative == nil {
+			return nil
+		}
+	}
+
+	p.span(ifStmt, start)
+	return ifStmt
+}
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	whileStmt := &ast.WhileStatement{}
+	start := p.currToken.Pos()
+	if whileStmt.Condition = p.parseCondition(); whileStmt.Condition == nil {
+		return nil
+	}
+	if whileStmt.Body = p.parseBody(); whileStmt.Body == nil {
+		return nil
+	}
+
+	p.span(whileStmt, start)
+	return whileStmt
+}
+
+func (p *Parser) parseDoWhileStatement() ast.Statement {
+	doStmt := &ast.DoWhileStatement{}
+	start := p.currToken.Pos()
+	if doStmt.Body = p.parseBody(); doStmt.Body == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.WHILE) {
+		return nil
+	}
+	if doStmt.Condition = p.parseCondition(); doStmt.Condition == nil {
+		return nil
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	p.span(doStmt, start)
+	return doStmt
+}
+
+// Any of the clauses can be left out. The first one can declare variables,
+// which are only visible in the loop, and so are the tags it declares.
+func (p *Parser) parseForStatement() ast.Statement {
+	forStmt := &ast.ForStatement{}
+	start := p.currToken.Pos()
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.pushTagScope()
+	defer p.popTagScope()
+
+	p.nextToken()
+	if p.currTokenIsDeclarationSpecifier() {
+		declStmt := p.parseDeclarationStatement(false)
+		if declStmt == nil {
+			return nil
+		}
+		forStmt.Init = declStmt
+	} else if !p.currTokenIs(token.SEMICOLON) {
+		initStart := p.currToken.Pos()
+		exprStmt := &ast.ExpressionStatement{Expression: p.parseExpression(LOWEST)}
+		if exprStmt.Expression == nil || !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+		p.span(exprStmt, initStart)
+		forStmt.Init = exprStmt
+	}
+
+	if !p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+		if forStmt.Condition = p.parseExpression(LOWEST); forStmt.Condition == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.SEMICOLON) {
+		return nil
+	}
+
+	if !p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		if forStmt.Post = p.parseExpression(LOWEST); forStmt.Post == nil {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if forStmt.Body = p.parseBody(); forStmt.Body == nil {
+		return nil
+	}
+
+	p.span(forStmt, start)
+	return forStmt
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	blockStmt := &ast.BlockStatement{Statements: []ast.Statement{}}
+	start := p.currToken.Pos()
+	p.pushTagScope()
+	defer p.popTagScope()
 
 	for !p.peekTokenIs(token.RBRACE, token.EOF) {
 		p.nextToken()
-		blockStmt.Statements = append(blockStmt.Statements, p.parseStatement())
-	}
+		stmtStart := p.currToken.Pos()
+		stmt := p.parseStatement()
+		if stmt != nil {
+			blockStmt.Statements = append(blockStmt.Statements, stmt)
+			continue
+		}
 
-	if !p.expectPeek(token.RBRACE) {
-		return nil
+		p.synchronize(true)
+		bad := &ast.BadStatement{}
+		p.span(bad, stmtStart)
+		blockStmt.Statements = append(blockStmt.Statements, bad)
+
+		// the statement that failed used up the '}' of the block
+		if p.currTokenIs(token.RBRACE) {
+			p.span(blockStmt, start)
+			return blockStmt
+		}
 	}
 
+	// the block is kept even when the '}' is missing, so the statements in
+	// it can still be looked at
+	p.expectPeek(token.RBRACE)
+	p.span(blockStmt, start)
 	return blockStmt
 }
+
+// Skip the rest of a statement or declaration that could not be parsed, up
+// to its ';' or to just before the '}' of the block it is in. At the top
+// level a '}' ends a function body instead, so it is skipped too.
+func (p *Parser) synchronize(inBlock bool) {
+	depth := 0
+	for first := true; ; first = false {
+		switch p.currToken.Type {
+		case token.EOF:
+			return
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if first && !p.failedAt(p.currToken) {
+				break // the end of something in the statement, like a struct
+			}
+
+			depth--
+			if depth < 0 || (depth == 0 && !inBlock) {
+				return
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				return
+			}
+		}
+
+		if p.peekTokenIs(token.EOF) || (inBlock && depth == 0 && p.peekTokenIs(token.RBRACE)) {
+			return
+		}
+		p.nextToken()
+	}
+}
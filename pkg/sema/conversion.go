@@ -0,0 +1,168 @@
+package sema
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/diag"
+	"github.com/tjarjoura/cc/pkg/layout"
+)
+
+// Wrap the expression in an implicit conversion to type t
+func (c *Checker) convert(expr *ast.Expression, kind ast.ConversionKind,
+	t ast.Declaration) ast.Declaration {
+	conv := &ast.ImplicitConversion{Kind: kind, Expression: *expr, To: t}
+	conv.SetSpan((*expr).Pos(), (*expr).End())
+	*expr = conv
+	return c.record(conv, t, RValue)
+}
+
+// Convert an arithmetic value to another arithmetic type, if they differ
+func (c *Checker) convertTo(expr *ast.Expression, t ast.Declaration) ast.Declaration {
+	from := c.info.Types[*expr].Type
+	if ast.SameType(from, t) {
+		return t
+	} else if !ast.IsArithmetic(from) || !ast.IsArithmetic(t) {
+		return c.convert(expr, ast.PointerConversion, t)
+	} else if ast.IsFloat(from) || ast.IsFloat(t) {
+		return c.convert(expr, ast.FloatingConversion, t)
+	}
+
+	return c.convert(expr, ast.IntegerConversion, t)
+}
+
+// Where a value is assigned to an object, used to describe problems with the
+// conversion
+type context struct {
+	kind     string // "assignment", "initialization", "return" or "argument"
+	function string // the called function, for arguments
+	argument int
+}
+
+var (
+	inAssignment     = context{kind: "assignment"}
+	inInitialization = context{kind: "initialization"}
+	inReturn         = context{kind: "return"}
+)
+
+func inArgument(function string, argument int) context {
+	return context{kind: "argument", function: function, argument: argument}
+}
+
+func (ctx context) describe(to ast.Declaration, from ast.Declaration) string {
+	switch ctx.kind {
+	case "initialization":
+		return fmt.Sprintf("initialization of '%s' from '%s'",
+			ast.TypeString(to), ast.TypeString(from))
+	case "return":
+		return fmt.Sprintf("returning '%s' from a function with return type '%s'",
+			ast.TypeString(from), ast.TypeString(to))
+	case "argument":
+		return fmt.Sprintf("passing '%s' to parameter %d of '%s' of type '%s'",
+			ast.TypeString(from), ctx.argument, ctx.function, ast.TypeString(to))
+	}
+
+	return fmt.Sprintf("assignment to '%s' from '%s'", ast.TypeString(to),
+		ast.TypeString(from))
+}
+
+// Check that the value of the expression can be assigned to an object of
+// type t and convert it
+func (c *Checker) assign(expr *ast.Expression, t ast.Declaration,
+	ctx context) ast.Declaration {
+	from := c.value(expr)
+	if from == nil {
+		return nil
+	}
+
+	// problems with an assignment are reported at the operator, the others
+	// at the value
+	if ctx.kind != "assignment" {
+		defer c.at((*expr).Pos(), span(*expr))()
+	}
+
+	if ast.IsVoid(from) {
+		c.err("void value not ignored as it ought to be")
+		return nil
+	}
+
+	t = ast.Unqualified(t)
+	kind, problem, ok := ast.AssignmentConversion(t, from,
+		isNullPointerConstant(*expr))
+	if !ok {
+		c.err(fmt.Sprintf("%s in %s", problem, ctx.describe(t, from)))
+		return nil
+	} else if problem != "" {
+		c.warn(conversionFlag(kind, problem),
+			fmt.Sprintf("%s %s", ctx.describe(t, from), problem))
+	} else if kind == ast.IntegerConversion && ast.IntegerWidth(t) < ast.IntegerWidth(from) &&
+		!fits(*expr, t) {
+		c.warn(diag.ImplicitConversion, fmt.Sprintf(
+			"conversion from '%s' to '%s' may change value",
+			ast.TypeString(from), ast.TypeString(t)))
+	}
+
+	if kind == "" {
+		return t
+	}
+	return c.convert(expr, kind, t)
+}
+
+// The warning that controls a problem with an assignment conversion
+func conversionFlag(kind ast.ConversionKind, problem string) diag.Flag {
+	switch {
+	case kind == ast.IntegerToPointer || kind == ast.PointerToInteger:
+		return diag.IntConversion
+	case strings.HasPrefix(problem, "discards"):
+		return diag.DiscardedQualifiers
+	}
+	return diag.IncompatiblePointerTypes
+}
+
+// Whether the expression is a constant that can be represented in the
+// integer type t
+func fits(expr ast.Expression, t ast.Declaration) bool {
+	value, ok := constantValue(expr)
+	if !ok {
+		return false
+	}
+
+	width := ast.IntegerWidth(t)
+	if ast.IsUnsigned(t) {
+		return value >= 0 && (width >= 64 || value < 1<<width)
+	}
+	return width >= 64 || (value >= -(1<<(width-1)) && value < 1<<(width-1))
+}
+
+// Whether two pointer types point to compatible types, ignoring qualifiers
+func compatiblePointers(a ast.Declaration, b ast.Declaration) bool {
+	return ast.Compatible(ast.Unqualified(a.(*ast.Pointer).PointsTo),
+		ast.Unqualified(b.(*ast.Pointer).PointsTo))
+}
+
+func isVoidPointer(t ast.Declaration) bool {
+	p, ok := t.(*ast.Pointer)
+	return ok && ast.IsVoid(p.PointsTo)
+}
+
+func isNullPointerConstant(expr ast.Expression) bool {
+	value, ok := constantValue(expr)
+	return ok && value == 0
+}
+
+// Evaluate an integer constant expression, ok is false if the value can not
+// be known at compile time
+func constantValue(expr ast.Expression) (int64, bool) {
+	v, err := layout.Eval(expr)
+	return v.Int, err == nil
+}
+
+// Warn if the expression is a constant whose value overflows its type
+func (c *Checker) checkOverflow(expr ast.Expression) {
+	if v, err := layout.Eval(expr); err == nil && v.Overflow {
+		c.warn(diag.Overflow, fmt.Sprintf(
+			"integer overflow in expression of type '%s' results in '%d'",
+			ast.TypeString(v.Type), v.Int))
+	}
+}
@@ -0,0 +1,739 @@
+package sema
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/diag"
+	"github.com/tjarjoura/cc/pkg/layout"
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+func (c *Checker) record(expr ast.Expression, t ast.Declaration,
+	category ValueCategory) ast.Declaration {
+	if t != nil {
+		c.info.Types[expr] = TypeAndValue{Type: t, Category: category}
+	}
+	return t
+}
+
+func (c *Checker) category(expr ast.Expression) ValueCategory {
+	return c.info.Types[expr].Category
+}
+
+// Check an expression and return its type, or nil if it is invalid. Arrays and
+// functions are left alone, see value().
+func (c *Checker) expr(expr ast.Expression) ast.Declaration {
+	if tv, ok := c.info.Types[expr]; ok { // already checked
+		return tv.Type
+	} else if expr == nil {
+		return nil
+	}
+
+	defer c.at(position(expr), ranges(expr)...)()
+
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral:
+		if e.Value > math.MaxInt32 {
+			return c.record(e, ast.LongType, RValue)
+		}
+		return c.record(e, ast.IntType, RValue)
+	case *ast.FloatLiteral:
+		return c.record(e, ast.DoubleType, RValue)
+	case *ast.StringLiteral:
+		size := int64(len(e.Value) + 1)
+		t := &ast.Array{ArrayOf: ast.CharType, ArraySize: &ast.IntegerLiteral{
+			Token: token.Token{Type: token.INTL, Literal: strconv.FormatInt(size, 10)},
+			Value: size}}
+		return c.record(e, t, LValue)
+	case *ast.Identifier:
+		return c.checkIdentifier(e)
+	case *ast.PrefixExpression:
+		return c.checkPrefix(e)
+	case *ast.InfixExpression:
+		return c.checkInfix(e)
+	case *ast.ConditionalExpression:
+		return c.checkConditional(e)
+	case *ast.SizeofExpression:
+		return c.checkSizeof(e)
+	case *ast.AlignofExpression:
+		c.checkType(e.TypeName)
+		if _, ok := e.TypeName.(*ast.FunctionDeclaration); ok {
+			c.err("invalid application of '_Alignof' to a function type")
+			return nil
+		} else if !ast.IsComplete(e.TypeName) && !layout.IsVLA(e.TypeName) {
+			c.err(fmt.Sprintf("invalid application of '_Alignof' to incomplete type '%s'",
+				ast.TypeString(e.TypeName)))
+			return nil
+		}
+		return c.record(e, ast.SizeType, RValue)
+	case *ast.CallExpression:
+		return c.checkCall(e)
+	case *ast.VaArgExpression:
+		return c.checkVaArg(e)
+	case *ast.IndexExpression:
+		return c.checkIndex(e)
+	case *ast.MemberExpression:
+		return c.checkMember(e)
+	}
+
+	c.err(fmt.Sprintf("unexpected expression '%s'", expr.String()))
+	return nil
+}
+
+// Where problems with an expression are reported, GCC points at the operator
+// of binary expressions and member accesses rather than where they start
+func position(expr ast.Expression) token.Pos {
+	switch e := expr.(type) {
+	case *ast.InfixExpression:
+		return e.Token.Pos()
+	case *ast.ConditionalExpression:
+		return e.Token.Pos()
+	case *ast.MemberExpression:
+		return e.Token.Pos()
+	}
+
+	return expr.Pos()
+}
+
+// What is underlined around the position, the operands of binary expressions
+// and the whole expression otherwise
+func ranges(expr ast.Expression) []diag.Range {
+	switch e := expr.(type) {
+	case *ast.InfixExpression:
+		if e.Left != nil && e.Right != nil {
+			return []diag.Range{span(e.Left), span(e.Right)}
+		}
+	case *ast.MemberExpression:
+		if e.Left != nil {
+			return []diag.Range{span(e.Left)}
+		}
+	}
+
+	return []diag.Range{span(expr)}
+}
+
+// Check an expression whose value is used. Arrays and functions are converted
+// to pointers, and the returned type is the converted one.
+func (c *Checker) value(expr *ast.Expression) ast.Declaration {
+	t := c.expr(*expr)
+	if t == nil {
+		return nil
+	}
+
+	switch t.(type) {
+	case *ast.Array:
+		return c.convert(expr, ast.ArrayToPointer, ast.Decay(t))
+	case *ast.FunctionDeclaration:
+		return c.convert(expr, ast.FunctionToPointer, ast.Decay(t))
+	}
+
+	if ast.IsVaList(t) { // va_list is an array type in the SysV ABI
+		return c.convert(expr, ast.ArrayToPointer, &ast.Pointer{PointsTo: t})
+	}
+
+	return t
+}
+
+// Like value(), but the result has to be a scalar
+func (c *Checker) scalar(expr *ast.Expression) ast.Declaration {
+	t := c.value(expr)
+	if t != nil && !ast.IsScalar(t) {
+		c.err("used a value that is not a scalar where a scalar is required")
+		return nil
+	}
+
+	return t
+}
+
+func (c *Checker) checkIdentifier(ident *ast.Identifier) ast.Declaration {
+	decl := c.lookup(ident.Value)
+	if decl == nil {
+		c.err(fmt.Sprintf("'%s' undeclared", ident.Value))
+		return nil
+	}
+	c.info.Uses[ident] = decl
+	c.used[decl] = true
+
+	if c.parameters[decl] {
+		return c.record(ident, ast.ParameterType(decl), LValue)
+	}
+
+	switch d := decl.(type) {
+	case *ast.FunctionDeclaration:
+		return c.record(ident, d, FunctionDesignator)
+	case *ast.VariableDeclaration:
+		if fn, ok := d.Type().(*ast.FunctionDeclaration); ok {
+			return c.record(ident, fn, FunctionDesignator)
+		}
+		return c.record(ident, d.Type(), LValue)
+	}
+
+	return nil
+}
+
+func (c *Checker) checkPrefix(p *ast.PrefixExpression) ast.Declaration {
+	switch p.Operator {
+	case token.AMP:
+		t := c.expr(p.Right)
+		if t == nil {
+			return nil
+		} else if c.category(p.Right) == RValue {
+			c.err("lvalue required as unary '&' operand")
+			return nil
+		}
+		return c.record(p, &ast.Pointer{PointsTo: t}, RValue)
+	case token.ASTERISK:
+		t := c.value(&p.Right)
+		if t == nil {
+			return nil
+		}
+
+		ptr, ok := t.(*ast.Pointer)
+		if !ok {
+			c.err(fmt.Sprintf("invalid type argument of unary '*' (have '%s')",
+				ast.TypeString(t)))
+			return nil
+		} else if ast.IsVoid(ptr.PointsTo) {
+			c.err("dereferencing 'void *' pointer")
+			return nil
+		} else if fn, ok := ptr.PointsTo.(*ast.FunctionDeclaration); ok {
+			return c.record(p, fn, FunctionDesignator)
+		}
+		return c.record(p, ptr.PointsTo, LValue)
+	case token.NOT:
+		if t := c.value(&p.Right); t == nil {
+			return nil
+		} else if !ast.IsScalar(t) {
+			c.err("wrong type argument to unary exclamation mark")
+			return nil
+		}
+		return c.record(p, ast.IntType, RValue)
+	}
+
+	t := c.value(&p.Right)
+	if t == nil {
+		return nil
+	}
+
+	switch p.Operator {
+	case token.MINUS, token.PLUS:
+		if !ast.IsArithmetic(t) {
+			name := map[string]string{token.MINUS: "minus", token.PLUS: "plus"}
+			c.err(fmt.Sprintf("wrong type argument to unary %s", name[p.Operator]))
+			return nil
+		}
+	case token.BITNOT:
+		if !ast.IsInteger(t) {
+			c.err("wrong type argument to bit-complement")
+			return nil
+		}
+	default:
+		c.err(fmt.Sprintf("unexpected prefix operator '%s'", p.Operator))
+		return nil
+	}
+
+	t = c.record(p, c.convertTo(&p.Right, ast.Promote(t)), RValue)
+	c.checkOverflow(p)
+	return t
+}
+
+var compoundOperators = map[string]string{
+	token.PLUSA:     token.PLUS,
+	token.MINUSA:    token.MINUS,
+	token.ASTERISKA: token.ASTERISK,
+	token.SLASHA:    token.SLASH,
+	token.MODA:      token.MOD,
+	token.LSHIFTA:   token.LSHIFT,
+	token.RSHIFTA:   token.RSHIFT,
+	token.BITANDA:   token.AMP,
+	token.BITORA:    token.BITOR,
+	token.BITXORA:   token.BITXOR,
+}
+
+func (c *Checker) checkInfix(inf *ast.InfixExpression) ast.Declaration {
+	switch inf.Operator {
+	case token.COMMA:
+		if c.expr(inf.Left) == nil {
+			return nil
+		}
+		return c.record(inf, c.value(&inf.Right), RValue)
+	case token.ASSIGN:
+		return c.checkAssignment(inf)
+	}
+
+	if _, ok := compoundOperators[inf.Operator]; ok {
+		return c.checkAssignment(inf)
+	}
+
+	left, right := c.value(&inf.Left), c.value(&inf.Right)
+	if left == nil || right == nil {
+		return nil
+	}
+
+	t := c.binaryType(inf.Operator, &inf.Left, &inf.Right, left, right)
+	if t != nil {
+		c.checkOverflow(inf)
+	}
+	return c.record(inf, t, RValue)
+}
+
+// The result type of a binary operator, with the operands converted as needed
+func (c *Checker) binaryType(op string, leftE *ast.Expression,
+	rightE *ast.Expression, left ast.Declaration, right ast.Declaration) ast.Declaration {
+	invalid := func() ast.Declaration {
+		c.err(fmt.Sprintf("invalid operands to binary %s (have '%s' and '%s')",
+			op, ast.TypeString(left), ast.TypeString(right)))
+		return nil
+	}
+
+	switch op {
+	case token.AND, token.OR:
+		if !ast.IsScalar(left) || !ast.IsScalar(right) {
+			return invalid()
+		}
+		return ast.IntType
+	case token.EQUALS, token.NOTEQUALS, token.LT, token.LTE, token.GT, token.GTE:
+		if ast.IsArithmetic(left) && ast.IsArithmetic(right) {
+			c.checkSignCompare(*leftE, *rightE, left, right)
+			c.arithmetic(leftE, rightE, left, right)
+			return ast.IntType
+		} else if ast.IsPointer(left) && ast.IsPointer(right) {
+			if !compatiblePointers(left, right) && !isVoidPointer(left) &&
+				!isVoidPointer(right) {
+				c.warn(diag.CompareDistinctPointerTypes, fmt.Sprintf(
+					"comparison of distinct pointer types lacks a cast ('%s' and '%s')",
+					ast.TypeString(left), ast.TypeString(right)))
+			}
+			return ast.IntType
+		} else if ast.IsPointer(left) && ast.IsInteger(right) {
+			c.pointerComparison(op, rightE, left)
+			return ast.IntType
+		} else if ast.IsInteger(left) && ast.IsPointer(right) {
+			c.pointerComparison(op, leftE, right)
+			return ast.IntType
+		}
+		return invalid()
+	case token.PLUS:
+		if ast.IsArithmetic(left) && ast.IsArithmetic(right) {
+			return c.arithmetic(leftE, rightE, left, right)
+		} else if ast.IsPointer(left) && ast.IsInteger(right) {
+			return left
+		} else if ast.IsInteger(left) && ast.IsPointer(right) {
+			return right
+		}
+		return invalid()
+	case token.MINUS:
+		if ast.IsArithmetic(left) && ast.IsArithmetic(right) {
+			return c.arithmetic(leftE, rightE, left, right)
+		} else if ast.IsPointer(left) && ast.IsInteger(right) {
+			return left
+		} else if ast.IsPointer(left) && ast.IsPointer(right) &&
+			compatiblePointers(left, right) {
+			return ast.LongType // ptrdiff_t
+		}
+		return invalid()
+	case token.ASTERISK, token.SLASH:
+		if !ast.IsArithmetic(left) || !ast.IsArithmetic(right) {
+			return invalid()
+		}
+		c.checkDivision(op, *rightE)
+		return c.arithmetic(leftE, rightE, left, right)
+	case token.MOD, token.AMP, token.BITOR, token.BITXOR:
+		if !ast.IsInteger(left) || !ast.IsInteger(right) {
+			return invalid()
+		}
+		c.checkDivision(op, *rightE)
+		return c.arithmetic(leftE, rightE, left, right)
+	case token.LSHIFT, token.RSHIFT:
+		if !ast.IsInteger(left) || !ast.IsInteger(right) {
+			return invalid()
+		}
+		c.checkShiftCount(op, *rightE, ast.Promote(left))
+		c.convertTo(rightE, ast.Promote(right))
+		return c.convertTo(leftE, ast.Promote(left))
+	}
+
+	c.err(fmt.Sprintf("unexpected infix operator '%s'", op))
+	return nil
+}
+
+func (c *Checker) checkDivision(op string, divisor ast.Expression) {
+	if op != token.SLASH && op != token.MOD {
+		return
+	}
+	if value, ok := constantValue(divisor); ok && value == 0 {
+		c.warn(diag.DivByZero, "division by zero")
+	}
+}
+
+// A shift by a negative count or by the width of the type or more is
+// undefined
+func (c *Checker) checkShiftCount(op string, count ast.Expression, t ast.Declaration) {
+	value, ok := constantValue(count)
+	if !ok {
+		return
+	}
+
+	direction := map[string]string{token.LSHIFT: "left", token.RSHIFT: "right"}[op]
+	if value < 0 {
+		c.warn(diag.ShiftCountNegative, direction+" shift count is negative")
+	} else if value >= int64(ast.IntegerWidth(t)) {
+		c.warn(diag.ShiftCountOverflow, direction+" shift count >= width of type")
+	}
+}
+
+// Comparing a signed and an unsigned integer converts the signed one to
+// unsigned, which changes negative values
+func (c *Checker) checkSignCompare(leftE ast.Expression, rightE ast.Expression,
+	left ast.Declaration, right ast.Declaration) {
+	l, r := ast.Promote(left), ast.Promote(right)
+	if !ast.IsInteger(l) || !ast.IsInteger(r) || ast.IsUnsigned(l) == ast.IsUnsigned(r) ||
+		!ast.IsUnsigned(ast.ArithmeticType(l, r)) {
+		return
+	}
+
+	signed := leftE
+	if ast.IsUnsigned(l) {
+		signed = rightE
+	}
+	if value, ok := constantValue(signed); ok && value >= 0 {
+		return
+	}
+
+	c.warn(diag.SignCompare, fmt.Sprintf(
+		"comparison of integer expressions of different signedness: '%s' and '%s'",
+		ast.TypeString(left), ast.TypeString(right)))
+}
+
+// Apply the usual arithmetic conversions to both operands
+func (c *Checker) arithmetic(leftE *ast.Expression, rightE *ast.Expression,
+	left ast.Declaration, right ast.Declaration) ast.Declaration {
+	t := ast.ArithmeticType(left, right)
+	c.convertTo(leftE, t)
+	c.convertTo(rightE, t)
+	return t
+}
+
+// Comparing a pointer with an integer is only allowed for null pointer
+// constants
+func (c *Checker) pointerComparison(op string, intE *ast.Expression,
+	ptr ast.Declaration) {
+	if isNullPointerConstant(*intE) {
+		c.convert(intE, ast.NullToPointer, ptr)
+		return
+	}
+
+	c.warn("", "comparison between pointer and integer")
+	c.convert(intE, ast.IntegerToPointer, ptr)
+}
+
+// Simple and compound assignment
+func (c *Checker) checkAssignment(inf *ast.InfixExpression) ast.Declaration {
+	left := c.expr(inf.Left)
+	if left == nil {
+		c.value(&inf.Right)
+		return nil
+	}
+
+	if c.category(inf.Left) != LValue {
+		c.err("lvalue required as left operand of assignment")
+		return nil
+	} else if isArray(left) {
+		c.err("assignment to expression with array type")
+		return nil
+	} else if ast.IsConst(left) {
+		if _, ok := inf.Left.(*ast.Identifier); ok {
+			c.err(fmt.Sprintf("assignment of read-only variable '%s'",
+				inf.Left.String()))
+		} else {
+			c.err(fmt.Sprintf("assignment of read-only location '%s'",
+				inf.Left.String()))
+		}
+		return nil
+	}
+
+	t := ast.Unqualified(left)
+	if op, ok := compoundOperators[inf.Operator]; ok {
+		// a op= b is checked like a = a op b, but the operands are converted
+		// when it is compiled
+		right := c.value(&inf.Right)
+		if right == nil {
+			return nil
+		}
+
+		var leftE, rightE ast.Expression = inf.Left, inf.Right
+		if c.binaryType(op, &leftE, &rightE, t, right) == nil {
+			return nil
+		}
+		return c.record(inf, t, RValue)
+	}
+
+	if c.assign(&inf.Right, t, inAssignment) == nil {
+		return nil
+	}
+	return c.record(inf, t, RValue)
+}
+
+func (c *Checker) checkConditional(e *ast.ConditionalExpression) ast.Declaration {
+	cond := c.scalar(&e.Condition)
+	a, b := c.value(&e.Consequence), c.value(&e.Alternative)
+	if cond == nil || a == nil || b == nil {
+		return nil
+	}
+
+	t, msg := ast.ConditionalType(a, b, isNullPointerConstant(e.Consequence),
+		isNullPointerConstant(e.Alternative))
+	if t == nil {
+		c.err(msg)
+		return nil
+	} else if msg != "" {
+		c.warn("", msg)
+	}
+
+	for _, arm := range []*ast.Expression{&e.Consequence, &e.Alternative} {
+		if ast.IsPointer(t) && isNullPointerConstant(*arm) {
+			c.convert(arm, ast.NullToPointer, t)
+		} else {
+			c.convertTo(arm, t)
+		}
+	}
+
+	return c.record(e, t, RValue)
+}
+
+func (c *Checker) checkSizeof(s *ast.SizeofExpression) ast.Declaration {
+	t := s.TypeName
+	if t != nil {
+		c.checkType(t)
+	} else if t = c.expr(s.Right); t == nil {
+		return nil
+	}
+
+	if _, ok := t.(*ast.FunctionDeclaration); ok {
+		c.err("invalid application of 'sizeof' to a function type")
+		return nil
+	} else if !ast.IsComplete(t) && !layout.IsVLA(t) {
+		c.err(fmt.Sprintf("invalid application of 'sizeof' to incomplete type '%s'",
+			ast.TypeString(t)))
+		return nil
+	}
+
+	return c.record(s, ast.SizeType, RValue)
+}
+
+var builtins = map[string]bool{
+	"va_start": true, "__builtin_va_start": true,
+	"va_end": true, "__builtin_va_end": true,
+	"va_copy": true, "__builtin_va_copy": true,
+}
+
+func (c *Checker) checkCall(call *ast.CallExpression) ast.Declaration {
+	if ident, ok := call.Function.(*ast.Identifier); ok &&
+		builtins[ident.Value] && c.lookup(ident.Value) == nil {
+		return c.checkBuiltin(ident.Value, call)
+	}
+
+	t := c.value(&call.Function)
+	if t == nil {
+		for i := range call.Arguments {
+			c.value(&call.Arguments[i])
+		}
+		return nil
+	}
+
+	fn := ast.FunctionType(t)
+	if fn == nil {
+		c.err(fmt.Sprintf("called object '%s' is not a function or function pointer",
+			call.Function.String()))
+		return nil
+	}
+
+	name := call.Function.String()
+	nParams := len(fn.Parameters)
+	if len(call.Arguments) < nParams {
+		c.err(fmt.Sprintf("too few arguments to function '%s'", name))
+		return nil
+	} else if len(call.Arguments) > nParams && !fn.Variadic {
+		c.err(fmt.Sprintf("too many arguments to function '%s'", name))
+		return nil
+	}
+
+	ok := true
+	for i := range call.Arguments {
+		arg := &call.Arguments[i]
+		if i < nParams {
+			ok = c.assign(arg, ast.ParameterType(fn.Parameters[i]),
+				inArgument(name, i+1)) != nil && ok
+			continue
+		}
+
+		// the default argument promotions
+		t := c.value(arg)
+		if t == nil {
+			ok = false
+		} else if ast.IsVoid(t) {
+			c.err("invalid use of void expression")
+			ok = false
+		} else if ast.IsFloat(t) {
+			c.convertTo(arg, ast.DoubleType)
+		} else {
+			c.convertTo(arg, ast.Promote(t))
+		}
+	}
+
+	if !ok {
+		return nil
+	}
+	return c.record(call, fn.Type(), RValue)
+}
+
+// va_start, va_end and va_copy take the va_list itself rather than a pointer
+// to it, so their arguments are not converted
+func (c *Checker) checkBuiltin(name string, call *ast.CallExpression) ast.Declaration {
+	nArgs := map[string]int{"va_start": 2, "va_end": 1, "va_copy": 2}
+	short := name
+	if len(short) > len("__builtin_") && short[:len("__builtin_")] == "__builtin_" {
+		short = short[len("__builtin_"):]
+	}
+
+	if len(call.Arguments) != nArgs[short] {
+		c.err(fmt.Sprintf("wrong number of arguments to '%s'", short))
+		return nil
+	}
+
+	for i, arg := range call.Arguments {
+		if short == "va_start" && i == 1 {
+			break
+		} else if !c.isVaList(arg) {
+			return nil
+		}
+	}
+
+	if short == "va_start" {
+		if !c.function.Variadic {
+			c.err("'va_start' used in function with fixed arguments")
+			return nil
+		}
+
+		params := c.function.Parameters
+		last, ok := call.Arguments[1].(*ast.Identifier)
+		if !ok || len(params) == 0 ||
+			ast.ParameterName(params[len(params)-1]) != last.Value {
+			c.warn(diag.Varargs, "second parameter of 'va_start' not last named argument")
+		}
+		c.expr(call.Arguments[1])
+	}
+
+	return c.record(call, ast.VoidType, RValue)
+}
+
+// Whether the expression is a va_list, or a va_list parameter which has been
+// adjusted to a pointer
+func (c *Checker) isVaList(expr ast.Expression) bool {
+	t := c.expr(expr)
+	if t == nil {
+		return false
+	}
+
+	if p, ok := t.(*ast.Pointer); ok && ast.IsVaList(p.PointsTo) {
+		return true
+	} else if ast.IsVaList(t) && c.category(expr) == LValue {
+		return true
+	}
+
+	c.err(fmt.Sprintf("'%s' is not a va_list", expr.String()))
+	return false
+}
+
+func (c *Checker) checkVaArg(v *ast.VaArgExpression) ast.Declaration {
+	c.checkType(v.TypeName)
+	if !c.isVaList(v.VaList) {
+		return nil
+	} else if !ast.IsComplete(v.TypeName) {
+		c.err(fmt.Sprintf("second argument to 'va_arg' is of incomplete type '%s'",
+			ast.TypeString(v.TypeName)))
+		return nil
+	}
+
+	return c.record(v, v.TypeName, RValue)
+}
+
+// arr[idx] is the same as *(arr + idx), so idx[arr] works too
+func (c *Checker) checkIndex(e *ast.IndexExpression) ast.Declaration {
+	left, right := c.value(&e.Left), c.value(&e.Index)
+	if left == nil || right == nil {
+		return nil
+	}
+
+	if ast.IsInteger(left) && ast.IsPointer(right) {
+		left, right = right, left
+	}
+
+	p, ok := left.(*ast.Pointer)
+	if !ok {
+		c.err("subscripted value is neither array nor pointer")
+		return nil
+	} else if !ast.IsInteger(right) {
+		c.err("array subscript is not an integer")
+		return nil
+	} else if !ast.IsComplete(p.PointsTo) {
+		c.err(fmt.Sprintf("invalid use of incomplete type '%s'",
+			ast.TypeString(p.PointsTo)))
+		return nil
+	}
+
+	return c.record(e, p.PointsTo, LValue)
+}
+
+func (c *Checker) checkMember(e *ast.MemberExpression) ast.Declaration {
+	var t ast.Declaration
+	category := LValue
+	if e.Arrow {
+		left := c.value(&e.Left)
+		if left == nil {
+			return nil
+		}
+
+		p, ok := left.(*ast.Pointer)
+		if !ok {
+			c.err(fmt.Sprintf("invalid type argument of '->' (have '%s')",
+				ast.TypeString(left)))
+			if ast.IsStruct(left) {
+				c.last().Replace(diag.Range{Start: e.Token.Pos(), End: e.Token.End()}, ".")
+			}
+			return nil
+		}
+		t = p.PointsTo
+	} else {
+		if t = c.expr(e.Left); t == nil {
+			return nil
+		}
+		category = c.category(e.Left)
+	}
+
+	s, ok := t.(*ast.StructOrUnionSpecification)
+	if p, isPointer := t.(*ast.Pointer); !e.Arrow && isPointer && ast.IsStruct(p.PointsTo) {
+		c.err(fmt.Sprintf("'%s' is a pointer; did you mean to use '->'?", e.Left))
+		c.last().Replace(diag.Range{Start: e.Token.Pos(), End: e.Token.End()}, "->")
+		return nil
+	} else if !ok {
+		c.err(fmt.Sprintf("request for member '%s' in something not a structure or union",
+			e.Member))
+		return nil
+	} else if s.Fields() == nil {
+		c.err(fmt.Sprintf("invalid use of incomplete type '%s'", ast.TypeString(s)))
+		return nil
+	}
+
+	member, _ := s.Member(e.Member)
+	if member == nil {
+		c.err(fmt.Sprintf("'%s' has no member named '%s'", ast.TypeString(s), e.Member))
+		return nil
+	}
+
+	return c.record(e, ast.Qualify(member.Type(), ast.IsConst(s), ast.IsVolatile(s)),
+		category)
+}
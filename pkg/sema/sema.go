@@ -0,0 +1,622 @@
+// Package sema checks a parsed translation unit against the constraints of the
+// C standard. It resolves identifiers, works out the type and value category
+// of every expression and makes implicit conversions explicit, so that code
+// generation only has to deal with well formed trees.
+package sema
+
+import (
+	"fmt"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/diag"
+	"github.com/tjarjoura/cc/pkg/layout"
+	"github.com/tjarjoura/cc/pkg/token"
+)
+
+type ValueCategory int
+
+const (
+	RValue ValueCategory = iota
+	LValue
+	FunctionDesignator
+)
+
+func (v ValueCategory) String() string {
+	switch v {
+	case LValue:
+		return "lvalue"
+	case FunctionDesignator:
+		return "function designator"
+	}
+	return "rvalue"
+}
+
+type TypeAndValue struct {
+	Type     ast.Declaration
+	Category ValueCategory
+}
+
+// Everything the checker found out about the translation unit
+type Info struct {
+	// type of every expression, including the conversions that were added
+	Types map[ast.Expression]TypeAndValue
+
+	// the declaration that each identifier refers to
+	Uses map[*ast.Identifier]ast.Declaration
+}
+
+type SemaError struct {
+	diag.Diagnostic
+}
+
+func (e *SemaError) Warning() bool { return e.Severity == diag.Warning }
+
+type scope struct {
+	parent *scope
+	names  map[string]ast.Declaration
+	decls  []ast.Declaration // in the order they were declared
+}
+
+// How control leaves the body of a loop other than by falling off its end
+type loopState struct {
+	broke     bool // a break can be reached
+	continued bool // a continue can be reached
+}
+
+type Checker struct {
+	// the warnings that are reported, the ones GCC reports by default
+	// unless it is changed
+	Warnings *diag.WarningOptions
+
+	translationUnit *ast.TranslationUnit
+	info            *Info
+	scope           *scope
+	function        *ast.FunctionDeclaration // the function being checked
+	reachable       bool                     // whether control can reach the statement being checked
+	reported        bool                     // whether unreachable code was reported since control was lost
+	loop            *loopState               // the innermost loop around the statement, nil if there is none
+	parameters      map[ast.Declaration]bool
+	used            map[ast.Declaration]bool                 // declarations that are referred to
+	structs         map[*ast.StructOrUnionSpecification]bool // definitions whose members were checked
+	pos             token.Pos                                // where errors are reported
+	ranges          []diag.Range                             // and what is underlined around them
+
+	errors []SemaError
+}
+
+func New(tUnit *ast.TranslationUnit) *Checker {
+	return &Checker{
+		Warnings:        diag.NewWarningOptions(),
+		translationUnit: tUnit,
+		info: &Info{
+			Types: map[ast.Expression]TypeAndValue{},
+			Uses:  map[*ast.Identifier]ast.Declaration{},
+		},
+		scope:      &scope{names: map[string]ast.Declaration{}},
+		parameters: map[ast.Declaration]bool{},
+		used:       map[ast.Declaration]bool{},
+		structs:    map[*ast.StructOrUnionSpecification]bool{},
+	}
+}
+
+func (c *Checker) Errors() []SemaError { return c.errors }
+
+func (c *Checker) report(severity diag.Severity, msg string) {
+	c.errors = append(c.errors, SemaError{diag.Diagnostic{Severity: severity,
+		Pos: c.pos, Ranges: c.ranges, Message: msg}})
+}
+
+func (c *Checker) err(msg string) { c.report(diag.Error, msg) }
+func (c *Checker) warn(flag diag.Flag, msg string) {
+	if c.Warnings.Enabled(flag) {
+		c.report(diag.Warning, msg)
+		c.last().Flag = flag
+	}
+}
+
+// The diagnostic that was reported last, to attach notes and fix-its to
+func (c *Checker) last() *diag.Diagnostic {
+	return &c.errors[len(c.errors)-1].Diagnostic
+}
+
+// Report errors at pos, underlining the ranges, until the returned function
+// is called, which is usually deferred
+func (c *Checker) at(pos token.Pos, ranges ...diag.Range) func() {
+	prevPos, prevRanges := c.pos, c.ranges
+	if pos.IsValid() {
+		c.pos, c.ranges = pos, ranges
+	}
+	return func() { c.pos, c.ranges = prevPos, prevRanges }
+}
+
+func span(n ast.Node) diag.Range {
+	return diag.Range{Start: n.Pos(), End: n.End()}
+}
+
+// Where the declaration names what it declares
+func declaredAt(decl ast.Declaration) token.Pos {
+	switch d := decl.(type) {
+	case *ast.VariableDeclaration:
+		return d.NamePos
+	case *ast.FunctionDeclaration:
+		return d.NamePos
+	}
+	return decl.Pos()
+}
+
+// Point at where the name was declared before
+func (c *Checker) notePrevious(name string, prev ast.Declaration) {
+	what := "declaration"
+	if fn, ok := prev.(*ast.FunctionDeclaration); ok && fn.Body != nil {
+		what = "definition"
+	}
+
+	c.last().AddNote(declaredAt(prev), fmt.Sprintf("previous %s of '%s' with type '%s'",
+		what, name, ast.TypeString(prev)))
+}
+
+func (c *Checker) Check() *Info {
+	for _, declStmt := range c.translationUnit.DeclarationStatements {
+		for _, decl := range declStmt.Declarations {
+			switch d := decl.(type) {
+			case *ast.VariableDeclaration:
+				c.checkVariableDeclaration(d)
+			case *ast.FunctionDeclaration:
+				c.checkFunction(d)
+			case *ast.StructOrUnionSpecification:
+				c.checkType(d)
+			}
+		}
+	}
+
+	return c.info
+}
+
+func (c *Checker) enterScope() {
+	c.scope = &scope{parent: c.scope, names: map[string]ast.Declaration{}}
+}
+
+func (c *Checker) leaveScope() {
+	if c.function != nil {
+		c.checkUnused(c.scope)
+	}
+	c.scope = c.scope.parent
+}
+
+// Warn about the local variables and parameters that are never referred to
+func (c *Checker) checkUnused(s *scope) {
+	for _, decl := range s.decls {
+		varDecl, ok := decl.(*ast.VariableDeclaration)
+		if !ok || c.used[decl] || varDecl.StorageClass == "extern" {
+			continue
+		}
+
+		restore := c.at(varDecl.NamePos)
+		if c.parameters[decl] {
+			c.warn(diag.UnusedParameter, fmt.Sprintf("unused parameter '%s'",
+				varDecl.Name))
+		} else {
+			c.warn(diag.UnusedVariable, fmt.Sprintf("unused variable '%s'",
+				varDecl.Name))
+		}
+		restore()
+	}
+}
+
+func (c *Checker) lookup(name string) ast.Declaration {
+	decl, _ := lookupIn(c.scope, name)
+	return decl
+}
+
+// Find the declaration of name in s or the scopes around it, and the scope
+// it was found in
+func lookupIn(s *scope, name string) (ast.Declaration, *scope) {
+	for ; s != nil; s = s.parent {
+		if decl, ok := s.names[name]; ok {
+			return decl, s
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *Checker) declare(name string, decl ast.Declaration) {
+	prev, ok := c.scope.names[name]
+	if !ok {
+		c.checkShadow(name)
+		c.scope.names[name] = decl
+		c.scope.decls = append(c.scope.decls, decl)
+		return
+	}
+
+	prevFn, prevIsFn := prev.(*ast.FunctionDeclaration)
+	fn, isFn := decl.(*ast.FunctionDeclaration)
+	switch {
+	case prevIsFn && isFn:
+		if !ast.Compatible(prevFn, fn) {
+			c.err(fmt.Sprintf("conflicting types for '%s'; have '%s', previously '%s'",
+				name, ast.TypeString(fn), ast.TypeString(prevFn)))
+			c.notePrevious(name, prev)
+		} else if prevFn.Body != nil && fn.Body != nil {
+			c.err(fmt.Sprintf("redefinition of '%s'", name))
+			c.notePrevious(name, prev)
+		}
+
+		if prevFn.Body == nil {
+			c.scope.names[name] = fn
+		}
+	case prevIsFn || isFn:
+		c.err(fmt.Sprintf("'%s' redeclared as different kind of symbol", name))
+		c.notePrevious(name, prev)
+	case c.scope.parent == nil:
+		// tentative definitions at file scope can be repeated, and each one
+		// can complete the type, like the size of an array
+		if !ast.Compatible(prev.Type(), decl.Type()) {
+			c.err(fmt.Sprintf("conflicting types for '%s'; have '%s', previously '%s'",
+				name, ast.TypeString(decl), ast.TypeString(prev)))
+			c.notePrevious(name, prev)
+			return
+		}
+
+		composite := ast.Composite(prev.Type(), decl.Type())
+		prev.SetType(composite)
+		decl.SetType(composite)
+	default:
+		c.err(fmt.Sprintf("redeclaration of '%s'", name))
+		c.notePrevious(name, prev)
+	}
+}
+
+// Warn when a local declaration hides one from an outer scope
+func (c *Checker) checkShadow(name string) {
+	if c.scope.parent == nil || !c.Warnings.Enabled(diag.Shadow) {
+		return
+	}
+
+	prev, s := lookupIn(c.scope.parent, name)
+	if prev == nil {
+		return
+	}
+
+	what := "a previous local"
+	if c.parameters[prev] {
+		what = "a parameter"
+	} else if s.parent == nil {
+		what = "a global declaration"
+	}
+
+	c.warn(diag.Shadow, fmt.Sprintf("declaration of '%s' shadows %s", name, what))
+	c.last().AddNote(declaredAt(prev), "shadowed declaration is here")
+}
+
+func (c *Checker) checkFunction(fnDecl *ast.FunctionDeclaration) {
+	defer c.at(fnDecl.NamePos)()
+	c.checkType(fnDecl)
+	c.declare(fnDecl.Name, fnDecl)
+	if fnDecl.Name == "main" && fnDecl.Inline {
+		c.err("'main' is not allowed to be declared inline")
+	}
+	if fnDecl.Body == nil {
+		return
+	}
+
+	if c.scope.parent != nil {
+		c.err(fmt.Sprintf("function definition of '%s' is not allowed here",
+			fnDecl.Name))
+		return
+	}
+
+	c.function = fnDecl
+	c.reachable, c.reported = true, false
+	c.enterScope()
+	defer func() {
+		c.leaveScope()
+		c.function = nil
+	}()
+
+	for i, param := range fnDecl.Parameters {
+		restore := c.at(param.Pos())
+		c.checkType(param)
+		c.parameters[param] = true
+
+		name := ast.ParameterName(param)
+		if name == "" {
+			c.err(fmt.Sprintf("parameter name omitted for parameter %d of '%s'",
+				i+1, fnDecl.Name))
+			restore()
+			continue
+		} else if t := ast.ParameterType(param); !ast.IsComplete(t) {
+			c.err(fmt.Sprintf("parameter %d ('%s') has incomplete type", i+1, name))
+		}
+		c.declare(name, param)
+		restore()
+	}
+
+	// the parameters and the outermost block share a scope
+	for _, stmt := range fnDecl.Body.Statements {
+		c.checkStatement(stmt)
+	}
+
+	// main returns 0 when it reaches the end
+	if !ast.IsVoid(fnDecl.Type()) && fnDecl.Name != "main" && c.reachable {
+		end := fnDecl.Body.End()
+		end.Column-- // at the closing brace
+		defer c.at(end)()
+		c.warn(diag.ReturnType, "control reaches end of non-void function")
+	}
+}
+
+func (c *Checker) checkVariableDeclaration(varDecl *ast.VariableDeclaration) {
+	defer c.at(varDecl.NamePos)()
+	t := varDecl.Type()
+	c.checkType(t)
+	c.checkAlignas(varDecl)
+	c.declare(varDecl.Name, varDecl)
+
+	if _, ok := t.(*ast.FunctionDeclaration); ok {
+		return
+	} else if c.scope.parent == nil {
+		// they are checked anyway, so that the errors in them are found
+		c.err(fmt.Sprintf("file-scope variable '%s' is not supported yet", varDecl.Name))
+	}
+
+	// arrays at file scope can be completed by a later declaration
+	incompleteArray := isArray(t) && (varDecl.Definition != nil || c.scope.parent == nil)
+	if !ast.IsComplete(t) && !incompleteArray && varDecl.StorageClass != "extern" {
+		c.err(fmt.Sprintf("storage size of '%s' isn't known", varDecl.Name))
+		return
+	}
+
+	if varDecl.Definition == nil {
+		return
+	} else if layout.IsVLA(t) {
+		c.err(fmt.Sprintf("variable-sized object '%s' may not be initialized",
+			varDecl.Name))
+		return
+	}
+
+	// initializer lists are not supported yet, but char s[] = "..." is
+	if arr, ok := t.(*ast.Array); ok {
+		_, isString := varDecl.Definition.(*ast.StringLiteral)
+		c.expr(varDecl.Definition)
+		if !isString || !ast.SameType(arr.ArrayOf, ast.CharType) {
+			c.err(fmt.Sprintf("invalid initializer for '%s'", varDecl.Name))
+		}
+		return
+	}
+
+	c.assign(&varDecl.Definition, t, inInitialization)
+}
+
+// Check the expressions that appear inside a type, like array sizes
+func (c *Checker) checkType(t ast.Declaration) {
+	switch d := t.(type) {
+	case *ast.Array:
+		c.checkType(d.ArrayOf)
+		if d.ArraySize == nil {
+			return
+		}
+
+		size := c.value(&d.ArraySize)
+		if size != nil && !ast.IsInteger(size) {
+			c.err(fmt.Sprintf("size of array has non-integer type '%s'",
+				ast.TypeString(size)))
+		} else if value, ok := constantValue(d.ArraySize); ok && value < 0 {
+			c.err("size of array is negative")
+		}
+
+		if _, ok := d.ArrayOf.(*ast.FunctionDeclaration); ok {
+			c.err("declaration of array of functions")
+		} else if !ast.IsComplete(d.ArrayOf) {
+			c.err("array type has incomplete element type")
+		}
+	case *ast.Pointer:
+		c.checkType(d.PointsTo)
+	case *ast.FunctionDeclaration:
+		switch d.ReturnType.(type) {
+		case *ast.Array:
+			c.err("function cannot return an array")
+		case *ast.FunctionDeclaration:
+			c.err("function cannot return a function")
+		}
+		c.checkType(d.ReturnType)
+	case *ast.VariableDeclaration:
+		c.checkType(d.Type())
+	case *ast.StructOrUnionSpecification:
+		// the members are checked once, where the struct is defined
+		if d.Members == nil || c.structs[d] {
+			return
+		}
+		c.structs[d] = true
+		for _, member := range d.Members {
+			c.checkAlignas(member)
+		}
+	}
+}
+
+// The argument of _Alignas has to be an integer constant that is 0, which
+// doesn't change anything, or a power of two that is at least the alignment
+// the type of the object already has
+func (c *Checker) checkAlignas(decl *ast.VariableDeclaration) {
+	if decl.AlignAs == nil {
+		return
+	}
+	defer c.at(decl.AlignAs.Pos(), span(decl.AlignAs))()
+
+	t := c.value(&decl.AlignAs)
+	align, ok := constantValue(decl.AlignAs)
+	switch {
+	case t == nil:
+	case !ast.IsInteger(t) || !ok:
+		c.err("requested alignment is not an integer constant")
+	case align == 0:
+	case align < 0 || align&(align-1) != 0:
+		c.err(fmt.Sprintf("requested alignment '%d' is not a positive power of 2", align))
+	case uint64(align) < layout.AlignOf(decl.Type()):
+		c.err(fmt.Sprintf("'_Alignas' specifiers cannot reduce alignment of '%s'",
+			decl.Name))
+	}
+}
+
+func (c *Checker) checkStatement(stmt ast.Statement) {
+	if stmt == nil {
+		return
+	}
+
+	defer c.at(stmt.Pos(), span(stmt))()
+	if !c.reachable && !c.reported && hasCode(stmt) {
+		c.warn(diag.UnreachableCode, "code will never be executed")
+		c.reported = true
+	}
+
+	switch s := stmt.(type) {
+	case *ast.DeclarationStatement:
+		for _, d := range s.Declarations {
+			switch decl := d.(type) {
+			case *ast.VariableDeclaration:
+				c.checkVariableDeclaration(decl)
+			case *ast.FunctionDeclaration:
+				c.checkFunction(decl)
+			case *ast.StructOrUnionSpecification:
+				c.checkType(decl)
+			}
+		}
+	case *ast.BlockStatement:
+		c.enterScope()
+		for _, stmt := range s.Statements {
+			c.checkStatement(stmt)
+		}
+		c.leaveScope()
+	case *ast.ExpressionStatement:
+		c.expr(s.Expression)
+	case *ast.ReturnStatement:
+		c.checkReturn(s)
+		c.reachable, c.reported = false, false
+	case *ast.IfStatement:
+		c.condition(&s.Condition)
+		reachable := c.reachable
+		c.checkStatement(s.Consequence)
+		afterConsequence := c.reachable
+		c.reachable = reachable
+		c.checkStatement(s.Alternative)
+		c.reachable = c.reachable || afterConsequence
+	case *ast.WhileStatement:
+		reachable := c.reachable
+		c.condition(&s.Condition)
+		_, broke := c.checkBody(s.Body)
+		c.afterLoop(reachable, reachable, broke, s.Condition)
+	case *ast.DoWhileStatement:
+		reachable := c.reachable
+		next, broke := c.checkBody(s.Body)
+		c.condition(&s.Condition)
+		c.afterLoop(reachable, next, broke, s.Condition)
+	case *ast.ForStatement:
+		// the variables declared in the first clause are only visible in
+		// the loop
+		c.enterScope()
+		reachable := c.reachable
+		c.checkStatement(s.Init)
+		if s.Condition != nil {
+			c.condition(&s.Condition)
+		}
+		if s.Post != nil {
+			c.expr(s.Post)
+		}
+		_, broke := c.checkBody(s.Body)
+		c.afterLoop(reachable, reachable, broke, s.Condition)
+		c.leaveScope()
+	case *ast.BreakStatement:
+		if c.loop == nil {
+			c.err("break statement not within loop or switch")
+		} else if c.reachable {
+			c.loop.broke = true
+		}
+		c.reachable, c.reported = false, false
+	case *ast.ContinueStatement:
+		if c.loop == nil {
+			c.err("continue statement not within a loop")
+		} else if c.reachable {
+			c.loop.continued = true
+		}
+		c.reachable, c.reported = false, false
+	}
+}
+
+// The controlling expression of an if or a loop has to be a scalar
+func (c *Checker) condition(expr *ast.Expression) {
+	defer c.at((*expr).Pos(), span(*expr))()
+	c.scalar(expr)
+}
+
+// Check the body of a loop. Control gets to the condition after it if it
+// can fall off the end of the body or continue, and it gets past the loop if
+// it can break.
+func (c *Checker) checkBody(body ast.Statement) (next bool, broke bool) {
+	outer := c.loop
+	c.loop = &loopState{}
+	defer func() { c.loop = outer }()
+
+	c.checkStatement(body)
+	return c.reachable || c.loop.continued, c.loop.broke
+}
+
+// Control gets past a loop when it breaks, or when the condition is checked
+// and can be false. A loop without a condition, or one that is a nonzero
+// constant, runs until something breaks out of it.
+func (c *Checker) afterLoop(reachable, checked, broke bool, cond ast.Expression) {
+	forever := cond == nil
+	if cond != nil {
+		value, ok := constantValue(cond)
+		forever = ok && value != 0
+	}
+
+	c.reachable = broke || (checked && !forever)
+	if reachable && !c.reachable {
+		c.reported = false
+	}
+}
+
+// Whether the statement does anything when control reaches it, declarations
+// without an initializer only name an object. Blocks are made of the
+// statements in them.
+func hasCode(stmt ast.Statement) bool {
+	switch s := stmt.(type) {
+	case *ast.DeclarationStatement:
+		for _, d := range s.Declarations {
+			if v, ok := d.(*ast.VariableDeclaration); ok &&
+				(v.Definition != nil || layout.IsVLA(v.Type())) {
+				return true
+			}
+		}
+		return false
+	case *ast.ExpressionStatement, *ast.ReturnStatement, *ast.IfStatement,
+		*ast.WhileStatement, *ast.DoWhileStatement, *ast.ForStatement,
+		*ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	}
+	return false
+}
+
+func (c *Checker) checkReturn(s *ast.ReturnStatement) {
+	returnType := c.function.Type()
+	if s.ReturnValue == nil {
+		if !ast.IsVoid(returnType) {
+			c.warn(diag.ReturnType,
+				"'return' with no value, in function returning non-void")
+		}
+		return
+	}
+
+	if ast.IsVoid(returnType) {
+		c.value(&s.ReturnValue)
+		c.err("'return' with a value, in function returning void")
+		return
+	}
+
+	c.assign(&s.ReturnValue, returnType, inReturn)
+}
+
+func isArray(t ast.Declaration) bool {
+	_, ok := t.(*ast.Array)
+	return ok
+}
@@ -0,0 +1,433 @@
+package sema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tjarjoura/cc/pkg/ast"
+	"github.com/tjarjoura/cc/pkg/diag"
+	"github.com/tjarjoura/cc/pkg/lexer"
+	"github.com/tjarjoura/cc/pkg/parser"
+)
+
+func check(t *testing.T, input string) (*ast.TranslationUnit, *Checker, *Info) {
+	p := parser.New(lexer.New(input))
+	tUnit := p.Parse()
+	for _, err := range p.Errors() {
+		t.Fatalf("parser error in %q: %s", input, err.String())
+	}
+
+	c := New(tUnit)
+	return tUnit, c, c.Check()
+}
+
+// The expression in the last statement of main
+func lastExpression(t *testing.T, tUnit *ast.TranslationUnit) ast.Expression {
+	decls := tUnit.DeclarationStatements
+	fn := decls[len(decls)-1].Declarations[0].(*ast.FunctionDeclaration)
+	stmts := fn.Body.Statements
+	switch s := stmts[len(stmts)-1].(type) {
+	case *ast.ExpressionStatement:
+		return s.Expression
+	case *ast.ReturnStatement:
+		return s.ReturnValue
+	}
+
+	t.Fatalf("last statement is not an expression")
+	return nil
+}
+
+func TestCheckValid(t *testing.T) {
+	tests := []string{
+		"int main() { int x = 3; { int x = 4; } return x; }",
+		"int f(int a, int b); int f(int a, int b) { return a + b; }",
+		"int main() { char c = 1; long l = c; int *p = 0; return p == 0; }",
+		"int main() { int a[3]; int *p = a; return p[1] + 2[a]; }",
+		"int add(int a, int b) { return a + b; } int main() { int (*fp)(int, int) = add; return fp(1, 2) + (*fp)(3, 4); }",
+		"struct s { int a; }; int main() { struct s v; struct s *p = &v; p->a = 1; return v.a; }",
+		"int f(int n, ...) { va_list ap; va_start(ap, n); int x = va_arg(ap, int); va_end(ap); return x; }",
+		"int main() { const char *s = \"abc\"; return sizeof s + sizeof(int); }",
+		"void v() { return; } int main() { v(); return 0; }",
+		"int main(int n) { int a[n]; return sizeof a; }",
+		"int main() { int *p; void *q = p; p = q; return p != q; }",
+		"int main() { int *p; const int *c = p; const void *v = c; return c == p; }",
+		"int f(const int n); int f(int n) { return n; }",
+		"int f(int a[]); int f(int *a) { return *a; }",
+		"static inline int twice(int n) { return 2 * n; } int main() { return twice(0); }",
+		"struct s { _Alignas(8) char c; _Alignas(struct s *) int i; };",
+		"int f(int n) { int s = 0; for (int i = 0; i < n; i = i + 1) { if (i == 3) continue; s = s + i; } return s; }",
+		"int f(int *p) { while (p) { if (*p) break; p = 0; } do p = p + 1; while (*p); return *p; }",
+		"int f(int n) { for (int n = 0; n < 3; n = n + 1) { int n = 5; } if (n) return 1; else return 2; }",
+	}
+
+	for _, input := range tests {
+		_, c, _ := check(t, input)
+		for _, err := range c.Errors() {
+			t.Errorf("%q: unexpected %s", input, err.String())
+		}
+	}
+}
+
+// The compiler doesn't support variables at file scope, but they are checked
+// like the other declarations
+func TestFileScopeVariables(t *testing.T) {
+	tests := []struct {
+		input  string
+		errors int
+	}{
+		{"int x; int main() { return x; }", 1},
+		{"int x[]; int x[3]; int main() { return sizeof x; }", 2},
+		{"_Alignas(16) char a; _Alignas(long) long b; _Alignas(0) int c; _Alignas(sizeof(int) * 2) int d;", 4},
+		{"extern int e; int f(int); int main() { return f(e); }", 1},
+	}
+
+	for _, tt := range tests {
+		_, c, _ := check(t, tt.input)
+		errors := c.Errors()
+		for _, err := range errors {
+			if !strings.Contains(err.Message, "file-scope variable") {
+				t.Errorf("%q: unexpected %s", tt.input, err.String())
+			}
+		}
+
+		if len(errors) != tt.errors {
+			t.Errorf("%q: expected %d errors, got %v", tt.input, tt.errors, errors)
+		}
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		input   string
+		message string
+		warn    bool
+	}{
+		{"int main() { return x; }", "'x' undeclared", false},
+		{"int main() { int x; int x; return 0; }", "redeclaration of 'x'", false},
+		{"int f(int a); long f(int a);", "conflicting types for 'f'", false},
+		{"int f() { return 0; } int f() { return 1; }", "redefinition of 'f'", false},
+		{"int f(int) { return 0; }", "parameter name omitted", false},
+		{"int main() { 3 = 4; return 0; }", "lvalue required as left operand of assignment", false},
+		{"int main() { return &3; }", "lvalue required as unary '&' operand", false},
+		{"int main() { const int x = 1; x = 2; return x; }", "assignment of read-only variable 'x'", false},
+		{"int main() { int a[2]; int b[2]; a = b; return 0; }", "assignment to expression with array type", false},
+		{"int main() { int *p; int *q; return p * q; }", "invalid operands to binary *", false},
+		{"int main() { int x; return *x; }", "invalid type argument of unary '*'", false},
+		{"int main() { int *p; return -p; }", "wrong type argument to unary minus", false},
+		{"int main() { int *p; return ~p; }", "wrong type argument to bit-complement", false},
+		{"int main() { int x; return x(); }", "called object 'x' is not a function or function pointer", false},
+		{"int f(int a); int main() { return f(); }", "too few arguments to function 'f'", false},
+		{"int f(int a); int main() { return f(1, 2); }", "too many arguments to function 'f'", false},
+		{"void v(); int main() { int x = v(); return x; }", "void value not ignored as it ought to be", false},
+		{"int main() { int x; return x[0]; }", "subscripted value is neither array nor pointer", false},
+		{"int main() { int a[2]; int *p; return a[p]; }", "array subscript is not an integer", false},
+		{"struct s { int a; }; int main() { struct s v; return v.b; }", "has no member named 'b'", false},
+		{"int main() { int x; return x.a; }", "request for member 'a' in something not a structure or union", false},
+		{"int f(); int main() { return sizeof f; }", "invalid application of 'sizeof' to a function type", false},
+		{"int f(int n) { va_list ap; va_start(ap, n); return 0; }", "'va_start' used in function with fixed arguments", false},
+		{"void v() { return 1; }", "'return' with a value, in function returning void", false},
+		{"int main() { int *p = 3; return 0; }", "makes pointer from integer without a cast", true},
+		{"int main() { int *p; int x = p; return x; }", "makes integer from pointer without a cast", true},
+		{"int main() { long *l; int *p = l; return 0; }", "uses incompatible pointer types", true},
+		{"int main() { const int *c; int *p = c; return 0; }", "discards 'const' qualifier from pointer target type", true},
+		{"int main() { volatile int *v; int *p; p = v; return 0; }", "discards 'volatile' qualifier", true},
+		{"int main() { char **p; const char **q = p; return 0; }", "uses incompatible pointer types", true},
+		{"int main() { int *p = 1; return 0; }", "initialization of 'int *' from 'int' makes pointer", true},
+		{"int main() { int *p; long l; l = p; return 0; }", "assignment to 'long int' from 'int *' makes integer", true},
+		{"int *f() { return 5; }", "returning 'int' from a function with return type 'int *'", true},
+		{"int f(char *s); int main() { return f(3); }", "passing 'int' to parameter 1 of 'f' of type 'char *'", true},
+		{"struct a { int x; }; struct b { int x; }; int main() { struct a v; struct b w; v = w; return 0; }", "incompatible types in assignment", false},
+		{"int x[3]; int x[4];", "conflicting types for 'x'", false},
+		{"int f(int *p); int f(const int *p);", "conflicting types for 'f'; have 'int(const int *)', previously 'int(int *)'", false},
+		{"int main() { int *p; return p * 2; }", "invalid operands to binary * (have 'int *' and 'int')", false},
+		{"int main() { unsigned u; int (*p)[3]; p = u; return 0; }", "assignment to 'int (*)[3]' from 'unsigned int' makes pointer", true},
+		{"int main() { int *p; long *q; return *(1 ? p : q); }", "pointer type mismatch in conditional expression", true},
+		{"int main() { return; }", "'return' with no value", true},
+		{"int main() { int *p; long *q; return p == q; }", "comparison of distinct pointer types lacks a cast", true},
+		{"inline int main() { return 0; }", "'main' is not allowed to be declared inline", false},
+		{"_Alignas(3) char x;", "requested alignment '3' is not a positive power of 2", false},
+		{"_Alignas(-1) char x;", "requested alignment '-1' is not a positive power of 2", false},
+		{"struct s { _Alignas(12) int i; };", "requested alignment '12' is not a positive power of 2", false},
+		{"int f(int n) { _Alignas(n) char x = 0; return x; }", "requested alignment is not an integer constant", false},
+		{"struct s { int i; }; struct s v; _Alignas(v) char x;", "requested alignment is not an integer constant", false},
+		{"_Alignas(char) long y;", "'_Alignas' specifiers cannot reduce alignment of 'y'", false},
+		{"_Alignas(2) struct { long l; } y;", "'_Alignas' specifiers cannot reduce alignment of 'y'", false},
+		{"int main() { break; }", "break statement not within loop or switch", false},
+		{"int main() { if (1) continue; return 0; }", "continue statement not within a loop", false},
+		{"struct s { int a; }; int main() { struct s v; if (v) return 1; return 0; }", "used a value that is not a scalar where a scalar is required", false},
+		{"struct s { int a; }; int main() { struct s v; while (v) {} return 0; }", "used a value that is not a scalar where a scalar is required", false},
+		{"int main() { for (int i = 0; i < 3; i = i + 1) {} return i; }", "'i' undeclared", false},
+	}
+
+	for _, tt := range tests {
+		_, c, _ := check(t, tt.input)
+		found := false
+		for _, err := range c.Errors() {
+			if strings.Contains(err.Message, tt.message) && err.Warning() == tt.warn {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("%q: expected %q (warning=%t), got %v", tt.input, tt.message,
+				tt.warn, c.Errors())
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"int main() { return x; }", "1:21: error: 'x' undeclared"},
+		{"int main() { int x; int x; return 0; }", "1:25: error: redeclaration of 'x'"},
+		{"int main() { int *p; return p * 2; }", "1:31: error: invalid operands to binary *"},
+		{"int main() { int *p = 3; return 0; }", "1:23: warning: initialization of 'int *'"},
+		{"int f(char *s);\nint main() { return f(3); }", "2:23: warning: passing 'int'"},
+		{"_Alignas(3) char x;", "1:10: error: requested alignment '3'"},
+		{"struct s { int a; } v;\nint main() { while (v) {} return 0; }", "2:21: error: used a value that is not a scalar"},
+		{"int main() {\n\tbreak;\n}", "2:2: error: break statement"},
+	}
+
+	for _, tt := range tests {
+		_, c, _ := check(t, tt.input)
+		found := false
+		for _, err := range c.Errors() {
+			if strings.HasPrefix(err.String(), tt.expected) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.expected, c.Errors())
+		}
+	}
+}
+
+func TestNotesAndFixIts(t *testing.T) {
+	tests := []struct {
+		input string
+		note  string // the note at the previous declaration
+		fix   string // the replacement suggested
+	}{
+		{"int main() { int x; long x; return 0; }", "1:18: note: previous declaration of 'x' with type 'int'", ""},
+		{"int f() { return 0; }\nint f() { return 1; }", "1:5: note: previous definition of 'f' with type 'int(void)'", ""},
+		{"struct s { int a; }; int main() { struct s *p; return p.a; }", "", "->"},
+		{"struct s { int a; }; int main() { struct s v; return v->a; }", "", "."},
+	}
+
+	for _, tt := range tests {
+		_, c, _ := check(t, tt.input)
+		errors := c.Errors()
+		if len(errors) != 1 {
+			t.Errorf("%q: expected 1 error, got %v", tt.input, errors)
+			continue
+		}
+
+		err := errors[0]
+		if tt.note != "" && (len(err.Notes) != 1 || err.Notes[0].String() != tt.note) {
+			t.Errorf("%q: expected note %q, got %v", tt.input, tt.note, err.Notes)
+		}
+
+		if tt.fix != "" && (len(err.FixIts) != 1 || err.FixIts[0].Replacement != tt.fix) {
+			t.Errorf("%q: expected fix-it %q, got %v", tt.input, tt.fix, err.FixIts)
+		}
+	}
+}
+
+func TestWarningFlags(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the warning, with the option that controls it
+	}{
+		{"int main() { int x; return 0; }", "1:18: warning: unused variable 'x' [-Wunused-variable]"},
+		{"int f(int n) { return 0; }", "1:11: warning: unused parameter 'n' [-Wunused-parameter]"},
+		{"int main() { int i; unsigned u; return i < u; }", "1:42: warning: comparison of integer expressions of different signedness: 'int' and 'unsigned int' [-Wsign-compare]"},
+		{"int main() { long l; int i = l; return i; }", "1:30: warning: conversion from 'long int' to 'int' may change value [-Wimplicit-conversion]"},
+		{"int f() { }", "1:11: warning: control reaches end of non-void function [-Wreturn-type]"},
+		{"int f(int n) { { return n; } }", ""},
+		{"int main() { int x = 1; { int x = 2; return x; } }", "1:31: warning: declaration of 'x' shadows a previous local [-Wshadow]"},
+		{"int f(int n) { { int n = 1; return n; } }", "1:22: warning: declaration of 'n' shadows a parameter [-Wshadow]"},
+		{"int x; int main() { int x = 1; return x; }", "1:25: warning: declaration of 'x' shadows a global declaration [-Wshadow]"},
+		{"int main() { int *p = 3; return 0; }", "1:23: warning: initialization of 'int *' from 'int' makes pointer from integer without a cast [-Wint-conversion]"},
+		{"int main() { long *l; int *p = l; return 0; }", "1:32: warning: initialization of 'int *' from 'long int *' uses incompatible pointer types [-Wincompatible-pointer-types]"},
+		{"int main() { const int *c; int *p = c; return 0; }", "1:37: warning: initialization of 'int *' from 'const int *' discards 'const' qualifier from pointer target type [-Wdiscarded-qualifiers]"},
+		{"int main() { return 2147483647 + 1; }", "1:32: warning: integer overflow in expression of type 'int' results in '-2147483648' [-Woverflow]"},
+		{"int main() { return -(-2147483647 - 1); }", "1:21: warning: integer overflow in expression of type 'int' results in '-2147483648' [-Woverflow]"},
+		{"int main() { int x = 1 << 31; return x; }", "1:24: warning: integer overflow in expression of type 'int' results in '-2147483648' [-Woverflow]"},
+		{"int main(int n) { return n / 0; }", "1:28: warning: division by zero [-Wdiv-by-zero]"},
+		{"int main(int n) { return n % (1 - 1); }", "1:28: warning: division by zero [-Wdiv-by-zero]"},
+		{"int main(int n) { return n << 32; }", "1:28: warning: left shift count >= width of type [-Wshift-count-overflow]"},
+		{"int main(int n) { return n >> -1; }", "1:28: warning: right shift count is negative [-Wshift-count-negative]"},
+		{"int main(int n) { long l = 1; return (l << 40) > n; }", ""},
+	}
+
+	all := diag.NewWarningOptions()
+	for _, option := range []string{"-Wall", "-Wextra", "-Wshadow", "-Wimplicit-conversion"} {
+		all.Set(option)
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		c := New(p.Parse())
+		c.Warnings = all
+		c.Check()
+
+		found := tt.expected == ""
+		for _, err := range c.Errors() {
+			if strings.HasPrefix(err.String(), tt.expected) && tt.expected != "" {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("%q: expected %q, got %v", tt.input, tt.expected, c.Errors())
+		}
+	}
+}
+
+func TestReachability(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"int f(int n) { return n; n = 1; n = 2; }", []string{
+			"1:26: warning: code will never be executed [-Wunreachable-code]"}},
+		{"int f(int n) { { return n; } int x; { n = 1; } }", []string{
+			"1:39: warning: code will never be executed [-Wunreachable-code]"}},
+		{"int f(int n) { return n; return 1; }", []string{
+			"1:26: warning: code will never be executed [-Wunreachable-code]"}},
+		{"int f(int n) { n++; }", []string{
+			"1:21: warning: control reaches end of non-void function [-Wreturn-type]"}},
+		{"int f(int n) { return n; int x; }", nil},
+		{"void f() { return; }", nil},
+		{"int main() { }", nil},
+		{"int f(int n) { if (n) return 1; else return 2; }", nil},
+		{"int f(int n) { if (n) return 1; }", []string{
+			"1:33: warning: control reaches end of non-void function [-Wreturn-type]"}},
+		{"int f(int n) { while (n) { break; n = 1; } return n; }", []string{
+			"1:35: warning: code will never be executed [-Wunreachable-code]"}},
+		{"int f(int n) { for (;;) { n = n + 1; } return n; }", []string{
+			"1:40: warning: code will never be executed [-Wunreachable-code]"}},
+		{"int f(int n) { while (1) { if (n) break; } }", []string{
+			"1:44: warning: control reaches end of non-void function [-Wreturn-type]"}},
+		{"int f(int n) { do { return n; } while (n); }", nil},
+		{"int f(int n) { do { continue; } while (n); }", []string{
+			"1:44: warning: control reaches end of non-void function [-Wreturn-type]"}},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		c := New(p.Parse())
+		c.Warnings.Set("-Wunreachable-code")
+		c.Check()
+
+		actual := []string{}
+		for _, err := range c.Errors() {
+			actual = append(actual, err.String())
+		}
+		if strings.Join(actual, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestWarningsOffByDefault(t *testing.T) {
+	tests := []string{
+		"int main() { int x; return 0; }",
+		"int main() { int i; unsigned u; return i < u; }",
+		"int main() { int i; unsigned u; return u < 3; }",
+		"int main() { long l; int i = l; char c = 65; return i + c; }",
+		"int main() { int x = 1; { int x = 2; return x; } }",
+	}
+
+	for _, input := range tests {
+		_, c, _ := check(t, input)
+		for _, err := range c.Errors() {
+			t.Errorf("%q: unexpected %s", input, err.String())
+		}
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		category ValueCategory
+	}{
+		{"int main() { char c; c + 1; }", "int", RValue},
+		{"int main() { long l; int i; l * i; }", "long int", RValue},
+		{"int main() { unsigned int u; int i; u - i; }", "unsigned int", RValue},
+		{"int main() { int a[2][3]; a[1]; }", "int[3]", LValue},
+		{"int main() { int a[2][3]; &a; }", "int (*)[2][3]", RValue},
+		{"int main() { int a[3]; a[1]; }", "int", LValue},
+		{"int main() { int *p; &p; }", "int **", RValue},
+		{"int main() { int *p; *p; }", "int", LValue},
+		{"int main() { sizeof(int); }", "unsigned long int", RValue},
+		{"int main() { int x; x = 3; }", "int", RValue},
+		{"int main() { const char *s; *s; }", "const char", LValue},
+		{"int main() { int x; x == 1; }", "int", RValue},
+		{"int main() { char *p; p - p; }", "long int", RValue},
+	}
+
+	for _, tt := range tests {
+		tUnit, c, info := check(t, tt.input)
+		for _, err := range c.Errors() {
+			t.Errorf("%q: unexpected %s", tt.input, err.String())
+		}
+
+		tv := info.Types[lastExpression(t, tUnit)]
+		if tv.Type == nil || ast.TypeString(tv.Type) != tt.expected || tv.Category != tt.category {
+			t.Errorf("%q: expected %s %s, got %v %s", tt.input, tt.category,
+				tt.expected, tv.Type, tv.Category)
+		}
+	}
+}
+
+func TestCheckConversions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []ast.ConversionKind // conversions of the operands, in order
+	}{
+		{"int main() { char c; int i; c + i; }", []ast.ConversionKind{ast.IntegerConversion}},
+		{"int main() { int a[3]; int *p; p = a; }", []ast.ConversionKind{ast.ArrayToPointer}},
+		{"int f(); int main() { int (*p)(); p = f; }", []ast.ConversionKind{ast.FunctionToPointer}},
+		{"int main() { int *p; p = 0; }", []ast.ConversionKind{ast.NullToPointer}},
+		{"int main() { long l; int i; i = l; }", []ast.ConversionKind{ast.IntegerConversion}},
+		{"int main() { int *p; void *v; v = p; }", []ast.ConversionKind{ast.PointerConversion}},
+		{"int main() { int i; long l; l = i; }", []ast.ConversionKind{ast.IntegerConversion}},
+		{"int main() { int i; int j; i = j; }", nil},
+	}
+
+	for _, tt := range tests {
+		tUnit, c, _ := check(t, tt.input)
+		for _, err := range c.Errors() {
+			t.Errorf("%q: unexpected %s", tt.input, err.String())
+		}
+
+		inf, ok := lastExpression(t, tUnit).(*ast.InfixExpression)
+		if !ok {
+			t.Fatalf("%q: expected an infix expression", tt.input)
+		}
+
+		kinds := []ast.ConversionKind{}
+		for _, operand := range []ast.Expression{inf.Left, inf.Right} {
+			if conv, ok := operand.(*ast.ImplicitConversion); ok {
+				kinds = append(kinds, conv.Kind)
+			}
+		}
+
+		if len(kinds) != len(tt.expected) {
+			t.Errorf("%q: expected conversions %v, got %v", tt.input, tt.expected, kinds)
+			continue
+		}
+
+		for i := range kinds {
+			if kinds[i] != tt.expected[i] {
+				t.Errorf("%q: expected conversions %v, got %v", tt.input,
+					tt.expected, kinds)
+			}
+		}
+	}
+}
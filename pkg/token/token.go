@@ -1,12 +1,57 @@
 package token
 
+import (
+	"fmt"
+	"strings"
+)
+
 type TokenType string
 type Token struct {
 	Type      TokenType
 	Literal   string
 	HasAssign bool
+	File      string
 	Line      int
 	Column    int
+	Offset    int // bytes from the start of the source
+}
+
+// Where the token starts in the source
+func (t Token) Pos() Pos { return Pos{File: t.File, Line: t.Line, Column: t.Column} }
+
+// Just past the last character of the token, only block comments span lines
+func (t Token) End() Pos {
+	if i := strings.LastIndexByte(t.Literal, '\n'); i >= 0 {
+		return Pos{File: t.File, Line: t.Line + strings.Count(t.Literal, "\n"),
+			Column: len(t.Literal) - i}
+	}
+	return Pos{File: t.File, Line: t.Line, Column: t.Column + len(t.Literal)}
+}
+
+// A position in a source file, lines and columns start at 1
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// The zero Pos is for nodes made up by the compiler, like implicit conversions
+func (p Pos) IsValid() bool { return p.Line > 0 }
+
+// Formats the position like GCC does, "file:line:col"
+func (p Pos) String() string {
+	if !p.IsValid() {
+		if p.File == "" {
+			return "-"
+		}
+		return p.File
+	}
+
+	s := fmt.Sprintf("%d:%d", p.Line, p.Column)
+	if p.File != "" {
+		s = p.File + ":" + s
+	}
+	return s
 }
 
 // TokenTypes
@@ -28,6 +73,7 @@ const (
 	FOR      = "FOR"
 	GOTO     = "GOTO"
 	IF       = "IF"
+	INLINE   = "INLINE"
 	INT      = "INT"
 	LONG     = "LONG"
 	REGISTER = "REGISTER"
@@ -44,6 +90,8 @@ const (
 	VOID     = "VOID"
 	VOLATILE = "VOLATILE"
 	WHILE    = "WHILE"
+	ALIGNAS  = "ALIGNAS"
+	ALIGNOF  = "ALIGNOF"
 
 	// identifiers and literals
 	IDENTIFIER = "IDENTIFIER"
@@ -91,8 +139,9 @@ const (
 	INC = "++"
 	DEC = "--"
 
-	DOT   = "."
-	ARROW = "->"
+	DOT      = "."
+	ARROW    = "->"
+	ELLIPSIS = "..."
 
 	LPAREN    = "("
 	RPAREN    = ")"
@@ -106,6 +155,10 @@ const (
 
 	EOF     = "EOF"
 	ILLEGAL = "ILLEGAL"
+
+	// comments are kept out of the tokens the parser sees, see
+	// Lexer.Comments
+	COMMENT = "COMMENT"
 )
 
 var keywords = map[string]TokenType{
@@ -125,6 +178,7 @@ var keywords = map[string]TokenType{
 	"for":      FOR,
 	"goto":     GOTO,
 	"if":       IF,
+	"inline":   INLINE,
 	"int":      INT,
 	"long":     LONG,
 	"register": REGISTER,
@@ -141,6 +195,8 @@ var keywords = map[string]TokenType{
 	"void":     VOID,
 	"volatile": VOLATILE,
 	"while":    WHILE,
+	"_Alignas": ALIGNAS,
+	"_Alignof": ALIGNOF,
 }
 
 func LookupIdent(ident string) TokenType {